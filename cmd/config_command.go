@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/andreikom/sensor-server/pkg/api"
+	"gopkg.in/yaml.v2"
+	"os"
+)
+
+// configCommand runs config validate or config print
+func configCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: sensor-server config validate|print [flags]")
+		return exitUsage
+	}
+	subcommand := args[0]
+	if subcommand != "validate" && subcommand != "print" {
+		fmt.Fprintf(os.Stderr, "Unknown config command '%s', expected validate or print\n", subcommand)
+		return exitUsage
+	}
+	flags := flag.NewFlagSet("config "+subcommand, flag.ContinueOnError)
+	sources := bindConfigFlags(flags)
+	if err := flags.Parse(args[1:]); err != nil {
+		return parseExitCode(err)
+	}
+	cfg, err := resolveConfig(sources)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	if subcommand == "print" {
+		return printConfig(cfg)
+	}
+	if err := api.Validate(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "The configuration is invalid: %v\n", err)
+		return exitFailure
+	}
+	fmt.Println("The configuration is valid")
+	return 0
+}
+
+// printConfig writes the effective settings as YAML, it can be used as a config file once the secrets are filled in
+func printConfig(cfg *api.Config) int {
+	out, err := yaml.Marshal(cfg.Redacted())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	if _, err := os.Stdout.Write(out); err != nil {
+		return exitFailure
+	}
+	return 0
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// captureStdout returns what run writes to the standard output
+func captureStdout(t *testing.T, run func()) string {
+	t.Helper()
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = writer
+	defer func() { os.Stdout = stdout }()
+	out := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(reader)
+		out <- string(data)
+	}()
+	run()
+	writer.Close()
+	return <-out
+}
+
+func resolveFlags(t *testing.T, args ...string) (int, error) {
+	t.Helper()
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	sources := bindConfigFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := resolveConfig(sources)
+	if err != nil {
+		return 0, err
+	}
+	return cfg.Server.Port, nil
+}
+
+func TestResolveConfigFromFlags(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv(configEnv, "")
+	// the config file in the user home is optional
+	if port, err := resolveFlags(t); err != nil || port != 8080 {
+		t.Errorf("without a config file: %d, %v", port, err)
+	}
+	if err := os.WriteFile(filepath.Join(home, ConfigFile), []byte("server:\n  port: 9001\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if port, err := resolveFlags(t); err != nil || port != 9001 {
+		t.Errorf("with ~/%s: %d, %v", ConfigFile, port, err)
+	}
+	chosen := filepath.Join(t.TempDir(), "sensor-server.yml")
+	if err := os.WriteFile(chosen, []byte("server:\n  port: 9002\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(configEnv, chosen)
+	if port, err := resolveFlags(t); err != nil || port != 9002 {
+		t.Errorf("with $%s: %d, %v", configEnv, port, err)
+	}
+	// --set is repeatable, the last one wins
+	if port, err := resolveFlags(t, "--config", filepath.Join(home, ConfigFile), "--set", "server.port=9003", "--set", "server.port=9004"); err != nil || port != 9004 {
+		t.Errorf("with --config and --set: %d, %v", port, err)
+	}
+	// an explicitly chosen file has to exist
+	if _, err := resolveFlags(t, "--config", filepath.Join(home, "missing.yml")); err == nil {
+		t.Error("a missing --config file was skipped")
+	}
+	t.Setenv(configEnv, filepath.Join(home, "missing.yml"))
+	if _, err := resolveFlags(t); err == nil {
+		t.Errorf("a missing $%s file was skipped", configEnv)
+	}
+}
+
+func TestConfigCommand(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(configEnv, "")
+	tests := []struct {
+		args []string
+		want int
+	}{
+		{nil, exitUsage},
+		{[]string{"check"}, exitUsage},
+		{[]string{"validate", "--unknown"}, exitUsage},
+		{[]string{"validate", "-h"}, 0},
+		{[]string{"validate"}, 0},
+		{[]string{"validate", "--set", "server.port=80"}, exitFailure},
+		{[]string{"validate", "--set", "server.prot=8081"}, exitFailure},
+	}
+	for _, test := range tests {
+		if got := configCommand(test.args); got != test.want {
+			t.Errorf("config %v exited with %d, want %d", test.args, got, test.want)
+		}
+	}
+}
+
+func TestConfigPrintRedactsSecrets(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(configEnv, "")
+	code := 0
+	out := captureStdout(t, func() {
+		code = configCommand([]string{"print", "--set", "mqtt.password=hunter2", "--set", "server.port=9005"})
+	})
+	if code != 0 {
+		t.Fatalf("config print exited with %d", code)
+	}
+	if strings.Contains(out, "hunter2") || !strings.Contains(out, "password: REDACTED") || !strings.Contains(out, "port: 9005") {
+		t.Errorf("config print wrote:\n%s", out)
+	}
+	if !strings.Contains(out, "shutdownTimeout: 30s") {
+		t.Errorf("durations are not printed as written in a config file:\n%s", out)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/andreikom/sensor-server/pkg/auth"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// keysFlags - keys are managed through the admin API of a running server if server is set, or else in the
+// keys file of the configuration, which a running server reads again on a configuration reload
+type keysFlags struct {
+	*configFlags
+	server string
+	token  string
+}
+
+func bindKeysFlags(flags *flag.FlagSet) *keysFlags {
+	sources := &keysFlags{configFlags: bindConfigFlags(flags)}
+	flags.StringVar(&sources.server, "server", "", "the url of a running server, the keys file of the configuration is edited if empty")
+	flags.StringVar(&sources.token, "token", "", "an admin API key or token of the server")
+	return sources
+}
+
+// keysCommand runs keys create, keys list or keys revoke
+func keysCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: sensor-server keys create|list|revoke [flags]")
+		return exitUsage
+	}
+	subcommand := args[0]
+	flags := flag.NewFlagSet("keys "+subcommand, flag.ContinueOnError)
+	sources := bindKeysFlags(flags)
+	var run func() error
+	switch subcommand {
+	case "create":
+		name := flags.String("name", "", "what the key is for, it goes into the logs")
+		scopes := flags.String("scope", "", "comma-separated scopes: ingest, read or admin")
+		sensors := flags.String("sensors", "", "comma-separated sensor ids or patterns such as greenhouse-*, all sensors if empty")
+		tags := flags.String("tags", "", "comma-separated tags of auth.sensorTags, the key may also access the sensors carrying them")
+		run = func() error {
+			return createKey(sources, *name, splitList(*scopes), splitList(*sensors), splitList(*tags))
+		}
+	case "list":
+		run = func() error {
+			return listKeys(sources)
+		}
+	case "revoke":
+		flags.Usage = func() {
+			fmt.Fprintln(flags.Output(), "Usage: sensor-server keys revoke [flags] id\n\nFlags:")
+			flags.PrintDefaults()
+		}
+		run = func() error {
+			if flags.NArg() != 1 {
+				flags.Usage()
+				return errUsage
+			}
+			return revokeKey(sources, flags.Arg(0))
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown keys command '%s', expected create, list or revoke\n", subcommand)
+		return exitUsage
+	}
+	if err := flags.Parse(args[1:]); err != nil {
+		return parseExitCode(err)
+	}
+	if err := run(); err != nil {
+		if err == errUsage {
+			return exitUsage
+		}
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	return 0
+}
+
+// errUsage - the arguments of a command were reported as invalid already
+var errUsage = errors.New("invalid arguments")
+
+func splitList(value string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func openKeyStore(sources *keysFlags) (*auth.KeyStore, error) {
+	cfg, err := resolveConfig(sources.configFlags)
+	if err != nil {
+		return nil, err
+	}
+	return auth.OpenKeyStore(cfg.Auth.KeysFile)
+}
+
+func createKey(sources *keysFlags, name string, scopes []string, sensors []string, tags []string) error {
+	if name == "" {
+		return fmt.Errorf("--name is required")
+	}
+	keyScopes := make([]auth.Scope, 0, len(scopes))
+	for _, scope := range scopes {
+		parsed, err := auth.ParseScope(scope)
+		if err != nil {
+			return err
+		}
+		keyScopes = append(keyScopes, parsed)
+	}
+	var token string
+	if sources.server != "" {
+		body, err := json.Marshal(map[string]interface{}{"name": name, "scopes": keyScopes, "sensors": sensors, "tags": tags})
+		if err != nil {
+			return err
+		}
+		resp, err := adminRequest(sources, http.MethodPost, "/admin/keys", body)
+		if err != nil {
+			return fmt.Errorf("could not have created the key: %w", err)
+		}
+		created := struct {
+			Key string `json:"key"`
+		}{}
+		if err := json.Unmarshal(resp, &created); err != nil {
+			return err
+		}
+		token = created.Key
+	} else {
+		store, err := openKeyStore(sources)
+		if err != nil {
+			return err
+		}
+		if token, _, err = store.Create(name, keyScopes, sensors, tags); err != nil {
+			return fmt.Errorf("could not have created the key: %w", err)
+		}
+		fmt.Fprintln(os.Stderr, "A running server accepts the key once its configuration is reloaded (SIGHUP)")
+	}
+	fmt.Fprintln(os.Stderr, "Store the key now, it cannot be shown again")
+	fmt.Println(token)
+	return nil
+}
+
+func listKeys(sources *keysFlags) error {
+	var keys []auth.Key
+	if sources.server != "" {
+		resp, err := adminRequest(sources, http.MethodGet, "/admin/keys", nil)
+		if err != nil {
+			return fmt.Errorf("could not have listed the keys: %w", err)
+		}
+		if err := json.Unmarshal(resp, &keys); err != nil {
+			return err
+		}
+	} else {
+		store, err := openKeyStore(sources)
+		if err != nil {
+			return err
+		}
+		keys = store.List()
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "ID\tNAME\tSCOPES\tSENSORS\tTAGS\tCREATED")
+	for _, key := range keys {
+		scopes := make([]string, len(key.Scopes))
+		for i, scope := range key.Scopes {
+			scopes[i] = string(scope)
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\n", key.Id, key.Name, strings.Join(scopes, ","),
+			orAll(key.Sensors), orAll(key.Tags), key.Created.Format(time.RFC3339))
+	}
+	return writer.Flush()
+}
+
+// orAll - keys without sensors and tags may access all sensors
+func orAll(items []string) string {
+	if len(items) == 0 {
+		return "-"
+	}
+	return strings.Join(items, ",")
+}
+
+func revokeKey(sources *keysFlags, id string) error {
+	if sources.server != "" {
+		if _, err := adminRequest(sources, http.MethodDelete, "/admin/keys/"+url.PathEscape(id), nil); err != nil {
+			return fmt.Errorf("could not have revoked the key: %w", err)
+		}
+		return nil
+	}
+	store, err := openKeyStore(sources)
+	if err != nil {
+		return err
+	}
+	if err := store.Revoke(id); err != nil {
+		return fmt.Errorf("could not have revoked the key: %w", err)
+	}
+	fmt.Fprintln(os.Stderr, "A running server rejects the key once its configuration is reloaded (SIGHUP)")
+	return nil
+}
+
+func adminRequest(sources *keysFlags, method string, path string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, strings.TrimSuffix(sources.server, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if sources.token != "" {
+		req.Header.Set("Authorization", "Bearer "+sources.token)
+	}
+	client := &http.Client{Timeout: queryTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	return respBody, nil
+}
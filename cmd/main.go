@@ -1,66 +1,160 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"github.com/andreikom/sensor-server/pkg/api"
+	"github.com/andreikom/sensor-server/pkg/logging"
 	"github.com/andreikom/sensor-server/pkg/utils"
-	"gopkg.in/yaml.v2"
+	"io"
 	"os"
 	"path/filepath"
-	"strconv"
+	"strings"
 )
 
 const (
 	ConfigFile = "config.yml"
+	// configEnv - the config file used if --config is not given
+	configEnv = api.EnvPrefix + "CONFIG"
+	// exitFailure - the server could not start or one of its listeners failed
+	exitFailure = 1
+	// exitShutdownTimeout - the server was stopped but did not drain within its shutdown timeout
+	exitShutdownTimeout = 2
+	// exitUsage - unknown command or invalid flags, EX_USAGE of sysexits.h
+	exitUsage = 64
 )
 
+const usage = `Usage: sensor-server [command] [flags]
+
+Commands:
+  serve            run the server (default)
+  import           read CSV or NDJSON readings into the store
+  export           write the readings or the archived rollups of the store
+  query            compute a statistic against a running server or the store
+  compact          migrate old records, archive and expire old days
+  verify           check the records of the store
+  keys create      create an API key, printed once
+  keys list        list the API keys
+  keys revoke      revoke an API key
+  oidc keygen      create the key and JWKS of a local token issuer, to try auth.oidc
+  oidc token       issue a JWT with the key of oidc keygen
+  simulate         send the readings of virtual sensors to a running server, to load test or demo it
+  config validate  check the effective configuration
+  config print     print the effective configuration, secrets redacted
+
+import, export, compact and query without --server work on the store directory, no server may be running on it.
+
+Settings are resolved from the defaults, the YAML file of --config, the %s* variables
+and --set flags, each overriding the previous ones. Run a command with -h for its flags.
+`
+
 func main() {
 	//defer profile.Start().Stop()
-	cfg := resolveConfig()
-	api.Start(cfg)
+	command, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+	switch command {
+	case "serve":
+		os.Exit(serve(args))
+	case "import":
+		os.Exit(importCommand(args))
+	case "export":
+		os.Exit(exportCommand(args))
+	case "query":
+		os.Exit(queryCommand(args))
+	case "compact":
+		os.Exit(compactCommand(args))
+	case "verify":
+		os.Exit(verifyCommand(args))
+	case "keys":
+		os.Exit(keysCommand(args))
+	case "oidc":
+		os.Exit(oidcCommand(args))
+	case "simulate":
+		os.Exit(simulateCommand(args))
+	case "config":
+		os.Exit(configCommand(args))
+	case "help":
+		printUsage(os.Stdout)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command '%s'\n\n", command)
+		printUsage(os.Stderr)
+		os.Exit(exitUsage)
+	}
 }
 
-func resolveConfig() *api.Config {
-	cfg := &api.Config{}
-	err := resolveFromYaml(cfg)
+func serve(args []string) int {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	sources := bindConfigFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return parseExitCode(err)
+	}
+	cfg, err := resolveConfig(sources)
 	if err != nil {
-		err := resolveEnvVars(cfg)
-		if err != nil {
-			return cfg
+		logging.Log.WithError(err).Error("Could not resolve the configuration")
+		return exitFailure
+	}
+	reload := func() (*api.Config, error) {
+		return resolveConfig(sources)
+	}
+	if err := api.Start(cfg, reload); err != nil {
+		logging.Log.WithError(err).Error("Sensor Server stopped")
+		if errors.Is(err, api.ErrShutdownTimeout) {
+			return exitShutdownTimeout
 		}
-		return cfg
+		return exitFailure
 	}
-	return cfg
+	return 0
 }
 
-func resolveFromYaml(cfg *api.Config) error {
-	userHome := utils.GetUserHome()
-	configFile, err := os.Open(filepath.Join(userHome, ConfigFile))
-	defer configFile.Close()
-	if err != nil {
-		fmt.Printf("Could not get '" + ConfigFile + "' file. Attempting to resolve from env vars\n")
-		return err
+// configFlags - the flags of the commands reading the configuration
+type configFlags struct {
+	file      string
+	overrides overrideFlag
+}
+
+// overrideFlag - a repeatable --set path=value
+type overrideFlag []string
+
+func (f *overrideFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *overrideFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+func bindConfigFlags(flags *flag.FlagSet) *configFlags {
+	sources := &configFlags{}
+	flags.StringVar(&sources.file, "config", "", "the YAML config file, $"+configEnv+" or ~/"+ConfigFile+" if it exists")
+	flags.Var(&sources.overrides, "set", "override a setting, e.g. --set server.port=8081, repeatable")
+	return sources
+}
+
+// resolveConfig - an explicitly chosen config file has to exist, the one in the user home is optional
+func resolveConfig(flags *configFlags) (*api.Config, error) {
+	sources := api.ConfigSources{File: flags.file, FileRequired: true, Environ: os.Environ(), Overrides: flags.overrides}
+	if sources.File == "" {
+		sources.File = os.Getenv(configEnv)
 	}
-	decoder := yaml.NewDecoder(configFile)
-	err = decoder.Decode(cfg)
-	if err != nil {
-		fmt.Printf("Could not get decode'" + ConfigFile + "' file. Attempting to resolve from env vars\n")
-		return err
+	if sources.File == "" {
+		sources.File = filepath.Join(utils.GetUserHome(), ConfigFile)
+		sources.FileRequired = false
 	}
-	fmt.Printf("Decoded yaml config file succcesfully\n")
-	return nil
+	return api.ResolveConfig(sources)
 }
 
-func resolveEnvVars(cfg *api.Config) error {
-	envPort := os.Getenv("sensor_server_port")
-	if envPort != "" {
-		if envPortInt, err := strconv.Atoi(envPort); err != nil {
-			fmt.Printf("Error reading port from env: %d\n", err)
-			return err
-		} else {
-			cfg.Server.Port = envPortInt
-		}
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, usage, api.EnvPrefix)
+}
+
+// parseExitCode - -h is not a failure
+func parseExitCode(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
 	}
-	fmt.Printf("sensor_server_port is not defined\n")
-	return nil
+	return exitUsage
 }
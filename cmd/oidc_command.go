@@ -0,0 +1,112 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/andreikom/sensor-server/pkg/auth"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	issuerKeyFile  = "issuer.pem"
+	issuerJwksFile = "jwks.json"
+)
+
+// oidcCommand runs oidc keygen or oidc token, a local token issuer to try auth.oidc without a provider
+func oidcCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: sensor-server oidc keygen|token [flags]")
+		return exitUsage
+	}
+	flags := flag.NewFlagSet("oidc "+args[0], flag.ContinueOnError)
+	var run func() error
+	switch args[0] {
+	case "keygen":
+		dir := flags.String("dir", ".", "the directory "+issuerKeyFile+" and "+issuerJwksFile+" are written to")
+		run = func() error {
+			return generateIssuer(*dir)
+		}
+	case "token":
+		keyFile := flags.String("key", issuerKeyFile, "the private key of oidc keygen")
+		issuer := flags.String("issuer", "", "the 'iss' claim, auth.oidc.issuer of the server")
+		audience := flags.String("audience", "", "the 'aud' claim, omitted if empty")
+		subject := flags.String("subject", "", "the 'sub' claim, the name of the principal in the logs")
+		roles := flags.String("roles", "", "comma-separated roles: viewer, operator or admin")
+		sensors := flags.String("sensors", "", "comma-separated sensor ids or patterns, all sensors if empty")
+		tags := flags.String("tags", "", "comma-separated tags of auth.sensorTags")
+		ttl := flags.Duration("ttl", time.Hour, "how long the token is valid")
+		run = func() error {
+			if *issuer == "" || *subject == "" {
+				return fmt.Errorf("--issuer and --subject are required")
+			}
+			claims := map[string]interface{}{"iss": *issuer, "sub": *subject, "roles": splitList(*roles)}
+			if *audience != "" {
+				claims["aud"] = *audience
+			}
+			if list := splitList(*sensors); len(list) > 0 {
+				claims["sensors"] = list
+			}
+			if list := splitList(*tags); len(list) > 0 {
+				claims["sensor_tags"] = list
+			}
+			return signToken(*keyFile, claims, *ttl)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown oidc command '%s', expected keygen or token\n", args[0])
+		return exitUsage
+	}
+	if err := flags.Parse(args[1:]); err != nil {
+		return parseExitCode(err)
+	}
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	return 0
+}
+
+func generateIssuer(dir string) error {
+	issuer, err := auth.NewIssuer()
+	if err != nil {
+		return err
+	}
+	privatePem, err := issuer.PrivateKeyPem()
+	if err != nil {
+		return err
+	}
+	jwks, err := issuer.Jwks()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, issuerKeyFile), privatePem, 0600); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, issuerJwksFile), jwks, 0644); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Set auth.oidc.jwksFile to %s\n", filepath.Join(dir, issuerJwksFile))
+	return nil
+}
+
+func signToken(keyFile string, claims map[string]interface{}, ttl time.Duration) error {
+	data, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return err
+	}
+	issuer, err := auth.ParseIssuer(data)
+	if err != nil {
+		return fmt.Errorf("invalid issuer key %s: %w", keyFile, err)
+	}
+	token, err := issuer.Sign(claims, ttl)
+	if err != nil {
+		return err
+	}
+	fmt.Println(token)
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"github.com/andreikom/sensor-server/pkg/auth"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOidcCommand(t *testing.T) {
+	dir := t.TempDir()
+	if code, _ := run(t, oidcCommand, "keygen", "--dir", dir); code != 0 {
+		t.Fatalf("oidc keygen exited with %d", code)
+	}
+	keyFile := filepath.Join(dir, issuerKeyFile)
+	code, out := run(t, oidcCommand, "token", "--key", keyFile, "--issuer", "https://id.example.com", "--audience", "sensor-server",
+		"--subject", "grafana", "--roles", "viewer,operator", "--sensors", "greenhouse-*")
+	if code != 0 {
+		t.Fatalf("oidc token exited with %d", code)
+	}
+	// the server accepts the token with the JWKS written by keygen
+	verifier, err := auth.NewTokenVerifier(auth.OidcOptions{Issuer: "https://id.example.com", Audience: "sensor-server",
+		JwksFile: filepath.Join(dir, issuerJwksFile)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	principal, err := verifier.Authenticate(strings.TrimSpace(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if principal.Name != "grafana" || !principal.HasScope(auth.Ingest) || principal.HasScope(auth.Admin) || principal.AllowsSensor("kitchen") {
+		t.Errorf("the token grants %+v", principal)
+	}
+
+	tests := []struct {
+		args []string
+		code int
+	}{
+		{nil, exitUsage},
+		{[]string{"rotate"}, exitUsage},
+		{[]string{"token", "--ttl", "forever"}, exitUsage},
+		{[]string{"token", "--key", keyFile, "--subject", "grafana"}, exitFailure},
+		{[]string{"token", "--key", filepath.Join(dir, "missing.pem"), "--issuer", "https://id.example.com", "--subject", "grafana"}, exitFailure},
+		{[]string{"token", "--key", filepath.Join(dir, issuerJwksFile), "--issuer", "https://id.example.com", "--subject", "grafana"}, exitFailure},
+	}
+	for _, test := range tests {
+		if code, out := run(t, oidcCommand, test.args...); code != test.code || out != "" {
+			t.Errorf("oidc %v exited with %d, want %d: %s", test.args, code, test.code, out)
+		}
+	}
+}
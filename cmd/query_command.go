@@ -0,0 +1,140 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/andreikom/sensor-server/pkg/api/metric"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// queryTimeout - how long a query against a running server may take
+const queryTimeout = 30 * time.Second
+
+// queryCommand computes a statistic of a sensor over a day, week or month, against a running server if --server
+// is given or else the store directly
+func queryCommand(args []string) int {
+	flags := flag.NewFlagSet("query", flag.ContinueOnError)
+	sources := bindStoreFlags(flags)
+	server := flags.String("server", "", "the url of a running server, e.g. http://localhost:8080, the store is read if empty")
+	metricName := flags.String("metric", metric.Temperature, "the metric")
+	sensorId := flags.String("sensor", "", "the sensor id")
+	period := flags.String("period", string(metric.Day), "daily, weekly or monthly")
+	stat := flags.String("stat", string(metric.Avg), "min, max or avg")
+	date := flags.String("date", "", "a date within the period, YYYY-MM-DD, today if empty")
+	unit := flags.String("unit", "", "the unit of the result, the store unit of the metric if empty")
+	tz := flags.String("tz", "", "the IANA timezone of the day boundaries, the one of the sensor if empty")
+	if err := flags.Parse(args); err != nil {
+		return parseExitCode(err)
+	}
+	query, err := parseQuery(*metricName, *sensorId, *period, *stat, *unit, *tz)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	query.date = *date
+	var result string
+	if *server != "" {
+		result, err = queryServer(*server, query)
+	} else {
+		result, err = queryStore(sources, query)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not have queried the %s %s of sensor %s: %v\n", query.period, query.stat, query.sensorId, err)
+		return exitFailure
+	}
+	fmt.Println(result)
+	return 0
+}
+
+// statQuery - the arguments of MetricService.GetStat
+type statQuery struct {
+	metric   string
+	sensorId string
+	period   metric.Period
+	stat     metric.Stat
+	date     string
+	unit     metric.Unit
+	loc      *time.Location
+}
+
+func parseQuery(metricName string, sensorId string, period string, stat string, unit string, tz string) (statQuery, error) {
+	query := statQuery{metric: metricName, sensorId: sensorId, period: metric.Period(period), stat: metric.Stat(stat)}
+	if sensorId == "" {
+		return query, fmt.Errorf("--sensor is required")
+	}
+	switch query.period {
+	case metric.Day, metric.Week, metric.Month:
+	default:
+		return query, fmt.Errorf("unknown period '%s', expected %s, %s or %s", period, metric.Day, metric.Week, metric.Month)
+	}
+	switch query.stat {
+	case metric.Min, metric.Max, metric.Avg:
+	default:
+		return query, fmt.Errorf("unknown statistic '%s', expected %s, %s or %s", stat, metric.Min, metric.Max, metric.Avg)
+	}
+	var err error
+	if query.unit, err = metric.ParseUnit(metricName, unit); err != nil {
+		return query, err
+	}
+	if query.loc, err = metric.ParseLocation(tz); err != nil {
+		return query, err
+	}
+	return query, nil
+}
+
+func queryStore(sources *storeFlags, query statQuery) (string, error) {
+	store, _, err := openStore(sources, true)
+	if err != nil {
+		return "", err
+	}
+	defer store.Close()
+	result, err := store.GetStat(query.metric, query.sensorId, query.period, query.stat, query.date, query.unit, query.loc)
+	if err != nil {
+		return "", err
+	}
+	return metric.FormatReading(result), nil
+}
+
+// queryServer - the routes of the server need a date, an empty one is today in the timezone of the query or
+// else of this machine
+func queryServer(server string, query statQuery) (string, error) {
+	date := query.date
+	if date == "" {
+		loc := query.loc
+		if loc == nil {
+			loc = time.Local
+		}
+		date = time.Now().In(loc).Format("2006-01-02")
+	}
+	endpoint := fmt.Sprintf("%s/metric/%s/%s_%s/%s/%s", strings.TrimSuffix(server, "/"), url.PathEscape(query.metric),
+		query.period, query.stat, url.PathEscape(query.sensorId), url.PathEscape(date))
+	params := url.Values{}
+	if query.unit != "" {
+		params.Set("unit", string(query.unit))
+	}
+	if query.loc != nil {
+		params.Set("tz", query.loc.String())
+	}
+	if len(params) > 0 {
+		endpoint += "?" + params.Encode()
+	}
+	client := &http.Client{Timeout: queryTimeout}
+	resp, err := client.Get(endpoint)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return strings.TrimSpace(string(body)), nil
+}
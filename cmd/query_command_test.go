@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestQueryTheStore(t *testing.T) {
+	store := newStoreDir(t)
+	hour := time.Now().UTC().Truncate(time.Hour).Add(-time.Hour)
+	input := writeInput(t, "readings.ndjson", fmt.Sprintf(`{"sensorId":"kitchen","value":20,"timestamp":%d}
+{"sensorId":"kitchen","value":23,"timestamp":%d}`, hour.Unix(), hour.Add(time.Minute).Unix()))
+	if code, _ := run(t, importCommand, "--store", store, input); code != 0 {
+		t.Fatalf("import exited with %d", code)
+	}
+	date := hour.Format("2006-01-02")
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--stat", "max"}, "23.00\n"},
+		{[]string{"--stat", "avg"}, "21.50\n"},
+		{[]string{"--stat", "min", "--unit", "F"}, "68.00\n"},
+		{[]string{"--period", "weekly", "--stat", "min"}, "20.00\n"},
+	}
+	for _, test := range tests {
+		args := append([]string{"--store", store, "--sensor", "kitchen", "--date", date, "--tz", "UTC"}, test.args...)
+		if code, out := run(t, queryCommand, args...); code != 0 || out != test.want {
+			t.Errorf("query %v exited with %d and wrote %q, want %q", test.args, code, out, test.want)
+		}
+	}
+	if code, _ := run(t, queryCommand, "--store", store, "--sensor", "attic", "--date", date); code != exitFailure {
+		t.Errorf("query of an unknown sensor exited with %d", code)
+	}
+}
+
+func TestQueryRejectsInvalidArguments(t *testing.T) {
+	store := newStoreDir(t)
+	for _, args := range [][]string{
+		{},
+		{"--sensor", "kitchen", "--period", "yearly"},
+		{"--sensor", "kitchen", "--stat", "median"},
+		{"--sensor", "kitchen", "--unit", "ppm"},
+		{"--sensor", "kitchen", "--tz", "Mars/Olympus"},
+		{"--sensor", "kitchen", "--metric", "radiation"},
+	} {
+		if code, _ := run(t, queryCommand, append([]string{"--store", store}, args...)...); code != exitUsage {
+			t.Errorf("query %v exited with %d", args, code)
+		}
+	}
+}
+
+func TestQueryAServer(t *testing.T) {
+	requested := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requested <- req.URL.String()
+		if req.URL.Query().Get("unit") == "K" {
+			http.Error(w, "unsupported unit", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintln(w, "21.50")
+	}))
+	defer server.Close()
+	newStoreDir(t)
+	code, out := run(t, queryCommand, "--server", server.URL+"/", "--sensor", "living room", "--period", "weekly", "--stat", "max",
+		"--date", "2026-10-19", "--unit", "F", "--tz", "Europe/Berlin")
+	if code != 0 || out != "21.50\n" {
+		t.Errorf("query exited with %d and wrote %q", code, out)
+	}
+	if url := <-requested; url != "/metric/temperature/weekly_max/living%20room/2026-10-19?tz=Europe%2FBerlin&unit=F" {
+		t.Errorf("requested %s", url)
+	}
+	// without a date the server is asked for today
+	run(t, queryCommand, "--server", server.URL, "--sensor", "kitchen", "--tz", "UTC")
+	if url := <-requested; url != "/metric/temperature/daily_avg/kitchen/"+time.Now().UTC().Format("2006-01-02")+"?tz=UTC&unit=C" {
+		t.Errorf("requested %s", url)
+	}
+	if code, _ := run(t, queryCommand, "--server", server.URL, "--sensor", "kitchen", "--unit", "K"); code != exitFailure {
+		t.Errorf("a rejected query exited with %d", code)
+	}
+	<-requested
+}
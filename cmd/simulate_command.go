@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"github.com/andreikom/sensor-server/pkg/api/metric"
+	"github.com/andreikom/sensor-server/pkg/client"
+	"github.com/andreikom/sensor-server/pkg/simulate"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// simulateCommand sends the readings of virtual sensors to a running server and reports the achieved throughput
+// and latencies, to load test or demo it
+func simulateCommand(args []string) int {
+	flags := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	var options simulate.Options
+	flags.IntVar(&options.Sensors, "sensors", 10, "the number of virtual sensors")
+	flags.StringVar(&options.SensorPrefix, "sensor-prefix", "sim-", "the prefix of the sensor ids, followed by the number of the sensor")
+	flags.StringVar(&options.Metric, "metric", metric.Temperature, "the metric of the readings")
+	flags.Float64Var(&options.Rate, "rate", 10, "readings per second over all sensors")
+	flags.DurationVar(&options.Duration, "duration", time.Minute, "how long to run, until interrupted if 0")
+	flags.IntVar(&options.Concurrency, "concurrency", 16, "readings sent at once")
+	flags.Float64Var(&options.TimeScale, "time-scale", 1, "simulated time per real time of the diurnal pattern and drift, e.g. 1440 for a day a minute")
+	flags.Int64Var(&options.Seed, "seed", time.Now().UnixNano(), "the seed of the generated readings")
+	flags.Float64Var(&options.Pattern.Base, "base", 21, "the mean of the readings")
+	flags.Float64Var(&options.Pattern.Amplitude, "amplitude", 4, "the amplitude of the diurnal sine, peaking at 15:00")
+	flags.Float64Var(&options.Pattern.Noise, "noise", 0.3, "the standard deviation of the noise")
+	flags.Float64Var(&options.Pattern.DriftPerHour, "drift", 0, "how far the mean moves per simulated hour")
+	flags.Float64Var(&options.Pattern.DropoutRate, "dropout", 0, "the share of readings the sensors skip, 0 to 1")
+	flags.Float64Var(&options.Pattern.SpikeRate, "spike-rate", 0, "the share of readings off by --spike-size, 0 to 1")
+	flags.Float64Var(&options.Pattern.SpikeSize, "spike-size", 10, "how far spikes are off")
+	transport := flags.String("transport", simulate.Http, "http, grpc or mqtt")
+	server := flags.String("server", "http://localhost:8080", "the url of the HTTP API")
+	grpcAddress := flags.String("grpc", "localhost:9000", "host:port of the gRPC API")
+	broker := flags.String("broker", "tcp://localhost:1883", "the url of the MQTT broker the server subscribes to")
+	username := flags.String("username", "", "the MQTT username")
+	password := flags.String("password", "", "the MQTT password")
+	token := flags.String("token", "", "the bearer token sent to the HTTP or gRPC API")
+	useTls := flags.Bool("tls", false, "use TLS for gRPC")
+	timeout := flags.Duration("timeout", 10*time.Second, "the timeout of a single reading")
+	quiet := flags.Bool("quiet", false, "do not print progress every 5s")
+	if err := flags.Parse(args); err != nil {
+		return parseExitCode(err)
+	}
+	clientOptions := client.Options{Address: *server, GrpcAddress: *grpcAddress, Token: *token, Timeout: *timeout}
+	if *useTls {
+		clientOptions.TLS = &tls.Config{}
+	}
+	var sender simulate.Sender
+	var err error
+	switch *transport {
+	case simulate.Http:
+		sender, err = simulate.NewHttpSender(clientOptions)
+	case simulate.Grpc:
+		sender, err = simulate.NewGrpcSender(clientOptions)
+	case simulate.Mqtt:
+		sender, err = simulate.NewMqttSender(*broker, *username, *password)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown transport '%s', expected %s, %s or %s\n", *transport, simulate.Http, simulate.Grpc, simulate.Mqtt)
+		return exitUsage
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not have connected over %s: %v\n", *transport, err)
+		return exitFailure
+	}
+	defer sender.Close()
+	if !*quiet {
+		options.Progress = func(report simulate.Report) {
+			fmt.Fprintln(os.Stderr, report)
+		}
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	fmt.Fprintf(os.Stderr, "Simulating %d sensors at %g readings/s over %s\n", options.Sensors, options.Rate, *transport)
+	report, err := simulate.Run(ctx, sender, options)
+	fmt.Println(report)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not have simulated the sensors: %v\n", err)
+		return exitFailure
+	}
+	if report.LastError != nil {
+		fmt.Fprintf(os.Stderr, "Last failure: %v\n", report.LastError)
+	}
+	return 0
+}
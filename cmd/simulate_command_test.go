@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSimulateCommand(t *testing.T) {
+	var readings int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/metric/" {
+			atomic.AddInt32(&readings, 1)
+		}
+	}))
+	defer server.Close()
+	code, out := run(t, simulateCommand, "--server", server.URL, "--sensors", "2", "--rate", "100", "--duration", "200ms", "--quiet")
+	if code != 0 || !strings.HasPrefix(out, "sent ") {
+		t.Fatalf("simulate exited with %d: %s", code, out)
+	}
+	if atomic.LoadInt32(&readings) == 0 {
+		t.Error("no readings were sent")
+	}
+
+	// every reading failing is a failure of the run
+	server.Close()
+	if code, out := run(t, simulateCommand, "--server", server.URL, "--rate", "100", "--duration", "100ms", "--quiet"); code != exitFailure {
+		t.Errorf("simulate against a stopped server exited with %d: %s", code, out)
+	}
+	tests := []struct {
+		args []string
+		code int
+	}{
+		{[]string{"--transport", "carrier-pigeon"}, exitUsage},
+		{[]string{"--rate", "fast"}, exitUsage},
+		{[]string{"--transport", "grpc", "--grpc", ""}, exitFailure},
+		{[]string{"--dropout", "2", "--duration", "1s"}, exitFailure},
+	}
+	for _, test := range tests {
+		if code, _ := run(t, simulateCommand, append(test.args, "--quiet")...); code != test.code {
+			t.Errorf("simulate %v exited with %d, want %d", test.args, code, test.code)
+		}
+	}
+}
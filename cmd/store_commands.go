@@ -0,0 +1,418 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/andreikom/sensor-server/pkg/api"
+	"github.com/andreikom/sensor-server/pkg/api/metric"
+	"github.com/andreikom/sensor-server/pkg/logging"
+	"github.com/andreikom/sensor-server/pkg/storage"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	formatCsv    = "csv"
+	formatNdjson = "ndjson"
+	tierRaw      = "raw"
+	tierArchive  = "archive"
+)
+
+// storeFlags - the flags of the commands working on a store directory
+type storeFlags struct {
+	*configFlags
+	store string
+}
+
+func bindStoreFlags(flags *flag.FlagSet) *storeFlags {
+	sources := &storeFlags{configFlags: bindConfigFlags(flags)}
+	flags.StringVar(&sources.store, "store", "", "the store directory, storage.path of the configuration if empty")
+	return sources
+}
+
+// openStore - the server must not be running on the store, a read-only store leaves records of older versions
+// to be rewritten by compact or the server
+func openStore(flags *storeFlags, readOnly bool) (*metric.MetricService, *storage.FsDriver, error) {
+	cfg, err := resolveStoreConfig(flags)
+	if err != nil {
+		return nil, nil, err
+	}
+	if readOnly {
+		return api.OpenReadOnlyStore(cfg)
+	}
+	return api.OpenStore(cfg)
+}
+
+// resolveStoreConfig - logs go to stderr, stdout is left to the output of the command
+func resolveStoreConfig(flags *storeFlags) (*api.Config, error) {
+	logging.Log.SetOutput(os.Stderr)
+	if flags.store != "" {
+		flags.overrides = append(flags.overrides, "storage.path="+flags.store)
+	}
+	cfg, err := resolveConfig(flags.configFlags)
+	if err != nil {
+		return nil, err
+	}
+	if err := logging.Configure(cfg.Logging.Format, cfg.Logging.Level); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// importCommand reads readings from CSV or NDJSON files, or stdin if none is given, into a store. Every file
+// is parsed before anything is written, so a malformed line leaves the store untouched
+func importCommand(args []string) int {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	sources := bindStoreFlags(flags)
+	format := flags.String("format", "", "csv or ndjson, by the file extension if empty and ndjson for stdin")
+	metricName := flags.String("metric", metric.Temperature, "the metric of readings without one")
+	unit := flags.String("unit", "", "the unit of readings without one, the store unit of their metric if empty")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: sensor-server import [flags] [file...]\n\nCSV files need a header naming the columns sensorId, value and timestamp, metric and unit are optional.\nTimestamps are RFC 3339 or unix seconds.\n\nFlags:")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return parseExitCode(err)
+	}
+	defaults := metric.Reading{Metric: *metricName, Unit: metric.Unit(*unit)}
+	files := flags.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	readings := make([]metric.Reading, 0)
+	for _, file := range files {
+		parsed, err := readReadings(file, *format, defaults)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not have read %s: %v\n", file, err)
+			return exitFailure
+		}
+		readings = append(readings, parsed...)
+	}
+	store, _, err := openStore(sources, false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not have opened the store: %v\n", err)
+		return exitFailure
+	}
+	defer store.Close()
+	if err := store.Import(context.Background(), readings); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not have imported the readings: %v\n", err)
+		return exitFailure
+	}
+	fmt.Fprintf(os.Stderr, "Imported %d readings\n", len(readings))
+	return 0
+}
+
+func readReadings(file string, format string, defaults metric.Reading) ([]metric.Reading, error) {
+	in := os.Stdin
+	if file != "-" {
+		opened, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer opened.Close()
+		in = opened
+	}
+	if format == "" {
+		format = formatNdjson
+		if strings.EqualFold(filepath.Ext(file), ".csv") {
+			format = formatCsv
+		}
+	}
+	switch format {
+	case formatCsv:
+		return readCsv(in, defaults)
+	case formatNdjson:
+		return readNdjson(in, defaults)
+	default:
+		return nil, fmt.Errorf("unknown format '%s', expected %s or %s", format, formatCsv, formatNdjson)
+	}
+}
+
+func readCsv(in io.Reader, defaults metric.Reading) ([]metric.Reading, error) {
+	reader := csv.NewReader(in)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("could not read the header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.ReplaceAll(strings.TrimSpace(name), "_", ""))] = i
+	}
+	for _, required := range []string{"sensorid", "value", "timestamp"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("the header has no %s column", required)
+		}
+	}
+	column := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	readings := make([]metric.Reading, 0)
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return readings, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		reading := defaults
+		reading.SensorId = column(record, "sensorid")
+		if value := column(record, "metric"); value != "" {
+			reading.Metric = value
+		}
+		if value := column(record, "unit"); value != "" {
+			reading.Unit = metric.Unit(value)
+		}
+		if reading.Value, err = strconv.ParseFloat(column(record, "value"), 64); err != nil {
+			return nil, fmt.Errorf("line %d: invalid value: %w", line, err)
+		}
+		if reading.Timestamp, err = parseTimestamp(column(record, "timestamp")); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		readings = append(readings, reading)
+	}
+}
+
+// ndjsonReading - a line of an NDJSON import, the timestamp is an RFC 3339 string or unix seconds
+type ndjsonReading struct {
+	SensorId  string          `json:"sensorId"`
+	Metric    string          `json:"metric"`
+	Value     *float64        `json:"value"`
+	Unit      string          `json:"unit"`
+	Timestamp json.RawMessage `json:"timestamp"`
+}
+
+func readNdjson(in io.Reader, defaults metric.Reading) ([]metric.Reading, error) {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	readings := make([]metric.Reading, 0)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		parsed := ndjsonReading{}
+		if err := json.Unmarshal(scanner.Bytes(), &parsed); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if parsed.Value == nil {
+			return nil, fmt.Errorf("line %d: the value is missing", line)
+		}
+		reading := defaults
+		reading.SensorId = parsed.SensorId
+		reading.Value = *parsed.Value
+		if parsed.Metric != "" {
+			reading.Metric = parsed.Metric
+		}
+		if parsed.Unit != "" {
+			reading.Unit = metric.Unit(parsed.Unit)
+		}
+		timestamp := strings.Trim(string(parsed.Timestamp), `"`)
+		var err error
+		if reading.Timestamp, err = parseTimestamp(timestamp); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		readings = append(readings, reading)
+	}
+	return readings, scanner.Err()
+}
+
+func parseTimestamp(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, errors.New("the timestamp is missing")
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Unix(0, int64(seconds*float64(time.Second))), nil
+	}
+	timestamp, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp '%s', expected RFC 3339 or unix seconds", value)
+	}
+	return timestamp, nil
+}
+
+// exportCommand writes the readings of a store in a format import reads back, or the rollups of the archive
+func exportCommand(args []string) int {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	sources := bindStoreFlags(flags)
+	format := flags.String("format", formatNdjson, "csv or ndjson")
+	metricName := flags.String("metric", "", "the metric to export, all if empty")
+	tier := flags.String("tier", tierRaw, "raw, the readings in the store unit by hour, or archive, the hourly rollups")
+	output := flags.String("out", "-", "the file to write, stdout if -")
+	if err := flags.Parse(args); err != nil {
+		return parseExitCode(err)
+	}
+	if *format != formatCsv && *format != formatNdjson {
+		fmt.Fprintf(os.Stderr, "Unknown format '%s', expected %s or %s\n", *format, formatCsv, formatNdjson)
+		return exitUsage
+	}
+	if *tier != tierRaw && *tier != tierArchive {
+		fmt.Fprintf(os.Stderr, "Unknown tier '%s', expected %s or %s\n", *tier, tierRaw, tierArchive)
+		return exitUsage
+	}
+	metrics := metric.Names()
+	if *metricName != "" {
+		def, err := metric.Lookup(*metricName)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitUsage
+		}
+		metrics = []string{def.Name}
+	}
+	store, _, err := openStore(sources, true)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not have opened the store: %v\n", err)
+		return exitFailure
+	}
+	defer store.Close()
+	out := os.Stdout
+	if *output != "-" {
+		if out, err = os.Create(*output); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitFailure
+		}
+	}
+	writer := newExportWriter(out, *format, *tier)
+	for _, name := range metrics {
+		if *tier == tierArchive {
+			err = store.ExportArchive(context.Background(), name, writer.writeRollup)
+		} else {
+			err = store.Export(name, writer.writeReading)
+		}
+		if err != nil {
+			break
+		}
+	}
+	if flushErr := writer.flush(); err == nil {
+		err = flushErr
+	}
+	if out != os.Stdout {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not have exported the store: %v\n", err)
+		return exitFailure
+	}
+	return 0
+}
+
+// exportWriter - writes readings or rollups as CSV with a header or as NDJSON
+type exportWriter struct {
+	buffered *bufio.Writer
+	csv      *csv.Writer
+	json     *json.Encoder
+}
+
+func newExportWriter(out io.Writer, format string, tier string) *exportWriter {
+	buffered := bufio.NewWriter(out)
+	if format == formatNdjson {
+		return &exportWriter{buffered: buffered, json: json.NewEncoder(buffered)}
+	}
+	writer := &exportWriter{buffered: buffered, csv: csv.NewWriter(buffered)}
+	if tier == tierArchive {
+		_ = writer.csv.Write([]string{"sensorId", "metric", "start", "min", "max", "sum", "count"})
+	} else {
+		_ = writer.csv.Write([]string{"sensorId", "metric", "timestamp", "value", "unit"})
+	}
+	return writer
+}
+
+func (w *exportWriter) writeReading(reading metric.Reading) error {
+	if w.json != nil {
+		return w.json.Encode(reading)
+	}
+	return w.csv.Write([]string{reading.SensorId, reading.Metric, reading.Timestamp.Format(time.RFC3339), formatFloat(reading.Value), string(reading.Unit)})
+}
+
+func (w *exportWriter) writeRollup(rollup metric.Rollup) error {
+	if w.json != nil {
+		return w.json.Encode(rollup)
+	}
+	return w.csv.Write([]string{rollup.SensorId, rollup.Metric, rollup.Start.Format(time.RFC3339), formatFloat(rollup.Min),
+		formatFloat(rollup.Max), formatFloat(rollup.Sum), strconv.Itoa(rollup.Count)})
+}
+
+func (w *exportWriter) flush() error {
+	if w.csv != nil {
+		w.csv.Flush()
+		if err := w.csv.Error(); err != nil {
+			return err
+		}
+	}
+	return w.buffered.Flush()
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// compactCommand migrates the records of a store to the current version and archives and expires old days
+func compactCommand(args []string) int {
+	flags := flag.NewFlagSet("compact", flag.ContinueOnError)
+	sources := bindStoreFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return parseExitCode(err)
+	}
+	store, _, err := openStore(sources, false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not have opened the store: %v\n", err)
+		return exitFailure
+	}
+	defer store.Close()
+	if err := store.Compact(); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not have compacted the store: %v\n", err)
+		return exitFailure
+	}
+	fmt.Fprintln(os.Stderr, "Compacted the store")
+	return 0
+}
+
+// verifyCommand lists the problems of the records of a store, it fails if any of them is an error
+func verifyCommand(args []string) int {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	sources := bindStoreFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return parseExitCode(err)
+	}
+	cfg, err := resolveStoreConfig(sources)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	if info, err := os.Stat(cfg.Storage.Path); err != nil || !info.IsDir() {
+		fmt.Fprintf(os.Stderr, "The store %s is not a directory\n", cfg.Storage.Path)
+		return exitFailure
+	}
+	// the records are read as they are, opening the store would migrate them
+	problems, err := metric.Verify(context.Background(), storage.NewFSDriver(cfg.Storage.Path))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not have listed the records: %v\n", err)
+		return exitFailure
+	}
+	errorCount := 0
+	for _, problem := range problems {
+		fmt.Println(problem)
+		if problem.Severity == metric.SeverityError {
+			errorCount++
+		}
+	}
+	fmt.Printf("%d errors, %d warnings\n", errorCount, len(problems)-errorCount)
+	if errorCount > 0 {
+		return exitFailure
+	}
+	return 0
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/andreikom/sensor-server/pkg/api/metric"
+	"github.com/andreikom/sensor-server/pkg/storage"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newStoreDir returns an empty store, the configuration is read from neither the user home nor the environment
+func newStoreDir(t *testing.T) string {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(configEnv, "")
+	return t.TempDir()
+}
+
+func writeInput(t *testing.T, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func run(t *testing.T, command func(args []string) int, args ...string) (int, string) {
+	t.Helper()
+	code := 0
+	out := captureStdout(t, func() { code = command(args) })
+	return code, out
+}
+
+func TestParseTimestamp(t *testing.T) {
+	tests := map[string]time.Time{
+		"1760000000":                time.Unix(1760000000, 0),
+		"1760000000.5":              time.Unix(1760000000, 500000000),
+		"2026-10-19T08:30:00Z":      time.Date(2026, 10, 19, 8, 30, 0, 0, time.UTC),
+		"2026-10-19T10:30:00+02:00": time.Date(2026, 10, 19, 8, 30, 0, 0, time.UTC),
+	}
+	for value, want := range tests {
+		if got, err := parseTimestamp(value); err != nil || !got.Equal(want) {
+			t.Errorf("parseTimestamp(%s) = %v, %v, want %v", value, got, err, want)
+		}
+	}
+	for _, value := range []string{"", "yesterday", "2026-10-19"} {
+		if _, err := parseTimestamp(value); err == nil {
+			t.Errorf("parseTimestamp(%q) was accepted", value)
+		}
+	}
+}
+
+func TestReadCsv(t *testing.T) {
+	defaults := metric.Reading{Metric: metric.Temperature, Unit: metric.Fahrenheit}
+	readings, err := readCsv(strings.NewReader("Sensor_Id, value, timestamp, metric, unit\nkitchen, 70, 1760000000,,\nbathroom, 55, 2026-10-19T08:30:00Z, humidity, %\n"), defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []metric.Reading{
+		{SensorId: "kitchen", Metric: metric.Temperature, Value: 70, Unit: metric.Fahrenheit, Timestamp: time.Unix(1760000000, 0)},
+		{SensorId: "bathroom", Metric: metric.Humidity, Value: 55, Unit: metric.RelativeHumidity, Timestamp: time.Date(2026, 10, 19, 8, 30, 0, 0, time.UTC)},
+	}
+	if len(readings) != len(want) {
+		t.Fatalf("read %+v", readings)
+	}
+	for i := range want {
+		if readings[i].SensorId != want[i].SensorId || readings[i].Metric != want[i].Metric || readings[i].Value != want[i].Value ||
+			readings[i].Unit != want[i].Unit || !readings[i].Timestamp.Equal(want[i].Timestamp) {
+			t.Errorf("reading %d = %+v, want %+v", i, readings[i], want[i])
+		}
+	}
+	tests := map[string]string{
+		"":                     "could not read the header",
+		"sensorId,timestamp\n": "the header has no value column",
+		"sensorId,value,timestamp\nkitchen,warm,1\n":              "line 2: invalid value",
+		"sensorId,value,timestamp\nkitchen,1,1\nkitchen,1,soon\n": "line 3: invalid timestamp",
+	}
+	for input, want := range tests {
+		if _, err := readCsv(strings.NewReader(input), defaults); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("readCsv(%q) returned %v, want %s", input, err, want)
+		}
+	}
+}
+
+func TestReadNdjson(t *testing.T) {
+	defaults := metric.Reading{Metric: metric.Temperature}
+	input := `{"sensorId":"kitchen","value":21.5,"timestamp":1760000000}
+
+{"sensorId":"bathroom","metric":"humidity","unit":"%","value":0,"timestamp":"2026-10-19T08:30:00Z"}
+`
+	readings, err := readNdjson(strings.NewReader(input), defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(readings) != 2 || readings[0].Value != 21.5 || readings[0].Metric != metric.Temperature || !readings[0].Timestamp.Equal(time.Unix(1760000000, 0)) {
+		t.Fatalf("read %+v", readings)
+	}
+	// a value of 0 is a reading, not a missing value
+	if readings[1].Value != 0 || readings[1].Metric != metric.Humidity || readings[1].Unit != metric.RelativeHumidity {
+		t.Errorf("read %+v", readings[1])
+	}
+	tests := map[string]string{
+		`{"sensorId":"kitchen","timestamp":1}`:             "line 1: the value is missing",
+		"\n" + `{"sensorId":"kitchen","value":1}`:          "line 2: the timestamp is missing",
+		`{"sensorId":"kitchen","value":"1","timestamp":1}`: "line 1: ",
+	}
+	for input, want := range tests {
+		if _, err := readNdjson(strings.NewReader(input), defaults); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("readNdjson(%q) returned %v, want %s", input, err, want)
+		}
+	}
+}
+
+func TestImportAndExportCommands(t *testing.T) {
+	store := newStoreDir(t)
+	hour := time.Now().UTC().Truncate(time.Hour).Add(-time.Hour)
+	csvFile := writeInput(t, "readings.csv", fmt.Sprintf("sensorId,value,timestamp\nkitchen,68,%d\nattic,32,%d\n", hour.Unix(), hour.Add(5*time.Minute).Unix()))
+	ndjsonFile := writeInput(t, "readings.ndjson", fmt.Sprintf(`{"sensorId":"bathroom","metric":"humidity","unit":"%%","value":55,"timestamp":"%s"}`, hour.Format(time.RFC3339)))
+	if code, _ := run(t, importCommand, "--store", store, "--unit", "F", csvFile, ndjsonFile); code != 0 {
+		t.Fatalf("import exited with %d", code)
+	}
+	code, out := run(t, exportCommand, "--store", store, "--format", "csv", "--metric", "temperature")
+	want := fmt.Sprintf("sensorId,metric,timestamp,value,unit\nattic,temperature,%[1]s,0,C\nkitchen,temperature,%[1]s,20,C\n", hour.Format(time.RFC3339))
+	if code != 0 || out != want {
+		t.Errorf("export exited with %d and wrote:\n%s", code, out)
+	}
+
+	// the NDJSON export of all metrics is imported as it is into another store
+	exported := filepath.Join(t.TempDir(), "export.ndjson")
+	if code, _ := run(t, exportCommand, "--store", store, "--out", exported); code != 0 {
+		t.Fatalf("export to a file exited with %d", code)
+	}
+	copied := t.TempDir()
+	if code, _ := run(t, importCommand, "--store", copied, exported); code != 0 {
+		t.Fatalf("import of the export exited with %d", code)
+	}
+	_, original := run(t, exportCommand, "--store", store)
+	_, copy := run(t, exportCommand, "--store", copied)
+	if original != copy || strings.Count(original, "\n") != 3 || !strings.Contains(original, `"metric":"humidity"`) {
+		t.Errorf("the copy exported:\n%s\nthe original:\n%s", copy, original)
+	}
+}
+
+func TestImportOfAMalformedFileWritesNothing(t *testing.T) {
+	store := newStoreDir(t)
+	hour := time.Now().UTC().Add(-time.Hour).Unix()
+	valid := writeInput(t, "valid.csv", fmt.Sprintf("sensorId,value,timestamp\nkitchen,20,%d\n", hour))
+	malformed := writeInput(t, "malformed.csv", fmt.Sprintf("sensorId,value,timestamp\nattic,warm,%d\n", hour))
+	invalid := writeInput(t, "invalid.ndjson", fmt.Sprintf(`{"sensorId":"attic","metric":"radiation","value":1,"timestamp":%d}`, hour))
+	for _, file := range []string{malformed, invalid} {
+		if code, _ := run(t, importCommand, "--store", store, valid, file); code != exitFailure {
+			t.Errorf("import of %s exited with %d", filepath.Base(file), code)
+		}
+	}
+	if code, out := run(t, exportCommand, "--store", store); code != 0 || out != "" {
+		t.Errorf("export exited with %d and wrote %q", code, out)
+	}
+	if code, _ := run(t, importCommand, "--store", store, "--format", "xml", valid); code != exitFailure {
+		t.Errorf("import of an unknown format exited with %d", code)
+	}
+	if code, _ := run(t, importCommand, "--store", filepath.Join(store, "missing"), valid); code != exitFailure {
+		t.Errorf("import into a missing store exited with %d", code)
+	}
+}
+
+func TestExportRejectsUnknownOptions(t *testing.T) {
+	store := newStoreDir(t)
+	for _, args := range [][]string{
+		{"--store", store, "--format", "xml"},
+		{"--store", store, "--tier", "cold"},
+		{"--store", store, "--metric", "radiation"},
+		{"--store", store, "--unknown"},
+	} {
+		if code, _ := run(t, exportCommand, args...); code != exitUsage {
+			t.Errorf("export %v exited with %d", args, code)
+		}
+	}
+}
+
+func TestCompactCommand(t *testing.T) {
+	store := newStoreDir(t)
+	old := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -4).Add(9 * time.Hour)
+	input := writeInput(t, "readings.ndjson", fmt.Sprintf(`{"sensorId":"cellar","value":8,"timestamp":%d}
+{"sensorId":"cellar","value":10,"timestamp":%d}`, old.Unix(), old.Add(time.Minute).Unix()))
+	if code, _ := run(t, importCommand, "--store", store, input); code != 0 {
+		t.Fatalf("import exited with %d", code)
+	}
+	if code, _ := run(t, compactCommand, "--store", store, "--set", "retention.rawDays=2"); code != 0 {
+		t.Fatalf("compact exited with %d", code)
+	}
+	if _, out := run(t, exportCommand, "--store", store); out != "" {
+		t.Errorf("the raw tier holds %s after compaction", out)
+	}
+	code, out := run(t, exportCommand, "--store", store, "--tier", "archive", "--format", "csv")
+	want := fmt.Sprintf("sensorId,metric,start,min,max,sum,count\ncellar,temperature,%s,8,10,18,2\n", old.Format(time.RFC3339))
+	if code != 0 || out != want {
+		t.Errorf("export of the archive exited with %d and wrote:\n%s", code, out)
+	}
+}
+
+func TestOnlyCompactRewritesOldRecords(t *testing.T) {
+	store := newStoreDir(t)
+	path := filepath.Join(store, "temperatures", "kitchen.json")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	legacy := `{"id":"kitchen","version":1,"unit":"K","dates":{"03-14-2022":[{"hour":9,"temp":[293.15]}]}}`
+	if err := os.WriteFile(path, []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hour := time.Date(2022, time.March, 14, 9, 0, 0, 0, time.Local).UTC()
+	if code, out := run(t, queryCommand, "--store", store, "--sensor", "kitchen", "--date", hour.Format("2006-01-02"), "--tz", "UTC"); code != 0 || out != "20.00\n" {
+		t.Errorf("query exited with %d and wrote %q", code, out)
+	}
+	want := fmt.Sprintf("sensorId,metric,timestamp,value,unit\nkitchen,temperature,%s,20,C\n", hour.Format(time.RFC3339))
+	if code, out := run(t, exportCommand, "--store", store, "--format", "csv"); code != 0 || out != want {
+		t.Errorf("export exited with %d and wrote:\n%s", code, out)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != legacy {
+		t.Fatalf("query and export rewrote the record: %s, %v", data, err)
+	}
+	if code, _ := run(t, compactCommand, "--store", store); code != 0 {
+		t.Fatalf("compact exited with %d", code)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := metric.Sensor{}
+	if err := json.Unmarshal(data, &record); err != nil || record.Version <= 1 || record.Unit != metric.Celsius {
+		t.Errorf("compact did not rewrite the record: %s, %v", data, err)
+	}
+}
+
+func TestVerifyCommand(t *testing.T) {
+	store := newStoreDir(t)
+	input := writeInput(t, "readings.ndjson", fmt.Sprintf(`{"sensorId":"kitchen","value":20,"timestamp":%d}`, time.Now().Add(-time.Hour).Unix()))
+	if code, _ := run(t, importCommand, "--store", store, input); code != 0 {
+		t.Fatalf("import exited with %d", code)
+	}
+	if code, out := run(t, verifyCommand, "--store", store); code != 0 || out != "0 errors, 0 warnings\n" {
+		t.Errorf("verify of a healthy store exited with %d and wrote %q", code, out)
+	}
+	if err := storage.NewFSDriver(store).SaveSensorData(context.Background(), metric.Temperature, "attic", []byte(`{"id":`)); err != nil {
+		t.Fatal(err)
+	}
+	code, out := run(t, verifyCommand, "--store", store)
+	if code != exitFailure || !strings.Contains(out, "error: temperature raw of sensor attic: could not be parsed") || !strings.HasSuffix(out, "1 errors, 0 warnings\n") {
+		t.Errorf("verify of a corrupt store exited with %d and wrote:\n%s", code, out)
+	}
+	if code, _ := run(t, verifyCommand, "--store", filepath.Join(store, "missing")); code != exitFailure {
+		t.Errorf("verify of a missing store exited with %d", code)
+	}
+}
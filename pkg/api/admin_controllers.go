@@ -0,0 +1,153 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/andreikom/sensor-server/pkg/api/metric"
+	"github.com/andreikom/sensor-server/pkg/auth"
+	"github.com/andreikom/sensor-server/pkg/logging"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strconv"
+)
+
+type adminController struct {
+	metricService *metric.MetricService
+	reloader      *reloader
+	keys          *auth.KeyStore
+}
+
+// keyRequest - the body of POST /admin/keys
+type keyRequest struct {
+	Name    string       `json:"name"`
+	Scopes  []auth.Scope `json:"scopes"`
+	Sensors []string     `json:"sensors"`
+	Tags    []string     `json:"tags"`
+}
+
+// createdKey - the response of POST /admin/keys, Token is not stored and cannot be read again
+type createdKey struct {
+	auth.Key
+	Token string `json:"key"`
+}
+
+func (c *adminController) GetDeadLetters(w http.ResponseWriter, req *http.Request) {
+	limit, ok := queryLimit(w, req)
+	if !ok {
+		return
+	}
+	letters, err := c.metricService.InspectDeadLetters(limit)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Could not have read the dead letter queue: %s", err), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(letters); err != nil {
+		logging.FromContext(req.Context()).WithError(err).WithField("endpoint", "deadletters").Warn("Could not write a response")
+	}
+}
+
+func (c *adminController) ReplayDeadLetters(w http.ResponseWriter, req *http.Request) {
+	limit, ok := queryLimit(w, req)
+	if !ok {
+		return
+	}
+	replayed, err := c.metricService.ReplayDeadLetters(limit)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Replayed %d dead letters before failing: %s", replayed, err), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]int{"replayed": replayed}); err != nil {
+		logging.FromContext(req.Context()).WithError(err).WithField("endpoint", "deadletters replay").Warn("Could not write a response")
+	}
+}
+
+// ReloadConfig serves POST /admin/config/reload, answering which changed settings were applied and which
+// need a restart. An invalid configuration is rejected with 422 and nothing is applied
+func (c *adminController) ReloadConfig(w http.ResponseWriter, req *http.Request) {
+	report, err := c.reloader.reload()
+	if errors.Is(err, errReloadUnsupported) {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		logging.FromContext(req.Context()).WithError(err).Warn("Could not have reloaded the configuration, keeping the current one")
+		http.Error(w, fmt.Sprintf("Could not have reloaded the configuration: %s", err), http.StatusUnprocessableEntity)
+		return
+	}
+	logReload(report)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		logging.FromContext(req.Context()).WithError(err).WithField("endpoint", "config reload").Warn("Could not write a response")
+	}
+}
+
+// ListKeys serves GET /admin/keys, the keys are listed without their secrets
+func (c *adminController) ListKeys(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(c.keys.List()); err != nil {
+		logging.FromContext(req.Context()).WithError(err).WithField("endpoint", "keys").Warn("Could not write a response")
+	}
+}
+
+// CreateKey serves POST /admin/keys, answering 201 with the new key
+func (c *adminController) CreateKey(w http.ResponseWriter, req *http.Request) {
+	keyReq := &keyRequest{}
+	if err := json.NewDecoder(req.Body).Decode(keyReq); err != nil {
+		http.Error(w, fmt.Sprintf("Could not parse the payload: %s", err), http.StatusBadRequest)
+		return
+	}
+	if keyReq.Name == "" {
+		http.Error(w, "the name of the key is missing", http.StatusBadRequest)
+		return
+	}
+	token, key, err := c.keys.Create(keyReq.Name, keyReq.Scopes, keyReq.Sensors, keyReq.Tags)
+	var invalid *auth.InvalidKeyError
+	if errors.As(err, &invalid) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		logging.FromContext(req.Context()).WithError(err).Error("Could not have saved an API key")
+		http.Error(w, "Could not have saved the API key", http.StatusInternalServerError)
+		return
+	}
+	logging.FromContext(req.Context()).WithField("id", key.Id).WithField("name", key.Name).Info("Created an API key")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(createdKey{Key: key, Token: token}); err != nil {
+		logging.FromContext(req.Context()).WithError(err).WithField("endpoint", "keys").Warn("Could not write a response")
+	}
+}
+
+// RevokeKey serves DELETE /admin/keys/{id}
+func (c *adminController) RevokeKey(w http.ResponseWriter, req *http.Request) {
+	id := mux.Vars(req)["id"]
+	err := c.keys.Revoke(id)
+	if errors.Is(err, auth.ErrKeyNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	logging.FromContext(req.Context()).WithField("id", id).Info("Revoked an API key")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// queryLimit resolves the optional 'limit' query parameter, 0 means the default limit
+func queryLimit(w http.ResponseWriter, req *http.Request) (int, bool) {
+	rawLimit := req.URL.Query().Get("limit")
+	if rawLimit == "" {
+		return 0, true
+	}
+	limit, err := strconv.Atoi(rawLimit)
+	if err != nil || limit < 0 {
+		http.Error(w, "invalid limit '"+rawLimit+"'", http.StatusBadRequest)
+		return 0, false
+	}
+	return limit, true
+}
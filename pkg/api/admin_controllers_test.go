@@ -0,0 +1,54 @@
+package api
+
+import (
+	"github.com/andreikom/sensor-server/pkg/auth"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCreateKeyStatuses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	keys, err := auth.OpenKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	controller := &adminController{keys: keys}
+	create := func(payload string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		controller.CreateKey(rec, httptest.NewRequest(http.MethodPost, "/admin/keys", strings.NewReader(payload)))
+		return rec
+	}
+	tests := []struct {
+		name    string
+		payload string
+		status  int
+		body    string
+	}{
+		{"malformed payload", `{"name":`, http.StatusBadRequest, "Could not parse the payload"},
+		{"missing name", `{"scopes":["read"]}`, http.StatusBadRequest, "the name of the key is missing"},
+		{"missing scopes", `{"name":"grafana"}`, http.StatusBadRequest, "at least one scope"},
+		{"unknown scope", `{"name":"grafana","scopes":["root"]}`, http.StatusBadRequest, "root"},
+		{"valid key", `{"name":"grafana","scopes":["read"]}`, http.StatusCreated, `"key":"ssk_`},
+	}
+	for _, test := range tests {
+		rec := create(test.payload)
+		if rec.Code != test.status || !strings.Contains(rec.Body.String(), test.body) {
+			t.Errorf("%s: answered %d with %q, want %d", test.name, rec.Code, rec.Body.String(), test.status)
+		}
+	}
+
+	// the keys file is replaced by renaming a temporary file, which cannot be written over a directory
+	if err := os.Mkdir(path+".tmp", 0700); err != nil {
+		t.Fatal(err)
+	}
+	if rec := create(`{"name":"telegraf","scopes":["ingest"]}`); rec.Code != http.StatusInternalServerError {
+		t.Errorf("a key which could not be saved answered %d with %q", rec.Code, rec.Body.String())
+	}
+	if listed := keys.List(); len(listed) != 1 {
+		t.Errorf("the store holds %d keys, want the one saved", len(listed))
+	}
+}
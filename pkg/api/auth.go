@@ -0,0 +1,91 @@
+package api
+
+import (
+	"github.com/andreikom/sensor-server/pkg/auth"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strings"
+)
+
+// ingestPaths - the write routes, the sensor ids of their bodies are checked by the controllers
+var ingestPaths = map[string]bool{
+	"/metric/":      true,
+	"/temp/":        true,
+	"/api/v2/write": true,
+	"/write":        true,
+	"/api/v1/write": true,
+}
+
+// httpRule - the probes are public, the stats of a sensor need the read scope, and the Prometheus query API
+// and /metrics, which span all sensors, a key which is not restricted to some sensors
+func httpRule(r *http.Request) auth.Rule {
+	path := r.URL.Path
+	switch {
+	case path == "/healthz" || path == "/readyz":
+		return auth.Rule{Public: true}
+	case strings.HasPrefix(path, "/admin/"):
+		return auth.Rule{Scope: auth.Admin, AllSensors: true}
+	case r.Method == http.MethodPost && ingestPaths[path]:
+		return auth.Rule{Scope: auth.Ingest}
+	case path == "/metric/" || mux.Vars(r)["sensorId"] != "":
+		return auth.Rule{Scope: auth.Read}
+	default:
+		return auth.Rule{Scope: auth.Read, AllSensors: true}
+	}
+}
+
+// adminRule - the rule of the HTTP API if API keys are disabled, the /admin/ routes, which manage the keys and
+// replay or reload what the server runs with, still need a key with the admin scope
+func adminRule(r *http.Request) auth.Rule {
+	if strings.HasPrefix(r.URL.Path, "/admin/") {
+		return httpRule(r)
+	}
+	return auth.Rule{Public: true}
+}
+
+// hasAdminKey - whether anyone may call the /admin/ routes with a key of keys
+func hasAdminKey(keys *auth.KeyStore) bool {
+	for _, key := range keys.List() {
+		for _, scope := range key.Scopes {
+			if scope == auth.Admin {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// grpcRule - health checks and reflection are public, the Save calls need the ingest scope and the others read
+func grpcRule(method string) auth.Rule {
+	if strings.HasPrefix(method, "/grpc.health.v1.Health/") || strings.HasPrefix(method, "/grpc.reflection.") {
+		return auth.Rule{Public: true}
+	}
+	if strings.HasPrefix(method[strings.LastIndex(method, "/")+1:], "Save") {
+		return auth.Rule{Scope: auth.Ingest}
+	}
+	return auth.Rule{Scope: auth.Read}
+}
+
+// newTokenVerifier returns nil unless JWTs of an OIDC provider are accepted
+func newTokenVerifier(cfg *Config) (*auth.TokenVerifier, error) {
+	oidc := cfg.Auth.Oidc
+	if !cfg.Auth.Enabled || oidc.Issuer == "" {
+		return nil, nil
+	}
+	roleMapping := make(map[string]auth.Role, len(oidc.RoleMapping))
+	for value, role := range oidc.RoleMapping {
+		roleMapping[value] = auth.Role(role)
+	}
+	return auth.NewTokenVerifier(auth.OidcOptions{
+		Issuer:       oidc.Issuer,
+		Audience:     oidc.Audience,
+		JwksFile:     oidc.JwksFile,
+		JwksUrl:      oidc.JwksUrl,
+		JwksRefresh:  oidc.JwksRefresh.Duration(),
+		Leeway:       oidc.Leeway.Duration(),
+		RolesClaim:   oidc.RolesClaim,
+		RoleMapping:  roleMapping,
+		SensorsClaim: oidc.SensorsClaim,
+		TagsClaim:    oidc.TagsClaim,
+	})
+}
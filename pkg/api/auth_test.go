@@ -0,0 +1,173 @@
+package api
+
+import (
+	"bytes"
+	"github.com/andreikom/sensor-server/pkg/api/metric/metrictest"
+	"github.com/andreikom/sensor-server/pkg/api/temperature"
+	"github.com/andreikom/sensor-server/pkg/auth"
+	"github.com/andreikom/sensor-server/pkg/health"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newAuthRouter returns the HTTP API with the keys of an admin and a reader, authEnabled as Auth.Enabled
+func newAuthRouter(t *testing.T, authEnabled bool) (http.Handler, map[string]string) {
+	t.Helper()
+	service, _ := metrictest.NewSpoolingService(t)
+	connProcessing = newThrottle(maxConnections)
+	keys, err := auth.OpenKeyStore(filepath.Join(t.TempDir(), "keys.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hasAdminKey(keys) {
+		t.Error("an empty key store has an admin key")
+	}
+	tokens := make(map[string]string)
+	for name, scope := range map[string]auth.Scope{"admin": auth.Admin, "reader": auth.Read, "ingest": auth.Ingest} {
+		if tokens[name], _, err = keys.Create(name, []auth.Scope{scope}, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !hasAdminKey(keys) {
+		t.Error("the admin key was not found")
+	}
+	cfg := DefaultConfig()
+	cfg.Auth.Enabled = authEnabled
+	httpServer := newHttpServer(&tempController{tempService: temperature.NewTempService(service)}, &metricController{metricService: service},
+		&adminController{metricService: service, keys: keys}, &influxController{metricService: service}, &promController{metricService: service},
+		&healthController{checker: health.NewChecker(), started: time.Now()}, auth.NewAuthenticator(keys, nil, nil), cfg)
+	return httpServer.Handler, tokens
+}
+
+func TestAdminRoutesNeedAKey(t *testing.T) {
+	for _, authEnabled := range []bool{false, true} {
+		router, tokens := newAuthRouter(t, authEnabled)
+		tests := []struct {
+			method string
+			path   string
+			token  string
+			status int
+		}{
+			{"GET", "/admin/keys", "", http.StatusUnauthorized},
+			{"POST", "/admin/keys", "", http.StatusUnauthorized},
+			{"DELETE", "/admin/keys/0123456789abcdef", "", http.StatusUnauthorized},
+			{"GET", "/admin/deadletters", "", http.StatusUnauthorized},
+			{"POST", "/admin/deadletters/replay", "", http.StatusUnauthorized},
+			{"POST", "/admin/config/reload", "", http.StatusUnauthorized},
+			{"GET", "/admin/keys", "reader", http.StatusForbidden},
+			{"POST", "/admin/config/reload", "ingest", http.StatusForbidden},
+			{"GET", "/admin/keys", "admin", http.StatusOK},
+			{"DELETE", "/admin/keys/0123456789abcdef", "admin", http.StatusNotFound},
+			{"GET", "/healthz", "", http.StatusOK},
+		}
+		for _, test := range tests {
+			req := httptest.NewRequest(test.method, test.path, nil)
+			if test.token != "" {
+				req.Header.Set("X-API-Key", tokens[test.token])
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != test.status {
+				t.Errorf("auth enabled %v: %s %s with %q = %d, want %d", authEnabled, test.method, test.path, test.token, rec.Code, test.status)
+			}
+		}
+	}
+}
+
+func TestReadingsNeedAKeyIfAuthIsEnabled(t *testing.T) {
+	for _, authEnabled := range []bool{false, true} {
+		router, tokens := newAuthRouter(t, authEnabled)
+		for _, token := range []string{"", "reader", "ingest"} {
+			req := httptest.NewRequest(http.MethodPost, "/metric/", bytes.NewBufferString(`{"sensorId":"kitchen","metric":"temperature","value":21}`))
+			if token != "" {
+				req.Header.Set("X-API-Key", tokens[token])
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			want := http.StatusOK
+			switch {
+			case authEnabled && token == "":
+				want = http.StatusUnauthorized
+			case authEnabled && token == "reader":
+				want = http.StatusForbidden
+			}
+			if rec.Code != want {
+				t.Errorf("auth enabled %v: POST /metric/ with %q = %d, want %d", authEnabled, token, rec.Code, want)
+			}
+		}
+	}
+}
+
+func TestHttpRule(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		rule   auth.Rule
+	}{
+		{"GET", "/readyz", auth.Rule{Public: true}},
+		{"POST", "/admin/config/reload", auth.Rule{Scope: auth.Admin, AllSensors: true}},
+		{"POST", "/api/v2/write", auth.Rule{Scope: auth.Ingest}},
+		{"GET", "/metric/", auth.Rule{Scope: auth.Read}},
+		{"GET", "/api/v1/query", auth.Rule{Scope: auth.Read, AllSensors: true}},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.path, nil)
+		if rule := httpRule(req); rule != test.rule {
+			t.Errorf("httpRule(%s %s) = %+v, want %+v", test.method, test.path, rule, test.rule)
+		}
+	}
+	if rule := adminRule(httptest.NewRequest("POST", "/api/v2/write", nil)); !rule.Public {
+		t.Errorf("adminRule of a write = %+v", rule)
+	}
+	if rule := adminRule(httptest.NewRequest("GET", "/admin/keys", nil)); rule.Public || rule.Scope != auth.Admin {
+		t.Errorf("adminRule of /admin/keys = %+v", rule)
+	}
+	if rule := grpcRule("/sensor.MetricService/SaveMetrics"); rule.Scope != auth.Ingest {
+		t.Errorf("grpcRule of a save = %+v", rule)
+	}
+	if rule := grpcRule("/grpc.health.v1.Health/Watch"); !rule.Public {
+		t.Errorf("grpcRule of the health service = %+v", rule)
+	}
+}
+
+func TestNewTokenVerifier(t *testing.T) {
+	issuer, err := auth.NewIssuer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks, err := issuer.Jwks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig()
+	cfg.Auth.Oidc.Issuer = "https://id.example.com"
+	cfg.Auth.Oidc.JwksFile = filepath.Join(t.TempDir(), "jwks.json")
+	cfg.Auth.Oidc.RoleMapping = map[string]string{"sensor-admins": "admin"}
+	if err := os.WriteFile(cfg.Auth.Oidc.JwksFile, jwks, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// tokens are only accepted if API keys are enabled
+	if verifier, err := newTokenVerifier(cfg); verifier != nil || err != nil {
+		t.Errorf("newTokenVerifier with auth disabled = %v, %v", verifier, err)
+	}
+	cfg.Auth.Enabled = true
+	verifier, err := newTokenVerifier(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := issuer.Sign(map[string]interface{}{"iss": "https://id.example.com", "sub": "ops", "roles": []string{"sensor-admins"}}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if principal, err := verifier.Authenticate(token); err != nil || !principal.HasScope(auth.Admin) {
+		t.Errorf("Authenticate = %+v, %v", principal, err)
+	}
+	cfg.Auth.Oidc.RoleMapping = map[string]string{"sensor-admins": "root"}
+	if _, err := newTokenVerifier(cfg); err == nil {
+		t.Error("a mapping to an unknown role was accepted")
+	}
+}
@@ -0,0 +1,238 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/andreikom/sensor-server/pkg/api/metric"
+	"github.com/andreikom/sensor-server/pkg/api/metric/metrictest"
+	"github.com/andreikom/sensor-server/pkg/api/temperature"
+	"github.com/andreikom/sensor-server/pkg/broker"
+	"github.com/andreikom/sensor-server/pkg/client"
+	"github.com/andreikom/sensor-server/pkg/health"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+)
+
+// apiClients - the clients of the HTTP and gRPC APIs of a server whose readings are spooled
+type apiClients struct {
+	service *metric.MetricService
+	spool   *broker.Spool
+	http    *client.Client
+	grpc    *client.GrpcClient
+}
+
+func newApiClients(t *testing.T) *apiClients {
+	t.Helper()
+	service, spool := metrictest.NewSpoolingService(t)
+	connProcessing = newThrottle(maxConnections)
+	tempService := temperature.NewTempService(service)
+	checker := health.NewChecker()
+	checker.Add("cache", func(ctx context.Context) error { return nil })
+	cfg := DefaultConfig()
+	httpServer := newHttpServer(&tempController{tempService: tempService}, &metricController{metricService: service},
+		&adminController{metricService: service}, &influxController{metricService: service}, &promController{metricService: service},
+		&healthController{checker: checker, started: time.Now()}, nil, cfg)
+	server := httptest.NewServer(httpServer.Handler)
+	t.Cleanup(server.Close)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	grpcServer := newGrpcServer(tempService, service, checker, nil)
+	go func() { _ = grpcServer.Serve(listener) }()
+	t.Cleanup(grpcServer.Stop)
+
+	options := client.Options{Address: server.URL, GrpcAddress: listener.Addr().String(), Timeout: 5 * time.Second, MaxRetries: -1}
+	httpClient, err := client.New(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grpcClient, err := client.NewGrpc(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = grpcClient.Close() })
+	return &apiClients{service: service, spool: spool, http: httpClient, grpc: grpcClient}
+}
+
+// spooled returns the readings sent so far as "sensorId metric value", sorted
+func (c *apiClients) spooled(t *testing.T) []string {
+	t.Helper()
+	readings := make([]string, 0)
+	for _, msg := range metrictest.SpooledReadings(t, c.spool) {
+		readings = append(readings, fmt.Sprintf("%s %s %v", msg.SensorId, msg.Metric, math.Round(msg.Value*1e9)/1e9))
+	}
+	sort.Strings(readings)
+	return readings
+}
+
+func TestClientsSave(t *testing.T) {
+	clients := newApiClients(t)
+	ctx := context.Background()
+	savers := map[string]interface {
+		Save(ctx context.Context, reading client.Reading) error
+	}{"http": clients.http, "grpc": clients.grpc}
+	for name, saver := range savers {
+		if err := saver.Save(ctx, client.Reading{SensorId: "kitchen", Metric: metric.Temperature, Value: 68, Unit: "F"}); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if err := saver.Save(ctx, client.Reading{SensorId: "bathroom", Metric: metric.Humidity, Value: 55}); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got := fmt.Sprint(clients.spooled(t)); got != "[bathroom humidity 55 kitchen temperature 20]" {
+			t.Errorf("%s: spooled %s", name, got)
+		}
+		// rejected by the server
+		if err := saver.Save(ctx, client.Reading{SensorId: "kitchen", Metric: "radiation", Value: 1}); err == nil {
+			t.Errorf("%s: an unknown metric was saved", name)
+		}
+		// rejected by the client
+		if err := saver.Save(ctx, client.Reading{SensorId: "kitchen", Value: 1}); !errors.Is(err, client.ErrInvalidReading) {
+			t.Errorf("%s: a reading without metric returned %v", name, err)
+		}
+	}
+	// a timestamped reading is written as a line
+	if err := clients.http.Save(ctx, client.Reading{SensorId: "attic", Metric: metric.Temperature, Value: 12, Time: time.Now().Add(-time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(clients.spooled(t)); got != "[attic temperature 12]" {
+		t.Errorf("spooled %s", got)
+	}
+	if err := clients.grpc.Save(ctx, client.Reading{SensorId: "attic", Metric: metric.Temperature, Value: 12, Time: time.Now()}); !errors.Is(err, client.ErrTimestampUnsupported) {
+		t.Errorf("the gRPC client returned %v for a timestamped reading", err)
+	}
+}
+
+func TestClientsSaveBatch(t *testing.T) {
+	clients := newApiClients(t)
+	ctx := context.Background()
+	now := time.Now().Add(-time.Minute)
+	if err := clients.http.SaveBatch(ctx, []client.Reading{
+		{SensorId: "living-room", Metric: metric.Temperature, Value: 21, Time: now},
+		{SensorId: "kitchen", Metric: metric.Temperature, Value: 32, Unit: "F", Time: now},
+		{SensorId: "bathroom", Metric: metric.Humidity, Value: 60},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(clients.spooled(t)); got != "[bathroom humidity 60 kitchen temperature 0 living-room temperature 21]" {
+		t.Errorf("spooled %s", got)
+	}
+	if err := clients.grpc.SaveBatch(ctx, []client.Reading{
+		{SensorId: "kitchen", Metric: metric.Temperature, Value: 20},
+		{SensorId: "attic", Metric: metric.Temperature, Value: 11},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(clients.spooled(t)); got != "[attic temperature 11 kitchen temperature 20]" {
+		t.Errorf("spooled %s", got)
+	}
+	// a batch with an invalid reading is not sent at all
+	for name, saver := range map[string]client.Saver{"http": clients.http, "grpc": clients.grpc} {
+		err := saver.SaveBatch(ctx, []client.Reading{{SensorId: "kitchen", Metric: metric.Temperature, Value: 20}, {SensorId: "kitchen", Value: 1}})
+		if !errors.Is(err, client.ErrInvalidReading) {
+			t.Errorf("%s: SaveBatch returned %v", name, err)
+		}
+		if spooled := clients.spooled(t); len(spooled) != 0 {
+			t.Errorf("%s: spooled %v", name, spooled)
+		}
+	}
+	// the server rejects the whole batch
+	err := clients.http.SaveBatch(ctx, []client.Reading{
+		{SensorId: "kitchen", Metric: metric.Temperature, Value: 20},
+		{SensorId: "kitchen", Metric: metric.Temperature, Value: 20, Time: time.Now().Add(48 * time.Hour)},
+	})
+	statusErr := &client.Error{}
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("SaveBatch with a reading in the future returned %v", err)
+	}
+	if spooled := clients.spooled(t); len(spooled) != 0 {
+		t.Errorf("spooled %v of a rejected batch", spooled)
+	}
+}
+
+func TestClientsStat(t *testing.T) {
+	clients := newApiClients(t)
+	hour := time.Now().UTC().Truncate(time.Hour).Add(-time.Hour)
+	if err := clients.service.Import(context.Background(), []metric.Reading{
+		{SensorId: "kitchen", Metric: metric.Temperature, Value: 20, Timestamp: hour},
+		{SensorId: "kitchen", Metric: metric.Temperature, Value: 25, Timestamp: hour.Add(time.Minute)},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	date := hour.Format("2006-01-02")
+	tests := []struct {
+		query client.StatQuery
+		want  float64
+	}{
+		{client.StatQuery{Period: client.Daily, Stat: client.Max}, 25},
+		{client.StatQuery{Period: client.Daily, Stat: client.Min, Unit: "F"}, 68},
+		{client.StatQuery{Period: client.Weekly, Stat: client.Avg}, 22.5},
+		{client.StatQuery{Period: client.Monthly, Stat: client.Min}, 20},
+	}
+	for name, stats := range map[string]interface {
+		Stat(ctx context.Context, query client.StatQuery) (float64, error)
+	}{"http": clients.http, "grpc": clients.grpc} {
+		for _, test := range tests {
+			query := test.query
+			query.SensorId, query.Metric, query.Date, query.Timezone = "kitchen", metric.Temperature, date, "UTC"
+			if got, err := stats.Stat(context.Background(), query); err != nil || got != test.want {
+				t.Errorf("%s: %s %s = %v, %v, want %v", name, query.Period, query.Stat, got, err, test.want)
+			}
+		}
+		if _, err := stats.Stat(context.Background(), client.StatQuery{SensorId: "attic", Metric: metric.Temperature, Period: client.Daily, Stat: client.Max, Date: date}); err == nil {
+			t.Errorf("%s: an unknown sensor returned a statistic", name)
+		}
+	}
+	if _, err := clients.grpc.Stat(context.Background(), client.StatQuery{SensorId: "kitchen", Metric: metric.Temperature, Period: "yearly", Stat: client.Max}); err == nil {
+		t.Error("the gRPC client accepted an unknown period")
+	}
+	if err := clients.http.Ready(context.Background()); err != nil {
+		t.Errorf("http: Ready returned %v", err)
+	}
+	if err := clients.grpc.Ready(context.Background()); err != nil {
+		t.Errorf("grpc: Ready returned %v", err)
+	}
+	names, err := clients.http.Metrics(context.Background())
+	if err != nil || len(names) != len(metric.Names()) {
+		t.Errorf("Metrics = %v, %v", names, err)
+	}
+}
+
+func TestClientsBatcherAndStream(t *testing.T) {
+	clients := newApiClients(t)
+	batcher := client.NewBatcher(clients.http, client.BatchOptions{Size: 2, Interval: time.Hour})
+	for i := 0; i < 3; i++ {
+		batcher.Add(client.Reading{SensorId: "kitchen", Metric: metric.Temperature, Value: float64(20 + i)})
+	}
+	if err := batcher.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(clients.spooled(t)); got != "[kitchen temperature 20 kitchen temperature 21 kitchen temperature 22]" {
+		t.Errorf("the batcher spooled %s", got)
+	}
+
+	readings := make(chan client.Reading)
+	done := make(chan error)
+	go func() {
+		done <- client.Stream(context.Background(), clients.grpc, readings, client.BatchOptions{Size: 2, Interval: 10 * time.Millisecond})
+	}()
+	for i := 0; i < 5; i++ {
+		readings <- client.Reading{SensorId: fmt.Sprintf("sensor-%d", i), Metric: metric.Humidity, Value: 50}
+	}
+	// the server rejects the reading, the stream goes on and reports it at the end
+	readings <- client.Reading{SensorId: "sensor-5", Metric: "radiation", Value: 1}
+	close(readings)
+	if err := <-done; err == nil {
+		t.Error("Stream did not report the rejected reading")
+	}
+	spooled := clients.spooled(t)
+	if len(spooled) != 5 || spooled[0] != "sensor-0 humidity 50" {
+		t.Errorf("the stream spooled %v", spooled)
+	}
+}
@@ -1,7 +1,17 @@
 package api
 
 import (
+	"errors"
+	"fmt"
+	"github.com/andreikom/sensor-server/pkg/api/metric"
+	"github.com/andreikom/sensor-server/pkg/broker"
+	"github.com/andreikom/sensor-server/pkg/config"
+	"github.com/andreikom/sensor-server/pkg/logging"
+	"github.com/andreikom/sensor-server/pkg/utils"
 	"github.com/go-playground/validator/v10"
+	"io/fs"
+	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -10,16 +20,266 @@ const (
 	readTimeout    = 5 * time.Second
 	maxConnections = 10
 	daysToKeep     = 7
+	spoolFile      = "spool/metrics.ndjson"
+	keysFile       = "auth/keys.json"
+	// defaultMqttTopic - the sensor id and metric name are the second and third topic levels
+	defaultMqttTopic = "sensors/+/+"
+	// defaultShutdownTimeout - how long a shutdown waits for requests and readings in progress
+	defaultShutdownTimeout = 30 * time.Second
+	grpcAddress            = ":9000"
+	pprofAddress           = "localhost:6060"
+	// EnvPrefix - settings are overridden by variables named after their path, e.g. SENSOR_SERVER_SERVER_PORT
+	// for server.port or SENSOR_SERVER_BROKER_URL for broker.url
+	EnvPrefix = "SENSOR_SERVER_"
+	// legacyPortEnv - the only variable read before settings could be overridden by EnvPrefix variables
+	legacyPortEnv = "sensor_server_port"
 )
 
 type Config struct {
 	Server struct {
-		Port           int `yaml:"port" validate:"numeric,min=1024"`
-		MaxConnections int `yaml:"maxConnections" validate:"numeric,min=1"`
+		// Host - the interface the HTTP API listens on, all of them if empty
+		Host string `yaml:"host"`
+		Port int    `yaml:"port" validate:"min=1024,max=65535"`
+		// MaxConnections - requests handled at once, the following ones wait for a slot
+		MaxConnections int `yaml:"maxConnections" validate:"min=1"`
+		// ReadTimeout - how long a client may take to send a request, e.g. "5s"
+		ReadTimeout config.Duration `yaml:"readTimeout" validate:"min=0"`
+		// ShutdownTimeout - how long a shutdown waits for requests and readings in progress, e.g. "45s"
+		ShutdownTimeout config.Duration `yaml:"shutdownTimeout" validate:"min=1"`
 	}
+	Grpc struct {
+		Address string `yaml:"address" validate:"required"`
+	}
+	// Pprof - the profiling endpoints, keep them on a local interface
+	Pprof struct {
+		Enabled bool   `yaml:"enabled"`
+		Address string `yaml:"address" validate:"required_if=Enabled true"`
+	}
+	Storage struct {
+		// Path - the directory readings are stored in
+		Path string `yaml:"path" validate:"required"`
+	}
+	Logging struct {
+		// Format - json or logfmt (default)
+		Format string `yaml:"format" validate:"omitempty,oneof=json logfmt"`
+		// Level - trace, debug, info (default), warn or error
+		Level string `yaml:"level" validate:"omitempty,oneof=trace debug info warn warning error"`
+	}
+	// Tracing - OpenTelemetry spans of requests, readings on the broker and storage calls, disabled if Exporter is empty
+	Tracing struct {
+		// Exporter - otlp, to the OTLP/HTTP receiver of a collector at Endpoint, or stdout
+		Exporter string `yaml:"exporter" validate:"omitempty,oneof=otlp stdout"`
+		// Endpoint - host:port or url of the OTLP/HTTP receiver, localhost:4318 if empty
+		Endpoint    string `yaml:"endpoint"`
+		ServiceName string `yaml:"serviceName"`
+		// SampleRatio - the share of traces started by the server which are recorded, all if empty
+		SampleRatio float64 `yaml:"sampleRatio" validate:"omitempty,gt=0,max=1"`
+	}
+	// Locations - IANA timezones defining the day and week boundaries of queries without an explicit 'tz'
+	Locations struct {
+		Default string            `yaml:"default" validate:"omitempty,timezone"`
+		Sensors map[string]string `yaml:"sensors" validate:"dive,timezone"`
+	}
+	Calendar struct {
+		// WeekStart - first day of the weeks of weekly statistics, monday (ISO-8601) if empty
+		WeekStart string `yaml:"weekStart" validate:"omitempty,oneof=monday tuesday wednesday thursday friday saturday sunday"`
+	}
+	Retention struct {
+		// RawDays - days of raw readings kept before they are rolled up into the archive tier
+		RawDays int `yaml:"rawDays" validate:"min=1"`
+		// ArchiveDays - days of hourly rollups kept in the archive tier, forever if empty
+		ArchiveDays int `yaml:"archiveDays" validate:"omitempty,min=1"`
+	}
+	Broker struct {
+		// MaxRedeliveries - attempts to store a reading before it is moved to the dead letter queue
+		MaxRedeliveries int `yaml:"maxRedeliveries" validate:"omitempty,min=1"`
+		// Url - the AMQP url of RabbitMQ
+		Url string `yaml:"url" validate:"required,url" secret:"url"`
+		// SpoolPath - the file readings are buffered in while the broker is unavailable, under Storage.Path if empty
+		SpoolPath string `yaml:"spoolPath"`
+		// SpoolMaxMessages - readings the spool holds before new ones are rejected
+		SpoolMaxMessages int `yaml:"spoolMaxMessages" validate:"omitempty,min=1"`
+	}
+	// Mqtt - subscribes readings published by devices to an MQTT broker, disabled if BrokerUrl is empty
+	Mqtt struct {
+		BrokerUrl string `yaml:"brokerUrl" validate:"omitempty,url"`
+		ClientId  string `yaml:"clientId"`
+		Username  string `yaml:"username"`
+		Password  string `yaml:"password" secret:"true"`
+		// Topics - the topics readings are subscribed on and ingested from by the embedded broker,
+		// defaultMqttTopic if empty
+		Topics []MqttTopicConfig `yaml:"topics" validate:"dive"`
+		// Broker - an embedded broker devices publish to directly
+		Broker struct {
+			Enabled bool   `yaml:"enabled"`
+			Address string `yaml:"address"`
+			// Sensors - sensorId -> password, or its bcrypt hash, devices log in with their sensor id as the username
+			Sensors        map[string]string `yaml:"sensors" validate:"dive,required" secret:"true"`
+			AllowAnonymous bool              `yaml:"allowAnonymous"`
+		}
+	}
+	// Ingest - listeners for compact "sensorId value [timestamp]" and CBOR readings of constrained devices
+	Ingest struct {
+		// UdpAddress and TcpAddress enable the listeners, e.g. ":8094"
+		UdpAddress string `yaml:"udpAddress"`
+		TcpAddress string `yaml:"tcpAddress"`
+		// Secret - the shared HMAC-SHA256 secret readings must be signed with, unsigned readings are accepted if empty
+		Secret string `yaml:"secret" secret:"true"`
+		// RatePerSecond and Burst limit the readings accepted from a single host
+		RatePerSecond float64 `yaml:"ratePerSecond" validate:"omitempty,gt=0"`
+		Burst         int     `yaml:"burst" validate:"omitempty,min=1"`
+	}
+	// Coap - a CoAP endpoint for posting and observing temperatures, disabled if Address is empty, e.g. ":5683"
+	Coap struct {
+		Address string `yaml:"address"`
+	}
+	Prometheus struct {
+		// SensorLabels - the labels the sensor id of a remote written series is looked up in, sensor, sensorId
+		// and sensor_id if empty
+		SensorLabels []string `yaml:"sensorLabels"`
+	}
+	// Metrics - the Prometheus /metrics endpoint
+	Metrics struct {
+		// SensorValues - also expose the latest reading of every sensor as a gauge
+		SensorValues bool `yaml:"sensorValues"`
+	}
+	// Auth - API keys required by the HTTP and gRPC APIs, anyone reaching their ports may read and write
+	// readings if it is disabled. The /admin/ routes need a key with the admin scope either way
+	Auth struct {
+		Enabled bool `yaml:"enabled"`
+		// KeysFile - the hashed API keys, managed with the keys command or /admin/keys, under Storage.Path if empty
+		KeysFile string `yaml:"keysFile"`
+		// SensorTags - sensorId -> tags, keys and tokens scoped to a tag may access the sensors carrying it
+		SensorTags map[string][]string `yaml:"sensorTags"`
+		// Oidc - bearer JWTs of an OpenID Connect provider, accepted besides the API keys if Issuer is set
+		Oidc struct {
+			// Issuer - the 'iss' of the tokens, their JWKS is discovered from its openid-configuration
+			// unless JwksFile or JwksUrl is set
+			Issuer   string `yaml:"issuer" validate:"required_with=JwksFile JwksUrl"`
+			Audience string `yaml:"audience"`
+			// JwksFile - a local JWK set, e.g. the one of the oidc keygen command, read again on reloads
+			JwksFile string `yaml:"jwksFile"`
+			JwksUrl  string `yaml:"jwksUrl" validate:"omitempty,url"`
+			// JwksRefresh - how often JwksUrl is fetched again, e.g. "15m", hourly if empty
+			JwksRefresh config.Duration `yaml:"jwksRefresh" validate:"min=0"`
+			// Leeway - the clock skew tolerated for the expiry of tokens, e.g. "30s"
+			Leeway config.Duration `yaml:"leeway" validate:"min=0"`
+			// RolesClaim - the claim of the roles or groups of the subject, a dotted path such as realm_access.roles,
+			// roles if empty
+			RolesClaim string `yaml:"rolesClaim"`
+			// RoleMapping - claim value -> viewer, operator or admin, values which are not mapped are taken as
+			// role names
+			RoleMapping map[string]string `yaml:"roleMapping" validate:"dive,oneof=viewer operator admin"`
+			// SensorsClaim and TagsClaim restrict the subject to some sensors, sensors and sensor_tags if empty
+			SensorsClaim string `yaml:"sensorsClaim"`
+			TagsClaim    string `yaml:"tagsClaim"`
+		}
+	}
+	Influx struct {
+		// SensorTags - the line protocol tags the sensor id of a line is looked up in, sensorId, sensor_id and sensor if empty
+		SensorTags []string `yaml:"sensorTags"`
+	}
+}
+
+// MqttTopicConfig - a subscription, the first '+' level of the topic is the sensor id and an optional second
+// one the metric name, e.g. sensors/+/temperature or sensors/+/+
+type MqttTopicConfig struct {
+	Topic string `yaml:"topic" validate:"required"`
+	// Metric - the metric of topics without a metric level, temperature if empty
+	Metric string `yaml:"metric"`
+	Qos    byte   `yaml:"qos" validate:"max=2"`
+	// Format - raw, json or auto (default)
+	Format string `yaml:"format" validate:"omitempty,oneof=raw json auto"`
+}
+
+// ConfigSources - the layers of a configuration, each one overriding the settings of the previous ones:
+// the defaults, File, the EnvPrefix variables of Environ and Overrides
+type ConfigSources struct {
+	// File - a YAML file, it is skipped if it does not exist unless FileRequired is set
+	File         string
+	FileRequired bool
+	// Environ - "NAME=value" pairs, usually os.Environ()
+	Environ []string
+	// Overrides - "path=value" pairs, e.g. "server.port=8081" or "logging.level=debug"
+	Overrides []string
+}
+
+// DefaultConfig returns the settings used unless they are overridden
+func DefaultConfig() *Config {
+	cfg := &Config{}
+	cfg.Server.Host = "localhost"
+	cfg.Server.Port = defaultPort
+	cfg.Server.MaxConnections = maxConnections
+	cfg.Server.ReadTimeout = config.Duration(readTimeout)
+	cfg.Server.ShutdownTimeout = config.Duration(defaultShutdownTimeout)
+	cfg.Grpc.Address = grpcAddress
+	cfg.Pprof.Enabled = true
+	cfg.Pprof.Address = pprofAddress
+	cfg.Storage.Path = utils.GetUserHome()
+	cfg.Logging.Format = logging.Logfmt
+	cfg.Logging.Level = "info"
+	cfg.Retention.RawDays = daysToKeep
+	cfg.Broker.Url = broker.DefaultUrl
+	cfg.Broker.SpoolMaxMessages = broker.DefaultSpoolMaxMessages
+	return cfg
+}
+
+// ResolveConfig layers sources onto the defaults, the result is not validated
+func ResolveConfig(sources ConfigSources) (*Config, error) {
+	cfg := DefaultConfig()
+	if sources.File != "" {
+		err := config.LoadFile(cfg, sources.File)
+		if err != nil && (sources.FileRequired || !errors.Is(err, fs.ErrNotExist)) {
+			return nil, fmt.Errorf("could not load the config file %s: %w", sources.File, err)
+		}
+	}
+	for _, variable := range sources.Environ {
+		if strings.HasPrefix(variable, legacyPortEnv+"=") {
+			if err := config.Set(cfg, "server.port", strings.TrimPrefix(variable, legacyPortEnv+"=")); err != nil {
+				return nil, fmt.Errorf("%s: %w", legacyPortEnv, err)
+			}
+		}
+	}
+	if _, err := config.ApplyEnv(cfg, EnvPrefix, sources.Environ); err != nil {
+		return nil, err
+	}
+	for _, override := range sources.Overrides {
+		parts := strings.SplitN(override, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid override '%s', expected path=value", override)
+		}
+		if err := config.Set(cfg, strings.TrimSpace(parts[0]), parts[1]); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Broker.SpoolPath == "" {
+		cfg.Broker.SpoolPath = filepath.Join(cfg.Storage.Path, spoolFile)
+	}
+	if cfg.Auth.KeysFile == "" {
+		cfg.Auth.KeysFile = filepath.Join(cfg.Storage.Path, keysFile)
+	}
+	return cfg, nil
+}
+
+// Validate checks the settings of cfg and the values only their subsystems can parse, such as timezones and
+// MQTT topics
+func Validate(cfg *Config) error {
+	if err := validator.New().Struct(cfg); err != nil {
+		return err
+	}
+	if _, err := metric.NewLocations(cfg.Locations.Default, cfg.Locations.Sensors); err != nil {
+		return fmt.Errorf("invalid locations configuration: %w", err)
+	}
+	if _, err := metric.ParseWeekday(cfg.Calendar.WeekStart); err != nil {
+		return fmt.Errorf("invalid calendar configuration: %w", err)
+	}
+	if _, err := mqttTopics(cfg); err != nil {
+		return fmt.Errorf("invalid mqtt configuration: %w", err)
+	}
+	return nil
 }
 
-func validate(cfg *Config) error {
-	validate := validator.New()
-	return validate.Struct(cfg)
+// Redacted returns a copy of cfg without its passwords and secrets, to be printed
+func (c *Config) Redacted() *Config {
+	return config.Redact(c).(*Config)
 }
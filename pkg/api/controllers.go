@@ -3,12 +3,15 @@ package api
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/andreikom/sensor-server/pkg/api/metric"
 	"github.com/andreikom/sensor-server/pkg/api/temperature"
+	"github.com/andreikom/sensor-server/pkg/auth"
+	"github.com/andreikom/sensor-server/pkg/logging"
 	"github.com/gorilla/mux"
 	"net/http"
-	"strconv"
 )
 
+// tempController - serves the /temp/ routes kept as compatibility aliases of the temperature metric
 // TODO [andreik]: later we will change this to grpc client +/or rabbitmq client
 type tempController struct {
 	tempService *temperature.TempService
@@ -18,14 +21,27 @@ func (c *tempController) SaveTemp(w http.ResponseWriter, req *http.Request) {
 	sensorIdTemp := &temperature.SensorIdTempJson{}
 	err := json.NewDecoder(req.Body).Decode(&sensorIdTemp)
 	if err != nil {
-		combinedErr := fmt.Sprintf("Could not have parse the payload: %d\n", err)
-		fmt.Println(combinedErr)
+		combinedErr := fmt.Sprintf("Could not have parse the payload: %s", err)
+		logging.FromContext(req.Context()).WithError(err).Warn("Could not have parse the payload")
 		http.Error(w, combinedErr, http.StatusBadRequest)
 		return
 	}
-	if err := c.tempService.SaveTemperature(sensorIdTemp.SensorId, sensorIdTemp.Temp); err != nil {
-		combinedErr := fmt.Sprintf("Could not have saved to storage: %d\n", err)
-		fmt.Println(combinedErr)
+	if err := auth.AllowSensor(req.Context(), sensorIdTemp.SensorId); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	unit, err := temperature.ParseUnit(sensorIdTemp.Unit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := metric.ValidateSensorId(sensorIdTemp.SensorId); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := c.tempService.SaveTemperature(req.Context(), sensorIdTemp.SensorId, sensorIdTemp.Temp, unit); err != nil {
+		combinedErr := fmt.Sprintf("Could not have saved to storage: %s", err)
+		logging.FromContext(req.Context()).WithError(err).WithField("sensorId", sensorIdTemp.SensorId).Error("Could not have saved to storage")
 		http.Error(w, combinedErr, http.StatusInternalServerError)
 	} else {
 		w.WriteHeader(http.StatusOK)
@@ -36,10 +52,18 @@ func (c *tempController) GetDailyMaxTemp(w http.ResponseWriter, req *http.Reques
 	vars := mux.Vars(req)
 	sensorId := vars["sensorId"]
 	date := vars["date"]
-	maxTemp, err := c.tempService.GetDailyMaxTempByDateAndById(sensorId, date)
+	unit, ok := queryUnit(w, req)
+	if !ok {
+		return
+	}
+	loc, ok := queryLocation(w, req)
+	if !ok {
+		return
+	}
+	maxTemp, err := c.tempService.GetDailyMaxTempByDateAndById(sensorId, date, unit, loc)
 	if err == nil {
-		if _, err := w.Write([]byte(strconv.Itoa(maxTemp))); err != nil {
-			fmt.Printf("Error while writing response to daily_max endpoint: %s", err)
+		if _, err := w.Write([]byte(metric.FormatReading(maxTemp))); err != nil {
+			logging.FromContext(req.Context()).WithError(err).WithField("endpoint", "daily_max").Warn("Could not write a response")
 		}
 	} else {
 		http.Error(w, err.Error(), http.StatusNotImplemented)
@@ -49,10 +73,18 @@ func (c *tempController) GetDailyMaxTemp(w http.ResponseWriter, req *http.Reques
 func (c *tempController) GetWeeklyMaxTemp(w http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
 	sensorId := vars["sensorId"]
-	maxTemp, err := c.tempService.GetDailyWeeklyMaxTempBySensorId(sensorId)
+	unit, ok := queryUnit(w, req)
+	if !ok {
+		return
+	}
+	loc, ok := queryLocation(w, req)
+	if !ok {
+		return
+	}
+	maxTemp, err := c.tempService.GetWeeklyMaxTempByDateAndById(sensorId, vars["date"], unit, loc)
 	if err == nil {
-		if _, err := w.Write([]byte(strconv.Itoa(maxTemp))); err != nil {
-			fmt.Printf("Error while writing response to daily_max endpoint: %s", err)
+		if _, err := w.Write([]byte(metric.FormatReading(maxTemp))); err != nil {
+			logging.FromContext(req.Context()).WithError(err).WithField("endpoint", "daily_max").Warn("Could not write a response")
 		}
 	} else {
 		http.Error(w, err.Error(), http.StatusNotImplemented)
@@ -63,10 +95,18 @@ func (c *tempController) GetDailyMinTemp(w http.ResponseWriter, req *http.Reques
 	vars := mux.Vars(req)
 	sensorId := vars["sensorId"]
 	date := vars["date"]
-	minTemp, err := c.tempService.GetDailyMinTempByDateAndById(sensorId, date)
+	unit, ok := queryUnit(w, req)
+	if !ok {
+		return
+	}
+	loc, ok := queryLocation(w, req)
+	if !ok {
+		return
+	}
+	minTemp, err := c.tempService.GetDailyMinTempByDateAndById(sensorId, date, unit, loc)
 	if err == nil {
-		if _, err := w.Write([]byte(strconv.Itoa(minTemp))); err != nil {
-			fmt.Printf("Error while writing response to daily_min endpoint: %s", err)
+		if _, err := w.Write([]byte(metric.FormatReading(minTemp))); err != nil {
+			logging.FromContext(req.Context()).WithError(err).WithField("endpoint", "daily_min").Warn("Could not write a response")
 		}
 	} else {
 		http.Error(w, err.Error(), http.StatusNotImplemented)
@@ -76,10 +116,18 @@ func (c *tempController) GetDailyMinTemp(w http.ResponseWriter, req *http.Reques
 func (c *tempController) GetWeeklyMinTemp(w http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
 	sensorId := vars["sensorId"]
-	minTemp, err := c.tempService.GetDailyWeeklyMaxTempBySensorId(sensorId)
+	unit, ok := queryUnit(w, req)
+	if !ok {
+		return
+	}
+	loc, ok := queryLocation(w, req)
+	if !ok {
+		return
+	}
+	minTemp, err := c.tempService.GetWeeklyMinTempByDateAndById(sensorId, vars["date"], unit, loc)
 	if err == nil {
-		if _, err := w.Write([]byte(strconv.Itoa(minTemp))); err != nil {
-			fmt.Printf("Error while writing response to daily_max endpoint: %s", err)
+		if _, err := w.Write([]byte(metric.FormatReading(minTemp))); err != nil {
+			logging.FromContext(req.Context()).WithError(err).WithField("endpoint", "daily_max").Warn("Could not write a response")
 		}
 	} else {
 		http.Error(w, err.Error(), http.StatusNotImplemented)
@@ -90,10 +138,18 @@ func (c *tempController) GetDailyAvgTemp(w http.ResponseWriter, req *http.Reques
 	vars := mux.Vars(req)
 	sensorId := vars["sensorId"]
 	date := vars["date"]
-	maxTemp, err := c.tempService.GetDailyAvgTempByDateAndById(sensorId, date)
+	unit, ok := queryUnit(w, req)
+	if !ok {
+		return
+	}
+	loc, ok := queryLocation(w, req)
+	if !ok {
+		return
+	}
+	maxTemp, err := c.tempService.GetDailyAvgTempByDateAndById(sensorId, date, unit, loc)
 	if err == nil {
-		if _, err := w.Write([]byte(fmt.Sprintf("%f", maxTemp))); err != nil {
-			fmt.Printf("Error while writing response to daily_min endpoint: %s", err)
+		if _, err := w.Write([]byte(metric.FormatReading(maxTemp))); err != nil {
+			logging.FromContext(req.Context()).WithError(err).WithField("endpoint", "daily_min").Warn("Could not write a response")
 		}
 	} else {
 		http.Error(w, err.Error(), http.StatusNotImplemented)
@@ -103,19 +159,37 @@ func (c *tempController) GetDailyAvgTemp(w http.ResponseWriter, req *http.Reques
 func (c *tempController) GetWeeklySensorAvgTemp(w http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
 	sensorId := vars["sensorId"]
-	maxTemp, err := c.tempService.GetDailyWeeklyAvgTempBySensorId(sensorId)
+	unit, ok := queryUnit(w, req)
+	if !ok {
+		return
+	}
+	loc, ok := queryLocation(w, req)
+	if !ok {
+		return
+	}
+	maxTemp, err := c.tempService.GetWeeklyAvgTempByDateAndById(sensorId, vars["date"], unit, loc)
 	if err == nil {
-		if _, err := w.Write([]byte(fmt.Sprintf("%f", maxTemp))); err != nil {
-			fmt.Printf("Error while writing response to daily_min endpoint: %s", err)
+		if _, err := w.Write([]byte(metric.FormatReading(maxTemp))); err != nil {
+			logging.FromContext(req.Context()).WithError(err).WithField("endpoint", "daily_min").Warn("Could not write a response")
 		}
 	} else {
 		http.Error(w, err.Error(), http.StatusNotImplemented)
 	}
 }
 
+// queryUnit resolves the optional 'unit' query parameter, writes a bad request response if it is not supported
+func queryUnit(w http.ResponseWriter, req *http.Request) (temperature.Unit, bool) {
+	unit, err := temperature.ParseUnit(req.URL.Query().Get("unit"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	return unit, true
+}
+
 func errMethodNotImplemented(w http.ResponseWriter, endpoint string) {
 	w.WriteHeader(http.StatusInternalServerError)
 	if _, err := w.Write([]byte(http.StatusText(http.StatusNotImplemented))); err != nil {
-		fmt.Printf("Error while writing response to %s API\n", endpoint)
+		logging.Log.WithError(err).WithField("endpoint", endpoint).Warn("Could not write a response")
 	}
 }
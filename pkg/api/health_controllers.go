@@ -0,0 +1,43 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/andreikom/sensor-server/pkg/health"
+	"github.com/andreikom/sensor-server/pkg/logging"
+	"net/http"
+	"time"
+)
+
+// healthController - the probes of orchestrators, they are not throttled so they are answered under load
+type healthController struct {
+	checker *health.Checker
+	started time.Time
+}
+
+// Liveness serves GET /healthz, the process is alive as long as it answers
+func (c *healthController) Liveness(w http.ResponseWriter, req *http.Request) {
+	writeHealth(w, req, http.StatusOK, map[string]interface{}{
+		"status":        health.StatusOk,
+		"uptimeSeconds": int64(time.Since(c.started).Seconds()),
+	})
+}
+
+// Readiness serves GET /readyz, 503 unless the cache is loaded, the broker is connected and the storage is
+// writable. The status of every dependency is listed under "checks"
+func (c *healthController) Readiness(w http.ResponseWriter, req *http.Request) {
+	report := c.checker.Run(req.Context())
+	status := http.StatusOK
+	if !report.Ok() {
+		status = http.StatusServiceUnavailable
+	}
+	writeHealth(w, req, status, report)
+}
+
+func writeHealth(w http.ResponseWriter, req *http.Request, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		logging.FromContext(req.Context()).WithError(err).Warn("Could not write a health response")
+	}
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/andreikom/sensor-server/pkg/api/metric"
+	"github.com/andreikom/sensor-server/pkg/broker"
+	"github.com/andreikom/sensor-server/pkg/health"
+	"github.com/andreikom/sensor-server/pkg/storage"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func probe(t *testing.T, handler http.HandlerFunc, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest("GET", "/readyz", nil))
+	if contentType := rec.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("Content-Type = %q", contentType)
+	}
+	if cacheControl := rec.Header().Get("Cache-Control"); cacheControl != "no-store" {
+		t.Errorf("Cache-Control = %q", cacheControl)
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), body); err != nil {
+		t.Fatalf("%v: %s", err, rec.Body)
+	}
+	return rec
+}
+
+func TestLiveness(t *testing.T) {
+	controller := &healthController{checker: health.NewChecker(), started: time.Now().Add(-90 * time.Second)}
+	controller.checker.ShutDown()
+	body := struct {
+		Status        string `json:"status"`
+		UptimeSeconds int64  `json:"uptimeSeconds"`
+	}{}
+	// the process is alive while it shuts down
+	if rec := probe(t, controller.Liveness, &body); rec.Code != http.StatusOK || body.Status != health.StatusOk || body.UptimeSeconds < 90 {
+		t.Errorf("GET /healthz = %d %+v", rec.Code, body)
+	}
+}
+
+func TestReadinessOfTheServerDependencies(t *testing.T) {
+	dir := t.TempDir()
+	spool, err := broker.OpenSpool(filepath.Join(dir, "spool", "messages.jsonl"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsDriver := storage.NewFSDriver(dir)
+	brokerClient := broker.NewClient(broker.Options{}, spool)
+	service := metric.NewMetricService(fsDriver, brokerClient, metric.Options{})
+	t.Cleanup(service.Close)
+	controller := &healthController{checker: newHealthChecker(fsDriver, brokerClient, service), started: time.Now()}
+
+	// RabbitMQ was never reached
+	report := health.Report{}
+	if rec := probe(t, controller.Readiness, &report); rec.Code != http.StatusServiceUnavailable || report.Status != health.StatusFail {
+		t.Errorf("GET /readyz = %d %+v", rec.Code, report)
+	}
+	if result := report.Checks["broker"]; result.Status != health.StatusFail || result.Error != broker.ErrNotConnected.Error() {
+		t.Errorf("the broker result is %+v", result)
+	}
+	for _, name := range []string{"cache", "storage"} {
+		if result := report.Checks[name]; result.Status != health.StatusOk {
+			t.Errorf("the %s result is %+v", name, result)
+		}
+	}
+
+	// a store that can no longer be written to
+	if err := os.Chmod(dir, 0500); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(dir, 0700)
+	if os.Getuid() != 0 {
+		report = health.Report{}
+		probe(t, controller.Readiness, &report)
+		if result := report.Checks["storage"]; result.Status != health.StatusFail {
+			t.Errorf("the storage result of a read only store is %+v", result)
+		}
+	}
+}
+
+func TestReadinessOfAReadyServer(t *testing.T) {
+	checker := health.NewChecker()
+	checker.Add("storage", storage.NewFSDriver(t.TempDir()).CheckWritable)
+	controller := &healthController{checker: checker, started: time.Now()}
+	report := health.Report{}
+	if rec := probe(t, controller.Readiness, &report); rec.Code != http.StatusOK || !report.Ok() {
+		t.Errorf("GET /readyz = %d %+v", rec.Code, report)
+	}
+	checker.ShutDown()
+	report = health.Report{}
+	if rec := probe(t, controller.Readiness, &report); rec.Code != http.StatusServiceUnavailable || report.Checks["storage"].Error != health.ErrShuttingDown.Error() {
+		t.Errorf("GET /readyz while shutting down = %d %+v", rec.Code, report)
+	}
+}
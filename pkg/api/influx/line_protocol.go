@@ -0,0 +1,216 @@
+package influx
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Point - a line of InfluxDB line protocol: measurement,tag=value field=value timestamp
+type Point struct {
+	Measurement string
+	Tags        map[string]string
+	// Fields - float64, int64, uint64, string or bool values
+	Fields map[string]interface{}
+	Time   time.Time
+	// Line - the line of the batch the point was parsed from, counts from 1
+	Line int
+}
+
+// LineError - a line which could not be parsed, Line counts from 1
+type LineError struct {
+	Line int
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+var precisions = map[string]time.Duration{
+	"":   time.Nanosecond,
+	"n":  time.Nanosecond,
+	"ns": time.Nanosecond,
+	"u":  time.Microsecond,
+	"us": time.Microsecond,
+	"µ":  time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+}
+
+// ParsePrecision resolves the 'precision' query parameter of the 1.x and 2.x write APIs, nanoseconds if empty
+func ParsePrecision(precision string) (time.Duration, error) {
+	unit, ok := precisions[precision]
+	if !ok {
+		return 0, fmt.Errorf("invalid precision '%s'", precision)
+	}
+	return unit, nil
+}
+
+// Parse parses a line protocol batch, lines without a timestamp are taken at now.
+// Blank lines and comments are skipped, the first malformed line fails the whole batch
+func Parse(data []byte, precision time.Duration, now time.Time) ([]Point, error) {
+	points := make([]Point, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), len(data)+1)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		point, err := parseLine(line, precision, now)
+		if err != nil {
+			return nil, &LineError{Line: lineNumber, Err: err}
+		}
+		point.Line = lineNumber
+		points = append(points, point)
+	}
+	return points, scanner.Err()
+}
+
+func parseLine(line string, precision time.Duration, now time.Time) (Point, error) {
+	seriesEnd := indexUnescaped(line, ' ', false)
+	if seriesEnd < 0 {
+		return Point{}, fmt.Errorf("missing fields")
+	}
+	series := splitUnescaped(line[:seriesEnd], ',', false)
+	point := Point{
+		Measurement: unescape(series[0]),
+		Tags:        make(map[string]string, len(series)-1),
+		Fields:      make(map[string]interface{}),
+		Time:        now,
+	}
+	if point.Measurement == "" {
+		return Point{}, fmt.Errorf("missing measurement")
+	}
+	for _, tag := range series[1:] {
+		key, value, err := splitPair(tag, false)
+		if err != nil {
+			return Point{}, fmt.Errorf("invalid tag '%s': %w", tag, err)
+		}
+		point.Tags[key] = value
+	}
+	rest := strings.TrimLeft(line[seriesEnd:], " ")
+	fieldsEnd := indexUnescaped(rest, ' ', true)
+	rawTimestamp := ""
+	if fieldsEnd >= 0 {
+		rawTimestamp = strings.TrimSpace(rest[fieldsEnd:])
+		rest = rest[:fieldsEnd]
+	}
+	if rest == "" {
+		return Point{}, fmt.Errorf("missing fields")
+	}
+	for _, field := range splitUnescaped(rest, ',', true) {
+		key, rawValue, err := splitPair(field, true)
+		if err != nil {
+			return Point{}, fmt.Errorf("invalid field '%s': %w", field, err)
+		}
+		value, err := parseFieldValue(rawValue)
+		if err != nil {
+			return Point{}, fmt.Errorf("invalid value of field '%s': %w", key, err)
+		}
+		point.Fields[key] = value
+	}
+	if rawTimestamp != "" {
+		timestamp, err := strconv.ParseInt(rawTimestamp, 10, 64)
+		if err != nil {
+			return Point{}, fmt.Errorf("invalid timestamp '%s'", rawTimestamp)
+		}
+		nanos := timestamp * int64(precision)
+		if timestamp != 0 && nanos/int64(precision) != timestamp {
+			return Point{}, fmt.Errorf("timestamp '%s' out of range", rawTimestamp)
+		}
+		point.Time = time.Unix(0, nanos).UTC()
+	}
+	return point, nil
+}
+
+func parseFieldValue(raw string) (interface{}, error) {
+	if raw == "" {
+		return nil, fmt.Errorf("missing value")
+	}
+	if strings.HasPrefix(raw, "\"") {
+		if len(raw) < 2 || !strings.HasSuffix(raw, "\"") {
+			return nil, fmt.Errorf("unterminated string %s", raw)
+		}
+		return strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(raw[1 : len(raw)-1]), nil
+	}
+	switch raw {
+	case "t", "T", "true", "True", "TRUE":
+		return true, nil
+	case "f", "F", "false", "False", "FALSE":
+		return false, nil
+	}
+	switch raw[len(raw)-1] {
+	case 'i':
+		return strconv.ParseInt(raw[:len(raw)-1], 10, 64)
+	case 'u':
+		return strconv.ParseUint(raw[:len(raw)-1], 10, 64)
+	}
+	return strconv.ParseFloat(raw, 64)
+}
+
+// splitPair splits key=value on the first unescaped '=', the value of a field keeps its quotes and escapes
+func splitPair(pair string, field bool) (string, string, error) {
+	i := indexUnescaped(pair, '=', false)
+	if i <= 0 {
+		return "", "", fmt.Errorf("missing '='")
+	}
+	key := unescape(pair[:i])
+	value := pair[i+1:]
+	if !field {
+		value = unescape(value)
+		if value == "" {
+			return "", "", fmt.Errorf("missing value")
+		}
+	}
+	return key, value, nil
+}
+
+// indexUnescaped returns the index of the first sep not preceded by a backslash, and outside double
+// quotes if quoted is set
+func indexUnescaped(s string, sep byte, quoted bool) int {
+	inQuotes := false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case quoted && s[i] == '"':
+			inQuotes = !inQuotes
+		case s[i] == sep && !inQuotes:
+			return i
+		}
+	}
+	return -1
+}
+
+func splitUnescaped(s string, sep byte, quoted bool) []string {
+	parts := make([]string, 0)
+	for {
+		i := indexUnescaped(s, sep, quoted)
+		if i < 0 {
+			return append(parts, s)
+		}
+		parts = append(parts, s[:i])
+		s = s[i+1:]
+	}
+}
+
+// unescape removes the backslashes escaping commas, equal signs and spaces of measurements, keys and tag values
+func unescape(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	return strings.NewReplacer(`\,`, `,`, `\=`, `=`, `\ `, ` `).Replace(s)
+}
@@ -0,0 +1,89 @@
+package influx
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	now := time.Date(2022, time.March, 14, 9, 0, 0, 0, time.UTC)
+	batch := `# a comment
+climate,sensor=kitchen temperature=21.5,humidity=40i,ok=true,note="a \"quoted\" value, with = signs" 1647248400000000000
+
+my\ climate,sensor=living\ room,zone=a\,b count=7u,flag=F
+`
+	points, err := Parse([]byte(batch), time.Nanosecond, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 2 {
+		t.Fatalf("parsed %d points, want 2", len(points))
+	}
+	first := points[0]
+	if first.Measurement != "climate" || first.Tags["sensor"] != "kitchen" || first.Line != 2 {
+		t.Errorf("first point = %+v", first)
+	}
+	if !first.Time.Equal(time.Unix(0, 1647248400000000000)) {
+		t.Errorf("first point taken at %s", first.Time)
+	}
+	wantFields := map[string]interface{}{"temperature": 21.5, "humidity": int64(40), "ok": true, "note": `a "quoted" value, with = signs`}
+	for key, want := range wantFields {
+		if first.Fields[key] != want {
+			t.Errorf("field %s = %#v, want %#v", key, first.Fields[key], want)
+		}
+	}
+	second := points[1]
+	if second.Measurement != "my climate" || second.Tags["sensor"] != "living room" || second.Tags["zone"] != "a,b" || second.Line != 4 {
+		t.Errorf("second point = %+v", second)
+	}
+	if second.Fields["count"] != uint64(7) || second.Fields["flag"] != false {
+		t.Errorf("second point fields = %#v", second.Fields)
+	}
+	// lines without a timestamp are taken at now
+	if !second.Time.Equal(now) {
+		t.Errorf("second point taken at %s, want %s", second.Time, now)
+	}
+}
+
+func TestParsePrecision(t *testing.T) {
+	for precision, want := range map[string]time.Duration{"": time.Nanosecond, "ns": time.Nanosecond, "us": time.Microsecond, "ms": time.Millisecond, "s": time.Second, "h": time.Hour} {
+		got, err := ParsePrecision(precision)
+		if err != nil || got != want {
+			t.Errorf("ParsePrecision(%q) = %v, %v, want %v", precision, got, err, want)
+		}
+	}
+	if _, err := ParsePrecision("d"); err == nil {
+		t.Error("ParsePrecision accepted an unknown precision")
+	}
+	points, err := Parse([]byte("t,sensor=a temperature=1 1647248400"), time.Second, time.Now())
+	if err != nil || !points[0].Time.Equal(time.Unix(1647248400, 0)) {
+		t.Errorf("a timestamp in seconds parsed as %v, %v", points, err)
+	}
+	if _, err := Parse([]byte("t,sensor=a temperature=1 9223372036854775807"), time.Second, time.Now()); err == nil {
+		t.Error("a timestamp overflowing nanoseconds was accepted")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	lines := []string{
+		"climate",
+		"climate,sensor=kitchen",
+		",sensor=kitchen temperature=1",
+		"climate,sensor temperature=1",
+		"climate,sensor= temperature=1",
+		"climate temperature",
+		"climate temperature=",
+		"climate temperature=warm",
+		`climate note="unterminated`,
+		"climate temperature=1i2",
+		"climate temperature=1 yesterday",
+	}
+	for _, line := range lines {
+		_, err := Parse([]byte("climate,sensor=kitchen temperature=1\n"+line), time.Nanosecond, time.Now())
+		var lineErr *LineError
+		if !errors.As(err, &lineErr) || lineErr.Line != 2 {
+			t.Errorf("%q: got %v, want an error of line 2", line, err)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package influx
+
+import (
+	"fmt"
+	"github.com/andreikom/sensor-server/pkg/api/metric"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// ValueField - the field holding the reading of a measurement named after a metric
+	ValueField = "value"
+	// UnitTag - the optional tag carrying the unit of the readings of a line, it applies to the metrics
+	// having such a unit. A <metric>_unit tag sets the unit of a single metric
+	UnitTag = "unit"
+)
+
+// DefaultSensorTags - the tags the sensor id of a line is looked up in, in order
+var DefaultSensorTags = []string{"sensorId", "sensor_id", "sensor"}
+
+// Reading - a reading mapped from a point
+type Reading struct {
+	SensorId string
+	Metric   string
+	Value    float64
+	Unit     metric.Unit
+	Time     time.Time
+}
+
+// Readings maps points onto sensor readings. A field named after a metric, or the ValueField of a
+// measurement named after a metric, is a reading of that metric:
+//
+//	climate,sensor=kitchen temperature=21.5,humidity=40i 1630000000000000000
+//	temperature,sensor=kitchen,unit=F value=70.7
+//	climate,sensor=kitchen,temperature_unit=F temperature=70.7,humidity=40i
+//
+// Other fields are skipped and counted. A point with a metric reading but no sensor tag, or with a unit
+// tag none of its readings can be reported in, is a LineError
+func Readings(points []Point, sensorTags []string) ([]Reading, int, error) {
+	if len(sensorTags) == 0 {
+		sensorTags = DefaultSensorTags
+	}
+	readings := make([]Reading, 0, len(points))
+	skipped := 0
+	for _, point := range points {
+		if err := checkUnitTag(point); err != nil {
+			return nil, skipped, &LineError{Line: point.Line, Err: err}
+		}
+		for field, rawValue := range point.Fields {
+			def, err := fieldMetric(point, field)
+			if err != nil {
+				skipped++
+				continue
+			}
+			metricName := def.Name
+			value, ok := numericValue(rawValue)
+			if !ok {
+				skipped++
+				continue
+			}
+			sensorId := sensorIdOf(point, sensorTags)
+			if sensorId == "" {
+				return nil, skipped, &LineError{Line: point.Line, Err: fmt.Errorf("%s reading of measurement '%s' has none of the sensor tags %v", metricName, point.Measurement, sensorTags)}
+			}
+			unit, err := unitOf(point, metricName)
+			if err != nil {
+				return nil, skipped, &LineError{Line: point.Line, Err: err}
+			}
+			readings = append(readings, Reading{SensorId: sensorId, Metric: metricName, Value: value, Unit: unit, Time: point.Time})
+		}
+	}
+	return readings, skipped, nil
+}
+
+// fieldMetric resolves the metric a field is a reading of
+func fieldMetric(point Point, field string) (*metric.Definition, error) {
+	def, err := metric.Lookup(field)
+	if err != nil && field == ValueField {
+		def, err = metric.Lookup(point.Measurement)
+	}
+	return def, err
+}
+
+// checkUnitTag rejects a UnitTag which is not a unit of any metric reading of the point, it would
+// silently fall back to the store unit of every one of them
+func checkUnitTag(point Point) error {
+	unit, ok := point.Tags[UnitTag]
+	if !ok {
+		return nil
+	}
+	metricNames := make([]string, 0, len(point.Fields))
+	for field := range point.Fields {
+		def, err := fieldMetric(point, field)
+		if err != nil {
+			continue
+		}
+		if _, err := def.ParseUnit(unit); err == nil {
+			return nil
+		}
+		metricNames = append(metricNames, def.Name)
+	}
+	if len(metricNames) == 0 {
+		return nil
+	}
+	sort.Strings(metricNames)
+	return fmt.Errorf("the %s tag '%s' is not a unit of %s", UnitTag, unit, strings.Join(metricNames, ", "))
+}
+
+func unitOf(point Point, metricName string) (metric.Unit, error) {
+	if unit, ok := point.Tags[metricName+"_"+UnitTag]; ok {
+		return metric.ParseUnit(metricName, unit)
+	}
+	if unit, err := metric.ParseUnit(metricName, point.Tags[UnitTag]); err == nil {
+		return unit, nil
+	}
+	return metric.ParseUnit(metricName, "")
+}
+
+func sensorIdOf(point Point, sensorTags []string) string {
+	for _, tag := range sensorTags {
+		if sensorId, ok := point.Tags[tag]; ok {
+			return sensorId
+		}
+	}
+	return ""
+}
+
+func numericValue(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	}
+	return 0, false
+}
@@ -0,0 +1,95 @@
+package influx
+
+import (
+	"errors"
+	"github.com/andreikom/sensor-server/pkg/api/metric"
+	"sort"
+	"testing"
+	"time"
+)
+
+func parseReadings(t *testing.T, batch string, sensorTags []string) ([]Reading, int, error) {
+	t.Helper()
+	points, err := Parse([]byte(batch), time.Nanosecond, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	readings, skipped, err := Readings(points, sensorTags)
+	sort.Slice(readings, func(i, j int) bool {
+		return readings[i].Metric < readings[j].Metric
+	})
+	return readings, skipped, err
+}
+
+func TestReadings(t *testing.T) {
+	batch := `climate,sensor=kitchen temperature=21.5,humidity=40i,battery=97,status="ok"
+temperature,sensorId=hallway,unit=F value=70.7
+climate,sensor_id=attic,temperature_unit=K,unit=ppb temperature=293.15,co2=400000u`
+	readings, skipped, err := parseReadings(t, batch, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// battery is not a metric, status is not a number
+	if skipped != 2 {
+		t.Errorf("skipped %d fields, want 2", skipped)
+	}
+	want := []Reading{
+		{SensorId: "attic", Metric: metric.CO2, Value: 400000, Unit: metric.PartsPerBillion},
+		{SensorId: "kitchen", Metric: metric.Humidity, Value: 40, Unit: metric.RelativeHumidity},
+		{SensorId: "kitchen", Metric: metric.Temperature, Value: 21.5, Unit: metric.Celsius},
+		{SensorId: "hallway", Metric: metric.Temperature, Value: 70.7, Unit: metric.Fahrenheit},
+		{SensorId: "attic", Metric: metric.Temperature, Value: 293.15, Unit: metric.Kelvin},
+	}
+	if len(readings) != len(want) {
+		t.Fatalf("got %d readings %+v, want %d", len(readings), readings, len(want))
+	}
+	sort.Slice(readings, func(i, j int) bool {
+		if readings[i].Metric != readings[j].Metric {
+			return readings[i].Metric < readings[j].Metric
+		}
+		return readings[i].Unit < readings[j].Unit
+	})
+	for i := range want {
+		got := readings[i]
+		got.Time = time.Time{}
+		if got != want[i] {
+			t.Errorf("reading %d = %+v, want %+v", i, got, want[i])
+		}
+	}
+}
+
+func TestReadingsSensorTags(t *testing.T) {
+	readings, _, err := parseReadings(t, "climate,device=kitchen,sensor=ignored temperature=20", []string{"device"})
+	if err != nil || len(readings) != 1 || readings[0].SensorId != "kitchen" {
+		t.Errorf("readings = %+v, %v, want a reading of the device tag", readings, err)
+	}
+	// points without any metric reading need no sensor tag
+	if _, skipped, err := parseReadings(t, "cpu load=0.5", nil); err != nil || skipped != 1 {
+		t.Errorf("a point without readings returned %d skipped, %v", skipped, err)
+	}
+}
+
+func TestReadingsErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		batch string
+		line  int
+	}{
+		{"missing sensor tag", "climate temperature=20", 1},
+		{"invalid unit tag", "climate,sensor=kitchen temperature=20,humidity=40\ntemperature,sensor=kitchen,unit=Farenheit value=70", 2},
+		{"unit tag of another metric", "climate,sensor=kitchen temperature=20\nclimate,sensor=kitchen,unit=ppm temperature=20,humidity=40", 2},
+		{"invalid metric unit tag", "climate,sensor=kitchen temperature=20\nclimate,sensor=kitchen,temperature_unit=X temperature=20", 2},
+	}
+	for _, test := range tests {
+		_, _, err := parseReadings(t, test.batch, nil)
+		var lineErr *LineError
+		if !errors.As(err, &lineErr) || lineErr.Line != test.line {
+			t.Errorf("%s: got %v, want an error of line %d", test.name, err, test.line)
+		}
+	}
+	// a unit tag applies to the readings having such a unit
+	readings, _, err := parseReadings(t, "climate,sensor=kitchen,unit=F temperature=70.7,humidity=40", nil)
+	if err != nil || len(readings) != 2 || readings[0].Unit != metric.RelativeHumidity || readings[1].Unit != metric.Fahrenheit {
+		t.Errorf("readings = %+v, %v", readings, err)
+	}
+}
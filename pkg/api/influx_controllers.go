@@ -0,0 +1,107 @@
+package api
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/andreikom/sensor-server/pkg/api/influx"
+	"github.com/andreikom/sensor-server/pkg/api/metric"
+	"github.com/andreikom/sensor-server/pkg/auth"
+	"github.com/andreikom/sensor-server/pkg/logging"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const maxInfluxBatchSize = 16 << 20
+
+// influxController - the InfluxDB 1.x and 2.x write APIs, so line protocol collectors like Telegraf can
+// write to the sensor server. Organizations, buckets and databases are ignored
+type influxController struct {
+	metricService *metric.MetricService
+	sensorTags    []string
+}
+
+// WriteV2 serves POST /api/v2/write
+func (c *influxController) WriteV2(w http.ResponseWriter, req *http.Request) {
+	c.write(w, req, func(status int, code string, message string) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(map[string]string{"code": code, "message": message})
+	})
+}
+
+// WriteV1 serves POST /write
+func (c *influxController) WriteV1(w http.ResponseWriter, req *http.Request) {
+	c.write(w, req, func(status int, code string, message string) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": message})
+	})
+}
+
+// write stores the readings of a batch, a batch failing to parse or holding readings outside the
+// retention window is rejected as a whole
+func (c *influxController) write(w http.ResponseWriter, req *http.Request, writeError func(status int, code string, message string)) {
+	precision, err := influx.ParsePrecision(req.URL.Query().Get("precision"))
+	if err != nil {
+		writeError(http.StatusBadRequest, "invalid", err.Error())
+		return
+	}
+	var body io.Reader = http.MaxBytesReader(w, req.Body, maxInfluxBatchSize)
+	if req.Header.Get("Content-Encoding") == "gzip" {
+		gzipReader, err := gzip.NewReader(body)
+		if err != nil {
+			writeError(http.StatusBadRequest, "invalid", fmt.Sprintf("invalid gzip body: %s", err))
+			return
+		}
+		defer gzipReader.Close()
+		body = io.LimitReader(gzipReader, maxInfluxBatchSize+1)
+	}
+	data, err := ioutil.ReadAll(body)
+	if err != nil || len(data) > maxInfluxBatchSize {
+		writeError(http.StatusRequestEntityTooLarge, "request too large", fmt.Sprintf("batches are limited to %d bytes", maxInfluxBatchSize))
+		return
+	}
+	points, err := influx.Parse(data, precision, time.Now())
+	if err != nil {
+		writeError(http.StatusBadRequest, "invalid", err.Error())
+		return
+	}
+	readings, skipped, err := influx.Readings(points, c.sensorTags)
+	if err != nil {
+		writeError(http.StatusBadRequest, "invalid", err.Error())
+		return
+	}
+	for _, reading := range readings {
+		if err := auth.AllowSensor(req.Context(), reading.SensorId); err != nil {
+			writeError(http.StatusForbidden, "forbidden", err.Error())
+			return
+		}
+		if err := metric.ValidateSensorId(reading.SensorId); err != nil {
+			writeError(http.StatusBadRequest, "invalid", err.Error())
+			return
+		}
+		if err := c.metricService.CheckTimestamp(reading.Time); err != nil {
+			writeError(http.StatusUnprocessableEntity, "unprocessable entity", fmt.Sprintf("%s reading of sensor %s: %s", reading.Metric, reading.SensorId, err))
+			return
+		}
+	}
+	for i, reading := range readings {
+		if err := c.metricService.SaveMetricAt(req.Context(), reading.SensorId, reading.Metric, reading.Value, reading.Unit, reading.Time); err != nil {
+			status, code := http.StatusInternalServerError, "internal error"
+			var rangeErr *metric.TimestampOutOfRangeError
+			if errors.As(err, &rangeErr) {
+				status, code = http.StatusUnprocessableEntity, "unprocessable entity"
+			}
+			writeError(status, code, fmt.Sprintf("stored %d of %d readings: %s", i, len(readings), err))
+			return
+		}
+	}
+	if skipped > 0 {
+		logging.FromContext(req.Context()).WithField("skipped", skipped).Info("Skipped line protocol fields which are not sensor readings")
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
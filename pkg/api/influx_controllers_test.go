@@ -0,0 +1,77 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/andreikom/sensor-server/pkg/api/metric"
+	"github.com/andreikom/sensor-server/pkg/api/metric/metrictest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestInfluxWrite(t *testing.T) {
+	service, spool := metrictest.NewSpoolingService(t)
+	controller := &influxController{metricService: service}
+	now := time.Now().Unix()
+	batch := fmt.Sprintf("climate,sensor=kitchen,unit=F temperature=70.7,humidity=40i %d\n", now)
+
+	rec := httptest.NewRecorder()
+	controller.WriteV2(rec, httptest.NewRequest(http.MethodPost, "/api/v2/write?precision=s", bytes.NewBufferString(batch)))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	readings := metrictest.SpooledReadings(t, spool)
+	if len(readings) != 2 {
+		t.Fatalf("stored %d readings, want 2", len(readings))
+	}
+	for _, reading := range readings {
+		if reading.Metric == metric.Temperature && (reading.Value < 21.49 || reading.Value > 21.51) {
+			t.Errorf("temperature stored as %v, want 21.5 Celsius", reading.Value)
+		}
+	}
+
+	compressed := &bytes.Buffer{}
+	gz := gzip.NewWriter(compressed)
+	_, _ = gz.Write([]byte(batch))
+	_ = gz.Close()
+	req := httptest.NewRequest(http.MethodPost, "/write?precision=s", compressed)
+	req.Header.Set("Content-Encoding", "gzip")
+	rec = httptest.NewRecorder()
+	controller.WriteV1(rec, req)
+	if rec.Code != http.StatusNoContent || len(metrictest.SpooledReadings(t, spool)) != 2 {
+		t.Errorf("a gzipped batch returned %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestInfluxWriteRejectsBatches(t *testing.T) {
+	service, spool := metrictest.NewSpoolingService(t)
+	controller := &influxController{metricService: service}
+	tests := []struct {
+		name   string
+		query  string
+		batch  string
+		status int
+	}{
+		{"malformed line", "", "climate,sensor=kitchen temperature=20\nclimate temperature", http.StatusBadRequest},
+		{"invalid unit tag", "", "climate,sensor=kitchen temperature=20\ntemperature,sensor=kitchen,unit=Farenheit value=70", http.StatusBadRequest},
+		{"missing sensor tag", "", "climate temperature=20", http.StatusBadRequest},
+		{"invalid sensor id", "", "climate,sensor=../x temperature=20", http.StatusBadRequest},
+		{"invalid precision", "?precision=d", "climate,sensor=kitchen temperature=20", http.StatusBadRequest},
+		{"reading in the future", "?precision=s", fmt.Sprintf("climate,sensor=kitchen temperature=20 %d", time.Now().Add(48*time.Hour).Unix()), http.StatusUnprocessableEntity},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		controller.WriteV2(rec, httptest.NewRequest(http.MethodPost, "/api/v2/write"+test.query, bytes.NewBufferString(test.batch)))
+		if rec.Code != test.status {
+			t.Errorf("%s: status %d, want %d: %s", test.name, rec.Code, test.status, rec.Body)
+		}
+		// batches are rejected as a whole
+		if spool.Len() != 0 {
+			t.Errorf("%s: %d readings were stored", test.name, spool.Len())
+			metrictest.SpooledReadings(t, spool)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/andreikom/sensor-server/pkg/logging"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// ErrShutdownTimeout - a component did not stop within Server.ShutdownTimeout, work in progress may have been cut off
+var ErrShutdownTimeout = errors.New("could not have shut down within the shutdown timeout")
+
+// services - the listeners of a running server, the first one failing stops the server
+type services struct {
+	errs chan error
+}
+
+func newServices() *services {
+	return &services{errs: make(chan error, 1)}
+}
+
+// run serves in the background, serve has to return nil once it is stopped
+func (s *services) run(name string, serve func() error) {
+	go func() {
+		if err := serve(); err != nil {
+			select {
+			case s.errs <- fmt.Errorf("%s: %w", name, err):
+			default: // the server is already shutting down for an earlier failure
+			}
+		}
+	}()
+}
+
+// wait blocks until SIGINT or SIGTERM is received or a service fails, returning the failure. SIGHUP calls
+// onHangup meanwhile. A second SIGINT or SIGTERM is not caught and terminates the process right away
+func (s *services) wait(onHangup func()) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	for {
+		select {
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				onHangup()
+				continue
+			}
+			logging.Log.WithField("signal", sig.String()).Info("Shutting down")
+			return nil
+		case err := <-s.errs:
+			logging.Log.WithError(err).Error("Shutting down after a failure")
+			return err
+		}
+	}
+}
+
+// shutdown - stops the components of the server one after another within the deadline of ctx
+type shutdown struct {
+	ctx context.Context
+	// err - ErrShutdownTimeout once a component did not stop in time
+	err error
+}
+
+// stop waits for stop to return until the deadline, then calls force if it is not nil. Components after one
+// which did not stop in time are still stopped, their force functions run right away
+func (s *shutdown) stop(component string, stop func(), force func()) {
+	done := make(chan struct{})
+	go func() {
+		stop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		logging.Log.WithField("component", component).Debug("Stopped")
+		return
+	case <-s.ctx.Done():
+	}
+	logging.Log.WithField("component", component).Warn("Could not have stopped within the shutdown timeout")
+	s.err = ErrShutdownTimeout
+	if force != nil {
+		force()
+	}
+}
+
+// ignoreServerClosed - ListenAndServe returns http.ErrServerClosed after Shutdown and Close
+func ignoreServerClosed(err error) error {
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
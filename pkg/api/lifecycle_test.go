@@ -0,0 +1,117 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestServicesStopOnTheFirstFailure(t *testing.T) {
+	services := newServices()
+	failure := errors.New("address already in use")
+	services.run("http", func() error { return failure })
+	services.run("grpc", func() error { return fmt.Errorf("second failure") })
+	services.run("pprof", func() error { return nil })
+	err := services.wait(func() { t.Error("a hangup was handled") })
+	if !errors.Is(err, failure) || err.Error() != "http: address already in use" && err.Error() != "grpc: second failure" {
+		t.Errorf("wait returned %v", err)
+	}
+}
+
+// signalUntilReturned sends sig to the process until wait returns, signals arriving before wait is notified of
+// them are caught by the test
+func signalUntilReturned(t *testing.T, sig syscall.Signal, wait func() error) error {
+	t.Helper()
+	caught := make(chan os.Signal, 16)
+	signal.Notify(caught, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(caught)
+	returned := make(chan error, 1)
+	go func() { returned <- wait() }()
+	deadline := time.After(5 * time.Second)
+	for {
+		if err := syscall.Kill(os.Getpid(), sig); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case err := <-returned:
+			return err
+		case <-deadline:
+			t.Fatalf("wait did not return after %s", sig)
+			return nil
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestServicesWaitForSignals(t *testing.T) {
+	for _, sig := range []syscall.Signal{syscall.SIGTERM, syscall.SIGINT} {
+		if err := signalUntilReturned(t, sig, func() error { return newServices().wait(func() {}) }); err != nil {
+			t.Errorf("%s: wait returned %v", sig, err)
+		}
+	}
+}
+
+func TestServicesHandleHangups(t *testing.T) {
+	services := newServices()
+	hangups := 0
+	// the hangup handler fails the server on the third hangup, so wait returns
+	err := signalUntilReturned(t, syscall.SIGHUP, func() error {
+		return services.wait(func() {
+			if hangups++; hangups == 3 {
+				services.run("reload", func() error { return errors.New("stop") })
+			}
+		})
+	})
+	if hangups < 3 || err == nil {
+		t.Errorf("handled %d hangups and returned %v", hangups, err)
+	}
+}
+
+func TestShutdownStopsComponentsInOrder(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	shutdown := &shutdown{ctx: ctx}
+	stopped := make([]string, 0)
+	for _, component := range []string{"ingest", "http", "broker"} {
+		component := component
+		shutdown.stop(component, func() { stopped = append(stopped, component) }, func() { t.Errorf("%s was forced", component) })
+	}
+	if fmt.Sprint(stopped) != "[ingest http broker]" || shutdown.err != nil {
+		t.Errorf("stopped %v with %v", stopped, shutdown.err)
+	}
+}
+
+func TestShutdownForcesComponentsAfterTheDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	shutdown := &shutdown{ctx: ctx}
+	release := make(chan struct{})
+	defer close(release)
+	forced := make([]string, 0)
+	start := time.Now()
+	shutdown.stop("http", func() { <-release }, func() { forced = append(forced, "http") })
+	// after the deadline the remaining components are forced right away
+	shutdown.stop("grpc", func() { <-release }, func() { forced = append(forced, "grpc") })
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("the shutdown took %s", elapsed)
+	}
+	if fmt.Sprint(forced) != "[http grpc]" || !errors.Is(shutdown.err, ErrShutdownTimeout) {
+		t.Errorf("forced %v with %v", forced, shutdown.err)
+	}
+}
+
+func TestIgnoreServerClosed(t *testing.T) {
+	if err := ignoreServerClosed(http.ErrServerClosed); err != nil {
+		t.Errorf("returned %v", err)
+	}
+	failure := errors.New("address already in use")
+	if err := ignoreServerClosed(failure); err != failure {
+		t.Errorf("returned %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+package metric
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/andreikom/sensor-server/pkg/logging"
+	"github.com/sirupsen/logrus"
+	"os"
+	"time"
+)
+
+// Archive - the retention tier days leave the cache for, hours are kept as rollups instead of raw readings
+type Archive struct {
+	Id      string                 `json:"id"`
+	Metric  string                 `json:"metric"`
+	Version int                    `json:"version"`
+	Unit    Unit                   `json:"unit"`
+	Dates   map[string][]HourStats `json:"dates"`
+}
+
+// HourStats - rollup of the readings of a single UTC hour
+type HourStats struct {
+	Value int     `json:"hour"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Sum   float64 `json:"sum"`
+	Count int     `json:"count"`
+}
+
+func statsOf(hour Hour) HourStats {
+	stats := HourStats{Value: hour.Value, Count: len(hour.Values)}
+	for i, value := range hour.Values {
+		if i == 0 || value < stats.Min {
+			stats.Min = value
+		}
+		if i == 0 || value > stats.Max {
+			stats.Max = value
+		}
+		stats.Sum += value
+	}
+	return stats
+}
+
+// rawRetentionStart - days before this UTC date are moved from the cache to the archive tier
+func (m *MetricService) rawRetentionStart() time.Time {
+	rawDays, _ := m.retention()
+	return rawRetentionStart(rawDays)
+}
+
+func rawRetentionStart(rawDays int) time.Time {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	return today.AddDate(0, 0, -rawDays)
+}
+
+// SetRetention changes the retention of the tiers, it applies from the next clean up of old entries on.
+// A rawDays of 0 keeps the current raw retention, an archiveDays of 0 keeps rollups forever
+func (m *MetricService) SetRetention(rawDays int, archiveDays int) {
+	m.retentionMutex.Lock()
+	defer m.retentionMutex.Unlock()
+	if rawDays > 0 {
+		m.options.RawRetentionDays = rawDays
+	}
+	m.options.ArchiveRetentionDays = archiveDays
+}
+
+// retention returns the days of raw readings and of rollups kept
+func (m *MetricService) retention() (int, int) {
+	m.retentionMutex.RLock()
+	defer m.retentionMutex.RUnlock()
+	return m.options.RawRetentionDays, m.options.ArchiveRetentionDays
+}
+
+func (m *MetricService) loadArchive(ctx context.Context, def *Definition, sensorId string) (*Archive, error) {
+	archive := &Archive{Id: sensorId, Metric: def.Name, Version: sensorRecordVersion, Unit: def.StoreUnit, Dates: make(map[string][]HourStats)}
+	data, err := m.storageDriver.GetArchiveData(ctx, def.Name, sensorId)
+	if errors.Is(err, os.ErrNotExist) {
+		return archive, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, archive); err != nil {
+		return nil, fmt.Errorf("could not unmarshall %s archive of sensor %s: %w", def.Name, sensorId, err)
+	}
+	if archive.Dates == nil {
+		archive.Dates = make(map[string][]HourStats)
+	}
+	return archive, nil
+}
+
+func (m *MetricService) saveArchive(ctx context.Context, def *Definition, sensorId string, archive *Archive) error {
+	serializedData, err := json.Marshal(archive)
+	if err != nil {
+		return err
+	}
+	return m.storageDriver.SaveArchiveData(ctx, def.Name, sensorId, serializedData)
+}
+
+// archiveOldEntries moves the days before lastDateToKeep from the cache to the archive tier and drops
+// archived days before lastArchiveDateToKeep, a zero lastArchiveDateToKeep keeps the archive forever.
+// The caller must hold the write lock
+func (m *MetricService) archiveOldEntries(ctx context.Context, def *Definition, sensorId string, sensorEntry Sensor, lastDateToKeep time.Time, lastArchiveDateToKeep time.Time) error {
+	oldDates := make([]string, 0)
+	for date := range sensorEntry.Dates {
+		parsedDate, err := time.Parse(dateLayout, date)
+		if err != nil {
+			logging.Log.WithFields(logrus.Fields{"sensorId": sensorId, "metric": def.Name, "date": date}).Warn("Could not have parsed a previous date")
+			continue
+		}
+		if parsedDate.Before(lastDateToKeep) {
+			oldDates = append(oldDates, date)
+		}
+	}
+	if len(oldDates) == 0 && lastArchiveDateToKeep.IsZero() {
+		return nil
+	}
+	archive, err := m.loadArchive(ctx, def, sensorId)
+	if err != nil {
+		return err
+	}
+	for _, date := range oldDates {
+		rollups := make([]HourStats, 0, len(sensorEntry.Dates[date]))
+		for _, hour := range sensorEntry.Dates[date] {
+			rollups = append(rollups, statsOf(hour))
+		}
+		archive.Dates[date] = rollups
+	}
+	expired := 0
+	if !lastArchiveDateToKeep.IsZero() {
+		for date := range archive.Dates {
+			parsedDate, err := time.Parse(dateLayout, date)
+			if err == nil && parsedDate.Before(lastArchiveDateToKeep) {
+				delete(archive.Dates, date)
+				expired++
+			}
+		}
+	}
+	if len(oldDates) == 0 && expired == 0 {
+		return nil
+	}
+	// the archive is written first, a crash in between leaves a day in both tiers rather than in none
+	if err := m.saveArchive(ctx, def, sensorId, archive); err != nil {
+		return err
+	}
+	for _, date := range oldDates {
+		delete(sensorEntry.Dates, date)
+	}
+	if len(oldDates) > 0 {
+		if err := m.saveToDisk(ctx, def.Name, sensorId, sensorEntry); err != nil {
+			return err
+		}
+	}
+	logging.Log.WithFields(logrus.Fields{"sensorId": sensorId, "metric": def.Name, "archived": len(oldDates), "expired": expired}).Info("Archived old days")
+	return nil
+}
@@ -0,0 +1,188 @@
+package metric
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Period - the calendar window a statistic is computed over
+type Period string
+
+const (
+	Day   Period = "daily"
+	Week  Period = "weekly"
+	Month Period = "monthly"
+)
+
+// Stat - the statistic computed over a period
+type Stat string
+
+const (
+	Max Stat = "max"
+	Min Stat = "min"
+	Avg Stat = "avg"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+// ParseWeekday resolves the first day of a week, an empty value falls back to Monday as in ISO-8601
+func ParseWeekday(day string) (time.Weekday, error) {
+	if day == "" {
+		return time.Monday, nil
+	}
+	weekday, ok := weekdays[strings.ToLower(day)]
+	if !ok {
+		return time.Monday, fmt.Errorf("invalid week start '%s'", day)
+	}
+	return weekday, nil
+}
+
+// periodBounds returns the [from, to) window of the period containing date in loc
+func periodBounds(period Period, date string, loc *time.Location, weekStart time.Weekday) (time.Time, time.Time, error) {
+	day, err := time.ParseInLocation(dateLayout, date, loc)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	switch period {
+	case Day:
+		return day, day.AddDate(0, 0, 1), nil
+	case Week:
+		offset := (int(day.Weekday()) - int(weekStart) + 7) % 7
+		from := day.AddDate(0, 0, -offset)
+		return from, from.AddDate(0, 0, 7), nil
+	case Month:
+		from := time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, loc)
+		return from, from.AddDate(0, 1, 0), nil
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("unknown period '%s'", period)
+}
+
+// dayStats - statistics of the hour buckets falling into a single local date
+type dayStats struct {
+	min   float64
+	max   float64
+	sum   float64
+	count int
+}
+
+func (d *dayStats) add(hour HourStats) {
+	if d.count == 0 || hour.Min < d.min {
+		d.min = hour.Min
+	}
+	if d.count == 0 || hour.Max > d.max {
+		d.max = hour.Max
+	}
+	d.sum += hour.Sum
+	d.count += hour.Count
+}
+
+// GetStat computes a statistic over the day, week or calendar month containing date, reading the
+// cache and, for windows reaching past the cached days, the archive tier.
+// An empty date anchors the period at today in loc.
+// Weekly and monthly averages are the mean of the daily averages of the days having readings.
+func (m *MetricService) GetStat(metricName string, sensorId string, period Period, stat Stat, date string, unit Unit, loc *time.Location) (float64, error) {
+	def, err := Lookup(metricName)
+	if err != nil {
+		return 0, err
+	}
+	loc = m.Location(sensorId, loc)
+	if date == "" {
+		date = time.Now().In(loc).Format(dateLayout)
+	}
+	date, err = ParseDate(date)
+	if err != nil {
+		return 0, err
+	}
+	from, to, err := periodBounds(period, date, loc, m.options.WeekStart)
+	if err != nil {
+		return 0, err
+	}
+	m.rwMutex.RLock()
+	days := m.collectDays(def, sensorId, from, to, loc)
+	m.rwMutex.RUnlock()
+	if len(days) == 0 {
+		return 0, &NotFoundError{Name: "entry for sensorId: '" + sensorId + "' and " + string(period) + " period of date: '" + date + "'"}
+	}
+	var res float64
+	switch stat {
+	case Max:
+		res = math.Inf(-1)
+		for _, day := range days {
+			res = math.Max(res, day.max)
+		}
+	case Min:
+		res = math.Inf(1)
+		for _, day := range days {
+			res = math.Min(res, day.min)
+		}
+	case Avg:
+		for _, day := range days {
+			res += day.sum / float64(day.count)
+		}
+		res = res / float64(len(days))
+	default:
+		return 0, fmt.Errorf("unknown statistic '%s'", stat)
+	}
+	return def.FromStore(unit, res), nil
+}
+
+// collectDays groups the hour buckets starting within [from, to) by their local date,
+// the caller must hold the read lock
+func (m *MetricService) collectDays(def *Definition, sensorId string, from time.Time, to time.Time, loc *time.Location) map[string]*dayStats {
+	days := make(map[string]*dayStats)
+	m.visitHours(def, sensorId, from, to, func(start time.Time, hour HourStats) {
+		localDate := start.In(loc).Format(dateLayout)
+		day, ok := days[localDate]
+		if !ok {
+			day = &dayStats{}
+			days[localDate] = day
+		}
+		day.add(hour)
+	})
+	return days
+}
+
+// visitHours calls visit with the UTC start of every non-empty hour bucket starting within [from, to),
+// reading the cache and, for windows reaching past the cached days, the archive tier.
+// Hours are visited in no particular order, the caller must hold the read lock
+func (m *MetricService) visitHours(def *Definition, sensorId string, from time.Time, to time.Time, visit func(start time.Time, hour HourStats)) {
+	add := func(date string, hour HourStats) {
+		start, err := bucketTime(date, hour.Value)
+		if err != nil || start.Before(from) || !start.Before(to) || hour.Count == 0 {
+			return
+		}
+		visit(start, hour)
+	}
+	sensorEntry, cached := m.weeklySensorCache[def.Name][sensorId]
+	if cached {
+		for date, hours := range sensorEntry.Dates {
+			for _, hour := range hours {
+				add(date, statsOf(hour))
+			}
+		}
+	}
+	if from.Before(m.rawRetentionStart()) {
+		archive, err := m.loadArchive(context.Background(), def, sensorId)
+		if err == nil {
+			for date, hours := range archive.Dates {
+				if _, ok := sensorEntry.Dates[date]; cached && ok {
+					continue // interrupted archival, the cache is authoritative
+				}
+				for _, hour := range hours {
+					add(date, hour)
+				}
+			}
+		}
+	}
+}
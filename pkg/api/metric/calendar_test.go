@@ -0,0 +1,120 @@
+package metric
+
+import (
+	"context"
+	"github.com/andreikom/sensor-server/pkg/storage"
+	"testing"
+	"time"
+)
+
+func TestParseWeekday(t *testing.T) {
+	if day, err := ParseWeekday(""); err != nil || day != time.Monday {
+		t.Errorf("ParseWeekday(\"\") = %v, %v, want Monday", day, err)
+	}
+	if day, err := ParseWeekday("Sunday"); err != nil || day != time.Sunday {
+		t.Errorf("ParseWeekday(Sunday) = %v, %v", day, err)
+	}
+	if _, err := ParseWeekday("someday"); err == nil {
+		t.Error("ParseWeekday accepted an unknown day")
+	}
+}
+
+func TestPeriodBounds(t *testing.T) {
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	tests := []struct {
+		period    Period
+		date      string
+		loc       *time.Location
+		weekStart time.Weekday
+		from      string
+		to        string
+	}{
+		{Day, "2022-03-14", time.UTC, time.Monday, "2022-03-14T00:00:00Z", "2022-03-15T00:00:00Z"},
+		// a Wednesday, weeks starting on Monday as in ISO-8601 or on Sunday
+		{Week, "2022-03-16", time.UTC, time.Monday, "2022-03-14T00:00:00Z", "2022-03-21T00:00:00Z"},
+		{Week, "2022-03-16", time.UTC, time.Sunday, "2022-03-13T00:00:00Z", "2022-03-20T00:00:00Z"},
+		// the first day of the week is its own start
+		{Week, "2022-03-13", time.UTC, time.Sunday, "2022-03-13T00:00:00Z", "2022-03-20T00:00:00Z"},
+		// across a year boundary
+		{Week, "2021-01-01", time.UTC, time.Monday, "2020-12-28T00:00:00Z", "2021-01-04T00:00:00Z"},
+		{Month, "2022-02-14", time.UTC, time.Monday, "2022-02-01T00:00:00Z", "2022-03-01T00:00:00Z"},
+		{Month, "2024-12-31", time.UTC, time.Monday, "2024-12-01T00:00:00Z", "2025-01-01T00:00:00Z"},
+		// the day clocks are turned forward has 23 hours
+		{Day, "2022-03-27", berlin, time.Monday, "2022-03-27T00:00:00+01:00", "2022-03-28T00:00:00+02:00"},
+		{Week, "2022-03-27", berlin, time.Monday, "2022-03-21T00:00:00+01:00", "2022-03-28T00:00:00+02:00"},
+	}
+	for _, test := range tests {
+		from, to, err := periodBounds(test.period, test.date, test.loc, test.weekStart)
+		if err != nil {
+			t.Errorf("%s of %s: %v", test.period, test.date, err)
+			continue
+		}
+		if from.Format(time.RFC3339) != test.from || to.Format(time.RFC3339) != test.to {
+			t.Errorf("%s of %s starting %s = [%s, %s), want [%s, %s)", test.period, test.date, test.weekStart,
+				from.Format(time.RFC3339), to.Format(time.RFC3339), test.from, test.to)
+		}
+	}
+	if _, _, err := periodBounds("yearly", "2022-03-14", time.UTC, time.Monday); err == nil {
+		t.Error("an unknown period was accepted")
+	}
+}
+
+func TestStatsAcrossRetentionTiers(t *testing.T) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	archived, cached := today.AddDate(0, 0, -3), today.AddDate(0, 0, -2)
+	// a week starting on the archived day contains both days
+	store := NewStore(storage.NewFSDriver(t.TempDir()), Options{WeekStart: archived.Weekday()})
+	readings := []Reading{
+		{SensorId: "kitchen", Metric: Temperature, Value: 10, Timestamp: archived.Add(9 * time.Hour)},
+		{SensorId: "kitchen", Metric: Temperature, Value: 14, Timestamp: archived.Add(9*time.Hour + 30*time.Minute)},
+		{SensorId: "kitchen", Metric: Temperature, Value: 30, Timestamp: archived.Add(15 * time.Hour)},
+		{SensorId: "kitchen", Metric: Temperature, Value: 20, Timestamp: cached.Add(9 * time.Hour)},
+	}
+	if err := store.Import(context.Background(), readings); err != nil {
+		t.Fatal(err)
+	}
+	assertStats := func(tier string) {
+		t.Helper()
+		tests := []struct {
+			period Period
+			stat   Stat
+			date   string
+			want   float64
+		}{
+			{Day, Min, archived.Format(dateLayout), 10},
+			{Day, Avg, archived.Format(dateLayout), 18},
+			{Week, Max, cached.Format(dateLayout), 30},
+			{Week, Min, cached.Format(dateLayout), 10},
+			// the mean of the daily averages 18 and 20
+			{Week, Avg, archived.Format(dateLayout), 19},
+		}
+		for _, test := range tests {
+			got, err := store.GetStat(Temperature, "kitchen", test.period, test.stat, test.date, Celsius, time.UTC)
+			if err != nil || got != test.want {
+				t.Errorf("%s: %s %s of %s = %v, %v, want %v", tier, test.period, test.stat, test.date, got, err, test.want)
+			}
+		}
+	}
+	assertStats("cache")
+
+	store.SetRetention(2, 0)
+	store.cleanOldEntries()
+	if _, ok := store.weeklySensorCache[Temperature]["kitchen"].Dates[archived.Format(dateLayout)]; ok {
+		t.Fatal("the day before the raw retention was not archived")
+	}
+	archive, err := store.loadArchive(context.Background(), TemperatureDefinition, "kitchen")
+	if err != nil || len(archive.Dates[archived.Format(dateLayout)]) != 2 {
+		t.Fatalf("archive = %+v, %v, want two hours of %s", archive, err, archived.Format(dateLayout))
+	}
+	assertStats("archive")
+
+	// rollups past the archive retention are dropped
+	store.SetRetention(1, 1)
+	store.cleanOldEntries()
+	if _, err := store.GetStat(Temperature, "kitchen", Day, Max, archived.Format(dateLayout), Celsius, time.UTC); err == nil {
+		t.Error("an expired day of the archive was still queried")
+	}
+	if got, err := store.GetStat(Temperature, "kitchen", Day, Max, cached.Format(dateLayout), Celsius, time.UTC); err != nil || got != 20 {
+		t.Errorf("daily max of the newly archived day = %v, %v, want 20", got, err)
+	}
+}
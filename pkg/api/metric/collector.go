@@ -0,0 +1,68 @@
+package metric
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	cachedReadingsDesc = prometheus.NewDesc("sensor_server_cache_readings",
+		"Raw readings held in the cache per sensor.", []string{"metric", "sensor"}, nil)
+	queueMessagesDesc = prometheus.NewDesc("sensor_server_queue_messages",
+		"Messages ready in a queue of the broker.", []string{"queue"}, nil)
+	spoolMessagesDesc = prometheus.NewDesc("sensor_server_spool_messages",
+		"Readings waiting in the spool for the broker.", nil, nil)
+	sensorValueDesc = prometheus.NewDesc("sensor_server_sensor_value",
+		"The latest reading of a sensor in the store unit of its metric.", []string{"metric", "sensor", "unit"}, nil)
+)
+
+// collector - state of the service read at scrape time, the queues are inspected only while connected
+type collector struct {
+	service      *MetricService
+	sensorValues bool
+}
+
+// Collector exposes the cache size per sensor, the depth of the queues and the spool,
+// and with sensorValues the latest reading of every sensor as a gauge
+func (m *MetricService) Collector(sensorValues bool) prometheus.Collector {
+	return &collector{service: m, sensorValues: sensorValues}
+}
+
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cachedReadingsDesc
+	ch <- queueMessagesDesc
+	ch <- spoolMessagesDesc
+	if c.sensorValues {
+		ch <- sensorValueDesc
+	}
+}
+
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	c.service.rwMutex.RLock()
+	for metricName, sensors := range c.service.weeklySensorCache {
+		def := definitions[metricName]
+		for sensorId, sensorEntry := range sensors {
+			readings := 0
+			for _, hours := range sensorEntry.Dates {
+				for _, hour := range hours {
+					readings += len(hour.Values)
+				}
+			}
+			ch <- prometheus.MustNewConstMetric(cachedReadingsDesc, prometheus.GaugeValue, float64(readings), metricName, sensorId)
+			if !c.sensorValues {
+				continue
+			}
+			if _, _, latest, ok := latestReading(sensorEntry); ok {
+				ch <- prometheus.MustNewConstMetric(sensorValueDesc, prometheus.GaugeValue, latest, metricName, sensorId, string(def.StoreUnit))
+			}
+		}
+	}
+	c.service.rwMutex.RUnlock()
+	if c.service.broker.Connected() {
+		for _, queue := range []string{RcvMetricQueue, DeadLetterQueue} {
+			if depth, err := c.service.broker.QueueDepth(queue); err == nil {
+				ch <- prometheus.MustNewConstMetric(queueMessagesDesc, prometheus.GaugeValue, float64(depth), queue)
+			}
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(spoolMessagesDesc, prometheus.GaugeValue, float64(c.service.broker.SpoolLen()))
+}
@@ -0,0 +1,97 @@
+package metric
+
+import (
+	"context"
+	"github.com/andreikom/sensor-server/pkg/broker"
+	"github.com/andreikom/sensor-server/pkg/monitoring"
+	"github.com/andreikom/sensor-server/pkg/storage"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/streadway/amqp"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newCollectedService(t *testing.T) *MetricService {
+	dir := t.TempDir()
+	spool, err := broker.OpenSpool(filepath.Join(dir, "spool", "messages.jsonl"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := NewMetricService(storage.NewFSDriver(dir), broker.NewClient(broker.Options{}, spool), Options{})
+	t.Cleanup(service.Close)
+	start := time.Now().UTC().Truncate(time.Hour).Add(-2 * time.Hour)
+	if err := service.Import(context.Background(), []Reading{
+		{SensorId: "kitchen", Metric: Temperature, Value: 20, Timestamp: start},
+		{SensorId: "kitchen", Metric: Temperature, Value: 21, Timestamp: start.Add(time.Minute)},
+		{SensorId: "kitchen", Metric: Temperature, Value: 22.5, Timestamp: start.Add(time.Hour)},
+		{SensorId: "bathroom", Metric: Humidity, Value: 65, Timestamp: start},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return service
+}
+
+func TestCollector(t *testing.T) {
+	service := newCollectedService(t)
+	if err := service.SaveMetric(context.Background(), "kitchen", Temperature, 23, Celsius); err != nil {
+		t.Fatal(err)
+	}
+	expected := `
+# HELP sensor_server_cache_readings Raw readings held in the cache per sensor.
+# TYPE sensor_server_cache_readings gauge
+sensor_server_cache_readings{metric="humidity",sensor="bathroom"} 1
+sensor_server_cache_readings{metric="temperature",sensor="kitchen"} 3
+# HELP sensor_server_sensor_value The latest reading of a sensor in the store unit of its metric.
+# TYPE sensor_server_sensor_value gauge
+sensor_server_sensor_value{metric="humidity",sensor="bathroom",unit="%"} 65
+sensor_server_sensor_value{metric="temperature",sensor="kitchen",unit="C"} 22.5
+# HELP sensor_server_spool_messages Readings waiting in the spool for the broker.
+# TYPE sensor_server_spool_messages gauge
+sensor_server_spool_messages 1
+`
+	if err := testutil.CollectAndCompare(service.Collector(true), strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+	// the queues are only inspected while connected and sensor values are opt-in
+	withoutValues := `
+# HELP sensor_server_sensor_value The latest reading of a sensor in the store unit of its metric.
+# TYPE sensor_server_sensor_value gauge
+# HELP sensor_server_queue_messages Messages ready in a queue of the broker.
+# TYPE sensor_server_queue_messages gauge
+`
+	if err := testutil.CollectAndCompare(service.Collector(false), strings.NewReader(withoutValues),
+		"sensor_server_sensor_value", "sensor_server_queue_messages"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReadingCounters(t *testing.T) {
+	service := newCollectedService(t)
+	received := testutil.ToFloat64(monitoring.ReadingsReceived.WithLabelValues(Humidity))
+	stored := testutil.ToFloat64(monitoring.ReadingsStored.WithLabelValues(Humidity))
+	deadLettered := testutil.ToFloat64(monitoring.ReadingsDeadLettered)
+
+	if err := service.SaveMetric(context.Background(), "bathroom", Humidity, 70, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.SaveMetric(context.Background(), "../bathroom", Humidity, 70, ""); err == nil {
+		t.Fatal("an invalid sensor id was accepted")
+	}
+	if got := testutil.ToFloat64(monitoring.ReadingsReceived.WithLabelValues(Humidity)) - received; got != 1 {
+		t.Errorf("counted %v received readings, want 1", got)
+	}
+
+	now := time.Now().UTC()
+	msg, _ := delivery(t, MetricQueueMsg{SensorId: "bathroom", Metric: Humidity, Date: now.Format(dateLayout), Hour: now.Hour(), Value: 70}, amqp.Table{publishedAtHeader: now.UnixNano()})
+	service.handleDelivery(msg)
+	if got := testutil.ToFloat64(monitoring.ReadingsStored.WithLabelValues(Humidity)) - stored; got != 1 {
+		t.Errorf("counted %v stored readings, want 1", got)
+	}
+	malformed, _ := delivery(t, []byte("not json"), nil)
+	service.handleDelivery(malformed)
+	if got := testutil.ToFloat64(monitoring.ReadingsDeadLettered) - deadLettered; got != 1 {
+		t.Errorf("counted %v dead-lettered messages, want 1", got)
+	}
+}
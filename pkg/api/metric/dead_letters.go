@@ -0,0 +1,92 @@
+package metric
+
+import (
+	"github.com/andreikom/sensor-server/pkg/logging"
+	"github.com/streadway/amqp"
+	"time"
+)
+
+const defaultDeadLetterLimit = 100
+
+// DeadLetter - a message parked in the DeadLetterQueue
+type DeadLetter struct {
+	Body        string    `json:"body"`
+	Error       string    `json:"error,omitempty"`
+	Retries     int       `json:"retries"`
+	Timestamp   time.Time `json:"timestamp"`
+	Redelivered bool      `json:"redelivered"`
+}
+
+// InspectDeadLetters returns up to limit messages from the head of the DeadLetterQueue,
+// the messages are put back on the queue
+func (m *MetricService) InspectDeadLetters(limit int) ([]DeadLetter, error) {
+	deliveries, err := m.getDeadLetters(limit)
+	letters := make([]DeadLetter, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		letters = append(letters, toDeadLetter(delivery))
+		if nackErr := delivery.Nack(false, true); nackErr != nil {
+			logging.Log.WithError(nackErr).Error("Could not return a dead letter to the queue")
+		}
+	}
+	return letters, err
+}
+
+// ReplayDeadLetters moves up to limit messages from the DeadLetterQueue back to RcvMetricQueue
+// with a reset retry count, returns how many messages were replayed
+func (m *MetricService) ReplayDeadLetters(limit int) (int, error) {
+	deliveries, err := m.getDeadLetters(limit)
+	replayed := 0
+	for _, delivery := range deliveries {
+		if err != nil {
+			_ = delivery.Nack(false, true)
+			continue
+		}
+		headers := copyHeaders(delivery.Headers)
+		delete(headers, retryCountHeader)
+		delete(headers, errorHeader)
+		if err = m.publish("", RcvMetricQueue, headers, delivery.Body); err != nil {
+			_ = delivery.Nack(false, true)
+			continue
+		}
+		_ = delivery.Ack(false)
+		replayed++
+	}
+	return replayed, err
+}
+
+func (m *MetricService) getDeadLetters(limit int) ([]amqp.Delivery, error) {
+	if limit <= 0 {
+		limit = defaultDeadLetterLimit
+	}
+	deliveries := make([]amqp.Delivery, 0)
+	ch, err := m.broker.Channel()
+	if err != nil {
+		return deliveries, err
+	}
+	for len(deliveries) < limit {
+		delivery, ok, err := ch.Get(DeadLetterQueue, false)
+		if err != nil {
+			return deliveries, err
+		}
+		if !ok {
+			break
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+func toDeadLetter(delivery amqp.Delivery) DeadLetter {
+	letter := DeadLetter{
+		Body:        string(delivery.Body),
+		Retries:     retryCount(delivery.Headers),
+		Timestamp:   delivery.Timestamp,
+		Redelivered: delivery.Redelivered,
+	}
+	if reason, ok := delivery.Headers[errorHeader].(string); ok {
+		letter.Error = reason
+	} else if _, ok := delivery.Headers["x-death"]; ok {
+		letter.Error = "rejected by the consumer"
+	}
+	return letter
+}
@@ -0,0 +1,155 @@
+package metric
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/andreikom/sensor-server/pkg/broker"
+	"github.com/andreikom/sensor-server/pkg/storage"
+	"github.com/streadway/amqp"
+	"testing"
+	"time"
+)
+
+// acknowledger records how a delivery was settled
+type acknowledger struct {
+	acked    bool
+	nacked   bool
+	requeue  bool
+	rejected bool
+}
+
+func (a *acknowledger) Ack(tag uint64, multiple bool) error {
+	a.acked = true
+	return nil
+}
+
+func (a *acknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	a.nacked, a.requeue = true, requeue
+	return nil
+}
+
+func (a *acknowledger) Reject(tag uint64, requeue bool) error {
+	a.rejected, a.requeue = true, requeue
+	return nil
+}
+
+// failingDriver fails every write of a sensor record
+type failingDriver struct {
+	storage.Driver
+}
+
+func (d failingDriver) SaveSensorData(ctx context.Context, metric string, sensorId string, data []byte) error {
+	return errors.New("disk full")
+}
+
+// newDisconnectedService returns a MetricService whose broker client was never started, so every publish fails
+func newDisconnectedService(t *testing.T, driver storage.Driver) *MetricService {
+	service := NewMetricService(driver, broker.NewClient(broker.Options{}, nil), Options{MaxRedeliveries: 2})
+	t.Cleanup(service.Close)
+	return service
+}
+
+func delivery(t *testing.T, body interface{}, headers amqp.Table) (amqp.Delivery, *acknowledger) {
+	data, ok := body.([]byte)
+	if !ok {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ack := &acknowledger{}
+	return amqp.Delivery{Acknowledger: ack, Headers: headers, Body: data}, ack
+}
+
+func TestStoredDeliveriesAreAcked(t *testing.T) {
+	service := newDisconnectedService(t, storage.NewFSDriver(t.TempDir()))
+	updates := make(chan Update, 1)
+	service.OnUpdate(func(update Update) {
+		updates <- update
+	})
+	now := time.Now().UTC()
+	msg, ack := delivery(t, MetricQueueMsg{SensorId: "kitchen", Metric: Temperature, Date: now.Format(dateLayout), Hour: now.Hour(), Value: 21.5}, amqp.Table{publishedAtHeader: now.UnixNano()})
+	service.handleDelivery(msg)
+	if !ack.acked || ack.nacked || ack.rejected {
+		t.Fatalf("the delivery was settled with %+v, want an ack", ack)
+	}
+	if got, _, err := service.GetLatest(Temperature, "kitchen", Celsius); err != nil || got != 21.5 {
+		t.Errorf("GetLatest = %v, %v, want 21.5", got, err)
+	}
+	select {
+	case update := <-updates:
+		if update.SensorId != "kitchen" || update.Value != 21.5 {
+			t.Errorf("listeners were notified with %+v", update)
+		}
+	default:
+		t.Error("listeners were not notified")
+	}
+}
+
+func TestUnstorableDeliveriesAreDeadLettered(t *testing.T) {
+	service := newDisconnectedService(t, storage.NewFSDriver(t.TempDir()))
+	tests := []struct {
+		name string
+		body interface{}
+	}{
+		{"unparseable body", []byte("{not json")},
+		{"unknown metric", MetricQueueMsg{SensorId: "kitchen", Metric: "radiation", Date: "2022-03-14", Hour: 9, Value: 1}},
+	}
+	for _, test := range tests {
+		msg, ack := delivery(t, test.body, nil)
+		service.handleDelivery(msg)
+		// the dead letter exchange can not be published to, the broker routes the rejected message there instead
+		if !ack.rejected || ack.requeue || ack.acked {
+			t.Errorf("%s was settled with %+v, want a reject without requeue", test.name, ack)
+		}
+	}
+}
+
+func TestFailedWritesAreRetried(t *testing.T) {
+	service := newDisconnectedService(t, failingDriver{storage.NewFSDriver(t.TempDir())})
+	now := time.Now().UTC()
+	body := MetricQueueMsg{SensorId: "kitchen", Metric: Temperature, Date: now.Format(dateLayout), Hour: now.Hour(), Value: 21.5}
+
+	msg, ack := delivery(t, body, nil)
+	service.handleDelivery(msg)
+	// the retry can not be published, the message is put back by the broker
+	if !ack.nacked || !ack.requeue || ack.acked {
+		t.Errorf("a failed write was settled with %+v, want a nack with requeue", ack)
+	}
+	if _, _, err := service.GetLatest(Temperature, "kitchen", Celsius); err == nil {
+		t.Error("a reading which could not be written was cached")
+	}
+
+	// retry counts published by other clients may be of any numeric type
+	for _, retries := range []interface{}{int32(2), int64(2), float64(2)} {
+		msg, ack = delivery(t, body, amqp.Table{retryCountHeader: retries})
+		service.handleDelivery(msg)
+		if !ack.rejected || ack.requeue {
+			t.Errorf("a message retried %v (%T) times was settled with %+v, want it dead-lettered", retries, retries, ack)
+		}
+	}
+}
+
+func TestDeliveriesAfterCloseAreRequeued(t *testing.T) {
+	service := newDisconnectedService(t, storage.NewFSDriver(t.TempDir()))
+	service.Close()
+	now := time.Now().UTC()
+	msg, ack := delivery(t, MetricQueueMsg{SensorId: "kitchen", Metric: Temperature, Date: now.Format(dateLayout), Hour: now.Hour(), Value: 21.5}, nil)
+	service.handleDelivery(msg)
+	if !ack.nacked || !ack.requeue {
+		t.Errorf("a delivery after Close was settled with %+v, want a nack with requeue", ack)
+	}
+}
+
+func TestToDeadLetter(t *testing.T) {
+	timestamp := time.Date(2022, time.March, 14, 9, 0, 0, 0, time.UTC)
+	letter := toDeadLetter(amqp.Delivery{Body: []byte("{}"), Timestamp: timestamp, Headers: amqp.Table{retryCountHeader: int32(5), errorHeader: "gave up"}})
+	if letter.Body != "{}" || letter.Retries != 5 || letter.Error != "gave up" || !letter.Timestamp.Equal(timestamp) {
+		t.Errorf("toDeadLetter = %+v", letter)
+	}
+	letter = toDeadLetter(amqp.Delivery{Headers: amqp.Table{"x-death": []interface{}{}}})
+	if letter.Error != "rejected by the consumer" {
+		t.Errorf("a message rejected by the consumer has error %q", letter.Error)
+	}
+}
@@ -0,0 +1,229 @@
+package metric
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	DewPoint     = "dew_point"
+	HeatIndex    = "heat_index"
+	RateOfChange = "rate_of_change"
+)
+
+type hourKey struct {
+	date string
+	hour int
+}
+
+// SeriesPoint - a single hourly value of a derived series
+type SeriesPoint struct {
+	Date  string  `json:"date"`
+	Hour  int     `json:"hour"`
+	Value float64 `json:"value"`
+}
+
+// DerivedQuery - describes a derived series over the day or week containing Date, the windows of the daily and
+// weekly statistics
+type DerivedQuery struct {
+	Derived  string
+	SensorId string
+	// Period - Day or Week, Day if empty
+	Period Period
+	// Date - anchors the period, today in Location if empty
+	Date string
+	// Metric - the source metric of RateOfChange, ignored by the other derived series
+	Metric string
+	// Unit - temperature unit of DewPoint and HeatIndex, unit of the source metric for RateOfChange (per hour)
+	Unit Unit
+	// Location - the timezone of Date and of the returned points, nil falls back to the sensor location
+	Location *time.Location
+}
+
+type UnknownDerivedError struct {
+	Derived string
+}
+
+func (e *UnknownDerivedError) Error() string {
+	return fmt.Sprintf("unknown derived series '%s', expected one of %s, %s, %s", e.Derived, DewPoint, HeatIndex, RateOfChange)
+}
+
+// DerivedMetricName returns the metric whose unit is used by the given derived series
+func DerivedMetricName(derived string, sourceMetric string) (string, error) {
+	switch derived {
+	case DewPoint, HeatIndex:
+		return Temperature, nil
+	case RateOfChange:
+		if sourceMetric == "" {
+			return Temperature, nil
+		}
+		return sourceMetric, nil
+	}
+	return "", &UnknownDerivedError{Derived: derived}
+}
+
+// GetDerivedSeries computes a derived series from the hourly averages of the period containing query.Date,
+// reading the cache and, for windows reaching past the cached days, the archive tier as GetStat does
+func (m *MetricService) GetDerivedSeries(query DerivedQuery) ([]SeriesPoint, error) {
+	metricName, err := DerivedMetricName(query.Derived, query.Metric)
+	if err != nil {
+		return nil, err
+	}
+	def, err := Lookup(metricName)
+	if err != nil {
+		return nil, err
+	}
+	if query.Period == "" {
+		query.Period = Day
+	}
+	loc := m.Location(query.SensorId, query.Location)
+	date := query.Date
+	if date == "" {
+		date = time.Now().In(loc).Format(dateLayout)
+	}
+	date, err = ParseDate(date)
+	if err != nil {
+		return nil, err
+	}
+	from, to, err := periodBounds(query.Period, date, loc, m.options.WeekStart)
+	if err != nil {
+		return nil, err
+	}
+	m.rwMutex.RLock()
+	defer m.rwMutex.RUnlock()
+	switch query.Derived {
+	case DewPoint:
+		return m.combinedSeries(query, from, to, loc, dewPoint)
+	case HeatIndex:
+		return m.combinedSeries(query, from, to, loc, heatIndex)
+	}
+	averages := m.hourlyAverages(def, query.SensorId, from, to, loc)
+	if len(averages) == 0 {
+		return nil, &NotFoundError{Name: "entry for sensorId: '" + query.SensorId + "' and " + string(query.Period) + " period of date: '" + date + "'"}
+	}
+	return rateOfChange(def, averages, query.Unit, loc), nil
+}
+
+// combinedSeries joins the hourly temperature and humidity averages of a sensor and applies calc to each pair,
+// results are converted from Celsius to the requested unit
+func (m *MetricService) combinedSeries(query DerivedQuery, from time.Time, to time.Time, loc *time.Location, calc func(tempC float64, humidity float64) float64) ([]SeriesPoint, error) {
+	temperatures := m.hourlyAverages(TemperatureDefinition, query.SensorId, from, to, loc)
+	if len(temperatures) == 0 {
+		return nil, &NotFoundError{Name: "temperature entry for sensorId: '" + query.SensorId + "'"}
+	}
+	humidities := m.hourlyAverages(HumidityDefinition, query.SensorId, from, to, loc)
+	if len(humidities) == 0 {
+		return nil, &NotFoundError{Name: "humidity entry for sensorId: '" + query.SensorId + "'"}
+	}
+	humidityByHour := make(map[hourKey]float64)
+	for _, point := range humidities {
+		humidityByHour[hourKey{date: point.Date, hour: point.Hour}] = point.Value
+	}
+	series := make([]SeriesPoint, 0)
+	for _, point := range temperatures {
+		humidity, ok := humidityByHour[hourKey{date: point.Date, hour: point.Hour}]
+		if !ok {
+			continue
+		}
+		value := calc(point.Value, humidity)
+		if math.IsNaN(value) {
+			continue
+		}
+		series = append(series, SeriesPoint{Date: point.Date, Hour: point.Hour, Value: TemperatureDefinition.FromStore(query.Unit, value)})
+	}
+	if len(series) == 0 {
+		return nil, &NotFoundError{Name: "overlapping temperature and humidity readings for sensorId: '" + query.SensorId + "'"}
+	}
+	return series, nil
+}
+
+// hourlyAverages returns the average of every hour bucket starting within [from, to), dated in loc and in
+// chronological order. The UTC buckets falling into the same local hour, e.g. when clocks are turned back,
+// are merged. The caller must hold the read lock
+func (m *MetricService) hourlyAverages(def *Definition, sensorId string, from time.Time, to time.Time, loc *time.Location) []SeriesPoint {
+	type bucket struct {
+		start time.Time
+		sum   float64
+		count int
+	}
+	buckets := make(map[hourKey]*bucket)
+	m.visitHours(def, sensorId, from, to, func(start time.Time, hour HourStats) {
+		local := start.In(loc)
+		key := hourKey{date: local.Format(dateLayout), hour: local.Hour()}
+		b, ok := buckets[key]
+		if !ok {
+			b = &bucket{start: start}
+			buckets[key] = b
+		}
+		if start.Before(b.start) {
+			b.start = start
+		}
+		b.sum += hour.Sum
+		b.count += hour.Count
+	})
+	keys := make([]hourKey, 0, len(buckets))
+	for key := range buckets {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return buckets[keys[i]].start.Before(buckets[keys[j]].start)
+	})
+	series := make([]SeriesPoint, 0, len(keys))
+	for _, key := range keys {
+		series = append(series, SeriesPoint{Date: key.date, Hour: key.hour, Value: buckets[key].sum / float64(buckets[key].count)})
+	}
+	return series
+}
+
+func pointTime(point SeriesPoint, loc *time.Location) time.Time {
+	date, err := time.ParseInLocation(dateLayout, point.Date, loc)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Date(date.Year(), date.Month(), date.Day(), point.Hour, 0, 0, 0, loc)
+}
+
+// rateOfChange - difference between consecutive hourly averages divided by the hours elapsed between them,
+// the first point of the series has no predecessor and is skipped
+func rateOfChange(def *Definition, averages []SeriesPoint, unit Unit, loc *time.Location) []SeriesPoint {
+	series := make([]SeriesPoint, 0)
+	for i := 1; i < len(averages); i++ {
+		elapsed := pointTime(averages[i], loc).Sub(pointTime(averages[i-1], loc)).Hours()
+		if elapsed <= 0 {
+			continue
+		}
+		perHour := (averages[i].Value - averages[i-1].Value) / elapsed
+		series = append(series, SeriesPoint{Date: averages[i].Date, Hour: averages[i].Hour, Value: def.DeltaFromStore(unit, perHour)})
+	}
+	return series
+}
+
+// dewPoint - Magnus formula with the Sonntag 1990 coefficients, accurate within 0.35°C for -45°C..60°C
+func dewPoint(tempC float64, humidity float64) float64 {
+	const b, c = 17.62, 243.12
+	if humidity <= 0 {
+		return math.NaN()
+	}
+	gamma := math.Log(humidity/100) + b*tempC/(c+tempC)
+	return c * gamma / (b - gamma)
+}
+
+// heatIndex - the NWS heat index: Steadman's simple formula, switching to the Rothfusz regression
+// with its low and high humidity adjustments once the simple result averaged with the temperature reaches 80°F
+func heatIndex(tempC float64, humidity float64) float64 {
+	t := TemperatureDefinition.FromStore(Fahrenheit, tempC)
+	hi := 0.5 * (t + 61.0 + (t-68.0)*1.2 + humidity*0.094)
+	if (hi+t)/2 >= 80 {
+		hi = -42.379 + 2.04901523*t + 10.14333127*humidity - 0.22475541*t*humidity -
+			0.00683783*t*t - 0.05481717*humidity*humidity + 0.00122874*t*t*humidity +
+			0.00085282*t*humidity*humidity - 0.00000199*t*t*humidity*humidity
+		if humidity < 13 && t >= 80 && t <= 112 {
+			hi -= (13 - humidity) / 4 * math.Sqrt((17-math.Abs(t-95))/17)
+		} else if humidity > 85 && t >= 80 && t <= 87 {
+			hi += (humidity - 85) / 10 * (87 - t) / 5
+		}
+	}
+	return TemperatureDefinition.ToStore(Fahrenheit, hi)
+}
@@ -0,0 +1,168 @@
+package metric
+
+import (
+	"context"
+	"errors"
+	"github.com/andreikom/sensor-server/pkg/storage"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDewPoint(t *testing.T) {
+	for _, tempC := range []float64{-10, 0, 15, 30} {
+		if got := dewPoint(tempC, 100); math.Abs(got-tempC) > 1e-9 {
+			t.Errorf("dewPoint(%v, 100%%) = %v, want the temperature", tempC, got)
+		}
+	}
+	// reference values of the Sonntag 1990 Magnus coefficients
+	tests := []struct {
+		tempC    float64
+		humidity float64
+		want     float64
+	}{
+		{25, 60, 16.69},
+		{30, 40, 14.93},
+		{0, 50, -9.20},
+	}
+	for _, test := range tests {
+		if got := dewPoint(test.tempC, test.humidity); math.Abs(got-test.want) > 0.05 {
+			t.Errorf("dewPoint(%v, %v%%) = %.2f, want %.2f", test.tempC, test.humidity, got, test.want)
+		}
+	}
+	if got := dewPoint(20, 0); !math.IsNaN(got) {
+		t.Errorf("dewPoint at 0%% humidity = %v, want NaN", got)
+	}
+}
+
+func TestHeatIndex(t *testing.T) {
+	// values of the NWS heat index chart, in Fahrenheit
+	tests := []struct {
+		tempF    float64
+		humidity float64
+		want     float64
+	}{
+		{90, 70, 106},
+		{100, 40, 109},
+		{86, 90, 105},
+		{70, 50, 69},
+	}
+	for _, test := range tests {
+		tempC := TemperatureDefinition.ToStore(Fahrenheit, test.tempF)
+		got := TemperatureDefinition.FromStore(Fahrenheit, heatIndex(tempC, test.humidity))
+		if math.Abs(got-test.want) > 1 {
+			t.Errorf("heatIndex(%v°F, %v%%) = %.1f°F, want %v°F", test.tempF, test.humidity, got, test.want)
+		}
+	}
+}
+
+func TestDerivedSeries(t *testing.T) {
+	store := NewStore(storage.NewFSDriver(t.TempDir()), Options{})
+	day := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -1)
+	at := func(hour int) time.Time {
+		return day.Add(time.Duration(hour) * time.Hour)
+	}
+	readings := []Reading{
+		{SensorId: "kitchen", Metric: Temperature, Value: 19, Timestamp: at(8)},
+		{SensorId: "kitchen", Metric: Temperature, Value: 21, Timestamp: at(8).Add(30 * time.Minute)},
+		{SensorId: "kitchen", Metric: Temperature, Value: 22, Timestamp: at(9)},
+		{SensorId: "kitchen", Metric: Temperature, Value: 26, Timestamp: at(11)},
+		{SensorId: "kitchen", Metric: Humidity, Value: 100, Timestamp: at(9)},
+		{SensorId: "kitchen", Metric: Humidity, Value: 60, Timestamp: at(11)},
+		{SensorId: "kitchen", Metric: Humidity, Value: 50, Timestamp: at(12)},
+	}
+	if err := store.Import(context.Background(), readings); err != nil {
+		t.Fatal(err)
+	}
+	date := day.Format(dateLayout)
+
+	rates, err := store.GetDerivedSeries(DerivedQuery{Derived: RateOfChange, SensorId: "kitchen", Date: date, Unit: Fahrenheit, Location: time.UTC})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// hourly averages 20, 22 and 26 degrees Celsius, a change of 2°C per hour is 3.6°F per hour
+	want := []SeriesPoint{{Date: date, Hour: 9, Value: 3.6}, {Date: date, Hour: 11, Value: 3.6}}
+	assertSeries(t, rates, want)
+
+	dewPoints, err := store.GetDerivedSeries(DerivedQuery{Derived: DewPoint, SensorId: "kitchen", Date: date, Unit: Celsius, Location: time.UTC})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// only the hours with both a temperature and a humidity reading
+	want = []SeriesPoint{{Date: date, Hour: 9, Value: 22}, {Date: date, Hour: 11, Value: dewPoint(26, 60)}}
+	assertSeries(t, dewPoints, want)
+
+	if _, err := store.GetDerivedSeries(DerivedQuery{Derived: DewPoint, SensorId: "hallway", Date: date, Location: time.UTC}); err == nil {
+		t.Error("a sensor without readings returned a series")
+	}
+	var derivedErr *UnknownDerivedError
+	if _, err := store.GetDerivedSeries(DerivedQuery{Derived: "wind_chill", SensorId: "kitchen"}); !errors.As(err, &derivedErr) {
+		t.Errorf("an unknown derived series returned %v", err)
+	}
+}
+
+func assertSeries(t *testing.T, got []SeriesPoint, want []SeriesPoint) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d points %v, want %v", len(got), got, want)
+	}
+	for i := range want {
+		if got[i].Date != want[i].Date || got[i].Hour != want[i].Hour || math.Abs(got[i].Value-want[i].Value) > 1e-9 {
+			t.Errorf("point %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestWeeklyDerivedSeriesAcrossRetentionTiers(t *testing.T) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	archived, cached, lastWeek := today.AddDate(0, 0, -3), today.AddDate(0, 0, -2), today.AddDate(0, 0, -10)
+	// a week starting on the archived day contains both days but not the one of the week before
+	store := NewStore(storage.NewFSDriver(t.TempDir()), Options{WeekStart: archived.Weekday()})
+	readings := []Reading{
+		{SensorId: "kitchen", Metric: Temperature, Value: 0, Timestamp: lastWeek.Add(9 * time.Hour)},
+		{SensorId: "kitchen", Metric: Temperature, Value: 10, Timestamp: archived.Add(9 * time.Hour)},
+		{SensorId: "kitchen", Metric: Temperature, Value: 14, Timestamp: archived.Add(9*time.Hour + 30*time.Minute)},
+		{SensorId: "kitchen", Metric: Temperature, Value: 30, Timestamp: archived.Add(15 * time.Hour)},
+		{SensorId: "kitchen", Metric: Temperature, Value: 21, Timestamp: cached.Add(9 * time.Hour)},
+	}
+	if err := store.Import(context.Background(), readings); err != nil {
+		t.Fatal(err)
+	}
+	// hourly averages 12, 30 and 21
+	want := []SeriesPoint{
+		{Date: archived.Format(dateLayout), Hour: 15, Value: 3},
+		{Date: cached.Format(dateLayout), Hour: 9, Value: -0.5},
+	}
+	assertWeek := func(tier string) {
+		t.Helper()
+		for _, date := range []string{archived.Format(dateLayout), cached.Format(dateLayout)} {
+			series, err := store.GetDerivedSeries(DerivedQuery{Derived: RateOfChange, SensorId: "kitchen", Period: Week, Date: date, Unit: Celsius, Location: time.UTC})
+			if err != nil {
+				t.Fatalf("%s: %v", tier, err)
+			}
+			assertSeries(t, series, want)
+		}
+		daily, err := store.GetDerivedSeries(DerivedQuery{Derived: RateOfChange, SensorId: "kitchen", Date: archived.Format(dateLayout), Unit: Celsius, Location: time.UTC})
+		if err != nil {
+			t.Fatalf("%s: %v", tier, err)
+		}
+		assertSeries(t, daily, want[:1])
+	}
+	assertWeek("cache")
+
+	store.SetRetention(2, 0)
+	store.cleanOldEntries()
+	if _, ok := store.weeklySensorCache[Temperature]["kitchen"].Dates[archived.Format(dateLayout)]; ok {
+		t.Fatal("the day before the raw retention was not archived")
+	}
+	assertWeek("archive")
+
+	var notFound *NotFoundError
+	if _, err := store.GetDerivedSeries(DerivedQuery{Derived: RateOfChange, SensorId: "kitchen", Period: Week, Date: "2001-01-01", Location: time.UTC}); !errors.As(err, &notFound) {
+		t.Errorf("a week without readings returned %v", err)
+	}
+	var invalid *InvalidArgumentError
+	if _, err := store.GetDerivedSeries(DerivedQuery{Derived: DewPoint, SensorId: "kitchen", Period: Week, Date: "last week"}); !errors.As(err, &invalid) {
+		t.Errorf("a malformed date returned %v", err)
+	}
+}
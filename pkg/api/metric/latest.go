@@ -0,0 +1,74 @@
+package metric
+
+import (
+	"time"
+)
+
+// Update - a reading committed to the store, Value is in the StoreUnit and Time is the start of its UTC hour
+type Update struct {
+	SensorId string
+	Metric   string
+	Value    float64
+	Time     time.Time
+}
+
+// UpdateListener is called from the consumer after a reading is committed, it must not block
+type UpdateListener func(update Update)
+
+// OnUpdate registers a listener for committed readings
+func (m *MetricService) OnUpdate(listener UpdateListener) {
+	m.listenersMutex.Lock()
+	defer m.listenersMutex.Unlock()
+	m.listeners = append(m.listeners, listener)
+}
+
+func (m *MetricService) notify(def *Definition, msg *MetricQueueMsg) {
+	timestamp, err := bucketTime(msg.Date, msg.Hour)
+	if err != nil {
+		return
+	}
+	update := Update{SensorId: msg.SensorId, Metric: def.Name, Value: msg.Value, Time: timestamp}
+	m.listenersMutex.RLock()
+	defer m.listenersMutex.RUnlock()
+	for _, listener := range m.listeners {
+		listener(update)
+	}
+}
+
+// GetLatest returns the most recent cached reading of a sensor and the start of the UTC hour it was taken in
+func (m *MetricService) GetLatest(metricName string, sensorId string, unit Unit) (float64, time.Time, error) {
+	def, err := Lookup(metricName)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	m.rwMutex.RLock()
+	defer m.rwMutex.RUnlock()
+	sensorEntry, ok := m.weeklySensorCache[def.Name][sensorId]
+	if !ok {
+		return 0, time.Time{}, &NotFoundError{Name: "sensor " + sensorId}
+	}
+	latestDate, latestHour, latest, ok := latestReading(sensorEntry)
+	if !ok {
+		return 0, time.Time{}, &NotFoundError{Name: "reading of sensorId: '" + sensorId + "'"}
+	}
+	timestamp, err := bucketTime(latestDate, latestHour)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return def.FromStore(unit, latest), timestamp, nil
+}
+
+// latestReading returns the date, hour and store unit value of the last reading of a record
+func latestReading(sensorEntry Sensor) (string, int, float64, bool) {
+	latestDate, latestHour := "", -1
+	var latest float64
+	for date, hours := range sensorEntry.Dates {
+		for _, hour := range hours {
+			if len(hour.Values) == 0 || date < latestDate || (date == latestDate && hour.Value <= latestHour) {
+				continue
+			}
+			latestDate, latestHour, latest = date, hour.Value, hour.Values[len(hour.Values)-1]
+		}
+	}
+	return latestDate, latestHour, latest, latestHour >= 0
+}
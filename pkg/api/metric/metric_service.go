@@ -0,0 +1,571 @@
+package metric
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/andreikom/sensor-server/pkg/broker"
+	"github.com/andreikom/sensor-server/pkg/logging"
+	"github.com/andreikom/sensor-server/pkg/monitoring"
+	"github.com/andreikom/sensor-server/pkg/storage"
+	"github.com/andreikom/sensor-server/pkg/tracing"
+	"github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	RcvMetricQueue = "RcvMetricQueue"
+	// DeadLetterExchange routes poison messages and messages exceeding MaxRedeliveries to DeadLetterQueue
+	DeadLetterExchange = "RcvMetricDLX"
+	DeadLetterQueue    = "RcvMetricDeadLetters"
+	// retryCountHeader - how many times a message was put back on RcvMetricQueue after a failed write
+	retryCountHeader = "x-retry-count"
+	// errorHeader - why a message was dead-lettered
+	errorHeader = "x-error"
+	// publishedAtHeader - unix nanoseconds of the first publish of a reading, the AMQP timestamp has a
+	// resolution of seconds
+	publishedAtHeader = "x-published-at"
+	// requestIdHeader - the id of the request a reading was received with, so it can be traced from ingest to disk
+	requestIdHeader = "x-request-id"
+
+	defaultMaxRedeliveries = 5
+	consumerPrefetch       = 32
+	// dateLayout - the layout of the date keys of a sensor record, dates and hours are kept in UTC
+	dateLayout = "2006-01-02"
+
+	defaultRawRetentionDays = 7
+	// maxClockSkew - how far ahead of the server clock a reading timestamp may be
+	maxClockSkew = time.Hour
+)
+
+// Options - calendar and retention settings of a MetricService
+type Options struct {
+	Locations *Locations
+	// WeekStart - the first day of the weeks weekly statistics are computed over
+	WeekStart time.Weekday
+	// RawRetentionDays - days of raw readings kept in the cache before they are rolled up into the archive tier
+	RawRetentionDays int
+	// ArchiveRetentionDays - days of rollups kept in the archive tier, 0 keeps them forever
+	ArchiveRetentionDays int
+	// MaxRedeliveries - attempts to store a message before it is moved to the DeadLetterQueue
+	MaxRedeliveries int
+}
+
+type MetricService struct {
+	storageDriver storage.Driver
+	// metric -> sensorId -> date -> hour -> values
+	weeklySensorCache map[string]map[string]Sensor
+	broker            *broker.Client
+	options           Options
+	rwMutex           sync.RWMutex // TODO [andreik]: we can improve to lock per sensor probably inside a map/struct
+	listeners         []UpdateListener
+	listenersMutex    sync.RWMutex
+	// retentionMutex guards the retention of options, which is changed by SetRetention
+	retentionMutex sync.RWMutex
+	// quit stops the clean up of old entries
+	quit chan struct{}
+	// closed is guarded by rwMutex, deliveries arriving after Close are requeued
+	closed      bool
+	cacheLoaded bool
+	// readOnly - records of older versions are not written back, see NewReadOnlyStore
+	readOnly bool
+}
+
+// NewMetricService registers the queues and the consumer of readings on the broker client,
+// which has to be started by the caller afterwards
+func NewMetricService(driver storage.Driver, brokerClient *broker.Client, options Options) *MetricService {
+	options = options.withDefaults()
+	cache := make(map[string]map[string]Sensor, len(definitions))
+	service := &MetricService{storageDriver: driver, weeklySensorCache: cache, broker: brokerClient, options: options, quit: make(chan struct{})}
+	service.initSensorCache()
+	service.scheduleOldEntriesCleanUp()
+	brokerClient.AddTopology(service.setupQueues)
+	brokerClient.Consume(RcvMetricQueue, service.handleDelivery)
+	return service
+}
+
+func (o Options) withDefaults() Options {
+	if o.RawRetentionDays <= 0 {
+		o.RawRetentionDays = defaultRawRetentionDays
+	}
+	if o.MaxRedeliveries <= 0 {
+		o.MaxRedeliveries = defaultMaxRedeliveries
+	}
+	return o
+}
+
+// ParseUnit resolves a unit of the given metric from user input, an empty value falls back to the StoreUnit
+func ParseUnit(metricName string, unit string) (Unit, error) {
+	def, err := Lookup(metricName)
+	if err != nil {
+		return "", err
+	}
+	return def.ParseUnit(unit)
+}
+
+// setupQueues declares the queues on every (re)connect of the broker client
+func (m *MetricService) setupQueues(ch *amqp.Channel) error {
+	err := ch.ExchangeDeclare(
+		DeadLetterExchange, // name
+		"direct",           // type
+		true,               // durable
+		false,              // auto-deleted
+		false,              // internal
+		false,              // no-wait
+		nil,                // arguments
+	)
+	if err != nil {
+		return fmt.Errorf("could not have created the '%s' exchange: %w", DeadLetterExchange, err)
+	}
+	if err := declareQueue(ch, DeadLetterQueue, nil); err != nil {
+		return err
+	}
+	err = ch.QueueBind(DeadLetterQueue, RcvMetricQueue, DeadLetterExchange, false, nil)
+	if err != nil {
+		return fmt.Errorf("could not have bound the '%s' queue: %w", DeadLetterQueue, err)
+	}
+	// messages rejected by the consumer are routed by the broker to the dead letter queue
+	if err := declareQueue(ch, RcvMetricQueue, amqp.Table{"x-dead-letter-exchange": DeadLetterExchange}); err != nil {
+		return err
+	}
+	if err := ch.Qos(consumerPrefetch, 0, false); err != nil {
+		return fmt.Errorf("could not have set the consumer prefetch: %w", err)
+	}
+	return nil
+}
+
+func declareQueue(ch *amqp.Channel, name string, args amqp.Table) error {
+	_, err := ch.QueueDeclare(
+		name,  // name
+		true,  // durable
+		false, // delete when unused
+		false, // exclusive
+		false, // no-wait
+		args,  // arguments
+	)
+	if err != nil {
+		return fmt.Errorf("could not have created the '%s' queue: %w", name, err)
+	}
+	return nil
+}
+
+func (m *MetricService) initSensorCache() {
+	ctx := context.Background()
+	for _, name := range Names() {
+		def := definitions[name]
+		sensors, err := m.storageDriver.GetAvailableSensors(ctx, name)
+		if err != nil {
+			panic("Could not have initialized sensor " + name + " cache")
+		}
+		m.weeklySensorCache[name] = make(map[string]Sensor, len(sensors))
+		for _, sensor := range sensors {
+			sensorEntry, err := m.storageDriver.GetSensorData(ctx, name, sensor)
+			if err != nil {
+				continue
+			}
+			res := &Sensor{}
+			err = json.Unmarshal(sensorEntry, &res)
+			if err != nil {
+				logging.Log.WithError(err).WithFields(logrus.Fields{"sensorId": sensor, "metric": name}).Error("Could not unmarshall a sensor record")
+				continue
+			}
+			if migrateSensorRecord(def, sensor, res) && !m.readOnly {
+				_ = m.saveToDisk(ctx, name, sensor, *res)
+				logging.Log.WithFields(logrus.Fields{"sensorId": sensor, "metric": name, "version": sensorRecordVersion}).Info("Migrated a sensor record")
+			}
+			m.weeklySensorCache[name][sensor] = *res
+		}
+	}
+	m.cacheLoaded = true
+}
+
+// CacheLoaded reports whether the records of all sensors were read into the cache
+func (m *MetricService) CacheLoaded() bool {
+	m.rwMutex.RLock()
+	defer m.rwMutex.RUnlock()
+	return m.cacheLoaded
+}
+
+// migrateSensorRecord upgrades a record written by an older server version in place.
+// Integer readings of version 0 records are decoded as float64 as is, temperature readings of
+// version 0 and 1 records are moved from "temp" to "values" and the unit is resolved.
+// Records before version 3 are keyed by the local date and hour of the server and are moved to UTC.
+// Returns true if the record was changed and has to be written back
+func migrateSensorRecord(def *Definition, sensorId string, sensor *Sensor) bool {
+	if sensor.Version >= sensorRecordVersion && sensor.Unit == def.StoreUnit {
+		return false
+	}
+	unit := sensor.Unit
+	if unit == "" {
+		unit = def.StoreUnit // version 0 records were always written in the store unit
+	}
+	for date, hours := range sensor.Dates {
+		for i := range hours {
+			if len(hours[i].LegacyTemp) > 0 {
+				hours[i].Values = append(hours[i].Values, hours[i].LegacyTemp...)
+				hours[i].LegacyTemp = nil
+			}
+			if unit != def.StoreUnit {
+				for j, value := range hours[i].Values {
+					hours[i].Values[j] = def.ToStore(unit, value)
+				}
+			}
+		}
+		sensor.Dates[date] = hours
+	}
+	if sensor.Dates == nil {
+		sensor.Dates = make(map[string][]Hour)
+	}
+	if sensor.Version < 3 {
+		migrateLegacyDates(sensor)
+	}
+	if sensor.Id == "" {
+		sensor.Id = sensorId
+	}
+	sensor.Metric = def.Name
+	sensor.Unit = def.StoreUnit
+	sensor.Version = sensorRecordVersion
+	return true
+}
+
+// withValue returns a copy of the record with value added to the given date and hour, the cached record
+// is left untouched so it is only replaced once the copy is committed to disk
+func (s Sensor) withValue(date string, currentHour int, value float64) Sensor {
+	updated := s
+	updated.Dates = make(map[string][]Hour, len(s.Dates)+1)
+	for key, hours := range s.Dates {
+		updated.Dates[key] = hours
+	}
+	hours := make([]Hour, len(s.Dates[date]), len(s.Dates[date])+1)
+	copy(hours, s.Dates[date])
+	for i := range hours {
+		if hours[i].Value == currentHour {
+			values := make([]float64, len(hours[i].Values), len(hours[i].Values)+1)
+			copy(values, hours[i].Values)
+			hours[i].Values = append(values, value)
+			updated.Dates[date] = hours
+			return updated
+		}
+	}
+	// match was not found, adding new hour
+	updated.Dates[date] = append(hours, Hour{
+		Value:  currentHour,
+		Values: []float64{value},
+	})
+	return updated
+}
+
+// handleDelivery acks a message only after its reading is committed to disk. Messages which can never be
+// stored are dead-lettered right away, failed writes are retried up to MaxRedeliveries times
+func (m *MetricService) handleDelivery(msg amqp.Delivery) {
+	logger := deliveryLogger(msg)
+	ctx, span := tracing.StartProcess(RcvMetricQueue, msg.Headers)
+	newMsg := &MetricQueueMsg{}
+	err := json.Unmarshal(msg.Body, &newMsg)
+	if err != nil {
+		logger.WithError(err).Error("Could not unmarshall a message from queue")
+		tracing.End(span, err)
+		m.deadLetter(logger, msg, "unparseable message: "+err.Error())
+		return
+	}
+	logger = logger.WithFields(logrus.Fields{"sensorId": newMsg.SensorId, "metric": newMsg.Metric})
+	span.SetAttributes(tracing.SensorIdKey.String(newMsg.SensorId), tracing.MetricKey.String(newMsg.Metric))
+	def, err := Lookup(newMsg.Metric)
+	if err != nil {
+		logger.WithError(err).Error("Could not store a message from queue")
+		tracing.End(span, err)
+		m.deadLetter(logger, msg, err.Error())
+		return
+	}
+	m.rwMutex.Lock()
+	if m.closed {
+		m.rwMutex.Unlock()
+		tracing.End(span, nil)
+		_ = msg.Nack(false, true)
+		return
+	}
+	err = m.saveEntryToCacheAndStore(ctx, def, newMsg)
+	m.rwMutex.Unlock()
+	tracing.End(span, err)
+	if err != nil {
+		m.retry(logger, msg, err)
+		return
+	}
+	if err := msg.Ack(false); err != nil {
+		logger.WithError(err).Warn("Could not ack a message")
+	}
+	logger.WithFields(logrus.Fields{"date": newMsg.Date, "hour": newMsg.Hour}).Debug("Stored a reading")
+	monitoring.ReadingsStored.WithLabelValues(def.Name).Inc()
+	if publishedAt, ok := msg.Headers[publishedAtHeader].(int64); ok {
+		monitoring.ConsumeLatency.Observe(time.Since(time.Unix(0, publishedAt)).Seconds())
+	}
+	m.notify(def, newMsg)
+}
+
+// retry puts a message back on the queue with an incremented retry count, or dead-letters it once
+// MaxRedeliveries is reached
+func (m *MetricService) retry(logger *logrus.Entry, msg amqp.Delivery, cause error) {
+	retries := retryCount(msg.Headers)
+	if retries >= m.options.MaxRedeliveries {
+		m.deadLetter(logger, msg, fmt.Sprintf("gave up after %d attempts: %s", retries+1, cause))
+		return
+	}
+	logger.WithError(cause).WithField("attempt", retries+1).Warn("Could not store a message from queue")
+	headers := copyHeaders(msg.Headers)
+	headers[retryCountHeader] = int32(retries + 1)
+	if err := m.publish("", RcvMetricQueue, headers, msg.Body); err != nil {
+		logger.WithError(err).Error("Could not requeue a message")
+		_ = msg.Nack(false, true)
+		return
+	}
+	_ = msg.Ack(false)
+}
+
+// deadLetter moves a message to the DeadLetterQueue recording the reason, if that is not possible
+// the message is rejected and the broker routes it there without the reason
+func (m *MetricService) deadLetter(logger *logrus.Entry, msg amqp.Delivery, reason string) {
+	monitoring.ReadingsDeadLettered.Inc()
+	logger.WithField("reason", reason).Warn("Dead-lettering a message")
+	headers := copyHeaders(msg.Headers)
+	headers[errorHeader] = reason
+	if err := m.publish(DeadLetterExchange, RcvMetricQueue, headers, msg.Body); err != nil {
+		logger.WithError(err).Error("Could not dead-letter a message")
+		_ = msg.Reject(false)
+		return
+	}
+	_ = msg.Ack(false)
+}
+
+// deliveryLogger - an entry carrying the request id the reading of a delivery was received with
+func deliveryLogger(msg amqp.Delivery) *logrus.Entry {
+	if requestId, ok := msg.Headers[requestIdHeader].(string); ok {
+		return logging.Log.WithField(logging.RequestIdField, requestId)
+	}
+	return logrus.NewEntry(logging.Log)
+}
+
+func retryCount(headers amqp.Table) int {
+	switch count := headers[retryCountHeader].(type) {
+	case int32:
+		return int(count)
+	case int64:
+		return int(count)
+	case int:
+		return count
+	case float64:
+		return int(count)
+	}
+	return 0
+}
+
+func copyHeaders(headers amqp.Table) amqp.Table {
+	copied := make(amqp.Table, len(headers)+1)
+	for key, value := range headers {
+		copied[key] = value
+	}
+	return copied
+}
+
+func (m *MetricService) saveEntryToCacheAndStore(ctx context.Context, def *Definition, msg *MetricQueueMsg) error {
+	sensorEntry, ok := m.weeklySensorCache[def.Name][msg.SensorId]
+	if !ok {
+		sensorEntry = Sensor{
+			Id:      msg.SensorId,
+			Metric:  def.Name,
+			Version: sensorRecordVersion,
+			Unit:    def.StoreUnit,
+			Dates:   make(map[string][]Hour),
+		}
+	}
+	updated := sensorEntry.withValue(msg.Date, msg.Hour, msg.Value)
+	if err := m.saveToDisk(ctx, def.Name, msg.SensorId, updated); err != nil {
+		return err
+	}
+	m.weeklySensorCache[def.Name][msg.SensorId] = updated
+	return nil
+}
+
+func (m *MetricService) saveToDisk(ctx context.Context, metricName string, sensorId string, sensorEntry Sensor) error {
+	serializedData, err := json.Marshal(sensorEntry)
+	if err != nil {
+		logging.Log.WithError(err).WithFields(logrus.Fields{"sensorId": sensorId, "metric": metricName}).Error("Could not have serialized sensor data")
+		return err
+	}
+	err = m.storageDriver.SaveSensorData(ctx, metricName, sensorId, serializedData)
+	if err != nil {
+		logging.Log.WithError(err).WithFields(logrus.Fields{"sensorId": sensorId, "metric": metricName}).Error("Could not save sensor data")
+		return err
+	}
+	return nil
+}
+
+func (m *MetricService) scheduleOldEntriesCleanUp() {
+	ticker := time.NewTicker(12 * time.Hour)
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				m.cleanOldEntries()
+			case <-m.quit:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+}
+
+func (m *MetricService) cleanOldEntries() {
+	rawDays, archiveDays := m.retention()
+	lastDateToKeep := rawRetentionStart(rawDays)
+	lastArchiveDateToKeep := time.Time{}
+	if archiveDays > 0 {
+		lastArchiveDateToKeep = lastDateToKeep.AddDate(0, 0, -archiveDays)
+	}
+	ctx, span := tracing.Tracer.Start(context.Background(), "metric.cleanOldEntries")
+	defer span.End()
+	m.rwMutex.Lock()
+	defer m.rwMutex.Unlock()
+	if m.closed {
+		return
+	}
+	for metricName, sensors := range m.weeklySensorCache {
+		def := definitions[metricName]
+		for sensorId, entry := range sensors {
+			if err := m.archiveOldEntries(ctx, def, sensorId, entry, lastDateToKeep, lastArchiveDateToKeep); err != nil {
+				logging.Log.WithError(err).WithFields(logrus.Fields{"sensorId": sensorId, "metric": metricName}).Error("Could not have archived old records")
+				continue
+			}
+		}
+		logging.Log.WithField("metric", metricName).Info("Old records cleaned")
+	}
+}
+
+// Close stops the clean up of old entries and waits for the writes in progress, the consumer should be stopped
+// before, see broker.Client.StopConsuming. Records are written to the storage driver synchronously, once the
+// write lock is acquired nothing is left to flush
+func (m *MetricService) Close() {
+	m.rwMutex.Lock()
+	defer m.rwMutex.Unlock()
+	if !m.closed {
+		m.closed = true
+		close(m.quit)
+	}
+}
+
+// publishToQueue publishes a reading with a publisher confirm, while the broker is unavailable
+// the reading is kept in the spool of the broker client. The trace context of ctx travels in the headers
+func (m *MetricService) publishToQueue(ctx context.Context, logger *logrus.Entry, requestId string, serializedMsg []byte) error {
+	if m.broker == nil {
+		return ErrNoBroker
+	}
+	headers := amqp.Table{publishedAtHeader: time.Now().UnixNano(), requestIdHeader: requestId}
+	_, span := tracing.StartPublish(ctx, RcvMetricQueue, headers)
+	err := m.broker.PublishOrSpool("", RcvMetricQueue, newPublishing(headers, serializedMsg))
+	tracing.End(span, err)
+	if err != nil {
+		logger.WithError(err).Error("Could not publish a reading")
+		return err
+	}
+	return nil
+}
+
+func (m *MetricService) publish(exchange string, key string, headers amqp.Table, body []byte) error {
+	return m.broker.Publish(exchange, key, newPublishing(headers, body))
+}
+
+func newPublishing(headers amqp.Table, body []byte) amqp.Publishing {
+	return amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		Headers:      headers,
+		Body:         body,
+	}
+}
+
+// SaveMetric publishes a reading reported in the given unit, it is converted to the StoreUnit before queueing.
+// The request id of ctx travels with the reading to the consumer, readings without one get a new id
+func (m *MetricService) SaveMetric(ctx context.Context, sensorId string, metricName string, data float64, unit Unit) error {
+	return m.SaveMetricAt(ctx, sensorId, metricName, data, unit, time.Now())
+}
+
+// SaveMetricAt publishes a reading taken at the given time, see CheckTimestamp and ValidateSensorId
+func (m *MetricService) SaveMetricAt(ctx context.Context, sensorId string, metricName string, data float64, unit Unit, timestamp time.Time) error {
+	def, err := Lookup(metricName)
+	if err != nil {
+		return err
+	}
+	if err := ValidateSensorId(sensorId); err != nil {
+		return err
+	}
+	if math.IsNaN(data) || math.IsInf(data, 0) {
+		return &InvalidArgumentError{Reason: fmt.Sprintf("invalid %s reading for sensor %s: %v", def.Name, sensorId, data)}
+	}
+	if err := m.CheckTimestamp(timestamp); err != nil {
+		return err
+	}
+	requestId := logging.RequestId(ctx)
+	if requestId == "" {
+		requestId = logging.NewRequestId()
+	}
+	logger := logging.Log.WithFields(logrus.Fields{logging.RequestIdField: requestId, "sensorId": sensorId, "metric": def.Name})
+	utc := timestamp.UTC()
+	msg := &MetricQueueMsg{sensorId, def.Name, utc.Format(dateLayout), utc.Hour(), def.ToStore(unit, data)}
+	serializedMsg, err := json.Marshal(msg)
+	if err != nil {
+		logger.WithError(err).Error("Could not have serialized sensor data")
+	}
+	err = m.publishToQueue(ctx, logger, requestId, serializedMsg)
+	if err != nil {
+		return err
+	}
+	monitoring.ReadingsReceived.WithLabelValues(def.Name).Inc()
+	logger.Debug("Published a reading")
+	return nil
+}
+
+// CheckTimestamp rejects readings of days already rolled up into the archive tier, they would replace
+// the rollups of their day, and readings too far in the future
+func (m *MetricService) CheckTimestamp(timestamp time.Time) error {
+	if timestamp.Before(m.rawRetentionStart()) {
+		return &TimestampOutOfRangeError{Timestamp: timestamp, Reason: "is older than the raw retention period"}
+	}
+	if timestamp.After(time.Now().Add(maxClockSkew)) {
+		return &TimestampOutOfRangeError{Timestamp: timestamp, Reason: "is in the future"}
+	}
+	return nil
+}
+
+// Location resolves the timezone defining day boundaries of a query, nil falls back to the sensor location
+func (m *MetricService) Location(sensorId string, loc *time.Location) *time.Location {
+	if loc != nil {
+		return loc
+	}
+	return m.options.Locations.For(sensorId)
+}
+
+func (m *MetricService) GetDailyMaxByDateAndById(metricName string, sensorId string, date string, unit Unit, loc *time.Location) (float64, error) {
+	return m.GetStat(metricName, sensorId, Day, Max, date, unit, loc)
+}
+
+func (m *MetricService) GetWeeklyMaxByDateAndById(metricName string, sensorId string, date string, unit Unit, loc *time.Location) (float64, error) {
+	return m.GetStat(metricName, sensorId, Week, Max, date, unit, loc)
+}
+
+func (m *MetricService) GetDailyMinByDateAndById(metricName string, sensorId string, date string, unit Unit, loc *time.Location) (float64, error) {
+	return m.GetStat(metricName, sensorId, Day, Min, date, unit, loc)
+}
+
+func (m *MetricService) GetWeeklyMinByDateAndById(metricName string, sensorId string, date string, unit Unit, loc *time.Location) (float64, error) {
+	return m.GetStat(metricName, sensorId, Week, Min, date, unit, loc)
+}
+
+func (m *MetricService) GetDailyAvgByDateAndById(metricName string, sensorId string, date string, unit Unit, loc *time.Location) (float64, error) {
+	return m.GetStat(metricName, sensorId, Day, Avg, date, unit, loc)
+}
+
+func (m *MetricService) GetWeeklyAvgByDateAndById(metricName string, sensorId string, date string, unit Unit, loc *time.Location) (float64, error) {
+	return m.GetStat(metricName, sensorId, Week, Avg, date, unit, loc)
+}
@@ -0,0 +1,100 @@
+package metric
+
+import (
+	"context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+type MetricServiceGrpc struct {
+	MetricService *MetricService
+}
+
+func (m *MetricServiceGrpc) SaveMetric(ctx context.Context, value *SensorMetricValue) (*Empty, error) {
+	unit, err := ParseUnit(value.Metric, value.Unit)
+	if err != nil {
+		return nil, GrpcError(err)
+	}
+	err = m.MetricService.SaveMetric(ctx, value.SensorId, value.Metric, value.Value, unit)
+	if err != nil {
+		return nil, GrpcError(err)
+	}
+	return &Empty{}, nil
+}
+
+func (m *MetricServiceGrpc) GetDailyMax(ctx context.Context, query *MetricQuery) (*Result, error) {
+	return m.getStat(query, Day, Max)
+}
+
+func (m *MetricServiceGrpc) GetWeeklyMax(ctx context.Context, query *MetricQuery) (*Result, error) {
+	return m.getStat(query, Week, Max)
+}
+
+func (m *MetricServiceGrpc) GetDailyMin(ctx context.Context, query *MetricQuery) (*Result, error) {
+	return m.getStat(query, Day, Min)
+}
+
+func (m *MetricServiceGrpc) GetWeeklyMin(ctx context.Context, query *MetricQuery) (*Result, error) {
+	return m.getStat(query, Week, Min)
+}
+
+func (m *MetricServiceGrpc) GetDailyAvg(ctx context.Context, query *MetricQuery) (*Result, error) {
+	return m.getStat(query, Day, Avg)
+}
+
+func (m *MetricServiceGrpc) GetWeeklyAvg(ctx context.Context, query *MetricQuery) (*Result, error) {
+	return m.getStat(query, Week, Avg)
+}
+
+func (m *MetricServiceGrpc) GetMonthlyMax(ctx context.Context, query *MetricQuery) (*Result, error) {
+	return m.getStat(query, Month, Max)
+}
+
+func (m *MetricServiceGrpc) GetMonthlyMin(ctx context.Context, query *MetricQuery) (*Result, error) {
+	return m.getStat(query, Month, Min)
+}
+
+func (m *MetricServiceGrpc) GetMonthlyAvg(ctx context.Context, query *MetricQuery) (*Result, error) {
+	return m.getStat(query, Month, Avg)
+}
+
+func (m *MetricServiceGrpc) getStat(query *MetricQuery, period Period, stat Stat) (*Result, error) {
+	unit, loc, err := parseQuery(query)
+	if err != nil {
+		return nil, GrpcError(err)
+	}
+	res, err := m.MetricService.GetStat(query.Metric, query.SensorId, period, stat, query.Date, unit, loc)
+	if err != nil {
+		return nil, GrpcError(err)
+	}
+	return &Result{Value: FormatReading(res)}, nil
+}
+
+// parseQuery resolves the optional unit and timezone of a query
+func parseQuery(query *MetricQuery) (Unit, *time.Location, error) {
+	unit, err := ParseUnit(query.Metric, query.Unit)
+	if err != nil {
+		return "", nil, err
+	}
+	loc, err := ParseLocation(query.Timezone)
+	if err != nil {
+		return "", nil, err
+	}
+	return unit, loc, nil
+}
+
+// GrpcError maps an error of the service to a gRPC status, InvalidArgument for the parameters of a call,
+// NotFound for a query without readings and Internal otherwise
+func GrpcError(err error) error {
+	switch {
+	case IsInvalid(err):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case IsNotFound(err):
+		return status.Error(codes.NotFound, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+func (m *MetricServiceGrpc) mustEmbedUnimplementedMetricServiceServer() {
+}
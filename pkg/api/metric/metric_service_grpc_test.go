@@ -0,0 +1,80 @@
+package metric
+
+import (
+	"context"
+	"github.com/andreikom/sensor-server/pkg/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestMetricServiceGrpc(t *testing.T) {
+	store := NewStore(storage.NewFSDriver(t.TempDir()), Options{})
+	day := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -1)
+	readings := []Reading{
+		{SensorId: "kitchen", Metric: Humidity, Value: 40, Timestamp: day.Add(time.Hour)},
+		{SensorId: "kitchen", Metric: Humidity, Value: 60, Timestamp: day.Add(2 * time.Hour)},
+	}
+	if err := store.Import(context.Background(), readings); err != nil {
+		t.Fatal(err)
+	}
+	server := &MetricServiceGrpc{MetricService: store}
+	date := day.Format(dateLayout)
+	query := func(changes func(query *MetricQuery)) *MetricQuery {
+		query := &MetricQuery{Metric: Humidity, SensorId: "kitchen", Date: date, Timezone: "UTC"}
+		if changes != nil {
+			changes(query)
+		}
+		return query
+	}
+	handlers := map[string]func(ctx context.Context, query *MetricQuery) (*Result, error){
+		"GetDailyMax": server.GetDailyMax, "GetWeeklyMax": server.GetWeeklyMax, "GetMonthlyMax": server.GetMonthlyMax,
+		"GetDailyMin": server.GetDailyMin, "GetWeeklyMin": server.GetWeeklyMin, "GetMonthlyMin": server.GetMonthlyMin,
+		"GetDailyAvg": server.GetDailyAvg, "GetWeeklyAvg": server.GetWeeklyAvg, "GetMonthlyAvg": server.GetMonthlyAvg,
+	}
+	want := map[string]string{"Max": "60.00", "Min": "40.00", "Avg": "50.00"}
+	for name, handler := range handlers {
+		res, err := handler(context.Background(), query(nil))
+		if err != nil || res.Value != want[name[len(name)-3:]] {
+			t.Errorf("%s = %v, %v", name, res, err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		query *MetricQuery
+		code  codes.Code
+	}{
+		{"an unknown sensor", query(func(query *MetricQuery) { query.SensorId = "hallway" }), codes.NotFound},
+		{"a day without readings", query(func(query *MetricQuery) { query.Date = "2001-01-01" }), codes.NotFound},
+		{"a malformed date", query(func(query *MetricQuery) { query.Date = "yesterday" }), codes.InvalidArgument},
+		{"an unknown timezone", query(func(query *MetricQuery) { query.Timezone = "Mars/Olympus_Mons" }), codes.InvalidArgument},
+		{"an unknown metric", query(func(query *MetricQuery) { query.Metric = "radiation" }), codes.InvalidArgument},
+		{"an unsupported unit", query(func(query *MetricQuery) { query.Unit = "F" }), codes.InvalidArgument},
+	}
+	for _, test := range tests {
+		for name, handler := range handlers {
+			if _, err := handler(context.Background(), test.query); status.Code(err) != test.code {
+				t.Errorf("%s of %s = %v, want %s", name, test.name, err, test.code)
+			}
+		}
+	}
+
+	saves := []struct {
+		value *SensorMetricValue
+		code  codes.Code
+	}{
+		{&SensorMetricValue{SensorId: "../kitchen", Metric: Humidity, Value: 50}, codes.InvalidArgument},
+		{&SensorMetricValue{SensorId: "kitchen", Metric: "radiation", Value: 50}, codes.InvalidArgument},
+		{&SensorMetricValue{SensorId: "kitchen", Metric: Humidity, Value: math.NaN()}, codes.InvalidArgument},
+		// the store has no broker to publish to
+		{&SensorMetricValue{SensorId: "kitchen", Metric: Humidity, Value: 50}, codes.Internal},
+	}
+	for _, save := range saves {
+		if _, err := server.SaveMetric(context.Background(), save.value); status.Code(err) != save.code {
+			t.Errorf("SaveMetric(%+v) = %v, want %s", save.value, err, save.code)
+		}
+	}
+}
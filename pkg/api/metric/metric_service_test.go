@@ -0,0 +1,182 @@
+package metric
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/andreikom/sensor-server/pkg/storage"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Utility method for saving an entry directly to disk
+func (m *MetricService) saveNewEntryToStore(def *Definition, sensorId string, data float64) error {
+	now := time.Now()
+	sensorData := &Sensor{
+		Id:      sensorId,
+		Metric:  def.Name,
+		Version: sensorRecordVersion,
+		Unit:    def.StoreUnit,
+		Dates:   make(map[string][]Hour),
+	}
+	hour := &Hour{
+		Value:  now.UTC().Hour(),
+		Values: make([]float64, 0),
+	}
+	hour.Values = append(hour.Values, data)
+	sensorData.Dates[now.UTC().Format("2006-01-02")] = append(sensorData.Dates[now.UTC().Format("2006-01-02")], *hour)
+	//serializedData, err := json.Marshal(sensorData)
+	//if err != nil {
+	//	fmt.Printf("Could not have serialized sensor %s data: %s\n", sensorId, err)
+	//	return err
+	//}
+	//err = m.storageDriver.SaveSensorData(def.Name, sensorId, serializedData)
+	//if err != nil {
+	//	fmt.Printf("Could not save data for sensor Id: %s data: %s\n", sensorId, err)
+	//	return err
+	//}
+	return nil
+}
+
+func TestValidateSensorId(t *testing.T) {
+	for _, id := range []string{"kitchen", "living-room_2", "sensor.01", "A.b-C_9"} {
+		if err := ValidateSensorId(id); err != nil {
+			t.Errorf("ValidateSensorId(%q) returned %v", id, err)
+		}
+	}
+	for _, id := range []string{"", ".", "..", "../../x", "a/b", `a\b`, "a..b", "kitchen ", "küche", "a:b"} {
+		var idErr *InvalidSensorIdError
+		if err := ValidateSensorId(id); !errors.As(err, &idErr) {
+			t.Errorf("ValidateSensorId(%q) = %v, want an InvalidSensorIdError", id, err)
+		}
+	}
+}
+
+func TestImportRejectsInvalidSensorIds(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "store"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	store := NewStore(storage.NewFSDriver(filepath.Join(dir, "store")), Options{})
+	readings := []Reading{
+		{SensorId: "kitchen", Metric: Temperature, Value: 21, Timestamp: time.Now()},
+		{SensorId: "../../escaped", Metric: Temperature, Value: 21, Timestamp: time.Now()},
+	}
+	var idErr *InvalidSensorIdError
+	if err := store.Import(context.Background(), readings); !errors.As(err, &idErr) {
+		t.Fatalf("Import returned %v, want an InvalidSensorIdError", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "escaped.json")); !os.IsNotExist(err) {
+		t.Errorf("a record was written outside of the store: %v", err)
+	}
+	if sensors, _ := store.Sensors(Temperature); len(sensors) != 0 {
+		t.Errorf("Import wrote %v although a reading was rejected", sensors)
+	}
+}
+
+func TestSaveMetricRejectsInvalidSensorIds(t *testing.T) {
+	store := NewStore(storage.NewFSDriver(t.TempDir()), Options{})
+	var idErr *InvalidSensorIdError
+	if err := store.SaveMetric(context.Background(), "a/b", Temperature, 21, Celsius); !errors.As(err, &idErr) {
+		t.Errorf("SaveMetric returned %v, want an InvalidSensorIdError", err)
+	}
+}
+
+func TestMigrateSensorRecord(t *testing.T) {
+	tests := []struct {
+		name   string
+		record string
+		want   []float64
+	}{
+		{"version 0 integer readings", `{"id":"kitchen","dates":{"03-14-2022":[{"hour":9,"temp":[20,22]}]}}`, []float64{20, 22}},
+		{"version 1 fahrenheit readings", `{"id":"kitchen","version":1,"unit":"F","dates":{"03-14-2022":[{"hour":9,"temp":[68,71.6]}]}}`, []float64{20, 22}},
+		{"version 2 local dates", `{"id":"kitchen","metric":"temperature","version":2,"unit":"C","dates":{"03-14-2022":[{"hour":9,"values":[20,22]}]}}`, []float64{20, 22}},
+	}
+	utc := time.Date(2022, time.March, 14, 9, 0, 0, 0, time.Local).UTC()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			record := &Sensor{}
+			if err := json.Unmarshal([]byte(test.record), record); err != nil {
+				t.Fatal(err)
+			}
+			if !migrateSensorRecord(TemperatureDefinition, "kitchen", record) {
+				t.Fatal("the record was not migrated")
+			}
+			if record.Version != sensorRecordVersion || record.Unit != Celsius || record.Metric != Temperature {
+				t.Errorf("migrated record is version %d in '%s' of '%s'", record.Version, record.Unit, record.Metric)
+			}
+			hours := record.Dates[utc.Format(dateLayout)]
+			if len(record.Dates) != 1 || len(hours) != 1 || hours[0].Value != utc.Hour() {
+				t.Fatalf("readings were not moved to %s, got %+v", utc, record.Dates)
+			}
+			if len(hours[0].LegacyTemp) != 0 || len(hours[0].Values) != len(test.want) {
+				t.Fatalf("got values %v and legacy values %v, want %v", hours[0].Values, hours[0].LegacyTemp, test.want)
+			}
+			for i, want := range test.want {
+				if math.Abs(hours[0].Values[i]-want) > 1e-9 {
+					t.Errorf("value %d = %v, want %v", i, hours[0].Values[i], want)
+				}
+			}
+			if migrateSensorRecord(TemperatureDefinition, "kitchen", record) {
+				t.Error("a migrated record was migrated again")
+			}
+		})
+	}
+}
+
+func TestOldRecordsAreMigratedOnLoad(t *testing.T) {
+	dir := t.TempDir()
+	folder := filepath.Join(dir, "temperatures")
+	if err := os.MkdirAll(folder, 0755); err != nil {
+		t.Fatal(err)
+	}
+	legacy := `{"id":"kitchen","version":1,"unit":"K","dates":{"03-14-2022":[{"hour":9,"temp":[293.15]}]}}`
+	if err := ioutil.WriteFile(filepath.Join(folder, "kitchen.json"), []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	readOnly := NewReadOnlyStore(storage.NewFSDriver(dir), Options{})
+	utc := time.Date(2022, time.March, 14, 9, 0, 0, 0, time.Local).UTC()
+	if got, err := readOnly.GetStat(Temperature, "kitchen", Day, Max, utc.Format(dateLayout), Celsius, time.UTC); err != nil || math.Abs(got-20) > 1e-9 {
+		t.Errorf("the read-only store returned %v, %v, want 20", got, err)
+	}
+	if err := readOnly.Import(context.Background(), []Reading{{SensorId: "kitchen", Metric: Temperature, Value: 21, Timestamp: utc}}); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("the read-only store imported a reading: %v", err)
+	}
+	if err := readOnly.Compact(); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("the read-only store was compacted: %v", err)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(folder, "kitchen.json")); err != nil || string(data) != legacy {
+		t.Fatalf("the read-only store rewrote the record: %s, %v", data, err)
+	}
+
+	NewStore(storage.NewFSDriver(dir), Options{})
+	data, err := ioutil.ReadFile(filepath.Join(folder, "kitchen.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := Sensor{}
+	if err := json.Unmarshal(data, &record); err != nil {
+		t.Fatal(err)
+	}
+	hours := record.Dates[utc.Format(dateLayout)]
+	if record.Version != sensorRecordVersion || len(hours) != 1 || math.Abs(hours[0].Values[0]-20) > 1e-9 {
+		t.Errorf("the record on disk was not migrated: %s", data)
+	}
+}
+
+func TestSetRetention(t *testing.T) {
+	store := NewStore(storage.NewFSDriver(t.TempDir()), Options{RawRetentionDays: 7, ArchiveRetentionDays: 30})
+	store.SetRetention(14, 0)
+	if rawDays, archiveDays := store.retention(); rawDays != 14 || archiveDays != 0 {
+		t.Errorf("retention = %d, %d, want 14 days of readings and rollups kept forever", rawDays, archiveDays)
+	}
+	// a raw retention of 0 keeps the current one
+	store.SetRetention(0, 90)
+	if rawDays, archiveDays := store.retention(); rawDays != 14 || archiveDays != 90 {
+		t.Errorf("retention = %d, %d, want 14 and 90 days", rawDays, archiveDays)
+	}
+}
@@ -0,0 +1,61 @@
+// Package metrictest - fixtures of the tests of the packages reading and writing through a MetricService,
+// such as the ingestion paths checking the readings it accepts without a broker
+package metrictest
+
+import (
+	"encoding/json"
+	"github.com/andreikom/sensor-server/pkg/api/metric"
+	"github.com/andreikom/sensor-server/pkg/broker"
+	"github.com/andreikom/sensor-server/pkg/storage"
+	"math"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+// NewSpoolingService returns a MetricService whose broker client is never connected, so every reading
+// it accepts ends up in the returned spool
+func NewSpoolingService(t *testing.T) (*metric.MetricService, *broker.Spool) {
+	t.Helper()
+	dir := t.TempDir()
+	spool, err := broker.OpenSpool(filepath.Join(dir, "spool", "messages.jsonl"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := metric.NewMetricService(storage.NewFSDriver(dir), broker.NewClient(broker.Options{}, spool), metric.Options{})
+	t.Cleanup(service.Close)
+	return service, spool
+}
+
+// SpooledReadings removes the readings from the spool
+func SpooledReadings(t *testing.T, spool *broker.Spool) []metric.MetricQueueMsg {
+	t.Helper()
+	readings := make([]metric.MetricQueueMsg, 0)
+	if _, err := spool.Drain(func(entry broker.SpoolEntry) error {
+		msg := metric.MetricQueueMsg{}
+		if err := json.Unmarshal(entry.Body, &msg); err != nil {
+			return err
+		}
+		readings = append(readings, msg)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return readings
+}
+
+// Near - whether two readings are equal but for floating point rounding, e.g. after a unit conversion
+func Near(a float64, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+// FreeAddress - a local TCP address nothing listens on, for servers the test starts itself
+func FreeAddress(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
@@ -0,0 +1,123 @@
+package metric
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// sensorRecordVersion - bumped whenever the on-disk sensor record layout changes
+// version 0 - integer temperature readings, no unit
+// version 1 - float temperature readings, explicit unit
+// version 2 - generic metric records, readings kept under "values"
+// version 3 - dates and hours kept in UTC, ISO-8601 date keys
+const sensorRecordVersion = 3
+
+type Sensor struct {
+	Id      string            `json:"id"`
+	Metric  string            `json:"metric"`
+	Version int               `json:"version"`
+	Unit    Unit              `json:"unit"`
+	Dates   map[string][]Hour `json:"dates"`
+}
+
+type Hour struct {
+	Value  int       `json:"hour"`
+	Values []float64 `json:"values"`
+	// LegacyTemp - readings of temperature records written before version 2, moved to Values on load
+	LegacyTemp []float64 `json:"temp,omitempty"`
+}
+
+// MetricQueueMsg - Represents the RabbitMq model, Value is always in the StoreUnit of the metric
+type MetricQueueMsg struct {
+	SensorId string  `json:"sensorId"`
+	Metric   string  `json:"metric"`
+	Date     string  `json:"date"`
+	Hour     int     `json:"hour"`
+	Value    float64 `json:"value"`
+}
+
+type SensorMetricJson struct {
+	SensorId string  `json:"sensorId"`
+	Metric   string  `json:"metric"`
+	Value    float64 `json:"value"`
+	Unit     string  `json:"unit"`
+}
+
+// sensorIdPattern - sensor ids name the records of the store, they are limited to characters safe in file names
+var sensorIdPattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// InvalidSensorIdError - a sensor id which can not name a sensor record
+type InvalidSensorIdError struct {
+	SensorId string
+}
+
+func (e *InvalidSensorIdError) Error() string {
+	return "invalid sensor id '" + e.SensorId + "', expected letters, digits, '.', '_' or '-'"
+}
+
+// ValidateSensorId rejects sensor ids which are empty, contain characters other than letters, digits, '.', '_'
+// and '-', or contain "..", every reading entering the MetricService is checked
+func ValidateSensorId(sensorId string) error {
+	if !sensorIdPattern.MatchString(sensorId) || sensorId == "." || strings.Contains(sensorId, "..") {
+		return &InvalidSensorIdError{SensorId: sensorId}
+	}
+	return nil
+}
+
+// NotFoundError - the service has no readings for what a query names
+type NotFoundError struct {
+	Name string
+}
+
+func (e *NotFoundError) Error() string {
+	return e.Name + ": not found"
+}
+
+// InvalidArgumentError - a malformed parameter of a request, such as the date or timezone of a query or the value
+// of a reading
+type InvalidArgumentError struct {
+	Reason string
+	Err    error
+}
+
+func (e *InvalidArgumentError) Error() string {
+	if e.Err != nil {
+		return e.Reason + ": " + e.Err.Error()
+	}
+	return e.Reason
+}
+
+func (e *InvalidArgumentError) Unwrap() error {
+	return e.Err
+}
+
+// TimestampOutOfRangeError - a reading timestamp outside the window readings can be stored for
+type TimestampOutOfRangeError struct {
+	Timestamp time.Time
+	Reason    string
+}
+
+func (e *TimestampOutOfRangeError) Error() string {
+	return "timestamp " + e.Timestamp.UTC().Format(time.RFC3339) + " " + e.Reason
+}
+
+// IsInvalid - err is caused by the parameters of a request, such as its sensor id, metric, unit, date or
+// timezone, rather than by the service
+func IsInvalid(err error) bool {
+	var invalidSensorId *InvalidSensorIdError
+	var invalidArgument *InvalidArgumentError
+	var unknownMetric *UnknownMetricError
+	var unknownDerived *UnknownDerivedError
+	var unsupportedUnit *UnsupportedUnitError
+	var outOfRange *TimestampOutOfRangeError
+	return errors.As(err, &invalidSensorId) || errors.As(err, &invalidArgument) || errors.As(err, &unknownMetric) ||
+		errors.As(err, &unknownDerived) || errors.As(err, &unsupportedUnit) || errors.As(err, &outOfRange)
+}
+
+// IsNotFound - err reports a query without readings
+func IsNotFound(err error) bool {
+	var notFound *NotFoundError
+	return errors.As(err, &notFound)
+}
@@ -0,0 +1,50 @@
+package metric
+
+import (
+	"context"
+	"github.com/andreikom/sensor-server/pkg/broker"
+	"github.com/andreikom/sensor-server/pkg/logging"
+	"github.com/andreikom/sensor-server/pkg/storage"
+	"github.com/streadway/amqp"
+	"path/filepath"
+	"testing"
+)
+
+func TestRequestIdsAreCarriedIntoQueueHeaders(t *testing.T) {
+	dir := t.TempDir()
+	spool, err := broker.OpenSpool(filepath.Join(dir, "spool", "messages.jsonl"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := NewMetricService(storage.NewFSDriver(dir), broker.NewClient(broker.Options{}, spool), Options{})
+	t.Cleanup(service.Close)
+	ctx := logging.WithRequestId(context.Background(), "req-42")
+	if err := service.SaveMetric(ctx, "kitchen", Temperature, 21, Celsius); err != nil {
+		t.Fatal(err)
+	}
+	// a reading received without a request id gets a new one
+	if err := service.SaveMetric(context.Background(), "kitchen", Temperature, 21, Celsius); err != nil {
+		t.Fatal(err)
+	}
+	requestIds := make([]string, 0)
+	if _, err := spool.Drain(func(entry broker.SpoolEntry) error {
+		requestId, _ := entry.Headers[requestIdHeader].(string)
+		requestIds = append(requestIds, requestId)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(requestIds) != 2 || requestIds[0] != "req-42" || requestIds[1] == "" || requestIds[1] == "req-42" {
+		t.Errorf("spooled the request ids %q, want req-42 and a new one", requestIds)
+	}
+}
+
+func TestDeliveryLogger(t *testing.T) {
+	logger := deliveryLogger(amqp.Delivery{Headers: amqp.Table{requestIdHeader: "req-42"}})
+	if logger.Data[logging.RequestIdField] != "req-42" {
+		t.Errorf("the logger of a delivery carries %v", logger.Data)
+	}
+	if logger := deliveryLogger(amqp.Delivery{}); len(logger.Data) != 0 {
+		t.Errorf("the logger of a delivery without a request id carries %v", logger.Data)
+	}
+}
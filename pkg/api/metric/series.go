@@ -0,0 +1,47 @@
+package metric
+
+import (
+	"sort"
+	"time"
+)
+
+// Sample - the average of the readings of the UTC hour starting at Time
+type Sample struct {
+	Time  time.Time
+	Value float64
+}
+
+// Sensors returns the ids of the cached sensors of a metric, sorted
+func (m *MetricService) Sensors(metricName string) ([]string, error) {
+	def, err := Lookup(metricName)
+	if err != nil {
+		return nil, err
+	}
+	m.rwMutex.RLock()
+	sensors := make([]string, 0, len(m.weeklySensorCache[def.Name]))
+	for sensorId := range m.weeklySensorCache[def.Name] {
+		sensors = append(sensors, sensorId)
+	}
+	m.rwMutex.RUnlock()
+	sort.Strings(sensors)
+	return sensors, nil
+}
+
+// GetHourlySeries returns the hourly averages of a sensor starting within [from, to) in chronological order,
+// hours before the cached days are read from the archive tier
+func (m *MetricService) GetHourlySeries(metricName string, sensorId string, from time.Time, to time.Time, unit Unit) ([]Sample, error) {
+	def, err := Lookup(metricName)
+	if err != nil {
+		return nil, err
+	}
+	samples := make([]Sample, 0)
+	m.rwMutex.RLock()
+	m.visitHours(def, sensorId, from, to, func(start time.Time, hour HourStats) {
+		samples = append(samples, Sample{Time: start, Value: def.FromStore(unit, hour.Sum/float64(hour.Count))})
+	})
+	m.rwMutex.RUnlock()
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i].Time.Before(samples[j].Time)
+	})
+	return samples, nil
+}
@@ -0,0 +1,236 @@
+package metric
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/andreikom/sensor-server/pkg/storage"
+	"math"
+	"sort"
+	"time"
+)
+
+// ErrNoBroker - readings are published to the broker, which a MetricService opened by NewStore has none of
+var ErrNoBroker = errors.New("the metric store has no broker, readings are written by Import")
+
+// ErrReadOnly - a MetricService opened by NewReadOnlyStore writes nothing
+var ErrReadOnly = errors.New("the metric store is opened read-only")
+
+// Reading - a reading imported into or exported from a store, Value is in Unit, the StoreUnit if empty
+type Reading struct {
+	SensorId  string    `json:"sensorId"`
+	Metric    string    `json:"metric"`
+	Value     float64   `json:"value"`
+	Unit      Unit      `json:"unit,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Rollup - an archived hour of a sensor exported from a store, Min, Max and Sum are in the StoreUnit
+type Rollup struct {
+	SensorId string    `json:"sensorId"`
+	Metric   string    `json:"metric"`
+	Start    time.Time `json:"start"`
+	Min      float64   `json:"min"`
+	Max      float64   `json:"max"`
+	Sum      float64   `json:"sum"`
+	Count    int       `json:"count"`
+}
+
+// NewStore opens the records of driver for maintenance while no server is running on them. Queries work as on
+// the server, readings are written by Import, old entries are only cleaned up by Compact. Records of older
+// versions are rewritten when they are read
+func NewStore(driver storage.Driver, options Options) *MetricService {
+	return openStore(driver, options, false)
+}
+
+// NewReadOnlyStore opens the records of driver for queries and exports, records of older versions are migrated
+// in memory only. Import and Compact return ErrReadOnly
+func NewReadOnlyStore(driver storage.Driver, options Options) *MetricService {
+	return openStore(driver, options, true)
+}
+
+func openStore(driver storage.Driver, options Options, readOnly bool) *MetricService {
+	cache := make(map[string]map[string]Sensor, len(definitions))
+	service := &MetricService{storageDriver: driver, weeklySensorCache: cache, options: options.withDefaults(), quit: make(chan struct{}), readOnly: readOnly}
+	service.initSensorCache()
+	return service
+}
+
+type sensorKey struct {
+	metric   string
+	sensorId string
+}
+
+// Import writes readings straight to the storage driver, every record is written once. Readings of days no
+// longer in the cache are merged into the rollups of the archive tier, readings in the future are rejected.
+// All readings are checked before the first record is written
+func (m *MetricService) Import(ctx context.Context, readings []Reading) error {
+	if m.readOnly {
+		return ErrReadOnly
+	}
+	rawStart := m.rawRetentionStart()
+	m.rwMutex.Lock()
+	defer m.rwMutex.Unlock()
+	records := make(map[sensorKey]Sensor)
+	archives := make(map[sensorKey]*Archive)
+	for i, reading := range readings {
+		def, err := Lookup(reading.Metric)
+		if err != nil {
+			return fmt.Errorf("reading %d: %w", i+1, err)
+		}
+		unit, err := def.ParseUnit(string(reading.Unit))
+		if err != nil {
+			return fmt.Errorf("reading %d: %w", i+1, err)
+		}
+		if err := ValidateSensorId(reading.SensorId); err != nil {
+			return fmt.Errorf("reading %d: %w", i+1, err)
+		}
+		if math.IsNaN(reading.Value) || math.IsInf(reading.Value, 0) {
+			return fmt.Errorf("reading %d: invalid value %v", i+1, reading.Value)
+		}
+		if reading.Timestamp.After(time.Now().Add(maxClockSkew)) {
+			return fmt.Errorf("reading %d: %w", i+1, &TimestampOutOfRangeError{Timestamp: reading.Timestamp, Reason: "is in the future"})
+		}
+		key := sensorKey{metric: def.Name, sensorId: reading.SensorId}
+		record, ok := records[key]
+		if !ok {
+			record, ok = m.weeklySensorCache[def.Name][reading.SensorId]
+			if !ok {
+				record = Sensor{Id: reading.SensorId, Metric: def.Name, Version: sensorRecordVersion, Unit: def.StoreUnit, Dates: make(map[string][]Hour)}
+			}
+		}
+		utc := reading.Timestamp.UTC()
+		date := utc.Format(dateLayout)
+		value := def.ToStore(unit, reading.Value)
+		// a cached day stays authoritative until it is archived, see visitHours
+		if _, cached := record.Dates[date]; !cached && utc.Before(rawStart) {
+			archive, ok := archives[key]
+			if !ok {
+				if archive, err = m.loadArchive(ctx, def, reading.SensorId); err != nil {
+					return err
+				}
+				archives[key] = archive
+			}
+			archive.Dates[date] = addToRollups(archive.Dates[date], utc.Hour(), value)
+			// sensors are listed by their raw records, one is written even without recent readings
+			records[key] = record
+			continue
+		}
+		records[key] = record.withValue(date, utc.Hour(), value)
+	}
+	for key, archive := range archives {
+		if err := m.saveArchive(ctx, definitions[key.metric], key.sensorId, archive); err != nil {
+			return err
+		}
+	}
+	for key, record := range records {
+		if err := m.saveToDisk(ctx, key.metric, key.sensorId, record); err != nil {
+			return err
+		}
+		if m.weeklySensorCache[key.metric] == nil {
+			m.weeklySensorCache[key.metric] = make(map[string]Sensor)
+		}
+		m.weeklySensorCache[key.metric][key.sensorId] = record
+	}
+	return nil
+}
+
+func addToRollups(rollups []HourStats, hour int, value float64) []HourStats {
+	for i := range rollups {
+		if rollups[i].Value == hour {
+			rollups[i].Min = math.Min(rollups[i].Min, value)
+			rollups[i].Max = math.Max(rollups[i].Max, value)
+			rollups[i].Sum += value
+			rollups[i].Count++
+			return rollups
+		}
+	}
+	return append(rollups, HourStats{Value: hour, Min: value, Max: value, Sum: value, Count: 1})
+}
+
+// Export calls visit with the raw readings of a metric in the StoreUnit, by sensor and in chronological order.
+// A reading carries the start of its UTC hour as the timestamp, the time within the hour is not kept
+func (m *MetricService) Export(metricName string, visit func(reading Reading) error) error {
+	def, err := Lookup(metricName)
+	if err != nil {
+		return err
+	}
+	sensors, err := m.Sensors(def.Name)
+	if err != nil {
+		return err
+	}
+	for _, sensorId := range sensors {
+		m.rwMutex.RLock()
+		record := m.weeklySensorCache[def.Name][sensorId]
+		m.rwMutex.RUnlock()
+		dates := make([]string, 0, len(record.Dates))
+		for date := range record.Dates {
+			dates = append(dates, date)
+		}
+		sort.Strings(dates)
+		for _, date := range dates {
+			hours := append([]Hour(nil), record.Dates[date]...)
+			sort.Slice(hours, func(i, j int) bool { return hours[i].Value < hours[j].Value })
+			for _, hour := range hours {
+				start, err := bucketTime(date, hour.Value)
+				if err != nil {
+					return fmt.Errorf("sensor %s: %w", sensorId, err)
+				}
+				for _, value := range hour.Values {
+					if err := visit(Reading{SensorId: sensorId, Metric: def.Name, Value: value, Unit: def.StoreUnit, Timestamp: start}); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// ExportArchive calls visit with the archived hours of a metric, by sensor and in chronological order
+func (m *MetricService) ExportArchive(ctx context.Context, metricName string, visit func(rollup Rollup) error) error {
+	def, err := Lookup(metricName)
+	if err != nil {
+		return err
+	}
+	sensors, err := m.Sensors(def.Name)
+	if err != nil {
+		return err
+	}
+	for _, sensorId := range sensors {
+		archive, err := m.loadArchive(ctx, def, sensorId)
+		if err != nil {
+			return err
+		}
+		dates := make([]string, 0, len(archive.Dates))
+		for date := range archive.Dates {
+			dates = append(dates, date)
+		}
+		sort.Strings(dates)
+		for _, date := range dates {
+			hours := archive.Dates[date]
+			sort.Slice(hours, func(i, j int) bool { return hours[i].Value < hours[j].Value })
+			for _, hour := range hours {
+				start, err := bucketTime(date, hour.Value)
+				if err != nil {
+					return fmt.Errorf("archive of sensor %s: %w", sensorId, err)
+				}
+				rollup := Rollup{SensorId: sensorId, Metric: def.Name, Start: start, Min: hour.Min, Max: hour.Max, Sum: hour.Sum, Count: hour.Count}
+				if err := visit(rollup); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Compact rolls the days past the raw retention up into the archive tier and drops the expired rollups, as
+// the server does twice a day. Records of older versions were already rewritten when the store was opened
+func (m *MetricService) Compact() error {
+	if m.readOnly {
+		return ErrReadOnly
+	}
+	m.cleanOldEntries()
+	return nil
+}
@@ -0,0 +1,143 @@
+package metric
+
+import (
+	"context"
+	"fmt"
+	"github.com/andreikom/sensor-server/pkg/storage"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func exportReadings(t *testing.T, store *MetricService, metricName string) []Reading {
+	t.Helper()
+	readings := make([]Reading, 0)
+	if err := store.Export(metricName, func(reading Reading) error {
+		readings = append(readings, reading)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return readings
+}
+
+func TestImportIsExported(t *testing.T) {
+	dir := t.TempDir()
+	store := NewStore(storage.NewFSDriver(dir), Options{})
+	hour := time.Now().UTC().Truncate(time.Hour).Add(-2 * time.Hour)
+	readings := []Reading{
+		{SensorId: "kitchen", Metric: Temperature, Value: 21.5, Timestamp: hour.Add(50 * time.Minute)},
+		{SensorId: "kitchen", Metric: Temperature, Value: 68, Unit: Fahrenheit, Timestamp: hour.Add(-time.Hour)},
+		{SensorId: "attic", Metric: Temperature, Value: 12, Timestamp: hour},
+		{SensorId: "kitchen", Metric: Humidity, Value: 40, Timestamp: hour},
+	}
+	if err := store.Import(context.Background(), readings); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	// the records were written, a store opened again reads them
+	reopened := NewStore(storage.NewFSDriver(dir), Options{})
+	exported := exportReadings(t, reopened, Temperature)
+	want := []string{
+		fmt.Sprintf("attic 12 C %s", hour.Format(time.RFC3339)),
+		fmt.Sprintf("kitchen 20 C %s", hour.Add(-time.Hour).Format(time.RFC3339)),
+		// the time within the hour is not kept
+		fmt.Sprintf("kitchen 21.5 C %s", hour.Format(time.RFC3339)),
+	}
+	if len(exported) != len(want) {
+		t.Fatalf("exported %+v", exported)
+	}
+	for i, reading := range exported {
+		got := fmt.Sprintf("%s %v %s %s", reading.SensorId, math.Round(reading.Value*1e9)/1e9, reading.Unit, reading.Timestamp.Format(time.RFC3339))
+		if got != want[i] || reading.Metric != Temperature {
+			t.Errorf("reading %d = %s, want %s", i, got, want[i])
+		}
+	}
+	if humidity := exportReadings(t, reopened, Humidity); len(humidity) != 1 || humidity[0].Value != 40 || humidity[0].Unit != RelativeHumidity {
+		t.Errorf("exported the humidity %+v", humidity)
+	}
+	if err := reopened.Export("pressure_altitude", func(Reading) error { return nil }); err == nil {
+		t.Error("an unknown metric was exported")
+	}
+}
+
+func TestImportChecksAllReadingsFirst(t *testing.T) {
+	now := time.Now().UTC()
+	valid := Reading{SensorId: "kitchen", Metric: Temperature, Value: 20, Timestamp: now.Add(-time.Hour)}
+	tests := []struct {
+		invalid Reading
+		err     string
+	}{
+		{Reading{SensorId: "kitchen", Metric: "radiation", Value: 1, Timestamp: now}, "reading 2: "},
+		{Reading{SensorId: "kitchen", Metric: Temperature, Value: 1, Unit: "ppm", Timestamp: now}, "reading 2: "},
+		{Reading{SensorId: "../kitchen", Metric: Temperature, Value: 1, Timestamp: now}, "reading 2: "},
+		{Reading{SensorId: "kitchen", Metric: Temperature, Value: math.NaN(), Timestamp: now}, "reading 2: invalid value NaN"},
+		{Reading{SensorId: "kitchen", Metric: Temperature, Value: math.Inf(1), Timestamp: now}, "reading 2: invalid value +Inf"},
+		{Reading{SensorId: "kitchen", Metric: Temperature, Value: 1, Timestamp: now.Add(48 * time.Hour)}, "is in the future"},
+	}
+	for _, test := range tests {
+		store := NewStore(storage.NewFSDriver(t.TempDir()), Options{})
+		err := store.Import(context.Background(), []Reading{valid, test.invalid})
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("Import of %+v returned %v, want %s", test.invalid, err, test.err)
+		}
+		// the valid reading was not written either
+		if sensors, err := store.Sensors(Temperature); err != nil || len(sensors) != 0 {
+			t.Errorf("Import of %+v wrote the sensors %v, %v", test.invalid, sensors, err)
+		}
+	}
+}
+
+func TestImportOfOldDaysIsArchived(t *testing.T) {
+	store := NewStore(storage.NewFSDriver(t.TempDir()), Options{RawRetentionDays: 2})
+	old := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -10).Add(6 * time.Hour)
+	readings := []Reading{
+		{SensorId: "cellar", Metric: Temperature, Value: 9, Timestamp: old},
+		{SensorId: "cellar", Metric: Temperature, Value: 11, Timestamp: old.Add(20 * time.Minute)},
+		{SensorId: "cellar", Metric: Temperature, Value: 10, Timestamp: old.Add(time.Hour)},
+	}
+	if err := store.Import(context.Background(), readings); err != nil {
+		t.Fatal(err)
+	}
+	// the sensor is listed by its raw record, which holds no readings
+	if exported := exportReadings(t, store, Temperature); len(exported) != 0 {
+		t.Errorf("the raw tier holds %+v", exported)
+	}
+	rollups := make([]Rollup, 0)
+	if err := store.ExportArchive(context.Background(), Temperature, func(rollup Rollup) error {
+		rollups = append(rollups, rollup)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want := []Rollup{
+		{SensorId: "cellar", Metric: Temperature, Start: old, Min: 9, Max: 11, Sum: 20, Count: 2},
+		{SensorId: "cellar", Metric: Temperature, Start: old.Add(time.Hour), Min: 10, Max: 10, Sum: 10, Count: 1},
+	}
+	if fmt.Sprint(rollups) != fmt.Sprint(want) {
+		t.Errorf("archived %+v, want %+v", rollups, want)
+	}
+	if got, err := store.GetStat(Temperature, "cellar", Day, Avg, old.Format(dateLayout), Celsius, time.UTC); err != nil || got != 10 {
+		t.Errorf("the daily average of the archived day = %v, %v, want 10", got, err)
+	}
+}
+
+func TestCompactArchivesOldDays(t *testing.T) {
+	store := NewStore(storage.NewFSDriver(t.TempDir()), Options{RawRetentionDays: 30})
+	old := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -5)
+	if err := store.Import(context.Background(), []Reading{{SensorId: "cellar", Metric: Temperature, Value: 9, Timestamp: old}}); err != nil {
+		t.Fatal(err)
+	}
+	store.SetRetention(2, 0)
+	if err := store.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if exported := exportReadings(t, store, Temperature); len(exported) != 0 {
+		t.Errorf("the raw tier holds %+v after compaction", exported)
+	}
+	if got, err := store.GetStat(Temperature, "cellar", Day, Max, old.Format(dateLayout), Celsius, time.UTC); err != nil || got != 9 {
+		t.Errorf("the daily max of the compacted day = %v, %v, want 9", got, err)
+	}
+}
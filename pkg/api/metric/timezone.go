@@ -0,0 +1,158 @@
+package metric
+
+import (
+	"fmt"
+	"github.com/andreikom/sensor-server/pkg/logging"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+const (
+	// legacyDateLayout - date keys of records written before version 3, in the local time of the server
+	legacyDateLayout = "01-02-2006"
+	monthLayout      = "2006-01"
+)
+
+// Locations - the timezone defining the day and week boundaries of a sensor unless a query overrides it
+type Locations struct {
+	Default *time.Location
+	Sensors map[string]*time.Location
+}
+
+// NewLocations resolves IANA timezone names, an empty default falls back to UTC
+func NewLocations(defaultTz string, sensors map[string]string) (*Locations, error) {
+	defaultLoc, err := time.LoadLocation(defaultTz)
+	if err != nil {
+		return nil, fmt.Errorf("invalid default timezone '%s': %w", defaultTz, err)
+	}
+	locations := &Locations{Default: defaultLoc, Sensors: make(map[string]*time.Location, len(sensors))}
+	for sensorId, tz := range sensors {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone '%s' of sensor %s: %w", tz, sensorId, err)
+		}
+		locations.Sensors[sensorId] = loc
+	}
+	return locations, nil
+}
+
+// For returns the location of a sensor
+func (l *Locations) For(sensorId string) *time.Location {
+	if l == nil {
+		return time.UTC
+	}
+	if loc, ok := l.Sensors[sensorId]; ok {
+		return loc
+	}
+	if l.Default == nil {
+		return time.UTC
+	}
+	return l.Default
+}
+
+// ParseLocation resolves an optional IANA timezone from user input, nil means the sensor default
+func ParseLocation(tz string) (*time.Location, error) {
+	if tz == "" {
+		return nil, nil
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, &InvalidArgumentError{Reason: fmt.Sprintf("invalid timezone '%s'", tz), Err: err}
+	}
+	return loc, nil
+}
+
+// ParseDate normalizes an ISO-8601 calendar date (2006-01-02) to a date key. An ISO week (2006-W01)
+// resolves to its Monday and a month (2006-01) to its first day, so they can anchor weekly and monthly
+// statistics. The MM-DD-YYYY form of the routes predating timezone support is still accepted
+func ParseDate(date string) (string, error) {
+	if parsed, err := time.Parse(dateLayout, date); err == nil {
+		return parsed.Format(dateLayout), nil
+	}
+	if parsed, err := time.Parse(monthLayout, date); err == nil {
+		return parsed.Format(dateLayout), nil
+	}
+	var year, week int
+	if n, err := fmt.Sscanf(date, "%4d-W%2d", &year, &week); err == nil && n == 2 && len(date) == 8 && week >= 1 && week <= 53 {
+		return isoWeekMonday(year, week).Format(dateLayout), nil
+	}
+	if parsed, err := time.Parse(legacyDateLayout, date); err == nil {
+		return parsed.Format(dateLayout), nil
+	}
+	return "", &InvalidArgumentError{Reason: fmt.Sprintf("invalid date '%s', expected ISO-8601 YYYY-MM-DD, YYYY-Www or YYYY-MM", date)}
+}
+
+// isoWeekMonday - January 4th always falls into the first ISO week of its year
+func isoWeekMonday(year int, week int) time.Time {
+	jan4 := time.Date(year, time.January, 4, 0, 0, 0, 0, time.UTC)
+	week1Monday := jan4.AddDate(0, 0, -((int(jan4.Weekday()) + 6) % 7))
+	return week1Monday.AddDate(0, 0, (week-1)*7)
+}
+
+// bucketTime - the start of an hour bucket kept under a UTC date key
+func bucketTime(date string, hour int) (time.Time, error) {
+	day, err := time.Parse(dateLayout, date)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return day.Add(time.Duration(hour) * time.Hour), nil
+}
+
+// inLocation regroups the UTC hour buckets of a sensor into the dates and hours of loc.
+// Buckets are whole UTC hours, so for zones with a fractional hour offset a bucket is
+// assigned to the local hour its start falls into.
+func inLocation(sensorEntry Sensor, loc *time.Location) Sensor {
+	local := Sensor{
+		Id:      sensorEntry.Id,
+		Metric:  sensorEntry.Metric,
+		Version: sensorEntry.Version,
+		Unit:    sensorEntry.Unit,
+		Dates:   make(map[string][]Hour, len(sensorEntry.Dates)),
+	}
+	for date, hours := range sensorEntry.Dates {
+		for _, hour := range hours {
+			start, err := bucketTime(date, hour.Value)
+			if err != nil {
+				logging.Log.WithFields(logrus.Fields{"sensorId": sensorEntry.Id, "date": date}).Warn("Could not have parsed a date")
+				continue
+			}
+			localStart := start.In(loc)
+			localDate := localStart.Format(dateLayout)
+			local.Dates[localDate] = mergeHour(local.Dates[localDate], localStart.Hour(), hour.Values)
+		}
+	}
+	return local
+}
+
+// mergeHour appends values to the given hour of a date, two UTC buckets can map onto the same local hour
+// when clocks are turned back
+func mergeHour(hours []Hour, hour int, values []float64) []Hour {
+	for i := range hours {
+		if hours[i].Value == hour {
+			hours[i].Values = append(hours[i].Values, values...)
+			return hours
+		}
+	}
+	return append(hours, Hour{Value: hour, Values: append([]float64(nil), values...)})
+}
+
+// migrateLegacyDates moves the buckets of a record keyed by the server local date and hour to UTC keys
+func migrateLegacyDates(sensor *Sensor) {
+	migrated := make(map[string][]Hour, len(sensor.Dates))
+	for date, hours := range sensor.Dates {
+		day, err := time.ParseInLocation(legacyDateLayout, date, time.Local)
+		if err != nil {
+			// already an ISO-8601 key
+			for _, hour := range hours {
+				migrated[date] = mergeHour(migrated[date], hour.Value, hour.Values)
+			}
+			continue
+		}
+		for _, hour := range hours {
+			start := time.Date(day.Year(), day.Month(), day.Day(), hour.Value, 0, 0, 0, time.Local).UTC()
+			utcDate := start.Format(dateLayout)
+			migrated[utcDate] = mergeHour(migrated[utcDate], start.Hour(), hour.Values)
+		}
+	}
+	sensor.Dates = migrated
+}
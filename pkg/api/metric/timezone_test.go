@@ -0,0 +1,124 @@
+package metric
+
+import (
+	"context"
+	"github.com/andreikom/sensor-server/pkg/storage"
+	"testing"
+	"time"
+)
+
+func TestLocations(t *testing.T) {
+	locations, err := NewLocations("Europe/Berlin", map[string]string{"cabin": "America/Anchorage"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := locations.For("cabin").String(); got != "America/Anchorage" {
+		t.Errorf("For(cabin) = %s", got)
+	}
+	if got := locations.For("kitchen").String(); got != "Europe/Berlin" {
+		t.Errorf("For(kitchen) = %s, want the default", got)
+	}
+	if got := (*Locations)(nil).For("kitchen"); got != time.UTC {
+		t.Errorf("nil Locations resolved %s, want UTC", got)
+	}
+	if locations, err := NewLocations("", nil); err != nil || locations.For("kitchen") != time.UTC {
+		t.Errorf("an empty default timezone resolved %v, %v", locations, err)
+	}
+	if _, err := NewLocations("Mars/Olympus_Mons", nil); err == nil {
+		t.Error("an unknown default timezone was accepted")
+	}
+	if _, err := NewLocations("UTC", map[string]string{"cabin": "Nowhere"}); err == nil {
+		t.Error("an unknown sensor timezone was accepted")
+	}
+	if loc, err := ParseLocation(""); loc != nil || err != nil {
+		t.Errorf("ParseLocation(\"\") = %v, %v, want the sensor default", loc, err)
+	}
+	if _, err := ParseLocation("Nowhere"); err == nil {
+		t.Error("ParseLocation accepted an unknown timezone")
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"2022-03-14", "2022-03-14"},
+		{"03-14-2022", "2022-03-14"},
+		{"2022-03", "2022-03-01"},
+		{"2022-W11", "2022-03-14"},
+		{"2021-W01", "2021-01-04"},
+		{"2020-W53", "2020-12-28"},
+		// the first ISO week of 2025 starts in 2024
+		{"2025-W01", "2024-12-30"},
+	}
+	for _, test := range tests {
+		got, err := ParseDate(test.input)
+		if err != nil || got != test.want {
+			t.Errorf("ParseDate(%q) = %q, %v, want %q", test.input, got, err, test.want)
+		}
+	}
+	for _, input := range []string{"", "2022-13-01", "2022-02-30", "2022-W00", "2022-W54", "2022-W1", "14.03.2022"} {
+		if got, err := ParseDate(input); err == nil {
+			t.Errorf("ParseDate(%q) = %q, want an error", input, got)
+		}
+	}
+}
+
+func TestInLocation(t *testing.T) {
+	newYork, _ := time.LoadLocation("America/New_York")
+	kolkata, _ := time.LoadLocation("Asia/Kolkata")
+	sensor := Sensor{Id: "kitchen", Dates: map[string][]Hour{
+		// 2021-11-07 05:00 and 06:00 UTC are both 01:00 in New York, clocks were turned back at 06:00 UTC
+		"2021-11-07": {{Value: 5, Values: []float64{1}}, {Value: 6, Values: []float64{2}}},
+		"2021-11-08": {{Value: 20, Values: []float64{3}}},
+	}}
+
+	local := inLocation(sensor, newYork)
+	hours := local.Dates["2021-11-07"]
+	if len(hours) != 1 || hours[0].Value != 1 || len(hours[0].Values) != 2 {
+		t.Errorf("the repeated hour was not merged: %+v", local.Dates)
+	}
+	if hours := local.Dates["2021-11-08"]; len(hours) != 1 || hours[0].Value != 15 {
+		t.Errorf("2021-11-08 20:00 UTC = %+v, want hour 15", hours)
+	}
+	// the cached record is left untouched
+	if len(sensor.Dates["2021-11-07"]) != 2 || len(sensor.Dates["2021-11-07"][0].Values) != 1 {
+		t.Errorf("inLocation changed the record: %+v", sensor.Dates)
+	}
+
+	// 20:00 UTC is 01:30 of the next day in Kolkata, the bucket is assigned to the hour its start falls into
+	local = inLocation(sensor, kolkata)
+	if hours := local.Dates["2021-11-09"]; len(hours) != 1 || hours[0].Value != 1 {
+		t.Errorf("2021-11-08 20:00 UTC in Kolkata = %+v, want 2021-11-09 hour 1", local.Dates)
+	}
+}
+
+func TestDailyStatsFollowTheSensorTimezone(t *testing.T) {
+	tokyo, _ := time.LoadLocation("Asia/Tokyo")
+	locations := &Locations{Default: time.UTC, Sensors: map[string]*time.Location{"kitchen": tokyo}}
+	store := NewStore(storage.NewFSDriver(t.TempDir()), Options{Locations: locations})
+	day := time.Now().In(tokyo).AddDate(0, 0, -2)
+	midnight := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, tokyo)
+	readings := []Reading{
+		// the hour before midnight belongs to the previous day in Tokyo but to the same UTC day
+		{SensorId: "kitchen", Metric: Temperature, Value: 10, Timestamp: midnight.Add(-time.Hour)},
+		{SensorId: "kitchen", Metric: Temperature, Value: 20, Timestamp: midnight.Add(time.Hour)},
+		{SensorId: "kitchen", Metric: Temperature, Value: 30, Timestamp: midnight.Add(23 * time.Hour)},
+	}
+	if err := store.Import(context.Background(), readings); err != nil {
+		t.Fatal(err)
+	}
+	date := midnight.Format(dateLayout)
+	if got, err := store.GetStat(Temperature, "kitchen", Day, Min, date, Celsius, nil); err != nil || got != 20 {
+		t.Errorf("daily min in the sensor timezone = %v, %v, want 20", got, err)
+	}
+	if got, err := store.GetStat(Temperature, "kitchen", Day, Max, date, Celsius, nil); err != nil || got != 30 {
+		t.Errorf("daily max in the sensor timezone = %v, %v, want 30", got, err)
+	}
+	// overridden by the query, midnight in Tokyo is 15:00 UTC of the previous day which holds the first two readings
+	utcDate := midnight.UTC().Format(dateLayout)
+	if got, err := store.GetStat(Temperature, "kitchen", Day, Max, utcDate, Celsius, time.UTC); err != nil || got != 20 {
+		t.Errorf("daily max in UTC = %v, %v, want 20", got, err)
+	}
+}
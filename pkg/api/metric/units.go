@@ -0,0 +1,175 @@
+package metric
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	Temperature = "temperature"
+	Humidity    = "humidity"
+	CO2         = "co2"
+	Pressure    = "pressure"
+)
+
+// Unit - the unit a reading is reported or queried in.
+// Readings are always kept in the StoreUnit of their metric inside the cache and on disk.
+type Unit string
+
+const (
+	Celsius          Unit = "C"
+	Fahrenheit       Unit = "F"
+	Kelvin           Unit = "K"
+	RelativeHumidity Unit = "%"
+	PartsPerMillion  Unit = "ppm"
+	PartsPerBillion  Unit = "ppb"
+	Hectopascal      Unit = "hPa"
+	Pascal           Unit = "Pa"
+	Kilopascal       Unit = "kPa"
+	InchOfMercury    Unit = "inHg"
+)
+
+// conversion - linear mapping of a unit onto the store unit of a metric: store = value*scale + offset
+type conversion struct {
+	scale  float64
+	offset float64
+}
+
+type Definition struct {
+	Name      string
+	StoreUnit Unit
+	units     map[Unit]conversion
+	aliases   map[string]Unit // upper-cased user input -> unit
+}
+
+var TemperatureDefinition = &Definition{
+	Name:      Temperature,
+	StoreUnit: Celsius,
+	units: map[Unit]conversion{
+		Celsius:    {scale: 1},
+		Fahrenheit: {scale: 5.0 / 9.0, offset: -32 * 5.0 / 9.0},
+		Kelvin:     {scale: 1, offset: -273.15},
+	},
+	aliases: map[string]Unit{"CELSIUS": Celsius, "FAHRENHEIT": Fahrenheit, "KELVIN": Kelvin},
+}
+
+var HumidityDefinition = &Definition{
+	Name:      Humidity,
+	StoreUnit: RelativeHumidity,
+	units:     map[Unit]conversion{RelativeHumidity: {scale: 1}},
+	aliases:   map[string]Unit{"RH": RelativeHumidity, "PERCENT": RelativeHumidity},
+}
+
+var CO2Definition = &Definition{
+	Name:      CO2,
+	StoreUnit: PartsPerMillion,
+	units: map[Unit]conversion{
+		PartsPerMillion: {scale: 1},
+		PartsPerBillion: {scale: 0.001},
+	},
+}
+
+var PressureDefinition = &Definition{
+	Name:      Pressure,
+	StoreUnit: Hectopascal,
+	units: map[Unit]conversion{
+		Hectopascal:   {scale: 1},
+		Pascal:        {scale: 0.01},
+		Kilopascal:    {scale: 10},
+		InchOfMercury: {scale: 33.8639},
+	},
+	aliases: map[string]Unit{"MBAR": Hectopascal},
+}
+
+var definitions = map[string]*Definition{
+	Temperature: TemperatureDefinition,
+	Humidity:    HumidityDefinition,
+	CO2:         CO2Definition,
+	Pressure:    PressureDefinition,
+}
+
+type UnknownMetricError struct {
+	Metric string
+}
+
+func (e *UnknownMetricError) Error() string {
+	return fmt.Sprintf("unknown metric '%s', expected one of %s", e.Metric, strings.Join(Names(), ", "))
+}
+
+type UnsupportedUnitError struct {
+	Metric string
+	Unit   string
+}
+
+func (e *UnsupportedUnitError) Error() string {
+	return fmt.Sprintf("unsupported unit '%s' for metric %s", e.Unit, e.Metric)
+}
+
+// Lookup resolves a metric definition by its name
+func Lookup(name string) (*Definition, error) {
+	def, ok := definitions[strings.ToLower(name)]
+	if !ok {
+		return nil, &UnknownMetricError{Metric: name}
+	}
+	return def, nil
+}
+
+// Names returns the names of all known metrics, sorted
+func Names() []string {
+	names := make([]string, 0, len(definitions))
+	for name := range definitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseUnit resolves a unit of this metric from user input, an empty value falls back to the StoreUnit
+func (d *Definition) ParseUnit(unit string) (Unit, error) {
+	trimmed := strings.TrimSpace(unit)
+	if trimmed == "" {
+		return d.StoreUnit, nil
+	}
+	for known := range d.units {
+		if strings.EqualFold(string(known), trimmed) {
+			return known, nil
+		}
+	}
+	if alias, ok := d.aliases[strings.ToUpper(trimmed)]; ok {
+		return alias, nil
+	}
+	return "", &UnsupportedUnitError{Metric: d.Name, Unit: unit}
+}
+
+// ToStore converts a value reported in unit to the StoreUnit
+func (d *Definition) ToStore(unit Unit, value float64) float64 {
+	conv, ok := d.units[unit]
+	if !ok {
+		return value
+	}
+	return value*conv.scale + conv.offset
+}
+
+// FromStore converts a value kept in the StoreUnit to unit
+func (d *Definition) FromStore(unit Unit, value float64) float64 {
+	conv, ok := d.units[unit]
+	if !ok {
+		return value
+	}
+	return (value - conv.offset) / conv.scale
+}
+
+// DeltaFromStore converts a difference between two values kept in the StoreUnit to unit, offsets cancel out
+func (d *Definition) DeltaFromStore(unit Unit, delta float64) float64 {
+	conv, ok := d.units[unit]
+	if !ok {
+		return delta
+	}
+	return delta / conv.scale
+}
+
+// FormatReading - the textual representation of a reading used by both HTTP and gRPC responses
+func FormatReading(value float64) string {
+	return fmt.Sprintf("%.2f", value)
+}
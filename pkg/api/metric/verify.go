@@ -0,0 +1,155 @@
+package metric
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/andreikom/sensor-server/pkg/storage"
+	"os"
+	"time"
+)
+
+const (
+	// SeverityError - the record is corrupt, readings are lost or wrong
+	SeverityError = "error"
+	// SeverityWarning - the record is usable, the server or Compact repairs it
+	SeverityWarning = "warning"
+	rawTier         = "raw"
+	archiveTier     = "archive"
+)
+
+// Problem - an inconsistency of a record found by Verify
+type Problem struct {
+	Severity string `json:"severity"`
+	Metric   string `json:"metric"`
+	SensorId string `json:"sensorId"`
+	Tier     string `json:"tier"`
+	Message  string `json:"message"`
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %s %s of sensor %s: %s", p.Severity, p.Metric, p.Tier, p.SensorId, p.Message)
+}
+
+// Verify reads every record of driver as stored, without migrating it, and returns the problems found.
+// The error is only set if the records could not be listed
+func Verify(ctx context.Context, driver storage.Driver) ([]Problem, error) {
+	problems := make([]Problem, 0)
+	for _, name := range Names() {
+		def := definitions[name]
+		sensors, err := driver.GetAvailableSensors(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, sensorId := range sensors {
+			report := func(severity string, tier string, format string, args ...interface{}) {
+				problems = append(problems, Problem{Severity: severity, Metric: name, SensorId: sensorId, Tier: tier, Message: fmt.Sprintf(format, args...)})
+			}
+			record, ok := verifyRecord(ctx, driver, def, sensorId, report)
+			verifyArchive(ctx, driver, def, sensorId, record, ok, report)
+		}
+	}
+	return problems, nil
+}
+
+type reportFunc func(severity string, tier string, format string, args ...interface{})
+
+func verifyRecord(ctx context.Context, driver storage.Driver, def *Definition, sensorId string, report reportFunc) (Sensor, bool) {
+	record := Sensor{}
+	data, err := driver.GetSensorData(ctx, def.Name, sensorId)
+	if err != nil {
+		report(SeverityError, rawTier, "could not be read: %v", err)
+		return record, false
+	}
+	if err := json.Unmarshal(data, &record); err != nil {
+		report(SeverityError, rawTier, "could not be parsed: %v", err)
+		return record, false
+	}
+	if record.Version < sensorRecordVersion {
+		report(SeverityWarning, rawTier, "version %d is migrated to %d on the next start or compaction", record.Version, sensorRecordVersion)
+	} else if record.Version > sensorRecordVersion {
+		report(SeverityError, rawTier, "version %d was written by a newer server", record.Version)
+	}
+	if record.Id != "" && record.Id != sensorId {
+		report(SeverityError, rawTier, "the record belongs to sensor %s", record.Id)
+	}
+	if record.Metric != "" && record.Metric != def.Name {
+		report(SeverityError, rawTier, "the record belongs to metric %s", record.Metric)
+	}
+	if record.Version >= sensorRecordVersion && record.Unit != def.StoreUnit {
+		report(SeverityError, rawTier, "unit %s instead of %s", record.Unit, def.StoreUnit)
+	}
+	for date, hours := range record.Dates {
+		if !validDate(date, record.Version, report, rawTier) {
+			continue
+		}
+		seen := make(map[int]bool, len(hours))
+		for _, hour := range hours {
+			verifyHour(date, hour.Value, seen, report, rawTier)
+			if len(hour.Values) == 0 && len(hour.LegacyTemp) == 0 {
+				report(SeverityWarning, rawTier, "%s hour %d has no readings", date, hour.Value)
+			}
+		}
+	}
+	return record, true
+}
+
+func verifyArchive(ctx context.Context, driver storage.Driver, def *Definition, sensorId string, record Sensor, recordOk bool, report reportFunc) {
+	data, err := driver.GetArchiveData(ctx, def.Name, sensorId)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		report(SeverityError, archiveTier, "could not be read: %v", err)
+		return
+	}
+	archive := Archive{}
+	if err := json.Unmarshal(data, &archive); err != nil {
+		report(SeverityError, archiveTier, "could not be parsed: %v", err)
+		return
+	}
+	if archive.Unit != "" && archive.Unit != def.StoreUnit {
+		report(SeverityError, archiveTier, "unit %s instead of %s", archive.Unit, def.StoreUnit)
+	}
+	for date, hours := range archive.Dates {
+		if !validDate(date, sensorRecordVersion, report, archiveTier) {
+			continue
+		}
+		if _, ok := record.Dates[date]; recordOk && ok {
+			report(SeverityWarning, archiveTier, "%s is also in the raw tier after an interrupted archival, compaction replaces the rollups", date)
+		}
+		seen := make(map[int]bool, len(hours))
+		for _, hour := range hours {
+			verifyHour(date, hour.Value, seen, report, archiveTier)
+			switch {
+			case hour.Count <= 0:
+				report(SeverityError, archiveTier, "%s hour %d has a count of %d", date, hour.Value, hour.Count)
+			case hour.Min > hour.Max:
+				report(SeverityError, archiveTier, "%s hour %d has a minimum above its maximum", date, hour.Value)
+			}
+		}
+	}
+}
+
+// validDate - records before version 3 are keyed by the local dates of the server, in no fixed layout
+func validDate(date string, version int, report reportFunc, tier string) bool {
+	if version < 3 {
+		return true
+	}
+	if _, err := time.Parse(dateLayout, date); err != nil {
+		report(SeverityError, tier, "invalid date %s", date)
+		return false
+	}
+	return true
+}
+
+func verifyHour(date string, hour int, seen map[int]bool, report reportFunc, tier string) {
+	if hour < 0 || hour > 23 {
+		report(SeverityError, tier, "%s has an invalid hour %d", date, hour)
+	}
+	if seen[hour] {
+		report(SeverityError, tier, "%s hour %d is listed more than once", date, hour)
+	}
+	seen[hour] = true
+}
@@ -0,0 +1,90 @@
+package metric
+
+import (
+	"context"
+	"github.com/andreikom/sensor-server/pkg/storage"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyOfAHealthyStore(t *testing.T) {
+	driver := storage.NewFSDriver(t.TempDir())
+	store := NewStore(driver, Options{RawRetentionDays: 2})
+	now := time.Now().UTC()
+	readings := []Reading{
+		{SensorId: "kitchen", Metric: Temperature, Value: 20, Timestamp: now.Add(-time.Hour)},
+		{SensorId: "kitchen", Metric: Temperature, Value: 19, Timestamp: now.AddDate(0, 0, -10)},
+		{SensorId: "bathroom", Metric: Humidity, Value: 60, Timestamp: now.Add(-time.Hour)},
+	}
+	if err := store.Import(context.Background(), readings); err != nil {
+		t.Fatal(err)
+	}
+	problems, err := Verify(context.Background(), driver)
+	if err != nil || len(problems) != 0 {
+		t.Errorf("Verify = %v, %v", problems, err)
+	}
+}
+
+func TestVerifyFindsProblems(t *testing.T) {
+	driver := storage.NewFSDriver(t.TempDir())
+	ctx := context.Background()
+	records := map[string]string{
+		"corrupt":   `{"id":`,
+		"newer":     `{"id":"newer","metric":"temperature","version":9,"unit":"C","dates":{}}`,
+		"older":     `{"id":"older","version":2,"dates":{"19/10/2026":[{"hour":1,"values":[20]}]}}`,
+		"moved":     `{"id":"other","metric":"temperature","version":3,"unit":"C","dates":{}}`,
+		"kelvin":    `{"id":"kelvin","metric":"temperature","version":3,"unit":"K","dates":{}}`,
+		"hours":     `{"id":"hours","metric":"temperature","version":3,"unit":"C","dates":{"2026-10-18":[{"hour":24,"values":[20]},{"hour":3,"values":[1]},{"hour":3,"values":[]}],"18/10/2026":[]}}`,
+		"archived":  `{"id":"archived","metric":"temperature","version":3,"unit":"C","dates":{"2026-10-01":[{"hour":1,"values":[20]}]}}`,
+		"archiveOk": `{"id":"archiveOk","metric":"temperature","version":3,"unit":"C","dates":{}}`,
+	}
+	for sensorId, record := range records {
+		if err := driver.SaveSensorData(ctx, Temperature, sensorId, []byte(record)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	archives := map[string]string{
+		"archived":  `{"id":"archived","metric":"temperature","version":3,"unit":"C","dates":{"2026-10-01":[{"hour":1,"min":5,"max":4,"sum":9,"count":2},{"hour":2,"min":1,"max":1,"sum":1,"count":0}]}}`,
+		"archiveOk": `not json`,
+	}
+	for sensorId, archive := range archives {
+		if err := driver.SaveArchiveData(ctx, Temperature, sensorId, []byte(archive)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	problems, err := Verify(ctx, driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := make([]string, 0, len(problems))
+	for _, problem := range problems {
+		found = append(found, problem.String())
+	}
+	sort.Strings(found)
+	want := []string{
+		"error: temperature archive of sensor archiveOk: could not be parsed",
+		"error: temperature archive of sensor archived: 2026-10-01 hour 1 has a minimum above its maximum",
+		"error: temperature archive of sensor archived: 2026-10-01 hour 2 has a count of 0",
+		"error: temperature raw of sensor corrupt: could not be parsed",
+		"error: temperature raw of sensor hours: 2026-10-18 has an invalid hour 24",
+		"error: temperature raw of sensor hours: 2026-10-18 hour 3 is listed more than once",
+		"error: temperature raw of sensor hours: invalid date 18/10/2026",
+		"error: temperature raw of sensor kelvin: unit K instead of C",
+		"error: temperature raw of sensor moved: the record belongs to sensor other",
+		"error: temperature raw of sensor newer: version 9 was written by a newer server",
+		"warning: temperature archive of sensor archived: 2026-10-01 is also in the raw tier after an interrupted archival",
+		"warning: temperature raw of sensor hours: 2026-10-18 hour 3 has no readings",
+		"warning: temperature raw of sensor older: version 2 is migrated to 3 on the next start or compaction",
+	}
+	sort.Strings(want)
+	if len(found) != len(want) {
+		t.Fatalf("Verify found:\n%s", strings.Join(found, "\n"))
+	}
+	for i := range want {
+		if !strings.HasPrefix(found[i], want[i]) {
+			t.Errorf("problem %d = %s, want %s", i, found[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/andreikom/sensor-server/pkg/api/metric"
+	"github.com/andreikom/sensor-server/pkg/auth"
+	"github.com/andreikom/sensor-server/pkg/logging"
+	"github.com/gorilla/mux"
+	"net/http"
+	"time"
+)
+
+type metricController struct {
+	metricService *metric.MetricService
+}
+
+func (c *metricController) SaveMetric(w http.ResponseWriter, req *http.Request) {
+	sensorMetric := &metric.SensorMetricJson{}
+	err := json.NewDecoder(req.Body).Decode(&sensorMetric)
+	if err != nil {
+		combinedErr := fmt.Sprintf("Could not have parse the payload: %s", err)
+		logging.FromContext(req.Context()).WithError(err).Warn("Could not have parse the payload")
+		http.Error(w, combinedErr, http.StatusBadRequest)
+		return
+	}
+	if err := auth.AllowSensor(req.Context(), sensorMetric.SensorId); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	unit, err := metric.ParseUnit(sensorMetric.Metric, sensorMetric.Unit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := metric.ValidateSensorId(sensorMetric.SensorId); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := c.metricService.SaveMetric(req.Context(), sensorMetric.SensorId, sensorMetric.Metric, sensorMetric.Value, unit); err != nil {
+		combinedErr := fmt.Sprintf("Could not have saved to storage: %s", err)
+		logging.FromContext(req.Context()).WithError(err).WithField("sensorId", sensorMetric.SensorId).Error("Could not have saved to storage")
+		http.Error(w, combinedErr, http.StatusInternalServerError)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (c *metricController) GetMetrics(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(metric.Names()); err != nil {
+		logging.FromContext(req.Context()).WithError(err).WithField("endpoint", "metrics").Warn("Could not write a response")
+	}
+}
+
+// GetStat serves a statistic over the day, week or calendar month containing the 'date' route variable,
+// routes without a date anchor the period at today
+func (c *metricController) GetStat(period metric.Period, stat metric.Stat) http.HandlerFunc {
+	endpoint := string(period) + "_" + string(stat)
+	return func(w http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+		unit, ok := queryMetricUnit(w, req, vars["metric"])
+		if !ok {
+			return
+		}
+		loc, ok := queryLocation(w, req)
+		if !ok {
+			return
+		}
+		res, err := c.metricService.GetStat(vars["metric"], vars["sensorId"], period, stat, vars["date"], unit, loc)
+		writeMetricResult(w, endpoint, res, err)
+	}
+}
+
+// GetDerived serves a derived series over the day or week containing the 'date' route variable, routes
+// without a date anchor the period at today
+func (c *metricController) GetDerived(period metric.Period) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+		c.writeDerivedSeries(w, req, vars["derived"], vars["sensorId"], period, vars["date"])
+	}
+}
+
+func (c *metricController) writeDerivedSeries(w http.ResponseWriter, req *http.Request, derived string, sensorId string, period metric.Period, date string) {
+	sourceMetric := req.URL.Query().Get("metric")
+	unitMetric, err := metric.DerivedMetricName(derived, sourceMetric)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	unit, ok := queryMetricUnit(w, req, unitMetric)
+	if !ok {
+		return
+	}
+	loc, ok := queryLocation(w, req)
+	if !ok {
+		return
+	}
+	series, err := c.metricService.GetDerivedSeries(metric.DerivedQuery{
+		Derived:  derived,
+		SensorId: sensorId,
+		Period:   period,
+		Date:     date,
+		Metric:   unitMetric,
+		Unit:     unit,
+		Location: loc,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), metricErrorStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(series); err != nil {
+		logging.FromContext(req.Context()).WithError(err).WithField("endpoint", derived).Warn("Could not write a response")
+	}
+}
+
+// queryMetricUnit resolves the optional 'unit' query parameter of a metric, writes a bad request response
+// if either the metric or the unit are not supported
+func queryMetricUnit(w http.ResponseWriter, req *http.Request, metricName string) (metric.Unit, bool) {
+	unit, err := metric.ParseUnit(metricName, req.URL.Query().Get("unit"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	return unit, true
+}
+
+// queryLocation resolves the optional 'tz' query parameter, an IANA timezone defining day boundaries.
+// nil means the location configured for the sensor
+func queryLocation(w http.ResponseWriter, req *http.Request) (*time.Location, bool) {
+	loc, err := metric.ParseLocation(req.URL.Query().Get("tz"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return loc, true
+}
+
+func writeMetricResult(w http.ResponseWriter, endpoint string, res float64, err error) {
+	if err != nil {
+		http.Error(w, err.Error(), metricErrorStatus(err))
+		return
+	}
+	if _, err := w.Write([]byte(metric.FormatReading(res))); err != nil {
+		logging.Log.WithError(err).WithField("endpoint", endpoint).Warn("Could not write a response")
+	}
+}
+
+// metricErrorStatus - 400 for a malformed date, timezone or sensor id, 404 for a sensor or period without
+// readings and 500 otherwise
+func metricErrorStatus(err error) int {
+	switch {
+	case metric.IsInvalid(err):
+		return http.StatusBadRequest
+	case metric.IsNotFound(err):
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"context"
+	"github.com/andreikom/sensor-server/pkg/api/metric"
+	"github.com/andreikom/sensor-server/pkg/api/temperature"
+	"github.com/andreikom/sensor-server/pkg/health"
+	"github.com/andreikom/sensor-server/pkg/storage"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricQueryStatuses(t *testing.T) {
+	day := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -1)
+	// the current week, which routes without a date query, contains the day
+	store := metric.NewStore(storage.NewFSDriver(t.TempDir()), metric.Options{WeekStart: day.Weekday()})
+	readings := []metric.Reading{
+		{SensorId: "kitchen", Metric: metric.Temperature, Value: 20, Timestamp: day.Add(8 * time.Hour)},
+		{SensorId: "kitchen", Metric: metric.Temperature, Value: 23, Timestamp: day.Add(10 * time.Hour)},
+		{SensorId: "kitchen", Metric: metric.Humidity, Value: 50, Timestamp: day.Add(10 * time.Hour)},
+	}
+	if err := store.Import(context.Background(), readings); err != nil {
+		t.Fatal(err)
+	}
+	connProcessing = newThrottle(maxConnections)
+	httpServer := newHttpServer(&tempController{tempService: temperature.NewTempService(store)}, &metricController{metricService: store},
+		&adminController{metricService: store}, &influxController{metricService: store}, &promController{metricService: store},
+		&healthController{checker: health.NewChecker(), started: time.Now()}, nil, DefaultConfig())
+	date := day.Format("2006-01-02")
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/metric/temperature/daily_max/kitchen/" + date + "?tz=UTC", http.StatusOK, "23.00"},
+		{"/metric/temperature/weekly_avg/kitchen/" + date + "?tz=UTC&unit=F", http.StatusOK, "70.70"},
+		{"/derived/rate_of_change/daily/kitchen/" + date + "?tz=UTC", http.StatusOK, `"value":1.5`},
+		{"/derived/dew_point/weekly/kitchen/" + date + "?tz=UTC", http.StatusOK, `"hour":10`},
+		{"/derived/rate_of_change/weekly/kitchen?tz=UTC", http.StatusOK, `"value":1.5`},
+		// malformed parameters
+		{"/metric/temperature/daily_max/kitchen/yesterday", http.StatusBadRequest, "invalid date"},
+		{"/metric/temperature/monthly_min/kitchen/2026-13", http.StatusBadRequest, "invalid date"},
+		{"/metric/temperature/daily_max/kitchen/" + date + "?tz=Mars/Olympus_Mons", http.StatusBadRequest, "invalid timezone"},
+		{"/metric/radiation/daily_max/kitchen/" + date, http.StatusBadRequest, "unknown metric"},
+		{"/derived/rate_of_change/weekly/kitchen/last-week", http.StatusBadRequest, "invalid date"},
+		{"/derived/wind_chill/daily/kitchen/" + date, http.StatusBadRequest, "unknown derived series"},
+		// nothing stored
+		{"/metric/temperature/daily_max/hallway/" + date, http.StatusNotFound, "not found"},
+		{"/metric/temperature/daily_max/kitchen/2001-01-01", http.StatusNotFound, "not found"},
+		{"/derived/dew_point/daily/hallway/" + date, http.StatusNotFound, "not found"},
+		{"/derived/rate_of_change/weekly/kitchen/2001-01-01", http.StatusNotFound, "not found"},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.path, nil))
+		if rec.Code != test.status || !strings.Contains(rec.Body.String(), test.body) {
+			t.Errorf("GET %s = %d %s, want %d %s", test.path, rec.Code, strings.TrimSpace(rec.Body.String()), test.status, test.body)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package prom
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// durationUnits - the units of PromQL durations
+var durationUnits = map[string]time.Duration{
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+	"d":  24 * time.Hour,
+	"w":  7 * 24 * time.Hour,
+	"y":  365 * 24 * time.Hour,
+}
+
+// ParseTime parses a unix timestamp in seconds, fractions allowed, or an RFC 3339 timestamp.
+// An empty value is fallback
+func ParseTime(value string, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		whole, fraction := math.Modf(seconds)
+		return time.Unix(int64(whole), int64(fraction*float64(time.Second))), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("cannot parse '%s' to a valid timestamp", value)
+}
+
+// ParseDuration parses seconds, fractions allowed, or a PromQL duration like 1h30m
+func ParseDuration(value string) (time.Duration, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		if seconds <= 0 || seconds > math.MaxInt64/float64(time.Second) {
+			return 0, fmt.Errorf("invalid duration '%s'", value)
+		}
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	var total time.Duration
+	rest := value
+	for rest != "" {
+		i := 0
+		for i < len(rest) && rest[i] >= '0' && rest[i] <= '9' {
+			i++
+		}
+		j := i
+		for j < len(rest) && (rest[j] < '0' || rest[j] > '9') {
+			j++
+		}
+		count, err := strconv.Atoi(rest[:i])
+		unit, ok := durationUnits[rest[i:j]]
+		if err != nil || !ok {
+			return 0, fmt.Errorf("cannot parse '%s' to a valid duration", value)
+		}
+		total += time.Duration(count) * unit
+		rest = rest[j:]
+	}
+	if total <= 0 {
+		return 0, fmt.Errorf("cannot parse '%s' to a valid duration", value)
+	}
+	return total, nil
+}
@@ -0,0 +1,54 @@
+package prom
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	fallback := time.Unix(42, 0)
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"", fallback},
+		{"1630000000", time.Unix(1630000000, 0)},
+		{"1630000000.5", time.Unix(1630000000, int64(500*time.Millisecond))},
+		{"2021-08-26T17:46:40Z", time.Unix(1630000000, 0)},
+		{"2021-08-26T19:46:40.25+02:00", time.Unix(1630000000, int64(250*time.Millisecond))},
+	}
+	for _, test := range tests {
+		got, err := ParseTime(test.value, fallback)
+		if err != nil || !got.Equal(test.want) {
+			t.Errorf("ParseTime(%q) = %v, %v, want %v", test.value, got, err, test.want)
+		}
+	}
+	if _, err := ParseTime("yesterday", fallback); err == nil {
+		t.Error("an invalid timestamp was accepted")
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"15", 15 * time.Second},
+		{"0.5", 500 * time.Millisecond},
+		{"1h30m", 90 * time.Minute},
+		{"1d", 24 * time.Hour},
+		{"2w", 14 * 24 * time.Hour},
+		{"250ms", 250 * time.Millisecond},
+	}
+	for _, test := range tests {
+		got, err := ParseDuration(test.value)
+		if err != nil || got != test.want {
+			t.Errorf("ParseDuration(%q) = %v, %v, want %v", test.value, got, err, test.want)
+		}
+	}
+	for _, value := range []string{"", "0", "-5", "0s", "5x", "h", "1e300"} {
+		if _, err := ParseDuration(value); err == nil {
+			t.Errorf("ParseDuration(%q) accepted an invalid duration", value)
+		}
+	}
+}
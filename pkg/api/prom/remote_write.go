@@ -0,0 +1,224 @@
+package prom
+
+import (
+	"errors"
+	"fmt"
+	"github.com/andreikom/sensor-server/pkg/api/metric"
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+	"math"
+	"time"
+)
+
+const (
+	// NameLabel - the label holding the metric name of a series
+	NameLabel = "__name__"
+	// SensorLabel and UnitLabel - the labels of the series served by the query API
+	SensorLabel = "sensor"
+	UnitLabel   = "unit"
+)
+
+// DefaultSensorLabels - the labels the sensor id of a written series is looked up in, in order
+var DefaultSensorLabels = []string{"sensor", "sensorId", "sensor_id"}
+
+var errMalformedRequest = errors.New("malformed remote write request")
+
+// ErrTooLarge - the decompressed size of a remote write request exceeds the limit
+var ErrTooLarge = errors.New("remote write request too large")
+
+// RemoteSample - a sample of a remote write request, Timestamp is in unix milliseconds
+type RemoteSample struct {
+	Value     float64
+	Timestamp int64
+}
+
+type TimeSeries struct {
+	Labels  map[string]string
+	Samples []RemoteSample
+}
+
+// Reading - a reading mapped from a sample
+type Reading struct {
+	SensorId string
+	Metric   string
+	Value    float64
+	Unit     metric.Unit
+	Time     time.Time
+}
+
+// DecodeWriteRequest decodes the snappy compressed prometheus.WriteRequest protobuf of the remote write
+// protocol. Exemplars, histograms and metadata are skipped. The decompressed size is read from the snappy
+// header before anything is allocated, a request larger than maxSize is ErrTooLarge
+func DecodeWriteRequest(compressed []byte, maxSize int) ([]TimeSeries, error) {
+	size, err := snappy.DecodedLen(compressed)
+	if err != nil {
+		return nil, fmt.Errorf("invalid snappy payload: %w", err)
+	}
+	if size > maxSize {
+		return nil, fmt.Errorf("%w: %d bytes decompressed, the limit is %d", ErrTooLarge, size, maxSize)
+	}
+	data, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return nil, fmt.Errorf("invalid snappy payload: %w", err)
+	}
+	series := make([]TimeSeries, 0)
+	err = walkFields(data, func(number protowire.Number, value []byte) error {
+		if number != 1 {
+			return nil
+		}
+		ts, err := decodeTimeSeries(value)
+		if err != nil {
+			return err
+		}
+		series = append(series, ts)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return series, nil
+}
+
+func decodeTimeSeries(data []byte) (TimeSeries, error) {
+	ts := TimeSeries{Labels: make(map[string]string)}
+	err := walkFields(data, func(number protowire.Number, value []byte) error {
+		switch number {
+		case 1:
+			var name, labelValue string
+			err := walkFields(value, func(number protowire.Number, value []byte) error {
+				switch number {
+				case 1:
+					name = string(value)
+				case 2:
+					labelValue = string(value)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			ts.Labels[name] = labelValue
+		case 2:
+			sample, err := decodeSample(value)
+			if err != nil {
+				return err
+			}
+			ts.Samples = append(ts.Samples, sample)
+		}
+		return nil
+	})
+	return ts, err
+}
+
+// decodeSample reads the double value (field 1) and the int64 timestamp (field 2) of a sample,
+// the scalar fields are read directly as walkFields only hands out length delimited ones
+func decodeSample(data []byte) (RemoteSample, error) {
+	sample := RemoteSample{}
+	for len(data) > 0 {
+		number, wireType, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return sample, errMalformedRequest
+		}
+		data = data[n:]
+		switch {
+		case number == 1 && wireType == protowire.Fixed64Type:
+			bits, n := protowire.ConsumeFixed64(data)
+			if n < 0 {
+				return sample, errMalformedRequest
+			}
+			sample.Value = math.Float64frombits(bits)
+			data = data[n:]
+		case number == 2 && wireType == protowire.VarintType:
+			timestamp, n := protowire.ConsumeVarint(data)
+			if n < 0 {
+				return sample, errMalformedRequest
+			}
+			sample.Timestamp = int64(timestamp)
+			data = data[n:]
+		default:
+			n := protowire.ConsumeFieldValue(number, wireType, data)
+			if n < 0 {
+				return sample, errMalformedRequest
+			}
+			data = data[n:]
+		}
+	}
+	return sample, nil
+}
+
+// walkFields calls visit with every length delimited field of a message, other fields are skipped
+func walkFields(data []byte, visit func(number protowire.Number, value []byte) error) error {
+	for len(data) > 0 {
+		number, wireType, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return errMalformedRequest
+		}
+		data = data[n:]
+		if wireType != protowire.BytesType {
+			n := protowire.ConsumeFieldValue(number, wireType, data)
+			if n < 0 {
+				return errMalformedRequest
+			}
+			data = data[n:]
+			continue
+		}
+		value, n := protowire.ConsumeBytes(data)
+		if n < 0 {
+			return errMalformedRequest
+		}
+		data = data[n:]
+		if err := visit(number, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Readings maps written series onto sensor readings. The name of a series is the metric, the sensor id is
+// taken from the first of sensorLabels present and the unit from the UnitLabel, the store unit if absent:
+//
+//	temperature{sensor="kitchen", unit="F"}
+//
+// Series of unknown metrics and stale markers are skipped and counted. A series of a known metric
+// without a sensor label or with an unsupported unit is an error
+func Readings(series []TimeSeries, sensorLabels []string) ([]Reading, int, error) {
+	if len(sensorLabels) == 0 {
+		sensorLabels = DefaultSensorLabels
+	}
+	readings := make([]Reading, 0, len(series))
+	skipped := 0
+	for _, ts := range series {
+		def, err := metric.Lookup(ts.Labels[NameLabel])
+		if err != nil {
+			skipped += len(ts.Samples)
+			continue
+		}
+		sensorId := ""
+		for _, label := range sensorLabels {
+			if sensorId = ts.Labels[label]; sensorId != "" {
+				break
+			}
+		}
+		if sensorId == "" {
+			return nil, 0, fmt.Errorf("series %s has none of the sensor labels %v", ts.Labels[NameLabel], sensorLabels)
+		}
+		unit, err := def.ParseUnit(ts.Labels[UnitLabel])
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, sample := range ts.Samples {
+			if math.IsNaN(sample.Value) || math.IsInf(sample.Value, 0) {
+				skipped++
+				continue
+			}
+			readings = append(readings, Reading{
+				SensorId: sensorId,
+				Metric:   def.Name,
+				Value:    sample.Value,
+				Unit:     unit,
+				Time:     time.Unix(0, sample.Timestamp*int64(time.Millisecond)),
+			})
+		}
+	}
+	return readings, skipped, nil
+}
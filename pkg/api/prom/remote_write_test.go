@@ -0,0 +1,146 @@
+package prom
+
+import (
+	"errors"
+	"github.com/andreikom/sensor-server/pkg/api/metric"
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+	"math"
+	"sort"
+	"testing"
+	"time"
+)
+
+// encodeWriteRequest builds the snappy compressed prometheus.WriteRequest of the series
+func encodeWriteRequest(series ...TimeSeries) []byte {
+	var request []byte
+	for _, ts := range series {
+		var encoded []byte
+		names := make([]string, 0, len(ts.Labels))
+		for name := range ts.Labels {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, name)
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, ts.Labels[name])
+			encoded = protowire.AppendTag(encoded, 1, protowire.BytesType)
+			encoded = protowire.AppendBytes(encoded, label)
+		}
+		for _, s := range ts.Samples {
+			var sample []byte
+			sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+			sample = protowire.AppendFixed64(sample, math.Float64bits(s.Value))
+			sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+			sample = protowire.AppendVarint(sample, uint64(s.Timestamp))
+			encoded = protowire.AppendTag(encoded, 2, protowire.BytesType)
+			encoded = protowire.AppendBytes(encoded, sample)
+		}
+		request = protowire.AppendTag(request, 1, protowire.BytesType)
+		request = protowire.AppendBytes(request, encoded)
+	}
+	// metadata (field 3) is skipped
+	request = protowire.AppendTag(request, 3, protowire.BytesType)
+	request = protowire.AppendBytes(request, []byte{0x08, 0x01})
+	return snappy.Encode(nil, request)
+}
+
+func TestDecodeWriteRequest(t *testing.T) {
+	written := []TimeSeries{
+		{Labels: map[string]string{NameLabel: "temperature", "sensor": "kitchen", "unit": "F"}, Samples: []RemoteSample{{70.7, 1630000000000}, {71.6, 1630000060000}}},
+		{Labels: map[string]string{NameLabel: "up", "job": "node"}, Samples: []RemoteSample{{1, 1630000000000}}},
+	}
+	series, err := DecodeWriteRequest(encodeWriteRequest(written...), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(series) != 2 {
+		t.Fatalf("decoded %d series, want 2", len(series))
+	}
+	for i, ts := range series {
+		if len(ts.Labels) != len(written[i].Labels) || len(ts.Samples) != len(written[i].Samples) {
+			t.Fatalf("decoded %+v, want %+v", ts, written[i])
+		}
+		for name, value := range written[i].Labels {
+			if ts.Labels[name] != value {
+				t.Errorf("label %s = %q, want %q", name, ts.Labels[name], value)
+			}
+		}
+		for j, sample := range written[i].Samples {
+			if ts.Samples[j] != sample {
+				t.Errorf("sample %d = %+v, want %+v", j, ts.Samples[j], sample)
+			}
+		}
+	}
+}
+
+func TestDecodeWriteRequestLimitsTheDecompressedSize(t *testing.T) {
+	// a megabyte of zeros compresses to a few kilobytes
+	compressed := snappy.Encode(nil, make([]byte, 1<<20))
+	if len(compressed) > 1<<16 {
+		t.Fatalf("compressed to %d bytes", len(compressed))
+	}
+	if _, err := DecodeWriteRequest(compressed, 1<<19); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("returned %v, want %v", err, ErrTooLarge)
+	}
+}
+
+func TestDecodeMalformedWriteRequests(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"not snappy":          []byte("temperature 21"),
+		"truncated protobuf":  snappy.Encode(nil, []byte{0x0a, 0x10, 0x0a}),
+		"truncated sample":    snappy.Encode(nil, []byte{0x0a, 0x03, 0x12, 0x01, 0x09}),
+		"invalid field tag":   snappy.Encode(nil, []byte{0x00}),
+		"invalid label field": snappy.Encode(nil, []byte{0x0a, 0x02, 0x0a, 0x05}),
+	} {
+		if _, err := DecodeWriteRequest(data, 1<<20); err == nil || errors.Is(err, ErrTooLarge) {
+			t.Errorf("%s: returned %v, want a decoding error", name, err)
+		}
+	}
+}
+
+func TestReadings(t *testing.T) {
+	at := int64(1630000000000)
+	series := []TimeSeries{
+		{Labels: map[string]string{NameLabel: "temperature", "sensor_id": "kitchen", "unit": "F"}, Samples: []RemoteSample{{70.7, at}, {math.NaN(), at + 1000}}},
+		{Labels: map[string]string{NameLabel: "humidity", "sensor": "bathroom"}, Samples: []RemoteSample{{65, at}}},
+		{Labels: map[string]string{NameLabel: "up", "sensor": "kitchen"}, Samples: []RemoteSample{{1, at}, {1, at + 1000}}},
+	}
+	readings, skipped, err := Readings(series, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if skipped != 3 {
+		t.Errorf("skipped %d samples, want the stale marker and both samples of up", skipped)
+	}
+	want := []Reading{
+		{SensorId: "kitchen", Metric: metric.Temperature, Value: 70.7, Unit: "F", Time: time.Unix(1630000000, 0)},
+		{SensorId: "bathroom", Metric: metric.Humidity, Value: 65, Unit: "%", Time: time.Unix(1630000000, 0)},
+	}
+	if len(readings) != len(want) {
+		t.Fatalf("mapped %+v, want %+v", readings, want)
+	}
+	for i := range want {
+		if readings[i].SensorId != want[i].SensorId || readings[i].Metric != want[i].Metric || readings[i].Value != want[i].Value ||
+			readings[i].Unit != want[i].Unit || !readings[i].Time.Equal(want[i].Time) {
+			t.Errorf("reading %d = %+v, want %+v", i, readings[i], want[i])
+		}
+	}
+
+	// the sensor labels are configurable
+	custom := []TimeSeries{{Labels: map[string]string{NameLabel: "temperature", "room": "attic", "sensor": "other"}, Samples: []RemoteSample{{20, at}}}}
+	if readings, _, err := Readings(custom, []string{"room"}); err != nil || len(readings) != 1 || readings[0].SensorId != "attic" {
+		t.Errorf("mapped %+v, %v, want the sensor id from the room label", readings, err)
+	}
+	for name, invalid := range map[string]TimeSeries{
+		"without a sensor label": {Labels: map[string]string{NameLabel: "temperature", "job": "node"}, Samples: []RemoteSample{{20, at}}},
+		"with an invalid unit":   {Labels: map[string]string{NameLabel: "temperature", "sensor": "kitchen", "unit": "ppm"}, Samples: []RemoteSample{{20, at}}},
+	} {
+		if _, _, err := Readings([]TimeSeries{invalid}, nil); err == nil {
+			t.Errorf("a series %s was accepted", name)
+		}
+	}
+}
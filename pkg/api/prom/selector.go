@@ -0,0 +1,162 @@
+package prom
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type MatchType string
+
+const (
+	MatchEqual     MatchType = "="
+	MatchNotEqual  MatchType = "!="
+	MatchRegexp    MatchType = "=~"
+	MatchNotRegexp MatchType = "!~"
+)
+
+// Matcher - a label matcher of a selector, regular expressions are anchored like in PromQL
+type Matcher struct {
+	Name  string
+	Type  MatchType
+	Value string
+	re    *regexp.Regexp
+}
+
+func (m *Matcher) Matches(value string) bool {
+	switch m.Type {
+	case MatchEqual:
+		return value == m.Value
+	case MatchNotEqual:
+		return value != m.Value
+	case MatchRegexp:
+		return m.re.MatchString(value)
+	case MatchNotRegexp:
+		return !m.re.MatchString(value)
+	}
+	return false
+}
+
+// Selector - the only PromQL expressions the query API evaluates are instant vector selectors:
+//
+//	temperature{sensor="kitchen"}
+//	{__name__=~"temperature|humidity", sensor!="attic"}
+type Selector struct {
+	Matchers []*Matcher
+}
+
+func (s *Selector) Matches(labels map[string]string) bool {
+	for _, matcher := range s.Matchers {
+		if !matcher.Matches(labels[matcher.Name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseSelector parses a vector selector, functions, operators and range selectors are not supported
+func ParseSelector(query string) (*Selector, error) {
+	rest := strings.TrimSpace(query)
+	selector := &Selector{}
+	name := identifierPrefix(rest, true)
+	if name != "" {
+		selector.Matchers = append(selector.Matchers, &Matcher{Name: NameLabel, Type: MatchEqual, Value: name})
+		rest = strings.TrimSpace(rest[len(name):])
+	}
+	if strings.HasPrefix(rest, "{") {
+		rest = strings.TrimSpace(rest[1:])
+		for !strings.HasPrefix(rest, "}") {
+			matcher, remaining, err := parseMatcher(rest)
+			if err != nil {
+				return nil, err
+			}
+			selector.Matchers = append(selector.Matchers, matcher)
+			rest = strings.TrimSpace(remaining)
+			if strings.HasPrefix(rest, ",") {
+				rest = strings.TrimSpace(rest[1:])
+			} else if !strings.HasPrefix(rest, "}") {
+				return nil, fmt.Errorf("expected ',' or '}' in selector '%s'", query)
+			}
+		}
+		rest = strings.TrimSpace(rest[1:])
+	}
+	if rest != "" || len(selector.Matchers) == 0 {
+		return nil, fmt.Errorf("unsupported expression '%s', only vector selectors like temperature{sensor=\"kitchen\"} are supported", query)
+	}
+	for _, matcher := range selector.Matchers {
+		if !matcher.Matches("") {
+			return selector, nil
+		}
+	}
+	return nil, fmt.Errorf("vector selector '%s' must contain at least one non-empty matcher", query)
+}
+
+func parseMatcher(data string) (*Matcher, string, error) {
+	name := identifierPrefix(data, false)
+	if name == "" {
+		return nil, "", fmt.Errorf("expected a label name at '%s'", data)
+	}
+	rest := strings.TrimSpace(data[len(name):])
+	matcher := &Matcher{Name: name}
+	for _, matchType := range []MatchType{MatchRegexp, MatchNotRegexp, MatchNotEqual, MatchEqual} {
+		if strings.HasPrefix(rest, string(matchType)) {
+			matcher.Type = matchType
+			rest = strings.TrimSpace(rest[len(matchType):])
+			break
+		}
+	}
+	if matcher.Type == "" {
+		return nil, "", fmt.Errorf("expected a match operator after label '%s'", name)
+	}
+	value, rest, err := unquote(rest)
+	if err != nil {
+		return nil, "", err
+	}
+	matcher.Value = value
+	if matcher.Type == MatchRegexp || matcher.Type == MatchNotRegexp {
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid regular expression '%s': %w", value, err)
+		}
+		matcher.re = re
+	}
+	return matcher, rest, nil
+}
+
+// identifierPrefix returns the metric or label name data starts with, metric names may contain colons
+func identifierPrefix(data string, metricName bool) string {
+	for i, c := range data {
+		valid := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9') || (metricName && c == ':')
+		if !valid {
+			return data[:i]
+		}
+	}
+	return data
+}
+
+// unquote reads a double, single or back quoted string literal off the start of data
+func unquote(data string) (string, string, error) {
+	if data == "" || !strings.ContainsRune("\"'`", rune(data[0])) {
+		return "", "", fmt.Errorf("expected a quoted string at '%s'", data)
+	}
+	quote := data[0]
+	for i := 1; i < len(data); i++ {
+		switch {
+		case data[i] == '\\' && quote != '`':
+			i++
+		case data[i] == quote:
+			literal := data[:i+1]
+			if quote == '\'' {
+				// strconv only knows double quoted strings
+				literal = `"` + strings.ReplaceAll(strings.ReplaceAll(literal[1:i], `\'`, `'`), `"`, `\"`) + `"`
+			}
+			value, err := strconv.Unquote(literal)
+			if err != nil {
+				return "", "", fmt.Errorf("invalid string literal %s", data[:i+1])
+			}
+			return value, data[i+1:], nil
+		}
+	}
+	return "", "", fmt.Errorf("unterminated string literal %s", data)
+}
@@ -0,0 +1,76 @@
+package prom
+
+import (
+	"testing"
+)
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		query    string
+		matching []map[string]string
+		other    []map[string]string
+	}{
+		{
+			query:    `temperature`,
+			matching: []map[string]string{{NameLabel: "temperature", "sensor": "kitchen"}},
+			other:    []map[string]string{{NameLabel: "humidity", "sensor": "kitchen"}},
+		},
+		{
+			query:    `temperature{sensor="kitchen"}`,
+			matching: []map[string]string{{NameLabel: "temperature", "sensor": "kitchen"}},
+			other:    []map[string]string{{NameLabel: "temperature", "sensor": "kitchen2"}, {NameLabel: "humidity", "sensor": "kitchen"}},
+		},
+		{
+			query:    ` { __name__ =~ "temperature|humidity" , sensor != 'attic' } `,
+			matching: []map[string]string{{NameLabel: "humidity", "sensor": "kitchen"}},
+			other:    []map[string]string{{NameLabel: "humidity", "sensor": "attic"}, {NameLabel: "pressure", "sensor": "kitchen"}},
+		},
+		{
+			// regular expressions are anchored
+			query:    "{sensor!~`kit.*`, sensor=~\"k.*\"}",
+			matching: []map[string]string{{"sensor": "kelvin"}},
+			other:    []map[string]string{{"sensor": "kitchen"}, {"sensor": "hallway k"}},
+		},
+		{
+			query:    `temperature{sensor="say \"hi\"",}`,
+			matching: []map[string]string{{NameLabel: "temperature", "sensor": `say "hi"`}},
+		},
+	}
+	for _, test := range tests {
+		selector, err := ParseSelector(test.query)
+		if err != nil {
+			t.Errorf("ParseSelector(%s) returned %v", test.query, err)
+			continue
+		}
+		for _, labels := range test.matching {
+			if !selector.Matches(labels) {
+				t.Errorf("%s does not match %v", test.query, labels)
+			}
+		}
+		for _, labels := range test.other {
+			if selector.Matches(labels) {
+				t.Errorf("%s matches %v", test.query, labels)
+			}
+		}
+	}
+}
+
+func TestParseUnsupportedSelectors(t *testing.T) {
+	for _, query := range []string{
+		``,
+		`{}`,
+		`{sensor=""}`,
+		`{sensor=~".*"}`,
+		`rate(temperature[5m])`,
+		`temperature > 20`,
+		`temperature{sensor}`,
+		`temperature{sensor="kitchen"`,
+		`temperature{sensor="kitchen" unit="C"}`,
+		`temperature{sensor=~"("}`,
+		`temperature{sensor=kitchen}`,
+	} {
+		if _, err := ParseSelector(query); err == nil {
+			t.Errorf("ParseSelector(%s) accepted an unsupported expression", query)
+		}
+	}
+}
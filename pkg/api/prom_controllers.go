@@ -0,0 +1,308 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/andreikom/sensor-server/pkg/api/metric"
+	"github.com/andreikom/sensor-server/pkg/api/prom"
+	"github.com/andreikom/sensor-server/pkg/auth"
+	"github.com/andreikom/sensor-server/pkg/logging"
+	"github.com/gorilla/mux"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	maxRemoteWriteSize = 16 << 20
+	// maxDecodedRemoteWriteSize - snappy compresses repetitive input well, the decompressed size is limited too
+	maxDecodedRemoteWriteSize = 64 << 20
+	// maxQueryPoints - the limit of points per series Prometheus applies to range queries as well
+	maxQueryPoints = 11000
+)
+
+// promController - the Prometheus remote write receiver and the subset of the Prometheus HTTP API Grafana's
+// Prometheus datasource needs. Every cached sensor is a series labeled {__name__="<metric>", sensor="<id>",
+// unit="<store unit>"}, its value at a time is the average of the readings of the UTC hour containing it
+type promController struct {
+	metricService *metric.MetricService
+	sensorLabels  []string
+}
+
+// series - the labels of a stored series
+type series map[string]string
+
+// RemoteWrite serves POST /api/v1/write
+func (c *promController) RemoteWrite(w http.ResponseWriter, req *http.Request) {
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, maxRemoteWriteSize))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("requests are limited to %d bytes", maxRemoteWriteSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	timeSeries, err := prom.DecodeWriteRequest(data, maxDecodedRemoteWriteSize)
+	if errors.Is(err, prom.ErrTooLarge) {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	readings, skipped, err := prom.Readings(timeSeries, c.sensorLabels)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Prometheus retries on 5xx only, samples outside the retention window would be retried forever
+	for _, reading := range readings {
+		if err := auth.AllowSensor(req.Context(), reading.SensorId); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err := metric.ValidateSensorId(reading.SensorId); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := c.metricService.CheckTimestamp(reading.Time); err != nil {
+			http.Error(w, fmt.Sprintf("%s sample of sensor %s: %s", reading.Metric, reading.SensorId, err), http.StatusBadRequest)
+			return
+		}
+	}
+	for i, reading := range readings {
+		if err := c.metricService.SaveMetricAt(req.Context(), reading.SensorId, reading.Metric, reading.Value, reading.Unit, reading.Time); err != nil {
+			status := http.StatusInternalServerError
+			var rangeErr *metric.TimestampOutOfRangeError
+			if errors.As(err, &rangeErr) {
+				status = http.StatusBadRequest
+			}
+			http.Error(w, fmt.Sprintf("stored %d of %d samples: %s", i, len(readings), err), status)
+			return
+		}
+	}
+	if skipped > 0 {
+		logging.FromContext(req.Context()).WithField("skipped", skipped).Info("Skipped remote write samples which are not sensor readings")
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// QueryRange serves GET and POST /api/v1/query_range
+func (c *promController) QueryRange(w http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		writePromError(w, http.StatusBadRequest, err)
+		return
+	}
+	start, err := prom.ParseTime(req.Form.Get("start"), time.Time{})
+	if err == nil && start.IsZero() {
+		err = errors.New("missing 'start' parameter")
+	}
+	if err != nil {
+		writePromError(w, http.StatusBadRequest, err)
+		return
+	}
+	end, err := prom.ParseTime(req.Form.Get("end"), time.Time{})
+	if err == nil && end.IsZero() {
+		err = errors.New("missing 'end' parameter")
+	}
+	if err != nil {
+		writePromError(w, http.StatusBadRequest, err)
+		return
+	}
+	step, err := prom.ParseDuration(req.Form.Get("step"))
+	if err != nil {
+		writePromError(w, http.StatusBadRequest, err)
+		return
+	}
+	if end.Before(start) {
+		writePromError(w, http.StatusBadRequest, errors.New("end timestamp must not be before start time"))
+		return
+	}
+	if end.Sub(start)/step > maxQueryPoints {
+		writePromError(w, http.StatusBadRequest, fmt.Errorf("exceeded maximum resolution of %d points per timeseries", maxQueryPoints))
+		return
+	}
+	selector, err := prom.ParseSelector(req.Form.Get("query"))
+	if err != nil {
+		writePromError(w, http.StatusBadRequest, err)
+		return
+	}
+	result := make([]map[string]interface{}, 0)
+	for _, labels := range c.matchingSeries([]*prom.Selector{selector}) {
+		samples, err := c.metricService.GetHourlySeries(labels[prom.NameLabel], labels[prom.SensorLabel], start.Truncate(time.Hour), end.Add(time.Nanosecond), metric.Unit(labels[prom.UnitLabel]))
+		if err != nil {
+			writePromError(w, http.StatusInternalServerError, err)
+			return
+		}
+		values := make([][]interface{}, 0)
+		i := 0
+		for t := start; !t.After(end); t = t.Add(step) {
+			for i < len(samples) && !samples[i].Time.Add(time.Hour).After(t) {
+				i++
+			}
+			if i < len(samples) && !samples[i].Time.After(t) {
+				values = append(values, promPoint(t, samples[i].Value))
+			}
+		}
+		if len(values) > 0 {
+			result = append(result, map[string]interface{}{"metric": labels, "values": values})
+		}
+	}
+	writePromData(w, map[string]interface{}{"resultType": "matrix", "result": result})
+}
+
+// Query serves GET and POST /api/v1/query, a number evaluates to a scalar so datasource health checks pass
+func (c *promController) Query(w http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		writePromError(w, http.StatusBadRequest, err)
+		return
+	}
+	at, err := prom.ParseTime(req.Form.Get("time"), time.Now())
+	if err != nil {
+		writePromError(w, http.StatusBadRequest, err)
+		return
+	}
+	query := req.Form.Get("query")
+	if value, err := strconv.ParseFloat(query, 64); err == nil {
+		writePromData(w, map[string]interface{}{"resultType": "scalar", "result": promPoint(at, value)})
+		return
+	}
+	selector, err := prom.ParseSelector(query)
+	if err != nil {
+		writePromError(w, http.StatusBadRequest, err)
+		return
+	}
+	hour := at.Truncate(time.Hour)
+	result := make([]map[string]interface{}, 0)
+	for _, labels := range c.matchingSeries([]*prom.Selector{selector}) {
+		samples, err := c.metricService.GetHourlySeries(labels[prom.NameLabel], labels[prom.SensorLabel], hour, hour.Add(time.Hour), metric.Unit(labels[prom.UnitLabel]))
+		if err != nil {
+			writePromError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if len(samples) > 0 {
+			result = append(result, map[string]interface{}{"metric": labels, "value": promPoint(at, samples[0].Value)})
+		}
+	}
+	writePromData(w, map[string]interface{}{"resultType": "vector", "result": result})
+}
+
+// Series serves GET and POST /api/v1/series, the time range is ignored as sensors are listed from the cache
+func (c *promController) Series(w http.ResponseWriter, req *http.Request) {
+	selectors, ok := formSelectors(w, req, true)
+	if !ok {
+		return
+	}
+	writePromData(w, c.matchingSeries(selectors))
+}
+
+// Labels serves GET and POST /api/v1/labels
+func (c *promController) Labels(w http.ResponseWriter, req *http.Request) {
+	selectors, ok := formSelectors(w, req, false)
+	if !ok {
+		return
+	}
+	names := make(map[string]bool)
+	for _, labels := range c.matchingSeries(selectors) {
+		for name := range labels {
+			names[name] = true
+		}
+	}
+	writePromData(w, sortedKeys(names))
+}
+
+// LabelValues serves GET /api/v1/label/{name}/values
+func (c *promController) LabelValues(w http.ResponseWriter, req *http.Request) {
+	selectors, ok := formSelectors(w, req, false)
+	if !ok {
+		return
+	}
+	name := mux.Vars(req)["name"]
+	values := make(map[string]bool)
+	for _, labels := range c.matchingSeries(selectors) {
+		if value, ok := labels[name]; ok {
+			values[value] = true
+		}
+	}
+	writePromData(w, sortedKeys(values))
+}
+
+// matchingSeries returns the series matching any of the selectors, every series if there are none
+func (c *promController) matchingSeries(selectors []*prom.Selector) []series {
+	matching := make([]series, 0)
+	for _, name := range metric.Names() {
+		def, _ := metric.Lookup(name)
+		sensors, err := c.metricService.Sensors(name)
+		if err != nil {
+			continue
+		}
+		for _, sensorId := range sensors {
+			labels := series{prom.NameLabel: name, prom.SensorLabel: sensorId, prom.UnitLabel: string(def.StoreUnit)}
+			for _, selector := range selectors {
+				if selector.Matches(labels) {
+					matching = append(matching, labels)
+					break
+				}
+			}
+			if len(selectors) == 0 {
+				matching = append(matching, labels)
+			}
+		}
+	}
+	return matching
+}
+
+// formSelectors parses the match[] parameters
+func formSelectors(w http.ResponseWriter, req *http.Request, required bool) ([]*prom.Selector, bool) {
+	if err := req.ParseForm(); err != nil {
+		writePromError(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+	matches := req.Form["match[]"]
+	if required && len(matches) == 0 {
+		writePromError(w, http.StatusBadRequest, errors.New("no match[] parameter provided"))
+		return nil, false
+	}
+	selectors := make([]*prom.Selector, 0, len(matches))
+	for _, match := range matches {
+		selector, err := prom.ParseSelector(match)
+		if err != nil {
+			writePromError(w, http.StatusBadRequest, err)
+			return nil, false
+		}
+		selectors = append(selectors, selector)
+	}
+	return selectors, true
+}
+
+// promPoint - a sample as the API encodes it, [<unix seconds>, "<value>"]
+func promPoint(t time.Time, value float64) []interface{} {
+	return []interface{}{float64(t.UnixNano()/int64(time.Millisecond)) / 1000, strconv.FormatFloat(value, 'f', -1, 64)}
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func writePromData(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"status": "success", "data": data}); err != nil {
+		logging.Log.WithError(err).Warn("Could not write a response of the query API")
+	}
+}
+
+func writePromError(w http.ResponseWriter, status int, err error) {
+	errorType := "bad_data"
+	if status >= http.StatusInternalServerError {
+		errorType = "internal"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"status": "error", "errorType": errorType, "error": err.Error()})
+}
@@ -0,0 +1,264 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/andreikom/sensor-server/pkg/api/metric"
+	"github.com/andreikom/sensor-server/pkg/api/metric/metrictest"
+	"github.com/golang/snappy"
+	"github.com/gorilla/mux"
+	"google.golang.org/protobuf/encoding/protowire"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// remoteSeries - a series of a remote write request with a single sample
+type remoteSeries struct {
+	labels [][2]string
+	value  float64
+	at     time.Time
+}
+
+// encodeRemoteWrite builds the snappy compressed prometheus.WriteRequest Prometheus sends
+func encodeRemoteWrite(series ...remoteSeries) []byte {
+	var request []byte
+	for _, s := range series {
+		var ts []byte
+		for _, label := range s.labels {
+			var encoded []byte
+			encoded = protowire.AppendTag(encoded, 1, protowire.BytesType)
+			encoded = protowire.AppendString(encoded, label[0])
+			encoded = protowire.AppendTag(encoded, 2, protowire.BytesType)
+			encoded = protowire.AppendString(encoded, label[1])
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, encoded)
+		}
+		var sample []byte
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(s.value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(s.at.UnixNano()/int64(time.Millisecond)))
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, sample)
+		request = protowire.AppendTag(request, 1, protowire.BytesType)
+		request = protowire.AppendBytes(request, ts)
+	}
+	return snappy.Encode(nil, request)
+}
+
+func TestRemoteWrite(t *testing.T) {
+	service, spool := metrictest.NewSpoolingService(t)
+	controller := &promController{metricService: service}
+	now := time.Now()
+	body := encodeRemoteWrite(
+		remoteSeries{[][2]string{{"__name__", "temperature"}, {"sensor", "kitchen"}, {"unit", "F"}}, 70.7, now},
+		remoteSeries{[][2]string{{"__name__", "humidity"}, {"sensor_id", "bathroom"}}, 65, now},
+		remoteSeries{[][2]string{{"__name__", "up"}, {"job", "node"}}, 1, now},
+	)
+	rec := httptest.NewRecorder()
+	controller.RemoteWrite(rec, httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(body)))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	readings := metrictest.SpooledReadings(t, spool)
+	if len(readings) != 2 {
+		t.Fatalf("stored %d readings, want 2", len(readings))
+	}
+	if r := readings[0]; r.SensorId != "kitchen" || r.Metric != metric.Temperature || r.Value < 21.49 || r.Value > 21.51 {
+		t.Errorf("stored %+v, want 21.5 degrees Celsius of kitchen", r)
+	}
+	if r := readings[1]; r.SensorId != "bathroom" || r.Metric != metric.Humidity || r.Value != 65 {
+		t.Errorf("stored %+v, want 65%% humidity of bathroom", r)
+	}
+}
+
+func TestRemoteWriteRejectsRequests(t *testing.T) {
+	service, spool := metrictest.NewSpoolingService(t)
+	controller := &promController{metricService: service}
+	now := time.Now()
+	kitchen := remoteSeries{[][2]string{{"__name__", "temperature"}, {"sensor", "kitchen"}}, 20, now}
+	tests := []struct {
+		name   string
+		body   []byte
+		status int
+	}{
+		{"not snappy", []byte("temperature 20"), http.StatusBadRequest},
+		// zeros compress well, the decompressed size is checked before anything is decoded
+		{"decompressing too large", snappy.Encode(nil, make([]byte, maxDecodedRemoteWriteSize+1)), http.StatusRequestEntityTooLarge},
+		{"missing sensor label", encodeRemoteWrite(kitchen, remoteSeries{[][2]string{{"__name__", "temperature"}}, 20, now}), http.StatusBadRequest},
+		{"invalid sensor id", encodeRemoteWrite(kitchen, remoteSeries{[][2]string{{"__name__", "temperature"}, {"sensor", "../x"}}, 20, now}), http.StatusBadRequest},
+		{"sample in the future", encodeRemoteWrite(kitchen, remoteSeries{kitchen.labels, 20, now.Add(48 * time.Hour)}), http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		controller.RemoteWrite(rec, httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(test.body)))
+		if rec.Code != test.status {
+			t.Errorf("%s: status %d, want %d: %s", test.name, rec.Code, test.status, rec.Body)
+		}
+		if spool.Len() != 0 {
+			t.Errorf("%s: %d readings were stored", test.name, spool.Len())
+			metrictest.SpooledReadings(t, spool)
+		}
+	}
+}
+
+// newQueriedController - kitchen averages 21 degrees in the first hour and 23 in the second, bathroom has humidity
+func newQueriedController(t *testing.T) (*promController, time.Time) {
+	service, _ := metrictest.NewSpoolingService(t)
+	start := time.Now().UTC().Truncate(time.Hour).Add(-3 * time.Hour)
+	if err := service.Import(context.Background(), []metric.Reading{
+		{SensorId: "kitchen", Metric: metric.Temperature, Value: 20, Timestamp: start},
+		{SensorId: "kitchen", Metric: metric.Temperature, Value: 22, Timestamp: start.Add(30 * time.Minute)},
+		{SensorId: "kitchen", Metric: metric.Temperature, Value: 23, Timestamp: start.Add(time.Hour)},
+		{SensorId: "bathroom", Metric: metric.Humidity, Value: 65, Timestamp: start},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return &promController{metricService: service}, start
+}
+
+// promResponse - the envelope of the Prometheus HTTP API
+type promResponse struct {
+	Status    string          `json:"status"`
+	ErrorType string          `json:"errorType"`
+	Error     string          `json:"error"`
+	Data      json.RawMessage `json:"data"`
+}
+
+func servePromApi(t *testing.T, handler http.HandlerFunc, path string, form url.Values, status int) promResponse {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if strings.HasPrefix(path, "/api/v1/label/") {
+		req = mux.SetURLVars(req, map[string]string{"name": strings.Split(path, "/")[4]})
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	if rec.Code != status {
+		t.Fatalf("%s %v: status %d, want %d: %s", path, form, rec.Code, status, rec.Body)
+	}
+	resp := promResponse{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestQueryRange(t *testing.T) {
+	controller, start := newQueriedController(t)
+	form := url.Values{
+		"query": {`temperature{sensor="kitchen"}`},
+		"start": {fmt.Sprint(start.Unix())},
+		"end":   {start.Add(150 * time.Minute).Format(time.RFC3339)},
+		"step":  {"30m"},
+	}
+	resp := servePromApi(t, controller.QueryRange, "/api/v1/query_range", form, http.StatusOK)
+	data := struct {
+		ResultType string `json:"resultType"`
+		Result     []struct {
+			Metric map[string]string `json:"metric"`
+			Values [][]interface{}   `json:"values"`
+		} `json:"result"`
+	}{}
+	if err := json.Unmarshal(resp.Data, &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.ResultType != "matrix" || len(data.Result) != 1 {
+		t.Fatalf("returned %s", resp.Data)
+	}
+	if want := map[string]string{"__name__": "temperature", "sensor": "kitchen", "unit": "C"}; !reflect.DeepEqual(data.Result[0].Metric, want) {
+		t.Errorf("series %v, want %v", data.Result[0].Metric, want)
+	}
+	// every step within an hour with readings gets the average of that hour, later steps have no value
+	want := [][]interface{}{
+		{float64(start.Unix()), "21"},
+		{float64(start.Add(30 * time.Minute).Unix()), "21"},
+		{float64(start.Add(60 * time.Minute).Unix()), "23"},
+		{float64(start.Add(90 * time.Minute).Unix()), "23"},
+	}
+	if !reflect.DeepEqual(data.Result[0].Values, want) {
+		t.Errorf("values %v, want %v", data.Result[0].Values, want)
+	}
+
+	hourly := url.Values{"query": {`{__name__=~"temp.*"}`}, "start": form["start"], "end": form["end"], "step": {"3600"}}
+	resp = servePromApi(t, controller.QueryRange, "/api/v1/query_range", hourly, http.StatusOK)
+	if !strings.Contains(string(resp.Data), `"values":[[`+fmt.Sprint(start.Unix())+`,"21"],[`+fmt.Sprint(start.Add(time.Hour).Unix())+`,"23"]]`) {
+		t.Errorf("an hourly step returned %s", resp.Data)
+	}
+}
+
+func TestQueryRangeRejectsParameters(t *testing.T) {
+	controller, start := newQueriedController(t)
+	valid := func() url.Values {
+		return url.Values{"query": {"temperature"}, "start": {fmt.Sprint(start.Unix())}, "end": {fmt.Sprint(start.Add(time.Hour).Unix())}, "step": {"60"}}
+	}
+	tests := []struct {
+		name  string
+		key   string
+		value string
+	}{
+		{"missing start", "start", ""},
+		{"invalid end", "end", "tomorrow"},
+		{"missing step", "step", ""},
+		{"end before start", "end", fmt.Sprint(start.Add(-time.Hour).Unix())},
+		{"too many points", "step", "0.1"},
+		{"unsupported query", "query", "avg(temperature)"},
+	}
+	for _, test := range tests {
+		form := valid()
+		form.Set(test.key, test.value)
+		resp := servePromApi(t, controller.QueryRange, "/api/v1/query_range", form, http.StatusBadRequest)
+		if resp.Status != "error" || resp.ErrorType != "bad_data" || resp.Error == "" {
+			t.Errorf("%s: returned %+v", test.name, resp)
+		}
+	}
+}
+
+func TestQuery(t *testing.T) {
+	controller, start := newQueriedController(t)
+	resp := servePromApi(t, controller.Query, "/api/v1/query", url.Values{"query": {"1+1"}, "time": {"10"}}, http.StatusBadRequest)
+	if resp.ErrorType != "bad_data" {
+		t.Errorf("an expression returned %+v", resp)
+	}
+	resp = servePromApi(t, controller.Query, "/api/v1/query", url.Values{"query": {"1"}, "time": {"10"}}, http.StatusOK)
+	if string(resp.Data) != `{"result":[10,"1"],"resultType":"scalar"}` {
+		t.Errorf("a number returned %s", resp.Data)
+	}
+	at := fmt.Sprint(start.Add(90 * time.Minute).Unix())
+	resp = servePromApi(t, controller.Query, "/api/v1/query", url.Values{"query": {`{sensor=~"kitchen|bathroom"}`}, "time": {at}}, http.StatusOK)
+	want := `{"result":[{"metric":{"__name__":"temperature","sensor":"kitchen","unit":"C"},"value":[` + at + `,"23"]}],"resultType":"vector"}`
+	if string(resp.Data) != want {
+		t.Errorf("an instant query returned %s, want %s", resp.Data, want)
+	}
+}
+
+func TestSeriesAndLabels(t *testing.T) {
+	controller, _ := newQueriedController(t)
+	resp := servePromApi(t, controller.Series, "/api/v1/series", url.Values{"match[]": {"humidity", `temperature{sensor="attic"}`}}, http.StatusOK)
+	if string(resp.Data) != `[{"__name__":"humidity","sensor":"bathroom","unit":"%"}]` {
+		t.Errorf("series returned %s", resp.Data)
+	}
+	servePromApi(t, controller.Series, "/api/v1/series", url.Values{}, http.StatusBadRequest)
+	servePromApi(t, controller.Series, "/api/v1/series", url.Values{"match[]": {"{}"}}, http.StatusBadRequest)
+
+	resp = servePromApi(t, controller.Labels, "/api/v1/labels", url.Values{}, http.StatusOK)
+	if string(resp.Data) != `["__name__","sensor","unit"]` {
+		t.Errorf("labels returned %s", resp.Data)
+	}
+	resp = servePromApi(t, controller.LabelValues, "/api/v1/label/sensor/values", url.Values{}, http.StatusOK)
+	if string(resp.Data) != `["bathroom","kitchen"]` {
+		t.Errorf("sensor values returned %s", resp.Data)
+	}
+	resp = servePromApi(t, controller.LabelValues, "/api/v1/label/__name__/values", url.Values{"match[]": {`{sensor="kitchen"}`}}, http.StatusOK)
+	if string(resp.Data) != `["temperature"]` {
+		t.Errorf("metric names of kitchen returned %s", resp.Data)
+	}
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"errors"
+	"github.com/andreikom/sensor-server/pkg/config"
+	"github.com/andreikom/sensor-server/pkg/logging"
+	"sort"
+	"sync"
+)
+
+// ConfigLoader resolves the configuration again from the sources the server was started with
+type ConfigLoader func() (*Config, error)
+
+// errReloadUnsupported - the server was started without a ConfigLoader
+var errReloadUnsupported = errors.New("the configuration of the server cannot be reloaded")
+
+// reloadable - the settings applied to a running server by a reload, see takeReloadable. Changes of the others
+// are reported and take effect after a restart
+var reloadable = map[string]bool{
+	"logging.format":        true,
+	"logging.level":         true,
+	"retention.rawDays":     true,
+	"retention.archiveDays": true,
+	"server.maxConnections": true,
+}
+
+// ReloadReport - the outcome of a reload, the paths of the changed settings
+type ReloadReport struct {
+	Applied         []string `json:"applied"`
+	RestartRequired []string `json:"restartRequired"`
+}
+
+// reloader - reloads the configuration on SIGHUP and POST /admin/config/reload, one reload at a time
+type reloader struct {
+	mutex sync.Mutex
+	load  ConfigLoader
+	// current - the effective configuration, settings requiring a restart keep the values the server started with
+	current *Config
+	// apply hands the reloadable settings of cfg to the subsystems, cfg is valid so it cannot fail
+	apply func(cfg *Config)
+}
+
+// reload validates the whole configuration before applying any setting, an invalid one leaves the server as it is
+func (r *reloader) reload() (ReloadReport, error) {
+	if r.load == nil {
+		return ReloadReport{}, errReloadUnsupported
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	cfg, err := r.load()
+	if err != nil {
+		return ReloadReport{}, err
+	}
+	if err := Validate(cfg); err != nil {
+		return ReloadReport{}, err
+	}
+	report := ReloadReport{Applied: []string{}, RestartRequired: []string{}}
+	for _, path := range config.Diff(r.current, cfg) {
+		if reloadable[path] {
+			report.Applied = append(report.Applied, path)
+		} else {
+			report.RestartRequired = append(report.RestartRequired, path)
+		}
+	}
+	sort.Strings(report.Applied)
+	sort.Strings(report.RestartRequired)
+	effective := *r.current
+	takeReloadable(&effective, cfg)
+	r.apply(&effective)
+	r.current = &effective
+	return report, nil
+}
+
+// reloadOnHangup - SIGHUP has no caller to answer, the outcome is logged
+func (r *reloader) reloadOnHangup() {
+	report, err := r.reload()
+	if err != nil {
+		logging.Log.WithError(err).Error("Could not have reloaded the configuration, keeping the current one")
+		return
+	}
+	logReload(report)
+}
+
+func logReload(report ReloadReport) {
+	entry := logging.Log.WithField("applied", report.Applied)
+	if len(report.RestartRequired) > 0 {
+		entry.WithField("restartRequired", report.RestartRequired).Warn("Reloaded the configuration, some changes need a restart")
+		return
+	}
+	entry.Info("Reloaded the configuration")
+}
+
+// takeReloadable copies the settings listed in reloadable from src to dst
+func takeReloadable(dst *Config, src *Config) {
+	dst.Logging = src.Logging
+	dst.Retention = src.Retention
+	dst.Server.MaxConnections = src.Server.MaxConnections
+}
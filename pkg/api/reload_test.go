@@ -0,0 +1,110 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTestReloader reloads the defaults with overrides, applied records the configurations handed to the subsystems
+func newTestReloader(t *testing.T, overrides *[]string) (*reloader, *[]*Config) {
+	t.Helper()
+	current, err := ResolveConfig(ConfigSources{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	applied := make([]*Config, 0)
+	return &reloader{
+		load: func() (*Config, error) {
+			return ResolveConfig(ConfigSources{Overrides: *overrides})
+		},
+		current: current,
+		apply:   func(cfg *Config) { applied = append(applied, cfg) },
+	}, &applied
+}
+
+func TestReloadAppliesReloadableSettings(t *testing.T) {
+	overrides := []string{"logging.level=debug", "server.maxConnections=3", "retention.rawDays=14", "server.port=9001", "grpc.address=:9001"}
+	configReloader, applied := newTestReloader(t, &overrides)
+	report, err := configReloader.reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(report.Applied) != "[logging.level retention.rawDays server.maxConnections]" {
+		t.Errorf("applied %v", report.Applied)
+	}
+	if fmt.Sprint(report.RestartRequired) != "[grpc.address server.port]" {
+		t.Errorf("restart required by %v", report.RestartRequired)
+	}
+	if len(*applied) != 1 {
+		t.Fatalf("applied %d configurations", len(*applied))
+	}
+	effective := (*applied)[0]
+	if effective.Logging.Level != "debug" || effective.Server.MaxConnections != 3 || effective.Retention.RawDays != 14 {
+		t.Errorf("applied %+v", effective)
+	}
+	// the settings requiring a restart keep the values the server started with
+	if effective.Server.Port != defaultPort || effective.Grpc.Address != grpcAddress || configReloader.current != effective {
+		t.Errorf("the effective configuration is %+v", effective)
+	}
+
+	// a second reload only reports what changed since, the pending restart is still reported
+	overrides = append(overrides, "logging.format=json")
+	report, err = configReloader.reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(report.Applied) != "[logging.format]" || fmt.Sprint(report.RestartRequired) != "[grpc.address server.port]" {
+		t.Errorf("the second reload reported %+v", report)
+	}
+}
+
+func TestReloadKeepsTheConfigurationOnErrors(t *testing.T) {
+	overrides := []string{"logging.level=debug", "server.maxConnections=0"}
+	configReloader, applied := newTestReloader(t, &overrides)
+	started := configReloader.current
+	if _, err := configReloader.reload(); err == nil || !strings.Contains(err.Error(), "MaxConnections") {
+		t.Errorf("an invalid configuration returned %v", err)
+	}
+	overrides = []string{"server.prot=1"}
+	if _, err := configReloader.reload(); err == nil {
+		t.Error("an unresolvable configuration was reloaded")
+	}
+	// nothing was applied, not even the valid settings
+	if len(*applied) != 0 || configReloader.current != started || started.Logging.Level != "info" {
+		t.Errorf("applied %d configurations, the current one is %+v", len(*applied), configReloader.current)
+	}
+	if _, err := (&reloader{}).reload(); !errors.Is(err, errReloadUnsupported) {
+		t.Errorf("a reload without loader returned %v", err)
+	}
+}
+
+func TestReloadConfigEndpoint(t *testing.T) {
+	overrides := []string{"logging.level=warn"}
+	configReloader, _ := newTestReloader(t, &overrides)
+	post := func(controller *adminController) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		controller.ReloadConfig(rec, httptest.NewRequest("POST", "/admin/config/reload", nil))
+		return rec
+	}
+	rec := post(&adminController{reloader: configReloader})
+	report := ReloadReport{}
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &report) != nil {
+		t.Fatalf("POST /admin/config/reload = %d %s", rec.Code, rec.Body)
+	}
+	// empty lists are written as [] rather than null
+	if fmt.Sprint(report.Applied) != "[logging.level]" || report.RestartRequired == nil || !strings.Contains(rec.Body.String(), `"restartRequired":[]`) {
+		t.Errorf("POST /admin/config/reload = %s", rec.Body)
+	}
+	overrides = []string{"logging.level=loud"}
+	if rec := post(&adminController{reloader: configReloader}); rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "Could not have reloaded the configuration") {
+		t.Errorf("an invalid configuration was answered with %d %s", rec.Code, rec.Body)
+	}
+	if rec := post(&adminController{reloader: &reloader{}}); rec.Code != http.StatusNotImplemented {
+		t.Errorf("a server without loader answered %d", rec.Code)
+	}
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"fmt"
+	"github.com/andreikom/sensor-server/pkg/api/metric"
+	"github.com/andreikom/sensor-server/pkg/storage"
+	"os"
+)
+
+// OpenStore opens the records under cfg.Storage.Path for the maintenance commands, no server may be running
+// on them as it would overwrite their changes with its cache
+func OpenStore(cfg *Config) (*metric.MetricService, *storage.FsDriver, error) {
+	fsDriver, err := storeDriver(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return metric.NewStore(fsDriver, metricOptions(cfg)), fsDriver, nil
+}
+
+// OpenReadOnlyStore opens the records under cfg.Storage.Path for the commands only reading them, which leave
+// records of older versions as they are
+func OpenReadOnlyStore(cfg *Config) (*metric.MetricService, *storage.FsDriver, error) {
+	fsDriver, err := storeDriver(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return metric.NewReadOnlyStore(fsDriver, metricOptions(cfg)), fsDriver, nil
+}
+
+func storeDriver(cfg *Config) (*storage.FsDriver, error) {
+	if err := Validate(cfg); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	info, err := os.Stat(cfg.Storage.Path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("the store %s is not a directory", cfg.Storage.Path)
+	}
+	return storage.NewFSDriver(cfg.Storage.Path), nil
+}
+
+// metricOptions - cfg has to be valid, see Validate
+func metricOptions(cfg *Config) metric.Options {
+	locations, _ := metric.NewLocations(cfg.Locations.Default, cfg.Locations.Sensors)
+	weekStart, _ := metric.ParseWeekday(cfg.Calendar.WeekStart)
+	return metric.Options{
+		Locations:            locations,
+		WeekStart:            weekStart,
+		RawRetentionDays:     cfg.Retention.RawDays,
+		ArchiveRetentionDays: cfg.Retention.ArchiveDays,
+		MaxRedeliveries:      cfg.Broker.MaxRedeliveries,
+	}
+}
@@ -2,7 +2,8 @@ package temperature
 
 import (
 	"context"
-	"strconv"
+	"github.com/andreikom/sensor-server/pkg/api/metric"
+	"time"
 )
 
 type TempServiceGrpc struct {
@@ -10,65 +11,100 @@ type TempServiceGrpc struct {
 }
 
 func (t *TempServiceGrpc) SaveTemp(ctx context.Context, sensorIdTemp *SensorIdTemp) (*Empty, error) {
-	err := t.TempService.SaveTemperature(sensorIdTemp.SensorId, int(sensorIdTemp.Temp))
+	unit, err := ParseUnit(sensorIdTemp.Unit)
 	if err != nil {
-		return nil, nil // TODO [andreik]: remove error?
+		return nil, metric.GrpcError(err)
+	}
+	err = t.TempService.SaveTemperature(ctx, sensorIdTemp.SensorId, sensorIdTemp.Temp, unit)
+	if err != nil {
+		return nil, metric.GrpcError(err)
 	}
 	return &Empty{}, nil
 }
 
 func (t *TempServiceGrpc) GetDailyMaxTempByDateAndById(ctx context.Context, sensorIdDate *SensorIdDate) (*Result, error) {
-	res, err := t.TempService.GetDailyMaxTempByDateAndById(sensorIdDate.SensorId, sensorIdDate.Date)
+	unit, loc, err := parseQuery(sensorIdDate)
 	if err != nil {
-		return nil, nil // TODO [andreik]: remove error?
+		return nil, metric.GrpcError(err)
 	}
-	msg := strconv.FormatInt(int64(res), 10)
-	return &Result{Value: msg}, nil
+	res, err := t.TempService.GetDailyMaxTempByDateAndById(sensorIdDate.SensorId, sensorIdDate.Date, unit, loc)
+	if err != nil {
+		return nil, metric.GrpcError(err)
+	}
+	return &Result{Value: metric.FormatReading(res)}, nil
 }
 
 func (t *TempServiceGrpc) GetWeeklyMaxTempById(ctx context.Context, sensorIdDate *SensorIdDate) (*Result, error) {
-	res, err := t.TempService.GetDailyWeeklyMaxTempBySensorId(sensorIdDate.SensorId)
+	unit, loc, err := parseQuery(sensorIdDate)
+	if err != nil {
+		return nil, metric.GrpcError(err)
+	}
+	res, err := t.TempService.GetWeeklyMaxTempByDateAndById(sensorIdDate.SensorId, sensorIdDate.Date, unit, loc)
 	if err != nil {
-		return nil, nil // TODO [andreik]: remove error?
+		return nil, metric.GrpcError(err)
 	}
-	msg := strconv.FormatInt(int64(res), 10)
-	return &Result{Value: msg}, nil
+	return &Result{Value: metric.FormatReading(res)}, nil
 }
 
 func (t *TempServiceGrpc) GetDailyMinTempByDateAndById(ctx context.Context, sensorIdDate *SensorIdDate) (*Result, error) {
-	res, err := t.TempService.GetDailyMinTempByDateAndById(sensorIdDate.SensorId, sensorIdDate.Date)
+	unit, loc, err := parseQuery(sensorIdDate)
+	if err != nil {
+		return nil, metric.GrpcError(err)
+	}
+	res, err := t.TempService.GetDailyMinTempByDateAndById(sensorIdDate.SensorId, sensorIdDate.Date, unit, loc)
 	if err != nil {
-		return nil, nil // TODO [andreik]: remove error?
+		return nil, metric.GrpcError(err)
 	}
-	msg := strconv.FormatInt(int64(res), 10)
-	return &Result{Value: msg}, nil
+	return &Result{Value: metric.FormatReading(res)}, nil
 }
 
 func (t *TempServiceGrpc) GetWeeklyMinTempById(ctx context.Context, sensorIdDate *SensorIdDate) (*Result, error) {
-	res, err := t.TempService.GetDailyWeeklyMinTempBySensorId(sensorIdDate.SensorId)
+	unit, loc, err := parseQuery(sensorIdDate)
+	if err != nil {
+		return nil, metric.GrpcError(err)
+	}
+	res, err := t.TempService.GetWeeklyMinTempByDateAndById(sensorIdDate.SensorId, sensorIdDate.Date, unit, loc)
 	if err != nil {
-		return nil, nil // TODO [andreik]: remove error?
+		return nil, metric.GrpcError(err)
 	}
-	msg := strconv.FormatInt(int64(res), 10)
-	return &Result{Value: msg}, nil
+	return &Result{Value: metric.FormatReading(res)}, nil
 }
 
 func (t *TempServiceGrpc) GetDailyAvgTempByDateAndById(ctx context.Context, sensorIdDate *SensorIdDate) (*Result, error) {
-	res, err := t.TempService.GetDailyAvgTempByDateAndById(sensorIdDate.SensorId, sensorIdDate.Date)
+	unit, loc, err := parseQuery(sensorIdDate)
 	if err != nil {
-		return nil, nil // TODO [andreik]: remove error?
+		return nil, metric.GrpcError(err)
 	}
-	msg := strconv.FormatInt(int64(res), 10)
-	return &Result{Value: msg}, nil
+	res, err := t.TempService.GetDailyAvgTempByDateAndById(sensorIdDate.SensorId, sensorIdDate.Date, unit, loc)
+	if err != nil {
+		return nil, metric.GrpcError(err)
+	}
+	return &Result{Value: metric.FormatReading(res)}, nil
 }
 
 func (t *TempServiceGrpc) GetWeeklyAvgTempById(ctx context.Context, sensorIdDate *SensorIdDate) (*Result, error) {
-	res, err := t.TempService.GetDailyWeeklyAvgTempBySensorId(sensorIdDate.SensorId)
+	unit, loc, err := parseQuery(sensorIdDate)
+	if err != nil {
+		return nil, metric.GrpcError(err)
+	}
+	res, err := t.TempService.GetWeeklyAvgTempByDateAndById(sensorIdDate.SensorId, sensorIdDate.Date, unit, loc)
+	if err != nil {
+		return nil, metric.GrpcError(err)
+	}
+	return &Result{Value: metric.FormatReading(res)}, nil
+}
+
+// parseQuery resolves the optional unit and timezone of a query
+func parseQuery(sensorIdDate *SensorIdDate) (Unit, *time.Location, error) {
+	unit, err := ParseUnit(sensorIdDate.Unit)
+	if err != nil {
+		return "", nil, err
+	}
+	loc, err := metric.ParseLocation(sensorIdDate.Timezone)
 	if err != nil {
-		return nil, nil // TODO [andreik]: remove error?
+		return "", nil, err
 	}
-	msg := strconv.FormatInt(int64(res), 10)
-	return &Result{Value: msg}, nil
+	return unit, loc, nil
 }
 
 func (t *TempServiceGrpc) mustEmbedUnimplementedTempServiceServer() {
@@ -0,0 +1,65 @@
+package temperature
+
+import (
+	"context"
+	"github.com/andreikom/sensor-server/pkg/api/metric"
+	"github.com/andreikom/sensor-server/pkg/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+func TestTempServiceGrpcErrors(t *testing.T) {
+	store := metric.NewStore(storage.NewFSDriver(t.TempDir()), metric.Options{})
+	now := time.Now().UTC().Truncate(time.Hour)
+	if err := store.Import(context.Background(), []metric.Reading{{SensorId: "kitchen", Metric: metric.Temperature, Value: 20, Timestamp: now}}); err != nil {
+		t.Fatal(err)
+	}
+	server := &TempServiceGrpc{TempService: NewTempService(store)}
+	date := now.Format("2006-01-02")
+	handlers := map[string]func(ctx context.Context, sensorIdDate *SensorIdDate) (*Result, error){
+		"GetDailyMaxTempByDateAndById": server.GetDailyMaxTempByDateAndById, "GetWeeklyMaxTempById": server.GetWeeklyMaxTempById,
+		"GetDailyMinTempByDateAndById": server.GetDailyMinTempByDateAndById, "GetWeeklyMinTempById": server.GetWeeklyMinTempById,
+		"GetDailyAvgTempByDateAndById": server.GetDailyAvgTempByDateAndById, "GetWeeklyAvgTempById": server.GetWeeklyAvgTempById,
+	}
+	tests := []struct {
+		query *SensorIdDate
+		code  codes.Code
+	}{
+		{&SensorIdDate{SensorId: "kitchen", Date: date, Timezone: "UTC", Unit: "F"}, codes.OK},
+		{&SensorIdDate{SensorId: "hallway", Date: date, Timezone: "UTC"}, codes.NotFound},
+		{&SensorIdDate{SensorId: "kitchen", Date: "19.10.2026"}, codes.InvalidArgument},
+		{&SensorIdDate{SensorId: "kitchen", Date: date, Timezone: "Nowhere/Special"}, codes.InvalidArgument},
+		{&SensorIdDate{SensorId: "kitchen", Date: date, Unit: "ppm"}, codes.InvalidArgument},
+	}
+	for _, test := range tests {
+		for name, handler := range handlers {
+			res, err := handler(context.Background(), test.query)
+			if status.Code(err) != test.code {
+				t.Errorf("%s(%+v) = %v, want %s", name, test.query, err, test.code)
+			}
+			if err == nil && res.Value != "68.00" {
+				t.Errorf("%s(%+v) = %s", name, test.query, res.Value)
+			}
+			if err != nil && res != nil {
+				t.Errorf("%s(%+v) answered %v besides the error", name, test.query, res)
+			}
+		}
+	}
+
+	saves := []struct {
+		reading *SensorIdTemp
+		code    codes.Code
+	}{
+		{&SensorIdTemp{SensorId: "kitchen sink", Temp: 20}, codes.InvalidArgument},
+		{&SensorIdTemp{SensorId: "kitchen", Temp: 20, Unit: "X"}, codes.InvalidArgument},
+		// the store has no broker to publish to
+		{&SensorIdTemp{SensorId: "kitchen", Temp: 20}, codes.Internal},
+	}
+	for _, save := range saves {
+		if res, err := server.SaveTemp(context.Background(), save.reading); status.Code(err) != save.code || res != nil {
+			t.Errorf("SaveTemp(%+v) = %v, %v, want %s", save.reading, res, err, save.code)
+		}
+	}
+}
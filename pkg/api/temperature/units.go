@@ -0,0 +1,20 @@
+package temperature
+
+import (
+	"github.com/andreikom/sensor-server/pkg/api/metric"
+)
+
+// Unit - the unit a temperature reading is reported or queried in.
+// Readings are always kept in Celsius inside the cache and on disk.
+type Unit = metric.Unit
+
+const (
+	Celsius    = metric.Celsius
+	Fahrenheit = metric.Fahrenheit
+	Kelvin     = metric.Kelvin
+)
+
+// ParseUnit resolves a temperature unit from user input, an empty value falls back to Celsius
+func ParseUnit(unit string) (Unit, error) {
+	return metric.TemperatureDefinition.ParseUnit(unit)
+}
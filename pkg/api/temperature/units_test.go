@@ -0,0 +1,121 @@
+package temperature
+
+import (
+	"context"
+	"github.com/andreikom/sensor-server/pkg/api/metric"
+	"github.com/andreikom/sensor-server/pkg/api/metric/metrictest"
+	"github.com/andreikom/sensor-server/pkg/storage"
+	"testing"
+	"time"
+)
+
+func TestParseUnit(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Unit
+	}{
+		{"", Celsius},
+		{"  ", Celsius},
+		{"C", Celsius},
+		{"c", Celsius},
+		{"celsius", Celsius},
+		{"F", Fahrenheit},
+		{"Fahrenheit", Fahrenheit},
+		{"k", Kelvin},
+		{"KELVIN", Kelvin},
+	}
+	for _, test := range tests {
+		got, err := ParseUnit(test.input)
+		if err != nil {
+			t.Errorf("ParseUnit(%q) returned %v", test.input, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ParseUnit(%q) = %q, want %q", test.input, got, test.want)
+		}
+	}
+	for _, input := range []string{"X", "degrees", "ppm"} {
+		if _, err := ParseUnit(input); err == nil {
+			t.Errorf("ParseUnit(%q) accepted an unsupported unit", input)
+		}
+	}
+}
+
+func TestConversions(t *testing.T) {
+	def := metric.TemperatureDefinition
+	tests := []struct {
+		unit    Unit
+		value   float64
+		celsius float64
+	}{
+		{Celsius, 21.5, 21.5},
+		{Fahrenheit, 32, 0},
+		{Fahrenheit, 212, 100},
+		{Fahrenheit, -40, -40},
+		{Kelvin, 273.15, 0},
+		{Kelvin, 0, -273.15},
+	}
+	for _, test := range tests {
+		if got := def.ToStore(test.unit, test.value); !metrictest.Near(got, test.celsius) {
+			t.Errorf("ToStore(%s, %v) = %v, want %v", test.unit, test.value, got, test.celsius)
+		}
+		if got := def.FromStore(test.unit, test.celsius); !metrictest.Near(got, test.value) {
+			t.Errorf("FromStore(%s, %v) = %v, want %v", test.unit, test.celsius, got, test.value)
+		}
+	}
+	// a difference of 10 degrees Celsius is 18 degrees Fahrenheit whatever the offset
+	if got := def.DeltaFromStore(Fahrenheit, 10); !metrictest.Near(got, 18) {
+		t.Errorf("DeltaFromStore(F, 10) = %v, want 18", got)
+	}
+	if got := def.DeltaFromStore(Kelvin, 10); !metrictest.Near(got, 10) {
+		t.Errorf("DeltaFromStore(K, 10) = %v, want 10", got)
+	}
+}
+
+func TestFractionalReadingsQueriedInUnits(t *testing.T) {
+	store := metric.NewStore(storage.NewFSDriver(t.TempDir()), metric.Options{})
+	now := time.Now().UTC().Truncate(time.Hour)
+	readings := []metric.Reading{
+		{SensorId: "kitchen", Metric: metric.Temperature, Value: 20.25, Timestamp: now},
+		{SensorId: "kitchen", Metric: metric.Temperature, Value: 71.6, Unit: Fahrenheit, Timestamp: now},
+	}
+	if err := store.Import(context.Background(), readings); err != nil {
+		t.Fatal(err)
+	}
+	service := NewTempService(store)
+	date := now.Format("2006-01-02")
+	tests := []struct {
+		name  string
+		query func(unit Unit) (float64, error)
+		unit  Unit
+		want  float64
+	}{
+		{"max", func(unit Unit) (float64, error) {
+			return service.GetDailyMaxTempByDateAndById("kitchen", date, unit, time.UTC)
+		}, Celsius, 22},
+		{"min", func(unit Unit) (float64, error) {
+			return service.GetDailyMinTempByDateAndById("kitchen", date, unit, time.UTC)
+		}, Celsius, 20.25},
+		{"avg", func(unit Unit) (float64, error) {
+			return service.GetDailyAvgTempByDateAndById("kitchen", date, unit, time.UTC)
+		}, Celsius, 21.125},
+		{"max", func(unit Unit) (float64, error) {
+			return service.GetDailyMaxTempByDateAndById("kitchen", date, unit, time.UTC)
+		}, Fahrenheit, 71.6},
+		{"min", func(unit Unit) (float64, error) {
+			return service.GetDailyMinTempByDateAndById("kitchen", date, unit, time.UTC)
+		}, Kelvin, 293.4},
+	}
+	for _, test := range tests {
+		got, err := test.query(test.unit)
+		if err != nil {
+			t.Fatalf("%s in %s: %v", test.name, test.unit, err)
+		}
+		if !metrictest.Near(got, test.want) {
+			t.Errorf("%s in %s = %v, want %v", test.name, test.unit, got, test.want)
+		}
+	}
+	if _, err := service.GetDailyMaxTempByDateAndById("hallway", date, Celsius, time.UTC); err == nil {
+		t.Error("an unknown sensor returned a reading")
+	}
+}
@@ -0,0 +1,42 @@
+package api
+
+import "sync"
+
+// throttle - limits the requests handled at once, the limit can be changed while requests are in progress
+type throttle struct {
+	mutex sync.Mutex
+	cond  *sync.Cond
+	limit int
+	inUse int
+}
+
+func newThrottle(limit int) *throttle {
+	t := &throttle{limit: limit}
+	t.cond = sync.NewCond(&t.mutex)
+	return t
+}
+
+// acquire waits for a free slot
+func (t *throttle) acquire() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for t.inUse >= t.limit {
+		t.cond.Wait()
+	}
+	t.inUse++
+}
+
+func (t *throttle) release() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.inUse--
+	t.cond.Signal()
+}
+
+// resize changes the limit, requests beyond a lowered limit finish and the following ones wait
+func (t *throttle) resize(limit int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.limit = limit
+	t.cond.Broadcast()
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// acquireAll starts n requests, the returned counter is the number of them holding a slot
+func acquireAll(throttle *throttle, n int) *int32 {
+	var acquired int32
+	for i := 0; i < n; i++ {
+		go func() {
+			throttle.acquire()
+			atomic.AddInt32(&acquired, 1)
+		}()
+	}
+	return &acquired
+}
+
+func eventually(t *testing.T, acquired *int32, want int32) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(acquired) != want && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	// no more requests than wanted get a slot
+	time.Sleep(20 * time.Millisecond)
+	if got := atomic.LoadInt32(acquired); got != want {
+		t.Fatalf("%d requests hold a slot, want %d", got, want)
+	}
+}
+
+func TestThrottleLimitsRequests(t *testing.T) {
+	throttle := newThrottle(2)
+	acquired := acquireAll(throttle, 4)
+	eventually(t, acquired, 2)
+	throttle.release()
+	eventually(t, acquired, 3)
+	throttle.release()
+	throttle.release()
+	eventually(t, acquired, 4)
+}
+
+func TestThrottleResize(t *testing.T) {
+	throttle := newThrottle(1)
+	acquired := acquireAll(throttle, 4)
+	eventually(t, acquired, 1)
+	// the waiting requests get the new slots right away
+	throttle.resize(3)
+	eventually(t, acquired, 3)
+	// requests beyond a lowered limit finish, the following ones wait until the server is below the limit
+	throttle.resize(1)
+	throttle.release()
+	throttle.release()
+	eventually(t, acquired, 3)
+	throttle.release()
+	eventually(t, acquired, 4)
+}
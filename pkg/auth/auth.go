@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+)
+
+// Scope - what a principal may do
+type Scope string
+
+const (
+	// Ingest - write readings
+	Ingest Scope = "ingest"
+	// Read - query readings and statistics
+	Read Scope = "read"
+	// Admin - the /admin/ routes, it also grants Ingest and Read
+	Admin Scope = "admin"
+)
+
+var (
+	// ErrUnauthenticated - the request carried no credentials, or invalid ones
+	ErrUnauthenticated = errors.New("a valid API key or token is required")
+	// ErrForbidden - the credentials of the request do not grant what it asked for
+	ErrForbidden = errors.New("the credentials do not grant access to this resource")
+)
+
+// ParseScope accepts ingest, read and admin
+func ParseScope(value string) (Scope, error) {
+	switch scope := Scope(value); scope {
+	case Ingest, Read, Admin:
+		return scope, nil
+	}
+	return "", fmt.Errorf("unknown scope '%s', expected %s, %s or %s", value, Ingest, Read, Admin)
+}
+
+// SensorTags - sensorId -> the tags of the sensor, principals scoped to a tag may access the sensors carrying it
+type SensorTags map[string][]string
+
+// Principal - who made a request and what it may do
+type Principal struct {
+	// Name - of the key or the subject of the token, it goes into the logs
+	Name   string
+	Scopes []Scope
+	// Sensors - the ids of the sensors the principal may access, or path.Match patterns such as "greenhouse-*".
+	// All sensors if Sensors and Tags are empty
+	Sensors []string
+	// Tags - the principal may also access the sensors carrying one of these tags
+	Tags []string
+	// sensorTags - set by the Authenticator from its configuration
+	sensorTags SensorTags
+}
+
+// HasScope - Admin grants every scope
+func (p *Principal) HasScope(scope Scope) bool {
+	for _, granted := range p.Scopes {
+		if granted == scope || granted == Admin {
+			return true
+		}
+	}
+	return false
+}
+
+// Restricted - the principal may only access some sensors
+func (p *Principal) Restricted() bool {
+	return len(p.Sensors) > 0 || len(p.Tags) > 0
+}
+
+func (p *Principal) AllowsSensor(sensorId string) bool {
+	if !p.Restricted() {
+		return true
+	}
+	for _, pattern := range p.Sensors {
+		if matched, _ := path.Match(pattern, sensorId); matched {
+			return true
+		}
+	}
+	for _, tag := range p.sensorTags[sensorId] {
+		for _, allowed := range p.Tags {
+			if tag == allowed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type principalKey struct{}
+
+func NewContext(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// FromContext returns the principal of an authenticated request, nil if authentication is disabled
+func FromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalKey{}).(*Principal)
+	return principal
+}
+
+// AllowSensor returns ErrForbidden if the principal of ctx may not access the sensor. It is called with the
+// sensor ids found in request bodies, those of the routes and gRPC requests are checked by the Authenticator
+func AllowSensor(ctx context.Context, sensorId string) error {
+	principal := FromContext(ctx)
+	if principal != nil && !principal.AllowsSensor(sensorId) {
+		return fmt.Errorf("%w: sensor %s", ErrForbidden, sensorId)
+	}
+	return nil
+}
+
+// validSensorPatterns - path.Match reports malformed patterns only while matching
+func validSensorPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return fmt.Errorf("invalid sensor pattern '%s'", pattern)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"github.com/golang-jwt/jwt/v4"
+	"math/big"
+	"time"
+)
+
+// Issuer - a local token issuer for development and tests, signing ES256 JWTs with a key of its own so the
+// OIDC setup can be tried without a provider. Its JWKS is served to the server as a file
+type Issuer struct {
+	key *ecdsa.PrivateKey
+	kid string
+}
+
+// NewIssuer generates a P-256 key
+func NewIssuer() (*Issuer, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return newIssuer(key), nil
+}
+
+// ParseIssuer reads the PEM written by Issuer.PrivateKeyPem
+func ParseIssuer(data []byte) (*Issuer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok || ecKey.Curve != elliptic.P256() {
+		return nil, errors.New("the issuer key has to be a P-256 key")
+	}
+	return newIssuer(ecKey), nil
+}
+
+func newIssuer(key *ecdsa.PrivateKey) *Issuer {
+	return &Issuer{key: key, kid: thumbprint(key.PublicKey)}
+}
+
+func (i *Issuer) PrivateKeyPem() ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(i.key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// Jwks - the public key of the issuer as a JWK set
+func (i *Issuer) Jwks() ([]byte, error) {
+	key := i.jwk()
+	key.Kid, key.Use, key.Alg = i.kid, "sig", "ES256"
+	return json.MarshalIndent(map[string][]jwk{"keys": {key}}, "", "  ")
+}
+
+func (i *Issuer) jwk() jwk {
+	size := (i.key.Curve.Params().BitSize + 7) / 8
+	return jwk{
+		Kty: "EC",
+		Crv: "P-256",
+		X:   base64.RawURLEncoding.EncodeToString(padded(i.key.X, size)),
+		Y:   base64.RawURLEncoding.EncodeToString(padded(i.key.Y, size)),
+	}
+}
+
+// Sign issues a token valid for ttl, claims such as iss, aud, sub, roles or sensors are added to the
+// time claims
+func (i *Issuer) Sign(claims map[string]interface{}, ttl time.Duration) (string, error) {
+	now := time.Now()
+	mapClaims := jwt.MapClaims{"iat": now.Unix(), "exp": now.Add(ttl).Unix()}
+	for name, value := range claims {
+		mapClaims[name] = value
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, mapClaims)
+	token.Header["kid"] = i.kid
+	return token.SignedString(i.key)
+}
+
+// thumbprint - the RFC 7638 thumbprint of the key, a stable key id
+func thumbprint(key ecdsa.PublicKey) string {
+	size := (key.Curve.Params().BitSize + 7) / 8
+	// the members in lexicographic order, without whitespace
+	canonical := `{"crv":"P-256","kty":"EC","x":"` + base64.RawURLEncoding.EncodeToString(padded(key.X, size)) +
+		`","y":"` + base64.RawURLEncoding.EncodeToString(padded(key.Y, size)) + `"}`
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func padded(value *big.Int, size int) []byte {
+	data := value.Bytes()
+	if len(data) >= size {
+		return data
+	}
+	return append(make([]byte, size-len(data)), data...)
+}
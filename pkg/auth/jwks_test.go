@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"testing"
+)
+
+func TestParseJwks(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer, err := NewIssuer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecJwk := issuer.jwk()
+	ecJwk.Kid = "ec"
+	encryption := ecJwk
+	encryption.Kid, encryption.Use = "enc", "enc"
+	set := map[string][]jwk{"keys": {
+		{Kty: "RSA", Kid: "rsa", Alg: "RS256", N: encode(rsaKey.N.Bytes()), E: encode(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{Kty: "OKP", Kid: "ed", Crv: "Ed25519", X: encode(edKey)},
+		ecJwk,
+		encryption,
+		{Kty: "oct", Kid: "hmac"},
+	}}
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := parseJwks(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 3 {
+		t.Errorf("parsed %d keys, want the 3 signature keys", len(keys))
+	}
+	if key, ok := keys["rsa"].key.(*rsa.PublicKey); !ok || key.N.Cmp(rsaKey.N) != 0 || key.E != rsaKey.E || keys["rsa"].alg != "RS256" {
+		t.Errorf("the RSA key is %+v", keys["rsa"])
+	}
+	if key, ok := keys["ed"].key.(ed25519.PublicKey); !ok || !key.Equal(edKey) {
+		t.Errorf("the Ed25519 key is %+v", keys["ed"])
+	}
+	if key, ok := keys["ec"].key.(*ecdsa.PublicKey); !ok || !key.Equal(&issuer.key.PublicKey) {
+		t.Errorf("the EC key is %+v", keys["ec"])
+	}
+}
+
+func TestParseInvalidJwks(t *testing.T) {
+	offCurve := newIssuerJwk(t)
+	offCurve.Y = offCurve.X
+	tests := map[string]string{
+		"not json":       `{"keys":`,
+		"no keys":        `{"keys":[]}`,
+		"only hmac keys": `{"keys":[{"kty":"oct","kid":"hmac","k":"c2VjcmV0"}]}`,
+		"unknown curve":  `{"keys":[{"kty":"EC","kid":"ec","crv":"P-192","x":"AQ","y":"AQ"}]}`,
+		"bad base64":     `{"keys":[{"kty":"RSA","kid":"rsa","n":"!!","e":"AQAB"}]}`,
+		"short ed25519":  `{"keys":[{"kty":"OKP","kid":"ed","crv":"Ed25519","x":"AQ"}]}`,
+		"off the curve":  mustJson(t, map[string][]jwk{"keys": {offCurve}}),
+	}
+	for name, data := range tests {
+		if _, err := parseJwks([]byte(data)); err == nil {
+			t.Errorf("%s: the JWKS was accepted", name)
+		}
+	}
+}
+
+func newIssuerJwk(t *testing.T) jwk {
+	t.Helper()
+	issuer, err := NewIssuer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return issuer.jwk()
+}
+
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func mustJson(t *testing.T, value interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
@@ -0,0 +1,229 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// keyPrefix - API keys are "ssk_<id>_<secret>", the prefix makes them easy to spot by secret scanners
+	keyPrefix    = "ssk_"
+	keyIdBytes   = 8
+	secretBytes  = 32
+	keysFileMode = 0600
+)
+
+// ErrKeyNotFound - no key has the given id
+var ErrKeyNotFound = errors.New("API key not found")
+
+// InvalidKeyError - the scopes or sensor patterns of a new key are invalid, nothing was saved
+type InvalidKeyError struct {
+	Err error
+}
+
+func (e *InvalidKeyError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *InvalidKeyError) Unwrap() error {
+	return e.Err
+}
+
+// Key - an API key as it is stored, the secret part of the key is only known by its hash
+type Key struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+	// Hash - the hex SHA-256 of the secret, omitted when keys are listed
+	Hash    string    `json:"hash,omitempty"`
+	Scopes  []Scope   `json:"scopes"`
+	Sensors []string  `json:"sensors,omitempty"`
+	Tags    []string  `json:"tags,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+// KeyStore - the API keys of a JSON file. The keys command edits the file of a running server, every change
+// reads the file first so none is lost, and the server picks up the changes of others on Reload
+type KeyStore struct {
+	path  string
+	mutex sync.RWMutex
+	keys  map[string]Key
+}
+
+// OpenKeyStore reads the keys of path, a missing file holds no keys
+func OpenKeyStore(path string) (*KeyStore, error) {
+	store := &KeyStore{path: path}
+	if err := store.Reload(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Reload reads the keys of the file again
+func (s *KeyStore) Reload() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.read()
+}
+
+func (s *KeyStore) read() error {
+	data, err := ioutil.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		s.keys = map[string]Key{}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	keys := make([]Key, 0)
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return fmt.Errorf("could not parse the API keys of %s: %w", s.path, err)
+	}
+	s.keys = make(map[string]Key, len(keys))
+	for _, key := range keys {
+		s.keys[key.Id] = key
+	}
+	return nil
+}
+
+// write replaces the file atomically, it is readable by its owner only
+func (s *KeyStore) write() error {
+	keys := s.sorted()
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, keysFileMode); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func (s *KeyStore) sorted() []Key {
+	keys := make([]Key, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Created.Equal(keys[j].Created) {
+			return keys[i].Id < keys[j].Id
+		}
+		return keys[i].Created.Before(keys[j].Created)
+	})
+	return keys
+}
+
+// Create adds a key and returns it, the returned token is the only time the key can be read. Invalid scopes
+// and sensor patterns are an InvalidKeyError, any other error comes from reading or writing the file
+func (s *KeyStore) Create(name string, scopes []Scope, sensors []string, tags []string) (string, Key, error) {
+	if len(scopes) == 0 {
+		return "", Key{}, &InvalidKeyError{Err: errors.New("an API key needs at least one scope")}
+	}
+	for _, scope := range scopes {
+		if _, err := ParseScope(string(scope)); err != nil {
+			return "", Key{}, &InvalidKeyError{Err: err}
+		}
+	}
+	if err := validSensorPatterns(sensors); err != nil {
+		return "", Key{}, &InvalidKeyError{Err: err}
+	}
+	id, err := randomHex(keyIdBytes)
+	if err != nil {
+		return "", Key{}, err
+	}
+	secret, err := randomHex(secretBytes)
+	if err != nil {
+		return "", Key{}, err
+	}
+	key := Key{Id: id, Name: name, Hash: hashSecret(secret), Scopes: scopes, Sensors: sensors, Tags: tags, Created: time.Now().UTC()}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.read(); err != nil {
+		return "", Key{}, err
+	}
+	s.keys[id] = key
+	if err := s.write(); err != nil {
+		delete(s.keys, id)
+		return "", Key{}, fmt.Errorf("could not have saved the API key: %w", err)
+	}
+	key.Hash = ""
+	return keyPrefix + id + "_" + secret, key, nil
+}
+
+// List returns the keys by creation time, without their hashes
+func (s *KeyStore) List() []Key {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	keys := s.sorted()
+	for i := range keys {
+		keys[i].Hash = ""
+	}
+	return keys
+}
+
+// Revoke deletes a key, requests with it fail from then on
+func (s *KeyStore) Revoke(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.read(); err != nil {
+		return err
+	}
+	key, ok := s.keys[id]
+	if !ok {
+		return ErrKeyNotFound
+	}
+	delete(s.keys, id)
+	if err := s.write(); err != nil {
+		s.keys[id] = key
+		return fmt.Errorf("could not have saved the API keys: %w", err)
+	}
+	return nil
+}
+
+// Authenticate returns the principal of a key, ErrUnauthenticated if the key is malformed or unknown
+func (s *KeyStore) Authenticate(token string) (*Principal, error) {
+	if !strings.HasPrefix(token, keyPrefix) {
+		return nil, ErrUnauthenticated
+	}
+	parts := strings.SplitN(strings.TrimPrefix(token, keyPrefix), "_", 2)
+	if len(parts) != 2 {
+		return nil, ErrUnauthenticated
+	}
+	s.mutex.RLock()
+	key, ok := s.keys[parts[0]]
+	s.mutex.RUnlock()
+	if !ok || subtle.ConstantTimeCompare([]byte(hashSecret(parts[1])), []byte(key.Hash)) != 1 {
+		return nil, ErrUnauthenticated
+	}
+	return &Principal{Name: key.Name, Scopes: key.Scopes, Sensors: key.Sensors, Tags: key.Tags}, nil
+}
+
+// hashSecret - secrets are 256 random bits, a plain SHA-256 cannot be brute forced and keeps every request cheap
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(data), nil
+}
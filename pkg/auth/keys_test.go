@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestKeyStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth", "keys.json")
+	store, err := OpenKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, key, err := store.Create("greenhouse", []Scope{Ingest}, []string{"greenhouse-*"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(token, keyPrefix+key.Id+"_") || key.Hash != "" {
+		t.Errorf("Create = %s, %+v", token, key)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != keysFileMode {
+		t.Errorf("the keys are stored with mode %s", info.Mode())
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret := token[strings.LastIndex(token, "_")+1:]; strings.Contains(string(data), secret) {
+		t.Error("the secret of the key is stored")
+	}
+
+	principal, err := store.Authenticate(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if principal.Name != "greenhouse" || !principal.HasScope(Ingest) || principal.HasScope(Read) || !principal.AllowsSensor("greenhouse-2") {
+		t.Errorf("Authenticate = %+v", principal)
+	}
+	for _, invalid := range []string{"", "greenhouse", keyPrefix + key.Id, keyPrefix + key.Id + "_0000", token + "0", strings.Replace(token, key.Id, "0000000000000000", 1)} {
+		if _, err := store.Authenticate(invalid); !errors.Is(err, ErrUnauthenticated) {
+			t.Errorf("Authenticate(%q) = %v", invalid, err)
+		}
+	}
+
+	// another store, e.g. the keys command, shares the file
+	other, err := OpenKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminToken, adminKey, err := other.Create("operator", []Scope{Admin}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Authenticate(adminToken); err == nil {
+		t.Error("a key created by another store was accepted before the reload")
+	}
+	if err := store.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Authenticate(adminToken); err != nil {
+		t.Errorf("a key created by another store was rejected after the reload: %v", err)
+	}
+	keys := store.List()
+	if len(keys) != 2 || keys[0].Id != key.Id || keys[1].Id != adminKey.Id || keys[0].Hash != "" {
+		t.Errorf("List = %+v", keys)
+	}
+
+	if err := store.Revoke(key.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Authenticate(token); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("a revoked key was accepted: %v", err)
+	}
+	if err := store.Revoke(key.Id); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("revoking the key twice returned %v", err)
+	}
+	// the revoke of the first store kept the key of the other one
+	if err := other.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if keys := other.List(); len(keys) != 1 || keys[0].Id != adminKey.Id {
+		t.Errorf("List = %+v", keys)
+	}
+}
+
+func TestCreateKeyValidation(t *testing.T) {
+	store, err := OpenKeyStore(filepath.Join(t.TempDir(), "keys.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var invalid *InvalidKeyError
+	if _, _, err := store.Create("none", nil, nil, nil); !errors.As(err, &invalid) {
+		t.Errorf("a key without scopes was created: %v", err)
+	}
+	if _, _, err := store.Create("root", []Scope{"root"}, nil, nil); !errors.As(err, &invalid) {
+		t.Errorf("a key with an unknown scope was created: %v", err)
+	}
+	if _, _, err := store.Create("broken", []Scope{Read}, []string{"greenhouse-["}, nil); !errors.As(err, &invalid) {
+		t.Errorf("a key with a malformed sensor pattern was created: %v", err)
+	}
+	if keys := store.List(); len(keys) != 0 {
+		t.Errorf("invalid keys were stored: %+v", keys)
+	}
+}
+
+func TestOpenKeyStoreRejectsMalformedFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	if err := os.WriteFile(path, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenKeyStore(path); err == nil {
+		t.Error("a malformed keys file was accepted")
+	}
+}
+
+func TestPrincipal(t *testing.T) {
+	if _, err := ParseScope("superuser"); err == nil {
+		t.Error("ParseScope accepted an unknown scope")
+	}
+	admin := &Principal{Scopes: []Scope{Admin}}
+	if !admin.HasScope(Ingest) || !admin.HasScope(Read) || admin.Restricted() || !admin.AllowsSensor("anything") {
+		t.Errorf("the admin %+v", admin)
+	}
+	restricted := &Principal{Scopes: []Scope{Read}, Sensors: []string{"kitchen", "greenhouse-*"}, Tags: []string{"outdoor"},
+		sensorTags: SensorTags{"garden": {"outdoor"}, "cellar": {"indoor"}}}
+	for sensorId, want := range map[string]bool{"kitchen": true, "greenhouse-1": true, "garden": true, "cellar": false, "hallway": false} {
+		if got := restricted.AllowsSensor(sensorId); got != want {
+			t.Errorf("AllowsSensor(%s) = %v, want %v", sensorId, got, want)
+		}
+	}
+}
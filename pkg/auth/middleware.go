@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"github.com/andreikom/sensor-server/pkg/logging"
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"strings"
+)
+
+const (
+	// apiKeyHeader - an alternative to "Authorization: Bearer <key>"
+	apiKeyHeader = "X-API-Key"
+	// apiKeyMetadata - the gRPC metadata key of apiKeyHeader, keys are lower case
+	apiKeyMetadata = "x-api-key"
+)
+
+// Rule - what a request needs. Public requests are not authenticated, AllSensors ones read or write the data
+// of any sensor and are forbidden to principals restricted to some sensors
+type Rule struct {
+	Public     bool
+	Scope      Scope
+	AllSensors bool
+}
+
+// Authenticator - authenticates the requests of the HTTP and gRPC APIs with the keys of a KeyStore, and with
+// JWTs if it has a TokenVerifier
+type Authenticator struct {
+	keys       *KeyStore
+	tokens     *TokenVerifier
+	sensorTags SensorTags
+}
+
+// NewAuthenticator - tokens may be nil, only API keys are accepted then
+func NewAuthenticator(keys *KeyStore, tokens *TokenVerifier, sensorTags SensorTags) *Authenticator {
+	return &Authenticator{keys: keys, tokens: tokens, sensorTags: sensorTags}
+}
+
+func (a *Authenticator) authenticate(token string) (*Principal, error) {
+	if token == "" {
+		return nil, ErrUnauthenticated
+	}
+	var principal *Principal
+	var err error
+	if a.tokens != nil && !strings.HasPrefix(token, keyPrefix) {
+		principal, err = a.tokens.Authenticate(token)
+	} else {
+		principal, err = a.keys.Authenticate(token)
+	}
+	if err != nil {
+		return nil, err
+	}
+	principal.sensorTags = a.sensorTags
+	return principal, nil
+}
+
+// authorize checks the principal against rule and the sensor of the request, if it names one
+func authorize(principal *Principal, rule Rule, sensorId string) error {
+	if !principal.HasScope(rule.Scope) {
+		return ErrForbidden
+	}
+	if principal.Restricted() && rule.AllSensors {
+		return ErrForbidden
+	}
+	if sensorId != "" && !principal.AllowsSensor(sensorId) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// HttpMiddleware authenticates the requests of the routes ruleOf does not make public, and checks the
+// 'sensorId' route variable. Sensor ids of request bodies are checked by the handlers with AllowSensor
+func (a *Authenticator) HttpMiddleware(ruleOf func(r *http.Request) Rule) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rule := ruleOf(r)
+			if rule.Public {
+				next.ServeHTTP(w, r)
+				return
+			}
+			principal, err := a.authenticate(httpToken(r))
+			if errors.Is(err, ErrForbidden) {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="sensor-server"`)
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			if err := authorize(principal, rule, mux.Vars(r)["sensorId"]); err != nil {
+				logging.FromContext(r.Context()).WithField("principal", principal.Name).WithField("path", r.URL.Path).Info("Forbidden request")
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), principal)))
+		})
+	}
+}
+
+// httpToken - a bearer token, the "Token" scheme of InfluxDB clients, the X-API-Key header or the password
+// of basic auth, which InfluxDB 1.x clients and Prometheus may be limited to
+func httpToken(r *http.Request) string {
+	if key := r.Header.Get(apiKeyHeader); key != "" {
+		return key
+	}
+	authorization := r.Header.Get("Authorization")
+	for _, scheme := range []string{"Bearer ", "Token "} {
+		if len(authorization) > len(scheme) && strings.EqualFold(authorization[:len(scheme)], scheme) {
+			return strings.TrimSpace(authorization[len(scheme):])
+		}
+	}
+	if _, password, ok := r.BasicAuth(); ok {
+		return password
+	}
+	return ""
+}
+
+// sensorRequest - requests generated by protoc for messages with a sensor_id field
+type sensorRequest interface {
+	GetSensorId() string
+}
+
+// UnaryServerInterceptor authenticates the calls ruleOf does not make public by the bearer token of the
+// "authorization" metadata or the "x-api-key" one. Principals restricted to some sensors may only make calls
+// whose request names one of them
+func (a *Authenticator) UnaryServerInterceptor(ruleOf func(method string) Rule) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		rule := ruleOf(info.FullMethod)
+		if rule.Public {
+			return handler(ctx, req)
+		}
+		principal, err := a.authenticate(grpcToken(ctx))
+		if errors.Is(err, ErrForbidden) {
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+		sensorId := ""
+		if sensorReq, ok := req.(sensorRequest); ok {
+			sensorId = sensorReq.GetSensorId()
+		}
+		if sensorId == "" && principal.Restricted() {
+			err = ErrForbidden
+		} else {
+			err = authorize(principal, rule, sensorId)
+		}
+		if err != nil {
+			logging.FromContext(ctx).WithField("principal", principal.Name).WithField("method", info.FullMethod).Info("Forbidden call")
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
+		return handler(NewContext(ctx, principal), req)
+	}
+}
+
+func grpcToken(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if values := md.Get(apiKeyMetadata); len(values) > 0 {
+		return values[0]
+	}
+	for _, authorization := range md.Get("authorization") {
+		if len(authorization) > len("Bearer ") && strings.EqualFold(authorization[:len("Bearer ")], "Bearer ") {
+			return strings.TrimSpace(authorization[len("Bearer "):])
+		}
+	}
+	return ""
+}
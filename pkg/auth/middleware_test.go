@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"context"
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestAuthenticator returns an authenticator with the tokens of an admin, a reader and a key restricted
+// to the greenhouse sensors
+func newTestAuthenticator(t *testing.T) (*Authenticator, map[string]string) {
+	t.Helper()
+	store, err := OpenKeyStore(filepath.Join(t.TempDir(), "keys.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := make(map[string]string)
+	for name, scopes := range map[string][]Scope{"admin": {Admin}, "reader": {Read}, "greenhouse": {Ingest, Read}} {
+		var sensors []string
+		if name == "greenhouse" {
+			sensors = []string{"greenhouse-*"}
+		}
+		token, _, err := store.Create(name, scopes, sensors, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens[name] = token
+	}
+	return NewAuthenticator(store, nil, nil), tokens
+}
+
+func TestHttpMiddleware(t *testing.T) {
+	authenticator, tokens := newTestAuthenticator(t)
+	router := mux.NewRouter()
+	router.Use(authenticator.HttpMiddleware(func(r *http.Request) Rule {
+		switch {
+		case r.URL.Path == "/healthz":
+			return Rule{Public: true}
+		case strings.HasPrefix(r.URL.Path, "/admin/"):
+			return Rule{Scope: Admin, AllSensors: true}
+		case r.Method == http.MethodPost:
+			return Rule{Scope: Ingest}
+		case mux.Vars(r)["sensorId"] != "":
+			return Rule{Scope: Read}
+		}
+		return Rule{Scope: Read, AllSensors: true}
+	}))
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if principal := FromContext(r.Context()); principal != nil {
+			w.Header().Set("X-Principal", principal.Name)
+		}
+	}
+	router.HandleFunc("/healthz", handler)
+	router.HandleFunc("/admin/keys", handler)
+	router.HandleFunc("/metric/", handler)
+	router.HandleFunc("/metric/temperature/daily_max/{sensorId}/{date}", handler)
+
+	tests := []struct {
+		method    string
+		path      string
+		token     string
+		status    int
+		principal string
+	}{
+		{"GET", "/healthz", "", http.StatusOK, ""},
+		{"GET", "/admin/keys", "", http.StatusUnauthorized, ""},
+		{"GET", "/admin/keys", "ssk_0_0", http.StatusUnauthorized, ""},
+		{"GET", "/admin/keys", "reader", http.StatusForbidden, ""},
+		{"GET", "/admin/keys", "admin", http.StatusOK, "admin"},
+		{"POST", "/metric/", "reader", http.StatusForbidden, ""},
+		{"POST", "/metric/", "greenhouse", http.StatusOK, "greenhouse"},
+		{"GET", "/metric/", "greenhouse", http.StatusForbidden, ""},
+		{"GET", "/metric/temperature/daily_max/greenhouse-1/2026-10-19", "greenhouse", http.StatusOK, "greenhouse"},
+		{"GET", "/metric/temperature/daily_max/kitchen/2026-10-19", "greenhouse", http.StatusForbidden, ""},
+		{"GET", "/metric/temperature/daily_max/kitchen/2026-10-19", "reader", http.StatusOK, "reader"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.path, nil)
+		token, ok := tokens[test.token]
+		if !ok {
+			token = test.token
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != test.status || rec.Header().Get("X-Principal") != test.principal {
+			t.Errorf("%s %s with %q = %d as %q, want %d as %q", test.method, test.path, test.token, rec.Code,
+				rec.Header().Get("X-Principal"), test.status, test.principal)
+		}
+		if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s %s answered 401 without a challenge", test.method, test.path)
+		}
+	}
+}
+
+func TestHttpToken(t *testing.T) {
+	tests := []func(r *http.Request){
+		func(r *http.Request) { r.Header.Set("Authorization", "Bearer secret") },
+		func(r *http.Request) { r.Header.Set("Authorization", "bearer  secret") },
+		func(r *http.Request) { r.Header.Set("Authorization", "Token secret") },
+		func(r *http.Request) { r.Header.Set("X-API-Key", "secret") },
+		func(r *http.Request) { r.SetBasicAuth("telegraf", "secret") },
+	}
+	for i, setCredentials := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		setCredentials(req)
+		if token := httpToken(req); token != "secret" {
+			t.Errorf("request %d: httpToken = %q", i, token)
+		}
+	}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer")
+	if token := httpToken(req); token != "" {
+		t.Errorf("httpToken of an empty bearer = %q", token)
+	}
+}
+
+type sensorReq struct {
+	sensorId string
+}
+
+func (r sensorReq) GetSensorId() string {
+	return r.sensorId
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	authenticator, tokens := newTestAuthenticator(t)
+	interceptor := authenticator.UnaryServerInterceptor(func(method string) Rule {
+		switch {
+		case strings.HasPrefix(method, "/grpc.health.v1.Health/"):
+			return Rule{Public: true}
+		case strings.Contains(method, "/Save"):
+			return Rule{Scope: Ingest}
+		}
+		return Rule{Scope: Read}
+	})
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		if principal := FromContext(ctx); principal != nil {
+			return principal.Name, nil
+		}
+		return "", nil
+	}
+	tests := []struct {
+		method    string
+		key       string
+		token     string
+		req       interface{}
+		code      codes.Code
+		principal string
+	}{
+		{"/grpc.health.v1.Health/Check", "", "", nil, codes.OK, ""},
+		{"/sensor.MetricService/SaveMetric", "", "", sensorReq{"kitchen"}, codes.Unauthenticated, ""},
+		{"/sensor.MetricService/SaveMetric", "authorization", "reader", sensorReq{"kitchen"}, codes.PermissionDenied, ""},
+		{"/sensor.MetricService/SaveMetric", "authorization", "greenhouse", sensorReq{"greenhouse-1"}, codes.OK, "greenhouse"},
+		{"/sensor.MetricService/SaveMetric", "x-api-key", "greenhouse", sensorReq{"kitchen"}, codes.PermissionDenied, ""},
+		{"/sensor.MetricService/GetMetrics", "x-api-key", "greenhouse", struct{}{}, codes.PermissionDenied, ""},
+		{"/sensor.MetricService/GetMetrics", "x-api-key", "reader", struct{}{}, codes.OK, "reader"},
+		{"/sensor.MetricService/GetMetrics", "x-api-key", "admin", sensorReq{}, codes.OK, "admin"},
+	}
+	for _, test := range tests {
+		ctx := context.Background()
+		if test.key == "authorization" {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", "Bearer "+tokens[test.token]))
+		} else if test.key != "" {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(test.key, tokens[test.token]))
+		}
+		resp, err := interceptor(ctx, test.req, &grpc.UnaryServerInfo{FullMethod: test.method}, handler)
+		if status.Code(err) != test.code || (err == nil && resp != test.principal) {
+			t.Errorf("%s with %q = %v, %v, want %s as %q", test.method, test.token, resp, err, test.code, test.principal)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/andreikom/sensor-server/pkg/logging"
+	"github.com/golang-jwt/jwt/v4"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultJwksRefresh = time.Hour
+	// minJwksFetchInterval - tokens signed by unknown keys fetch the JWKS again at most this often
+	minJwksFetchInterval = time.Minute
+	jwksFetchTimeout     = 10 * time.Second
+	defaultRolesClaim    = "roles"
+	defaultSensorsClaim  = "sensors"
+	defaultTagsClaim     = "sensor_tags"
+)
+
+// Role - what the subject of a token may do, mapped from its claims
+type Role string
+
+const (
+	// RoleViewer - the read scope
+	RoleViewer Role = "viewer"
+	// RoleOperator - the read and ingest scopes
+	RoleOperator Role = "operator"
+	// RoleAdmin - the admin scope
+	RoleAdmin Role = "admin"
+)
+
+var roleScopes = map[Role][]Scope{
+	RoleViewer:   {Read},
+	RoleOperator: {Read, Ingest},
+	RoleAdmin:    {Admin},
+}
+
+// signingMethods - asymmetric algorithms only, a JWKS holds public keys
+var signingMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// OidcOptions - how the bearer JWTs of an OpenID Connect provider are validated and mapped to principals
+type OidcOptions struct {
+	// Issuer - the 'iss' tokens must carry. The JWKS is discovered from its openid-configuration if JwksFile
+	// and JwksUrl are empty
+	Issuer string
+	// Audience - the 'aud' tokens must contain, not checked if empty
+	Audience string
+	// JwksFile - a local JWK set
+	JwksFile string
+	// JwksUrl - the jwks_uri of the provider
+	JwksUrl string
+	// JwksRefresh - how often JwksUrl is fetched again, defaultJwksRefresh if empty
+	JwksRefresh time.Duration
+	// Leeway - the clock skew tolerated for exp, nbf and iat
+	Leeway time.Duration
+	// RolesClaim - the claim holding the roles or groups of the subject, a dotted path such as
+	// realm_access.roles, defaultRolesClaim if empty
+	RolesClaim string
+	// RoleMapping - claim value -> role, values which are not mapped are taken as role names
+	RoleMapping map[string]Role
+	// SensorsClaim and TagsClaim restrict the subject to some sensors as API keys do, defaultSensorsClaim
+	// and defaultTagsClaim if empty
+	SensorsClaim string
+	TagsClaim    string
+	// HttpClient - fetches the JWKS and the openid-configuration
+	HttpClient *http.Client
+}
+
+func (o OidcOptions) withDefaults() OidcOptions {
+	if o.JwksRefresh <= 0 {
+		o.JwksRefresh = defaultJwksRefresh
+	}
+	if o.RolesClaim == "" {
+		o.RolesClaim = defaultRolesClaim
+	}
+	if o.SensorsClaim == "" {
+		o.SensorsClaim = defaultSensorsClaim
+	}
+	if o.TagsClaim == "" {
+		o.TagsClaim = defaultTagsClaim
+	}
+	if o.HttpClient == nil {
+		o.HttpClient = &http.Client{Timeout: jwksFetchTimeout}
+	}
+	return o
+}
+
+// ParseRole accepts viewer, operator and admin
+func ParseRole(value string) (Role, error) {
+	switch role := Role(value); role {
+	case RoleViewer, RoleOperator, RoleAdmin:
+		return role, nil
+	}
+	return "", fmt.Errorf("unknown role '%s', expected %s, %s or %s", value, RoleViewer, RoleOperator, RoleAdmin)
+}
+
+// TokenVerifier - validates JWTs against the keys of a JWKS. A JWKS of a url is fetched again every
+// JwksRefresh, in the background of the requests, and when a token is signed by a key it does not hold
+type TokenVerifier struct {
+	options  OidcOptions
+	parser   *jwt.Parser
+	mutex    sync.RWMutex
+	keys     map[string]publicKey
+	fetched  time.Time
+	fetching sync.Mutex
+}
+
+// NewTokenVerifier loads the JWKS. A provider which cannot be reached yet is logged and tried again by the
+// first token, a file which cannot be read fails
+func NewTokenVerifier(options OidcOptions) (*TokenVerifier, error) {
+	options = options.withDefaults()
+	if options.JwksFile == "" && options.JwksUrl == "" && options.Issuer == "" {
+		return nil, errors.New("an issuer, a JWKS file or a JWKS url is required")
+	}
+	for value, role := range options.RoleMapping {
+		if _, err := ParseRole(string(role)); err != nil {
+			return nil, fmt.Errorf("invalid mapping of '%s': %w", value, err)
+		}
+	}
+	v := &TokenVerifier{
+		options: options,
+		parser:  jwt.NewParser(jwt.WithValidMethods(signingMethods), jwt.WithoutClaimsValidation()),
+		keys:    map[string]publicKey{},
+	}
+	if err := v.Refresh(); err != nil {
+		if options.JwksFile != "" {
+			return nil, err
+		}
+		logging.Log.WithError(err).Warn("Could not have fetched the JWKS, retrying with the first token")
+	}
+	return v, nil
+}
+
+// Refresh loads the JWKS again, the current keys are kept if it fails
+func (v *TokenVerifier) Refresh() error {
+	v.fetching.Lock()
+	defer v.fetching.Unlock()
+	v.mutex.Lock()
+	v.fetched = time.Now()
+	v.mutex.Unlock()
+	data, err := v.loadJwks()
+	if err != nil {
+		return err
+	}
+	keys, err := parseJwks(data)
+	if err != nil {
+		return err
+	}
+	v.mutex.Lock()
+	v.keys = keys
+	v.mutex.Unlock()
+	return nil
+}
+
+func (v *TokenVerifier) loadJwks() ([]byte, error) {
+	if v.options.JwksFile != "" {
+		return ioutil.ReadFile(v.options.JwksFile)
+	}
+	jwksUrl := v.options.JwksUrl
+	if jwksUrl == "" {
+		discovery := struct {
+			JwksUri string `json:"jwks_uri"`
+		}{}
+		data, err := v.get(strings.TrimSuffix(v.options.Issuer, "/") + "/.well-known/openid-configuration")
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &discovery); err != nil || discovery.JwksUri == "" {
+			return nil, fmt.Errorf("the openid-configuration of %s has no jwks_uri", v.options.Issuer)
+		}
+		jwksUrl = discovery.JwksUri
+	}
+	return v.get(jwksUrl)
+}
+
+func (v *TokenVerifier) get(url string) ([]byte, error) {
+	resp, err := v.options.HttpClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// key returns the key a token names, it refreshes a stale JWKS of a url without waiting for it and one
+// missing the key right away
+func (v *TokenVerifier) key(kid string) (publicKey, bool) {
+	v.mutex.RLock()
+	key, ok := v.keys[kid]
+	age := time.Since(v.fetched)
+	v.mutex.RUnlock()
+	if v.options.JwksFile != "" {
+		return key, ok
+	}
+	if !ok && age >= minJwksFetchInterval {
+		if err := v.Refresh(); err != nil {
+			logging.Log.WithError(err).Warn("Could not have fetched the JWKS")
+		}
+		v.mutex.RLock()
+		key, ok = v.keys[kid]
+		v.mutex.RUnlock()
+	} else if age >= v.options.JwksRefresh {
+		go func() {
+			if err := v.Refresh(); err != nil {
+				logging.Log.WithError(err).Warn("Could not have refreshed the JWKS, keeping the current keys")
+			}
+		}()
+	}
+	return key, ok
+}
+
+func (v *TokenVerifier) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := v.key(kid)
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key '%s'", kid)
+	}
+	if key.alg != "" && key.alg != token.Method.Alg() {
+		return nil, fmt.Errorf("key '%s' is not for %s", kid, token.Method.Alg())
+	}
+	return key.key, nil
+}
+
+// Authenticate validates a token and maps its claims to a principal named after its subject. Tokens without
+// a known role are rejected as they would grant nothing
+func (v *TokenVerifier) Authenticate(token string) (*Principal, error) {
+	claims := jwt.MapClaims{}
+	if _, err := v.parser.ParseWithClaims(token, claims, v.keyFunc); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+	if err := v.validate(claims); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+	principal := &Principal{
+		Name:    fmt.Sprint(claims["sub"]),
+		Sensors: claimValues(claims, v.options.SensorsClaim),
+		Tags:    claimValues(claims, v.options.TagsClaim),
+	}
+	granted := map[Scope]bool{}
+	for _, value := range claimValues(claims, v.options.RolesClaim) {
+		role, ok := v.options.RoleMapping[value]
+		if !ok {
+			role = Role(value)
+		}
+		for _, scope := range roleScopes[role] {
+			if !granted[scope] {
+				granted[scope] = true
+				principal.Scopes = append(principal.Scopes, scope)
+			}
+		}
+	}
+	if len(principal.Scopes) == 0 {
+		return nil, fmt.Errorf("%w: the token grants no role", ErrForbidden)
+	}
+	if err := validSensorPatterns(principal.Sensors); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+	return principal, nil
+}
+
+// validate - exp is required, the provider decides how long tokens live
+func (v *TokenVerifier) validate(claims jwt.MapClaims) error {
+	now := time.Now()
+	leeway := v.options.Leeway
+	if !claims.VerifyExpiresAt(now.Add(-leeway).Unix(), true) {
+		return errors.New("the token is expired or has no expiry")
+	}
+	if !claims.VerifyNotBefore(now.Add(leeway).Unix(), false) || !claims.VerifyIssuedAt(now.Add(leeway).Unix(), false) {
+		return errors.New("the token is not valid yet")
+	}
+	if v.options.Issuer != "" && !claims.VerifyIssuer(v.options.Issuer, true) {
+		return errors.New("the token has another issuer")
+	}
+	if v.options.Audience != "" && !claims.VerifyAudience(v.options.Audience, true) {
+		return errors.New("the token is for another audience")
+	}
+	return nil
+}
+
+// claimValues - the strings of the claim at a dotted path, a string claim is split on spaces like 'scope'
+func claimValues(claims jwt.MapClaims, path string) []string {
+	var value interface{} = map[string]interface{}(claims)
+	for _, name := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[name]
+	}
+	switch value := value.(type) {
+	case string:
+		return strings.Fields(value)
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+package auth
+
+import (
+	"errors"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testIssuer = "https://id.example.com/realms/sensors"
+
+// newFileVerifier returns a verifier of the tokens of a new issuer, its JWKS written to a file
+func newFileVerifier(t *testing.T, options OidcOptions) (*TokenVerifier, *Issuer) {
+	t.Helper()
+	issuer, err := NewIssuer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks, err := issuer.Jwks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	options.JwksFile = filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(options.JwksFile, jwks, 0644); err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := NewTokenVerifier(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return verifier, issuer
+}
+
+func sign(t *testing.T, issuer *Issuer, claims map[string]interface{}, ttl time.Duration) string {
+	t.Helper()
+	token, err := issuer.Sign(claims, ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestAuthenticateToken(t *testing.T) {
+	verifier, issuer := newFileVerifier(t, OidcOptions{Issuer: testIssuer, Audience: "sensor-server", Leeway: 30 * time.Second,
+		RoleMapping: map[string]Role{"sensor-writers": RoleOperator}})
+	token := sign(t, issuer, map[string]interface{}{"iss": testIssuer, "aud": "sensor-server", "sub": "greenhouse-gateway",
+		"roles": []string{"sensor-writers", "unrelated"}, "sensors": []string{"greenhouse-*"}, "sensor_tags": "outdoor heated"}, time.Hour)
+	principal, err := verifier.Authenticate(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if principal.Name != "greenhouse-gateway" || !principal.HasScope(Ingest) || !principal.HasScope(Read) || principal.HasScope(Admin) {
+		t.Errorf("Authenticate = %+v", principal)
+	}
+	if len(principal.Sensors) != 1 || principal.Sensors[0] != "greenhouse-*" || len(principal.Tags) != 2 || principal.Tags[1] != "heated" {
+		t.Errorf("Authenticate = %+v", principal)
+	}
+
+	other, err := NewIssuer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := func(changes map[string]interface{}) map[string]interface{} {
+		claims := map[string]interface{}{"iss": testIssuer, "aud": "sensor-server", "sub": "ops", "roles": "admin"}
+		for name, value := range changes {
+			if value == nil {
+				delete(claims, name)
+			} else {
+				claims[name] = value
+			}
+		}
+		return claims
+	}
+	tests := map[string]string{
+		"another issuer":   sign(t, issuer, claims(map[string]interface{}{"iss": "https://evil.example.com"}), time.Hour),
+		"no issuer":        sign(t, issuer, claims(map[string]interface{}{"iss": nil}), time.Hour),
+		"another audience": sign(t, issuer, claims(map[string]interface{}{"aud": "grafana"}), time.Hour),
+		"expired":          sign(t, issuer, claims(nil), -time.Minute),
+		"not valid yet":    sign(t, issuer, claims(map[string]interface{}{"nbf": time.Now().Add(time.Hour).Unix()}), time.Hour),
+		"unknown key":      sign(t, other, claims(nil), time.Hour),
+		"malformed":        "eyJhbGciOiJFUzI1NiJ9.e30",
+		"bad sensors":      sign(t, issuer, claims(map[string]interface{}{"sensors": []string{"["}}), time.Hour),
+	}
+	for name, token := range tests {
+		if _, err := verifier.Authenticate(token); !errors.Is(err, ErrUnauthenticated) {
+			t.Errorf("%s: Authenticate = %v", name, err)
+		}
+	}
+	// within the leeway
+	if _, err := verifier.Authenticate(sign(t, issuer, claims(nil), -10*time.Second)); err != nil {
+		t.Errorf("a token expired within the leeway was rejected: %v", err)
+	}
+	// a valid token without a role grants nothing
+	if _, err := verifier.Authenticate(sign(t, issuer, claims(map[string]interface{}{"roles": []string{"guest"}}), time.Hour)); !errors.Is(err, ErrForbidden) {
+		t.Errorf("a token without a role: %v", err)
+	}
+	// symmetric algorithms are refused, the public JWKS would be the HMAC secret
+	hmac, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims(claims(map[string]interface{}{"exp": time.Now().Add(time.Hour).Unix()}))).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifier.Authenticate(hmac); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("an HS256 token: %v", err)
+	}
+}
+
+func TestTokenClaims(t *testing.T) {
+	verifier, issuer := newFileVerifier(t, OidcOptions{Issuer: testIssuer, RolesClaim: "realm_access.roles", SensorsClaim: "sensor_ids"})
+	token := sign(t, issuer, map[string]interface{}{"iss": testIssuer, "sub": "grafana",
+		"realm_access": map[string]interface{}{"roles": []string{"viewer"}}, "sensor_ids": "kitchen cellar"}, time.Hour)
+	principal, err := verifier.Authenticate(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !principal.HasScope(Read) || principal.HasScope(Ingest) || !principal.AllowsSensor("cellar") || principal.AllowsSensor("garden") {
+		t.Errorf("Authenticate = %+v", principal)
+	}
+	if _, err := NewTokenVerifier(OidcOptions{}); err == nil {
+		t.Error("a verifier without a JWKS was created")
+	}
+	if _, err := NewTokenVerifier(OidcOptions{JwksFile: filepath.Join(t.TempDir(), "missing.json")}); err == nil {
+		t.Error("a verifier of a missing JWKS file was created")
+	}
+	if _, err := NewTokenVerifier(OidcOptions{Issuer: testIssuer, JwksFile: "jwks.json", RoleMapping: map[string]Role{"root": "superuser"}}); err == nil {
+		t.Error("a mapping to an unknown role was accepted")
+	}
+}
+
+func TestDiscoveredJwks(t *testing.T) {
+	issuer, err := NewIssuer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var current atomic.Value
+	current.Store(issuer)
+	var fetches int32
+	var provider *httptest.Server
+	provider = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			_, _ = w.Write([]byte(`{"issuer":"` + provider.URL + `","jwks_uri":"` + provider.URL + `/certs"}`))
+		case "/certs":
+			atomic.AddInt32(&fetches, 1)
+			jwks, _ := current.Load().(*Issuer).Jwks()
+			_, _ = w.Write(jwks)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer provider.Close()
+	verifier, err := NewTokenVerifier(OidcOptions{Issuer: provider.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := map[string]interface{}{"iss": provider.URL, "sub": "ops", "roles": "admin"}
+	if _, err := verifier.Authenticate(sign(t, issuer, claims, time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the provider rotates its key, tokens of the new one fetch the JWKS again once the last fetch is old enough
+	rotated, err := NewIssuer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	current.Store(rotated)
+	token := sign(t, rotated, claims, time.Hour)
+	if _, err := verifier.Authenticate(token); err == nil {
+		t.Error("the JWKS was fetched again right after the last fetch")
+	}
+	verifier.mutex.Lock()
+	verifier.fetched = time.Now().Add(-minJwksFetchInterval)
+	verifier.mutex.Unlock()
+	if _, err := verifier.Authenticate(token); err != nil {
+		t.Errorf("a token of the rotated key was rejected: %v", err)
+	}
+	if fetches := atomic.LoadInt32(&fetches); fetches != 2 {
+		t.Errorf("the JWKS was fetched %d times, want 2", fetches)
+	}
+
+	// an unreachable provider keeps the current keys
+	provider.Close()
+	if err := verifier.Refresh(); err == nil {
+		t.Error("refreshing from a stopped provider succeeded")
+	}
+	if _, err := verifier.Authenticate(token); err != nil {
+		t.Errorf("the keys were dropped by a failed refresh: %v", err)
+	}
+}
+
+func TestIssuerKeyRoundTrip(t *testing.T) {
+	issuer, err := NewIssuer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := issuer.PrivateKeyPem()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseIssuer(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.kid != issuer.kid || !parsed.key.Equal(issuer.key) {
+		t.Error("the parsed issuer has another key")
+	}
+	if _, err := ParseIssuer([]byte("not a key")); err == nil {
+		t.Error("a file without a PEM block was parsed")
+	}
+}
+
+func TestAuthenticatorAcceptsKeysAndTokens(t *testing.T) {
+	verifier, issuer := newFileVerifier(t, OidcOptions{Issuer: testIssuer})
+	keys, err := OpenKeyStore(filepath.Join(t.TempDir(), "keys.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiKey, _, err := keys.Create("telegraf", []Scope{Ingest}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticator := NewAuthenticator(keys, verifier, SensorTags{"garden": {"outdoor"}})
+	idToken := sign(t, issuer, map[string]interface{}{"iss": testIssuer, "sub": "grafana", "roles": "viewer", "sensor_tags": []string{"outdoor"}}, time.Hour)
+	for token, name := range map[string]string{apiKey: "telegraf", idToken: "grafana"} {
+		principal, err := authenticator.authenticate(token)
+		if err != nil || principal.Name != name {
+			t.Errorf("authenticate = %+v, %v, want %s", principal, err, name)
+		}
+	}
+	if principal, _ := authenticator.authenticate(idToken); principal == nil || !principal.AllowsSensor("garden") || principal.AllowsSensor("kitchen") {
+		t.Errorf("the tags of the sensors were not applied to %+v", principal)
+	}
+	if _, err := NewAuthenticator(keys, nil, nil).authenticate(idToken); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("a JWT was accepted without a verifier: %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package broker
+
+import (
+	"errors"
+	"github.com/streadway/amqp"
+	"testing"
+	"time"
+)
+
+func TestPublishOrSpoolWhileDisconnected(t *testing.T) {
+	spool, _ := openSpool(t, 1)
+	client := NewClient(Options{}, spool)
+	msg := amqp.Publishing{ContentType: "application/json", Headers: amqp.Table{"x-request-id": "abc"}, Body: []byte("{}")}
+	if err := client.PublishOrSpool("", "queue", msg); err != nil {
+		t.Fatalf("a message was not spooled: %v", err)
+	}
+	if client.SpoolLen() != 1 {
+		t.Errorf("SpoolLen = %d, want 1", client.SpoolLen())
+	}
+	// neither published nor spooled
+	if err := client.PublishOrSpool("", "queue", msg); !errors.Is(err, ErrSpoolFull) {
+		t.Errorf("PublishOrSpool to a full spool returned %v", err)
+	}
+	if err := NewClient(Options{}, nil).PublishOrSpool("", "queue", msg); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("PublishOrSpool without a spool returned %v", err)
+	}
+}
+
+func TestDrainLoopRetriesUntilDisconnected(t *testing.T) {
+	spool, _ := openSpool(t, 0)
+	client := NewClient(Options{DrainInterval: 10 * time.Millisecond}, spool)
+	if err := spool.Append(SpoolEntry{Key: "queue", Body: []byte("{}")}); err != nil {
+		t.Fatal(err)
+	}
+	connected := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		client.drainLoop(connected)
+	}()
+	client.requestDrain()
+	time.Sleep(50 * time.Millisecond)
+	close(connected)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the drain loop did not stop with the connection")
+	}
+	// every attempt failed, the message is kept for the next connection
+	if spool.Len() != 1 {
+		t.Errorf("the spool holds %d messages, want 1", spool.Len())
+	}
+}
+
+func TestPublishResult(t *testing.T) {
+	for err, want := range map[error]string{
+		nil:               "confirmed",
+		ErrNacked:         "nacked",
+		ErrConfirmTimeout: "timeout",
+		ErrNotConnected:   "error",
+	} {
+		if got := publishResult(err); got != want {
+			t.Errorf("publishResult(%v) = %q, want %q", err, got, want)
+		}
+	}
+}
+
+func TestStopConsumingWaitsForHandlers(t *testing.T) {
+	client := NewClient(Options{}, nil)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handled := 0
+	cons := consumer{queue: "queue", handler: func(delivery amqp.Delivery) {
+		handled++
+		close(started)
+		<-release
+	}}
+	deliveries := make(chan amqp.Delivery, 2)
+	deliveries <- amqp.Delivery{Body: []byte("first")}
+	client.handlers.Add(1)
+	go client.handleDeliveries(cons, deliveries)
+	<-started
+
+	stopped := make(chan struct{})
+	go func() {
+		client.StopConsuming()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Fatal("StopConsuming returned while a delivery was handled")
+	case <-time.After(50 * time.Millisecond):
+	}
+	deliveries <- amqp.Delivery{Body: []byte("second")}
+	close(release)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("StopConsuming did not return after the handler")
+	}
+	if handled != 1 {
+		t.Errorf("handled %d deliveries, want the one in progress only", handled)
+	}
+	// stopping again returns right away
+	client.StopConsuming()
+}
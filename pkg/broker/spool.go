@@ -0,0 +1,217 @@
+package broker
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/andreikom/sensor-server/pkg/logging"
+	"github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const DefaultSpoolMaxMessages = 100000
+
+var ErrSpoolFull = errors.New("the spool is full")
+
+// SpoolEntry - a message waiting for the broker, stored as one JSON line
+type SpoolEntry struct {
+	Exchange    string `json:"exchange"`
+	Key         string `json:"key"`
+	ContentType string `json:"contentType,omitempty"`
+	// Headers - JSON keeps strings as they are, numbers are restored as int64 if they are integers and as
+	// float64 otherwise, see restoreHeader
+	Headers amqp.Table `json:"headers,omitempty"`
+	Body    []byte     `json:"body"`
+}
+
+// Spool - a bounded append-only file of messages accepted while the broker was unavailable
+type Spool struct {
+	path        string
+	maxMessages int
+	mutex       sync.Mutex
+	count       int
+	// drainMutex - only one drain runs at a time
+	drainMutex sync.Mutex
+}
+
+// OpenSpool opens or creates the spool file, messages left by a previous run are kept
+func OpenSpool(path string, maxMessages int) (*Spool, error) {
+	if maxMessages <= 0 {
+		maxMessages = DefaultSpoolMaxMessages
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+	spool := &Spool{path: path, maxMessages: maxMessages}
+	entries, err := spool.read()
+	if err != nil {
+		return nil, err
+	}
+	spool.count = len(entries)
+	if spool.count > 0 {
+		logging.Log.WithFields(logrus.Fields{"count": spool.count, "path": path}).Info("Found spooled messages")
+	}
+	return spool, nil
+}
+
+// Len returns the number of spooled messages
+func (s *Spool) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.count
+}
+
+// Append stores a message on disk, fails with ErrSpoolFull once maxMessages are spooled
+func (s *Spool) Append(entry SpoolEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.count >= s.maxMessages {
+		return ErrSpoolFull
+	}
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	s.count++
+	return nil
+}
+
+// Drain publishes the spooled messages in order and removes the published ones, it stops at the first
+// message publish fails for. Messages appended meanwhile stay for the next drain
+func (s *Spool) Drain(publish func(SpoolEntry) error) (int, error) {
+	s.drainMutex.Lock()
+	defer s.drainMutex.Unlock()
+	s.mutex.Lock()
+	entries, err := s.read()
+	s.mutex.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	published := 0
+	var publishErr error
+	for _, entry := range entries {
+		if publishErr = publish(entry); publishErr != nil {
+			break
+		}
+		published++
+	}
+	if published > 0 {
+		if err := s.truncate(published); err != nil {
+			return published, err
+		}
+	}
+	return published, publishErr
+}
+
+// truncate drops the first n messages, the rest are rewritten through a temporary file
+func (s *Spool) truncate(n int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entries, err := s.read()
+	if err != nil {
+		return err
+	}
+	if n > len(entries) {
+		n = len(entries)
+	}
+	remaining := entries[n:]
+	tmpPath := s.path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	for _, entry := range remaining {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			file.Close()
+			return err
+		}
+		_, _ = writer.Write(append(line, '\n'))
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return err
+	}
+	s.count = len(remaining)
+	return nil
+}
+
+// read returns all spooled messages, a line cut short by a crash is skipped. The caller must hold the mutex
+func (s *Spool) read() ([]SpoolEntry, error) {
+	file, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	entries := make([]SpoolEntry, 0)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry SpoolEntry
+		decoder := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+		decoder.UseNumber()
+		if err := decoder.Decode(&entry); err != nil {
+			logging.Log.WithError(err).WithField("path", s.path).Warn("Skipping a corrupt spool entry")
+			continue
+		}
+		for key, value := range entry.Headers {
+			entry.Headers[key] = restoreHeader(value)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// restoreHeader converts a header value decoded from JSON back to a type amqp.Table accepts. Numbers are
+// decoded as json.Number, integers like the unix nanoseconds of a publish do not fit into a float64
+func restoreHeader(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		table := make(amqp.Table, len(v))
+		for key, nested := range v {
+			table[key] = restoreHeader(nested)
+		}
+		return table
+	case []interface{}:
+		for i, nested := range v {
+			v[i] = restoreHeader(nested)
+		}
+		return v
+	}
+	return value
+}
@@ -0,0 +1,148 @@
+package broker
+
+import (
+	"errors"
+	"github.com/streadway/amqp"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openSpool(t *testing.T, maxMessages int) (*Spool, string) {
+	path := filepath.Join(t.TempDir(), "spool", "messages.jsonl")
+	spool, err := OpenSpool(path, maxMessages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return spool, path
+}
+
+func TestSpoolKeepsMessagesAcrossRestarts(t *testing.T) {
+	spool, path := openSpool(t, 0)
+	for _, body := range []string{"first", "second"} {
+		if err := spool.Append(SpoolEntry{Key: "queue", Body: []byte(body)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reopened, err := OpenSpool(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.Len() != 2 {
+		t.Errorf("a reopened spool holds %d messages, want 2", reopened.Len())
+	}
+}
+
+func TestSpoolDrainStopsAtTheFirstFailure(t *testing.T) {
+	spool, _ := openSpool(t, 0)
+	for _, body := range []string{"1", "2", "3"} {
+		if err := spool.Append(SpoolEntry{Key: "queue", Body: []byte(body)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	published := make([]string, 0)
+	drained, err := spool.Drain(func(entry SpoolEntry) error {
+		if string(entry.Body) == "2" {
+			return ErrNotConnected
+		}
+		published = append(published, string(entry.Body))
+		return nil
+	})
+	if drained != 1 || !errors.Is(err, ErrNotConnected) || spool.Len() != 2 {
+		t.Fatalf("Drain = %d, %v leaving %d messages, want 1 published and 2 left", drained, err, spool.Len())
+	}
+	// the next drain retries the remaining messages in order
+	drained, err = spool.Drain(func(entry SpoolEntry) error {
+		published = append(published, string(entry.Body))
+		return nil
+	})
+	if drained != 2 || err != nil || spool.Len() != 0 {
+		t.Fatalf("Drain = %d, %v leaving %d messages, want all published", drained, err, spool.Len())
+	}
+	if len(published) != 3 || published[0] != "1" || published[1] != "2" || published[2] != "3" {
+		t.Errorf("published %v, want [1 2 3]", published)
+	}
+}
+
+func TestSpoolIsBounded(t *testing.T) {
+	spool, _ := openSpool(t, 1)
+	if err := spool.Append(SpoolEntry{Key: "queue"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := spool.Append(SpoolEntry{Key: "queue"}); !errors.Is(err, ErrSpoolFull) {
+		t.Errorf("Append to a full spool returned %v", err)
+	}
+}
+
+func TestSpoolRestoresHeaderTypes(t *testing.T) {
+	spool, _ := openSpool(t, 0)
+	publishedAt := time.Date(2022, time.March, 14, 9, 0, 0, 123456789, time.UTC).UnixNano()
+	headers := amqp.Table{
+		"x-published-at": publishedAt,
+		"x-retry-count":  int32(2),
+		"x-ratio":        0.5,
+		"x-request-id":   "abc",
+		"x-nested":       amqp.Table{"count": int64(1)},
+		"x-list":         []interface{}{int64(1), "a"},
+	}
+	if err := spool.Append(SpoolEntry{Key: "queue", Headers: headers}); err != nil {
+		t.Fatal(err)
+	}
+	var restored amqp.Table
+	if _, err := spool.Drain(func(entry SpoolEntry) error {
+		restored = entry.Headers
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := restored["x-published-at"].(int64); !ok || got != publishedAt {
+		t.Errorf("x-published-at = %v (%T), want %d", restored["x-published-at"], restored["x-published-at"], publishedAt)
+	}
+	if got, ok := restored["x-retry-count"].(int64); !ok || got != 2 {
+		t.Errorf("x-retry-count = %v (%T), want 2", restored["x-retry-count"], restored["x-retry-count"])
+	}
+	if got, ok := restored["x-ratio"].(float64); !ok || got != 0.5 {
+		t.Errorf("x-ratio = %v (%T), want 0.5", restored["x-ratio"], restored["x-ratio"])
+	}
+	if got, ok := restored["x-request-id"].(string); !ok || got != "abc" {
+		t.Errorf("x-request-id = %v (%T), want abc", restored["x-request-id"], restored["x-request-id"])
+	}
+	if nested, ok := restored["x-nested"].(amqp.Table); !ok || nested["count"] != int64(1) {
+		t.Errorf("x-nested = %v (%T)", restored["x-nested"], restored["x-nested"])
+	}
+	if list, ok := restored["x-list"].([]interface{}); !ok || list[0] != int64(1) || list[1] != "a" {
+		t.Errorf("x-list = %v (%T)", restored["x-list"], restored["x-list"])
+	}
+	if err := restored.Validate(); err != nil {
+		t.Errorf("restored headers are not a valid table: %v", err)
+	}
+}
+
+func TestSpoolSkipsCorruptLines(t *testing.T) {
+	spool, path := openSpool(t, 0)
+	if err := spool.Append(SpoolEntry{Key: "queue", Body: []byte("kept")}); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a line cut short by a crash
+	_, _ = file.WriteString(`{"key":"queue","bo`)
+	file.Close()
+	reopened, err := OpenSpool(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.Len() != 1 {
+		t.Errorf("a spool with a corrupt line holds %d messages, want 1", reopened.Len())
+	}
+	if _, err := reopened.Drain(func(entry SpoolEntry) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(path); len(data) != 0 {
+		t.Errorf("the drained spool still holds %q", data)
+	}
+}
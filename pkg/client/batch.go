@@ -0,0 +1,143 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBatchSize     = 1000
+	defaultBatchInterval = time.Second
+)
+
+// Saver - a Client or a GrpcClient
+type Saver interface {
+	SaveBatch(ctx context.Context, readings []Reading) error
+}
+
+// BatchOptions - when a Batcher sends the readings it collected
+type BatchOptions struct {
+	// Size - readings sending a batch right away, defaultBatchSize if empty
+	Size int
+	// Interval - the longest a reading waits to be sent, defaultBatchInterval if empty
+	Interval time.Duration
+	// OnError is called with the readings of a batch which could not be sent, they are dropped otherwise
+	OnError func(err error, readings []Reading)
+}
+
+// Batcher - collects readings and sends them in batches from a single goroutine, in the order they were added
+type Batcher struct {
+	saver   Saver
+	options BatchOptions
+	mutex   sync.Mutex
+	pending []Reading
+	full    chan struct{}
+	quit    chan struct{}
+	done    chan struct{}
+}
+
+func NewBatcher(saver Saver, options BatchOptions) *Batcher {
+	if options.Size <= 0 {
+		options.Size = defaultBatchSize
+	}
+	if options.Interval <= 0 {
+		options.Interval = defaultBatchInterval
+	}
+	b := &Batcher{
+		saver:   saver,
+		options: options,
+		pending: make([]Reading, 0, options.Size),
+		full:    make(chan struct{}, 1),
+		quit:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// Add queues a reading, it does not wait for the batch to be sent
+func (b *Batcher) Add(reading Reading) {
+	b.mutex.Lock()
+	b.pending = append(b.pending, reading)
+	full := len(b.pending) >= b.options.Size
+	b.mutex.Unlock()
+	if full {
+		select {
+		case b.full <- struct{}{}:
+		default: // a flush is already due
+		}
+	}
+}
+
+// Close sends the remaining readings within ctx, the Batcher cannot be used afterwards
+func (b *Batcher) Close(ctx context.Context) error {
+	close(b.quit)
+	<-b.done
+	return b.send(ctx)
+}
+
+func (b *Batcher) run() {
+	defer close(b.done)
+	ticker := time.NewTicker(b.options.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-b.full:
+		case <-b.quit:
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), b.options.Interval*10)
+		_ = b.send(ctx)
+		cancel()
+	}
+}
+
+func (b *Batcher) send(ctx context.Context) error {
+	b.mutex.Lock()
+	batch := b.pending
+	b.pending = make([]Reading, 0, b.options.Size)
+	b.mutex.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+	err := b.saver.SaveBatch(ctx, batch)
+	if err != nil && b.options.OnError != nil {
+		b.options.OnError(err, batch)
+	}
+	return err
+}
+
+// Stream sends the readings received from readings in batches until it is closed, then returns the last
+// failure to send a batch, if any. Cancelling ctx stops it without sending the pending readings
+func Stream(ctx context.Context, saver Saver, readings <-chan Reading, options BatchOptions) error {
+	var mutex sync.Mutex
+	var lastErr error
+	onError := options.OnError
+	options.OnError = func(err error, batch []Reading) {
+		mutex.Lock()
+		lastErr = err
+		mutex.Unlock()
+		if onError != nil {
+			onError(err, batch)
+		}
+	}
+	batcher := NewBatcher(saver, options)
+	for {
+		select {
+		case reading, ok := <-readings:
+			if !ok {
+				_ = batcher.Close(ctx)
+				mutex.Lock()
+				defer mutex.Unlock()
+				return lastErr
+			}
+			batcher.Add(reading)
+		case <-ctx.Done():
+			close(batcher.quit)
+			<-batcher.done
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingSaver - a Saver keeping the batches it is sent, failing while err is set
+type recordingSaver struct {
+	mutex   sync.Mutex
+	batches [][]Reading
+	err     error
+	sent    chan struct{}
+}
+
+func newRecordingSaver() *recordingSaver {
+	return &recordingSaver{sent: make(chan struct{}, 100)}
+}
+
+func (s *recordingSaver) SaveBatch(ctx context.Context, readings []Reading) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sent <- struct{}{}
+	if s.err != nil {
+		return s.err
+	}
+	s.batches = append(s.batches, readings)
+	return nil
+}
+
+func (s *recordingSaver) sizes() []int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	sizes := make([]int, 0, len(s.batches))
+	for _, batch := range s.batches {
+		sizes = append(sizes, len(batch))
+	}
+	return sizes
+}
+
+func (s *recordingSaver) waitForBatch(t *testing.T) {
+	t.Helper()
+	select {
+	case <-s.sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no batch was sent")
+	}
+}
+
+func reading(value float64) Reading {
+	return Reading{SensorId: "kitchen", Metric: "temperature", Value: value}
+}
+
+func TestBatcherSendsFullBatches(t *testing.T) {
+	saver := newRecordingSaver()
+	batcher := NewBatcher(saver, BatchOptions{Size: 3, Interval: time.Hour})
+	for i := 0; i < 7; i++ {
+		batcher.Add(reading(float64(i)))
+		if i == 2 {
+			saver.waitForBatch(t)
+		}
+	}
+	saver.waitForBatch(t)
+	if err := batcher.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	sizes := saver.sizes()
+	total := 0
+	for _, size := range sizes {
+		total += size
+	}
+	if sizes[0] != 3 || total != 7 {
+		t.Errorf("sent batches of %v readings", sizes)
+	}
+	// the readings are sent in the order they were added
+	value := 0.0
+	for _, batch := range saver.batches {
+		for _, reading := range batch {
+			if reading.Value != value {
+				t.Fatalf("sent %v after %v", reading.Value, value-1)
+			}
+			value++
+		}
+	}
+}
+
+func TestBatcherSendsAfterTheInterval(t *testing.T) {
+	saver := newRecordingSaver()
+	batcher := NewBatcher(saver, BatchOptions{Size: 100, Interval: 20 * time.Millisecond})
+	defer batcher.Close(context.Background())
+	batcher.Add(reading(1))
+	batcher.Add(reading(2))
+	saver.waitForBatch(t)
+	if sizes := saver.sizes(); len(sizes) != 1 || sizes[0] != 2 {
+		t.Errorf("sent batches of %v readings", sizes)
+	}
+}
+
+func TestBatcherReportsFailedBatches(t *testing.T) {
+	saver := newRecordingSaver()
+	saver.err = errors.New("503 Service Unavailable")
+	var failed []Reading
+	batcher := NewBatcher(saver, BatchOptions{Size: 2, Interval: time.Hour, OnError: func(err error, readings []Reading) {
+		failed = append(failed, readings...)
+	}})
+	batcher.Add(reading(1))
+	batcher.Add(reading(2))
+	saver.waitForBatch(t)
+	batcher.Add(reading(3))
+	if err := batcher.Close(context.Background()); err != saver.err {
+		t.Errorf("Close returned %v", err)
+	}
+	if len(failed) != 3 || failed[2].Value != 3 {
+		t.Errorf("OnError was called with %+v", failed)
+	}
+	// nothing is left to send
+	if err := NewBatcher(saver, BatchOptions{}).Close(context.Background()); err != nil {
+		t.Errorf("Close of an empty batcher returned %v", err)
+	}
+}
+
+func TestStream(t *testing.T) {
+	saver := newRecordingSaver()
+	readings := make(chan Reading)
+	done := make(chan error)
+	go func() {
+		done <- Stream(context.Background(), saver, readings, BatchOptions{Size: 2, Interval: time.Hour})
+	}()
+	for i := 0; i < 5; i++ {
+		readings <- reading(float64(i))
+	}
+	close(readings)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	total := 0
+	for _, size := range saver.sizes() {
+		total += size
+	}
+	if total != 5 {
+		t.Errorf("sent batches of %v readings", saver.sizes())
+	}
+}
+
+func TestStreamReturnsTheLastFailure(t *testing.T) {
+	saver := newRecordingSaver()
+	saver.err = errors.New("503 Service Unavailable")
+	readings := make(chan Reading, 3)
+	readings <- reading(1)
+	close(readings)
+	failures := 0
+	err := Stream(context.Background(), saver, readings, BatchOptions{OnError: func(error, []Reading) { failures++ }})
+	if err != saver.err || failures != 1 {
+		t.Errorf("Stream returned %v after %d failures", err, failures)
+	}
+}
+
+func TestStreamStopsOnCancel(t *testing.T) {
+	saver := newRecordingSaver()
+	readings := make(chan Reading)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- Stream(ctx, saver, readings, BatchOptions{Size: 10, Interval: time.Hour}) }()
+	readings <- reading(1)
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Stream returned %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stream did not stop")
+	}
+	// the pending reading is not sent
+	if sizes := saver.sizes(); len(sizes) != 0 {
+		t.Errorf("sent batches of %v readings", sizes)
+	}
+}
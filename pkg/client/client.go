@@ -0,0 +1,369 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTimeout    = 10 * time.Second
+	defaultMaxRetries = 3
+	defaultMinBackoff = 100 * time.Millisecond
+	defaultMaxBackoff = 5 * time.Second
+	defaultSensorTag  = "sensorId"
+	// maxBatchLines - readings sent in a single write request, the server accepts up to 16 MiB per batch
+	maxBatchLines = 5000
+	dateLayout    = "2006-01-02"
+)
+
+type Period string
+
+const (
+	Daily   Period = "daily"
+	Weekly  Period = "weekly"
+	Monthly Period = "monthly"
+)
+
+type Stat string
+
+const (
+	Min Stat = "min"
+	Max Stat = "max"
+	Avg Stat = "avg"
+)
+
+// Options - the connection settings of a Client or a GrpcClient
+type Options struct {
+	// Address - the url of the HTTP API, e.g. http://localhost:8080
+	Address string
+	// GrpcAddress - host:port of the gRPC API, e.g. localhost:9000
+	GrpcAddress string
+	// TLS - used by https addresses and, if set, by gRPC, which is plaintext otherwise
+	TLS *tls.Config
+	// Token - sent as a bearer token with every call, an API key or a JWT
+	Token string
+	// Timeout - of a single attempt of a call, defaultTimeout if empty
+	Timeout time.Duration
+	// MaxRetries - attempts after the first one of calls the server was unavailable for, defaultMaxRetries if
+	// empty, none if negative
+	MaxRetries int
+	// MinBackoff and MaxBackoff bound the exponential delay between attempts
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// SensorTag - the line protocol tag the server reads the sensor ids of batches from, sensorId if empty
+	SensorTag string
+	// HttpClient - used instead of a client built from TLS, e.g. to add a transport
+	HttpClient *http.Client
+}
+
+func (o Options) withDefaults() Options {
+	if o.Timeout <= 0 {
+		o.Timeout = defaultTimeout
+	}
+	if o.MaxRetries == 0 {
+		o.MaxRetries = defaultMaxRetries
+	}
+	if o.MaxRetries < 0 {
+		o.MaxRetries = 0
+	}
+	if o.MinBackoff <= 0 {
+		o.MinBackoff = defaultMinBackoff
+	}
+	if o.MaxBackoff < o.MinBackoff {
+		o.MaxBackoff = defaultMaxBackoff
+	}
+	if o.SensorTag == "" {
+		o.SensorTag = defaultSensorTag
+	}
+	return o
+}
+
+// backoff - the delay before the given retry, with jitter so clients do not retry in lockstep
+func (o Options) backoff(retry int) time.Duration {
+	delay := o.MinBackoff << uint(retry)
+	if delay > o.MaxBackoff || delay <= 0 {
+		delay = o.MaxBackoff
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// Reading - a reading of a sensor, Unit is the store unit of the metric if empty and Time the time it is
+// received at if zero
+type Reading struct {
+	SensorId string
+	Metric   string
+	Value    float64
+	Unit     string
+	Time     time.Time
+}
+
+// StatQuery - a statistic of a sensor over the day, week or month containing Date
+type StatQuery struct {
+	SensorId string
+	Metric   string
+	Period   Period
+	Stat     Stat
+	// Date - YYYY-MM-DD, today in Timezone if empty
+	Date string
+	// Unit - of the result, the store unit of the metric if empty
+	Unit string
+	// Timezone - the IANA timezone of the day boundaries, the one of the sensor if empty
+	Timezone string
+}
+
+// ErrInvalidReading - a reading the server would reject or which cannot be written as a line, it is not sent
+var ErrInvalidReading = errors.New("invalid reading")
+
+// validate - the sensor id and metric end up in line protocol tags, which cannot hold line breaks
+func (r Reading) validate() error {
+	switch {
+	case strings.TrimSpace(r.SensorId) == "":
+		return fmt.Errorf("%w: the sensor id is missing", ErrInvalidReading)
+	case strings.TrimSpace(r.Metric) == "":
+		return fmt.Errorf("%w: the metric of sensor %s is missing", ErrInvalidReading, r.SensorId)
+	case strings.ContainsAny(r.SensorId+r.Metric+r.Unit, "\r\n"):
+		return fmt.Errorf("%w: line breaks in the sensor id, metric or unit of sensor %q", ErrInvalidReading, r.SensorId)
+	case math.IsNaN(r.Value) || math.IsInf(r.Value, 0):
+		return fmt.Errorf("%w: the value %v of sensor %s", ErrInvalidReading, r.Value, r.SensorId)
+	}
+	return nil
+}
+
+// validateAll - a batch with an invalid reading is not sent at all
+func validateAll(readings []Reading) error {
+	for i, reading := range readings {
+		if err := reading.validate(); err != nil {
+			return fmt.Errorf("reading %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// Error - a call the server answered with an error status
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Client - a client of the HTTP API, safe for concurrent use
+type Client struct {
+	options Options
+	baseUrl *url.URL
+	http    *http.Client
+}
+
+func New(options Options) (*Client, error) {
+	options = options.withDefaults()
+	baseUrl, err := url.Parse(strings.TrimSuffix(options.Address, "/"))
+	if err != nil || baseUrl.Host == "" {
+		return nil, fmt.Errorf("invalid address '%s', expected e.g. http://localhost:8080", options.Address)
+	}
+	httpClient := options.HttpClient
+	if httpClient == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = options.TLS
+		httpClient = &http.Client{Transport: transport}
+	}
+	return &Client{options: options, baseUrl: baseUrl, http: httpClient}, nil
+}
+
+// Save sends a reading, readings with a Time are sent as a batch of one
+func (c *Client) Save(ctx context.Context, reading Reading) error {
+	if err := reading.validate(); err != nil {
+		return err
+	}
+	if !reading.Time.IsZero() {
+		return c.SaveBatch(ctx, []Reading{reading})
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"sensorId": reading.SensorId,
+		"metric":   reading.Metric,
+		"value":    reading.Value,
+		"unit":     reading.Unit,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = c.do(ctx, http.MethodPost, "/metric/", nil, "application/json", body)
+	return err
+}
+
+// SaveBatch sends readings through the line protocol write API, in requests of up to maxBatchLines readings.
+// The server rejects a request as a whole, the requests before a failed one are stored. Nothing is sent if
+// a reading is invalid
+func (c *Client) SaveBatch(ctx context.Context, readings []Reading) error {
+	if err := validateAll(readings); err != nil {
+		return err
+	}
+	for start := 0; start < len(readings); start += maxBatchLines {
+		end := start + maxBatchLines
+		if end > len(readings) {
+			end = len(readings)
+		}
+		body := lineProtocol(readings[start:end], c.options.SensorTag)
+		query := url.Values{"precision": []string{"ns"}}
+		if _, err := c.do(ctx, http.MethodPost, "/api/v2/write", query, "text/plain; charset=utf-8", body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stat computes a statistic, the result is in the unit of the query
+func (c *Client) Stat(ctx context.Context, query StatQuery) (float64, error) {
+	date := query.Date
+	if date == "" {
+		loc, err := time.LoadLocation(query.Timezone)
+		if err != nil {
+			return 0, err
+		}
+		date = time.Now().In(loc).Format(dateLayout)
+	}
+	path := fmt.Sprintf("/metric/%s/%s_%s/%s/%s", url.PathEscape(query.Metric), query.Period, query.Stat,
+		url.PathEscape(query.SensorId), url.PathEscape(date))
+	params := url.Values{}
+	if query.Unit != "" {
+		params.Set("unit", query.Unit)
+	}
+	if query.Timezone != "" {
+		params.Set("tz", query.Timezone)
+	}
+	body, err := c.do(ctx, http.MethodGet, path, params, "", nil)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(strings.TrimSpace(string(body)), 64)
+}
+
+// Metrics returns the names of the metrics the server stores
+func (c *Client) Metrics(ctx context.Context) ([]string, error) {
+	body, err := c.do(ctx, http.MethodGet, "/metric/", nil, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0)
+	return names, json.Unmarshal(body, &names)
+}
+
+// Ready returns nil if the server is ready to serve, an *Error listing the failing dependencies otherwise.
+// It is not retried, a server which is not ready answers 503
+func (c *Client) Ready(ctx context.Context) error {
+	_, err := c.attempt(ctx, http.MethodGet, c.endpoint("/readyz", nil), "", nil)
+	return err
+}
+
+// endpoint - the segments of path are escaped already
+func (c *Client) endpoint(path string, query url.Values) string {
+	endpoint := c.baseUrl.String() + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+	return endpoint
+}
+
+// do sends a request, retrying while the server is unreachable or answers 429 or 503
+func (c *Client) do(ctx context.Context, method string, path string, query url.Values, contentType string, body []byte) ([]byte, error) {
+	endpoint := c.endpoint(path, query)
+	for attempt := 0; ; attempt++ {
+		respBody, err := c.attempt(ctx, method, endpoint, contentType, body)
+		if err == nil || attempt >= c.options.MaxRetries || !retryable(ctx, err) {
+			return respBody, err
+		}
+		select {
+		case <-time.After(c.options.backoff(attempt)):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (c *Client) attempt(ctx context.Context, method string, endpoint string, contentType string, body []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.options.Timeout)
+	defer cancel()
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.options.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.options.Token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(respBody))}
+	}
+	return respBody, nil
+}
+
+// retryable - readings are not deduplicated, only failures where the server did not process the call are retried
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var statusErr *Error
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode == http.StatusServiceUnavailable
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// lineProtocol - a line per reading, "<metric>,<sensorTag>=<sensorId>[,unit=<unit>] value=<value> [<ns>]"
+func lineProtocol(readings []Reading, sensorTag string) []byte {
+	var buffer bytes.Buffer
+	for _, reading := range readings {
+		buffer.WriteString(measurementEscaper.Replace(reading.Metric))
+		buffer.WriteByte(',')
+		buffer.WriteString(tagEscaper.Replace(sensorTag))
+		buffer.WriteByte('=')
+		buffer.WriteString(tagEscaper.Replace(reading.SensorId))
+		if reading.Unit != "" {
+			buffer.WriteString(",unit=")
+			buffer.WriteString(tagEscaper.Replace(reading.Unit))
+		}
+		buffer.WriteString(" value=")
+		buffer.WriteString(strconv.FormatFloat(reading.Value, 'f', -1, 64))
+		if !reading.Time.IsZero() {
+			buffer.WriteByte(' ')
+			buffer.WriteString(strconv.FormatInt(reading.Time.UnixNano(), 10))
+		}
+		buffer.WriteByte('\n')
+	}
+	return buffer.Bytes()
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	tagEscaper         = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+)
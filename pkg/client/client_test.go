@@ -0,0 +1,222 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestClient answers every request with handler, retries are not delayed
+func newTestClient(t *testing.T, handler http.HandlerFunc, options Options) *Client {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	options.Address = server.URL
+	options.MinBackoff = time.Millisecond
+	options.MaxBackoff = time.Millisecond
+	client, err := New(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestNewRejectsInvalidAddresses(t *testing.T) {
+	for _, address := range []string{"", "localhost:8080", "http://"} {
+		if _, err := New(Options{Address: address}); err == nil {
+			t.Errorf("New accepted the address %q", address)
+		}
+	}
+}
+
+func TestLineProtocol(t *testing.T) {
+	at := time.Unix(1760000000, 5)
+	readings := []Reading{
+		{SensorId: "kitchen", Metric: "temperature", Value: 21.5, Unit: "C", Time: at},
+		{SensorId: "living room,east=1", Metric: "air quality", Value: -0.25},
+	}
+	want := "temperature,sensorId=kitchen,unit=C value=21.5 1760000000000000005\n" +
+		`air\ quality,sensor_id=living\ room\,east\=1 value=-0.25` + "\n"
+	got := string(lineProtocol(readings[:1], "sensorId")) + string(lineProtocol(readings[1:], "sensor_id"))
+	if got != want {
+		t.Errorf("lineProtocol =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestInvalidReadingsAreNotSent(t *testing.T) {
+	var requests int32
+	client := newTestClient(t, func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+	}, Options{})
+	valid := Reading{SensorId: "kitchen", Metric: "temperature", Value: 20}
+	invalid := []Reading{
+		{Metric: "temperature", Value: 20},
+		{SensorId: "  ", Metric: "temperature", Value: 20},
+		{SensorId: "kitchen", Value: 20},
+		{SensorId: "kitchen", Metric: "temperature", Value: math.NaN()},
+		{SensorId: "kitchen", Metric: "temperature", Value: math.Inf(-1)},
+		{SensorId: "kitchen\ntemperature,sensorId=attic", Metric: "temperature", Value: 20},
+		{SensorId: "kitchen", Metric: "temperature", Value: 20, Unit: "C\n"},
+	}
+	for _, reading := range invalid {
+		if err := client.Save(context.Background(), reading); !errors.Is(err, ErrInvalidReading) {
+			t.Errorf("Save(%+v) returned %v", reading, err)
+		}
+		err := client.SaveBatch(context.Background(), []Reading{valid, reading})
+		if !errors.Is(err, ErrInvalidReading) || !strings.HasPrefix(err.Error(), "reading 2: ") {
+			t.Errorf("SaveBatch with %+v returned %v", reading, err)
+		}
+	}
+	if requests != 0 {
+		t.Errorf("%d requests were sent", requests)
+	}
+}
+
+func TestSave(t *testing.T) {
+	requests := make(chan string, 2)
+	client := newTestClient(t, func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		requests <- fmt.Sprintf("%s %s %s %s %s", req.Method, req.URL, req.Header.Get("Content-Type"), req.Header.Get("Authorization"), body)
+	}, Options{Token: "key", SensorTag: "sensor"})
+	if err := client.Save(context.Background(), Reading{SensorId: "kitchen", Metric: "temperature", Value: 21.5}); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-requests; got != `POST /metric/ application/json Bearer key {"metric":"temperature","sensorId":"kitchen","unit":"","value":21.5}` {
+		t.Errorf("sent %s", got)
+	}
+	// a reading with a time is written as a line
+	if err := client.Save(context.Background(), Reading{SensorId: "kitchen", Metric: "temperature", Value: 21.5, Time: time.Unix(1, 0)}); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-requests; got != "POST /api/v2/write?precision=ns text/plain; charset=utf-8 Bearer key temperature,sensor=kitchen value=21.5 1000000000\n" {
+		t.Errorf("sent %s", got)
+	}
+}
+
+func TestSaveBatchIsSplit(t *testing.T) {
+	lines := make(chan int, 3)
+	client := newTestClient(t, func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		lines <- strings.Count(string(body), "\n")
+	}, Options{})
+	readings := make([]Reading, maxBatchLines*2+1)
+	for i := range readings {
+		readings[i] = Reading{SensorId: "kitchen", Metric: "temperature", Value: float64(i), Time: time.Unix(int64(i), 0)}
+	}
+	if err := client.SaveBatch(context.Background(), readings); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(<-lines, <-lines, <-lines); got != fmt.Sprint(maxBatchLines, maxBatchLines, 1) {
+		t.Errorf("sent batches of %s lines", got)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	var attempts int32
+	statuses := []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK}
+	client := newTestClient(t, func(w http.ResponseWriter, req *http.Request) {
+		attempt := atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(statuses[int(attempt-1)%len(statuses)])
+	}, Options{})
+	if err := client.Save(context.Background(), Reading{SensorId: "kitchen", Metric: "temperature", Value: 20}); err != nil || attempts != 3 {
+		t.Errorf("Save returned %v after %d attempts", err, attempts)
+	}
+
+	// readings are not deduplicated, a request the server processed is not sent again
+	atomic.StoreInt32(&attempts, 0)
+	client = newTestClient(t, func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		http.Error(w, "unknown metric", http.StatusBadRequest)
+	}, Options{})
+	err := client.Save(context.Background(), Reading{SensorId: "kitchen", Metric: "radiation", Value: 20})
+	statusErr := &Error{}
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusBadRequest || err.Error() != "400 Bad Request: unknown metric" || attempts != 1 {
+		t.Errorf("Save returned %v after %d attempts", err, attempts)
+	}
+
+	// an unavailable server is given up on after MaxRetries
+	atomic.StoreInt32(&attempts, 0)
+	client = newTestClient(t, func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}, Options{MaxRetries: 2})
+	if err := client.Save(context.Background(), Reading{SensorId: "kitchen", Metric: "temperature", Value: 20}); err == nil || attempts != 3 {
+		t.Errorf("Save returned %v after %d attempts", err, attempts)
+	}
+	atomic.StoreInt32(&attempts, 0)
+	if err := client.Ready(context.Background()); err == nil || attempts != 1 {
+		t.Errorf("Ready returned %v after %d attempts", err, attempts)
+	}
+}
+
+func TestUnreachableServersAreRetried(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	address := server.URL
+	server.Close()
+	client, err := New(Options{Address: address, MaxRetries: 2, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err := client.Metrics(context.Background()); err == nil {
+		t.Error("a closed server answered")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := client.Metrics(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("a cancelled call returned %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("the calls took %s", elapsed)
+	}
+}
+
+func TestStatAndMetrics(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/metric/":
+			fmt.Fprint(w, `["humidity","temperature"]`)
+		default:
+			fmt.Fprintf(w, "%s?%s\n", req.URL.EscapedPath(), req.URL.RawQuery)
+		}
+	}, Options{})
+	names, err := client.Metrics(context.Background())
+	if err != nil || fmt.Sprint(names) != "[humidity temperature]" {
+		t.Errorf("Metrics = %v, %v", names, err)
+	}
+	// the answer is not a number
+	_, err = client.Stat(context.Background(), StatQuery{SensorId: "living room", Metric: "temperature", Period: Weekly, Stat: Max, Date: "2026-10-19", Unit: "F", Timezone: "Europe/Berlin"})
+	if err == nil || !strings.Contains(err.Error(), `"/metric/temperature/weekly_max/living%20room/2026-10-19?tz=Europe%2FBerlin&unit=F"`) {
+		t.Errorf("Stat requested %v", err)
+	}
+	if _, err := client.Stat(context.Background(), StatQuery{SensorId: "kitchen", Metric: "temperature", Period: Daily, Stat: Avg, Timezone: "Mars/Olympus"}); err == nil {
+		t.Error("Stat accepted an unknown timezone")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	options := Options{MinBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}.withDefaults()
+	for retry, max := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second} {
+		for i := 0; i < 20; i++ {
+			if delay := options.backoff(retry); delay < max/2 || delay > max {
+				t.Errorf("backoff(%d) = %s, want between %s and %s", retry, delay, max/2, max)
+			}
+		}
+	}
+	// no overflow after many retries
+	if delay := options.backoff(80); delay < options.MaxBackoff/2 || delay > options.MaxBackoff {
+		t.Errorf("backoff(80) = %s", delay)
+	}
+	defaults := Options{MaxRetries: -1}.withDefaults()
+	if defaults.MaxRetries != 0 || defaults.Timeout != defaultTimeout || defaults.SensorTag != defaultSensorTag || defaults.MaxBackoff != defaultMaxBackoff {
+		t.Errorf("withDefaults = %+v", defaults)
+	}
+}
@@ -0,0 +1,187 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/andreikom/sensor-server/pkg/api/metric"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// grpcBatchConcurrency - calls SaveBatch of a GrpcClient makes at once
+const grpcBatchConcurrency = 8
+
+// ErrTimestampUnsupported - the gRPC API stores readings at the time they are received
+var ErrTimestampUnsupported = errors.New("the gRPC API does not accept reading timestamps, use the HTTP client")
+
+// GrpcClient - a client of the gRPC API, safe for concurrent use
+type GrpcClient struct {
+	options Options
+	conn    *grpc.ClientConn
+	metrics metric.MetricServiceClient
+	health  healthpb.HealthClient
+}
+
+// NewGrpc connects to options.GrpcAddress in the background, calls wait for the connection within their timeout
+func NewGrpc(options Options) (*GrpcClient, error) {
+	options = options.withDefaults()
+	if options.GrpcAddress == "" {
+		return nil, errors.New("the gRPC address is missing, expected e.g. localhost:9000")
+	}
+	client := &GrpcClient{options: options}
+	transportCredentials := insecure.NewCredentials()
+	if options.TLS != nil {
+		transportCredentials = credentials.NewTLS(options.TLS)
+	}
+	dialOptions := []grpc.DialOption{
+		grpc.WithTransportCredentials(transportCredentials),
+		grpc.WithChainUnaryInterceptor(client.retryInterceptor),
+	}
+	if options.Token != "" {
+		dialOptions = append(dialOptions, grpc.WithPerRPCCredentials(tokenCredentials{token: options.Token, requireTls: options.TLS != nil}))
+	}
+	conn, err := grpc.Dial(options.GrpcAddress, dialOptions...)
+	if err != nil {
+		return nil, err
+	}
+	client.conn = conn
+	client.metrics = metric.NewMetricServiceClient(conn)
+	client.health = healthpb.NewHealthClient(conn)
+	return client, nil
+}
+
+func (c *GrpcClient) Close() error {
+	return c.conn.Close()
+}
+
+// Save sends a reading, it has to be without a Time
+func (c *GrpcClient) Save(ctx context.Context, reading Reading) error {
+	if err := reading.validate(); err != nil {
+		return err
+	}
+	if !reading.Time.IsZero() {
+		return ErrTimestampUnsupported
+	}
+	_, err := c.metrics.SaveMetric(ctx, &metric.SensorMetricValue{SensorId: reading.SensorId, Metric: reading.Metric, Value: reading.Value, Unit: reading.Unit})
+	return err
+}
+
+// SaveBatch sends readings concurrently, returning the first failure. The API has no batch call, readings
+// sent before or besides a failed one are stored. Nothing is sent if a reading is invalid
+func (c *GrpcClient) SaveBatch(ctx context.Context, readings []Reading) error {
+	if err := validateAll(readings); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var once sync.Once
+	var firstErr error
+	slots := make(chan struct{}, grpcBatchConcurrency)
+	var wg sync.WaitGroup
+	for _, reading := range readings {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(reading Reading) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			if err := c.Save(ctx, reading); err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}(reading)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// Stat computes a statistic, the result is in the unit of the query
+func (c *GrpcClient) Stat(ctx context.Context, query StatQuery) (float64, error) {
+	call, err := c.statCall(query.Period, query.Stat)
+	if err != nil {
+		return 0, err
+	}
+	result, err := call(ctx, &metric.MetricQuery{SensorId: query.SensorId, Metric: query.Metric, Date: query.Date, Unit: query.Unit, Timezone: query.Timezone})
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(result.Value, 64)
+}
+
+type statCall func(ctx context.Context, in *metric.MetricQuery, opts ...grpc.CallOption) (*metric.Result, error)
+
+func (c *GrpcClient) statCall(period Period, stat Stat) (statCall, error) {
+	calls := map[Period]map[Stat]statCall{
+		Daily:   {Min: c.metrics.GetDailyMin, Max: c.metrics.GetDailyMax, Avg: c.metrics.GetDailyAvg},
+		Weekly:  {Min: c.metrics.GetWeeklyMin, Max: c.metrics.GetWeeklyMax, Avg: c.metrics.GetWeeklyAvg},
+		Monthly: {Min: c.metrics.GetMonthlyMin, Max: c.metrics.GetMonthlyMax, Avg: c.metrics.GetMonthlyAvg},
+	}
+	call, ok := calls[period][stat]
+	if !ok {
+		return nil, fmt.Errorf("unknown %s statistic '%s'", period, stat)
+	}
+	return call, nil
+}
+
+// Ready returns nil if the grpc.health.v1 service reports the server as serving
+func (c *GrpcClient) Ready(ctx context.Context) error {
+	resp, err := c.health.Check(ctx, &healthpb.HealthCheckRequest{}, grpc.WaitForReady(true))
+	if err != nil {
+		return err
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		return status.Errorf(codes.Unavailable, "the server is %s", resp.Status)
+	}
+	return nil
+}
+
+// retryInterceptor - every attempt gets Options.Timeout, calls failing with Unavailable or ResourceExhausted
+// were not processed by the server and are retried
+func (c *GrpcClient) retryInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	for attempt := 0; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, c.options.Timeout)
+		err := invoker(attemptCtx, method, req, reply, cc, opts...)
+		cancel()
+		code := status.Code(err)
+		if err == nil || attempt >= c.options.MaxRetries || ctx.Err() != nil || (code != codes.Unavailable && code != codes.ResourceExhausted) {
+			return err
+		}
+		select {
+		case <-time.After(c.options.backoff(attempt)):
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
+
+// tokenCredentials - sends the token as the bearer token of the "authorization" metadata
+type tokenCredentials struct {
+	token      string
+	requireTls bool
+}
+
+func (t tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + t.token}, nil
+}
+
+func (t tokenCredentials) RequireTransportSecurity() bool {
+	return t.requireTls
+}
@@ -0,0 +1,217 @@
+package coap
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// message types of RFC 7252
+const (
+	confirmable     byte = 0
+	nonConfirmable  byte = 1
+	acknowledgement byte = 2
+	reset           byte = 3
+)
+
+// codes are class << 5 | detail, written as class.detail
+const (
+	codeEmpty               byte = 0
+	codeGet                 byte = 1
+	codePost                byte = 2
+	codeChanged             byte = 2<<5 | 4
+	codeContent             byte = 2<<5 | 5
+	codeBadRequest          byte = 4<<5 | 0
+	codeNotFound            byte = 4<<5 | 4
+	codeMethodNotAllowed    byte = 4<<5 | 5
+	codeNotAcceptable       byte = 4<<5 | 6
+	codeUnsupportedFormat   byte = 4<<5 | 15
+	codeInternalServerError byte = 5<<5 | 0
+	codeServiceUnavailable  byte = 5<<5 | 3
+)
+
+// option numbers of RFC 7252 and the Observe option of RFC 7641
+const (
+	optionObserve       uint16 = 6
+	optionUriPath       uint16 = 11
+	optionContentFormat uint16 = 12
+	optionUriQuery      uint16 = 15
+	optionAccept        uint16 = 17
+)
+
+// content formats of RFC 7252 and RFC 7049
+const (
+	contentFormatText uint32 = 0
+	contentFormatJson uint32 = 50
+	contentFormatCbor uint32 = 60
+)
+
+const (
+	payloadMarker  byte = 0xff
+	maxTokenLength      = 8
+)
+
+var errMalformedMessage = errors.New("malformed message")
+
+type option struct {
+	number uint16
+	value  []byte
+}
+
+type message struct {
+	kind      byte
+	code      byte
+	messageId uint16
+	token     []byte
+	options   []option
+	payload   []byte
+}
+
+func parseMessage(data []byte) (*message, error) {
+	if len(data) < 4 || data[0]>>6 != 1 {
+		return nil, errMalformedMessage
+	}
+	tokenLength := int(data[0] & 0x0f)
+	if tokenLength > maxTokenLength || len(data) < 4+tokenLength {
+		return nil, errMalformedMessage
+	}
+	msg := &message{
+		kind:      data[0] >> 4 & 0x03,
+		code:      data[1],
+		messageId: binary.BigEndian.Uint16(data[2:4]),
+		token:     append([]byte(nil), data[4:4+tokenLength]...),
+	}
+	rest := data[4+tokenLength:]
+	number := uint16(0)
+	for len(rest) > 0 {
+		if rest[0] == payloadMarker {
+			if len(rest) == 1 {
+				return nil, errMalformedMessage
+			}
+			msg.payload = rest[1:]
+			break
+		}
+		delta, length := int(rest[0]>>4), int(rest[0]&0x0f)
+		rest = rest[1:]
+		var err error
+		if delta, rest, err = extendedValue(delta, rest); err != nil {
+			return nil, err
+		}
+		if length, rest, err = extendedValue(length, rest); err != nil {
+			return nil, err
+		}
+		if len(rest) < length || int(number)+delta > 0xffff {
+			return nil, errMalformedMessage
+		}
+		number += uint16(delta)
+		msg.options = append(msg.options, option{number: number, value: rest[:length]})
+		rest = rest[length:]
+	}
+	return msg, nil
+}
+
+func extendedValue(value int, rest []byte) (int, []byte, error) {
+	switch value {
+	case 13:
+		if len(rest) < 1 {
+			return 0, nil, errMalformedMessage
+		}
+		return int(rest[0]) + 13, rest[1:], nil
+	case 14:
+		if len(rest) < 2 {
+			return 0, nil, errMalformedMessage
+		}
+		return int(binary.BigEndian.Uint16(rest)) + 269, rest[2:], nil
+	case 15:
+		return 0, nil, errMalformedMessage
+	}
+	return value, rest, nil
+}
+
+func (m *message) bytes() []byte {
+	buf := []byte{1<<6 | m.kind<<4 | byte(len(m.token)), m.code, byte(m.messageId >> 8), byte(m.messageId)}
+	buf = append(buf, m.token...)
+	options := append([]option(nil), m.options...)
+	sort.SliceStable(options, func(i, j int) bool { return options[i].number < options[j].number })
+	previous := uint16(0)
+	for _, opt := range options {
+		delta, length := int(opt.number-previous), len(opt.value)
+		previous = opt.number
+		deltaNibble, deltaExt := nibble(delta)
+		lengthNibble, lengthExt := nibble(length)
+		buf = append(buf, deltaNibble<<4|lengthNibble)
+		buf = append(buf, deltaExt...)
+		buf = append(buf, lengthExt...)
+		buf = append(buf, opt.value...)
+	}
+	if len(m.payload) > 0 {
+		buf = append(buf, payloadMarker)
+		buf = append(buf, m.payload...)
+	}
+	return buf
+}
+
+func nibble(value int) (byte, []byte) {
+	switch {
+	case value < 13:
+		return byte(value), nil
+	case value < 269:
+		return 13, []byte{byte(value - 13)}
+	}
+	ext := make([]byte, 2)
+	binary.BigEndian.PutUint16(ext, uint16(value-269))
+	return 14, ext
+}
+
+func (m *message) option(number uint16) ([]byte, bool) {
+	for _, opt := range m.options {
+		if opt.number == number {
+			return opt.value, true
+		}
+	}
+	return nil, false
+}
+
+func (m *message) uintOption(number uint16) (uint32, bool) {
+	value, ok := m.option(number)
+	if !ok || len(value) > 4 {
+		return 0, false
+	}
+	result := uint32(0)
+	for _, b := range value {
+		result = result<<8 | uint32(b)
+	}
+	return result, true
+}
+
+func (m *message) path() string {
+	segments := make([]string, 0)
+	for _, opt := range m.options {
+		if opt.number == optionUriPath {
+			segments = append(segments, string(opt.value))
+		}
+	}
+	return "/" + strings.Join(segments, "/")
+}
+
+func (m *message) query(key string) string {
+	for _, opt := range m.options {
+		if opt.number == optionUriQuery && strings.HasPrefix(string(opt.value), key+"=") {
+			return string(opt.value[len(key)+1:])
+		}
+	}
+	return ""
+}
+
+// uintValue - the minimal big endian encoding of an integer option, zero is the empty value
+func uintValue(value uint32) []byte {
+	buf := make([]byte, 0, 4)
+	for shift := 24; shift >= 0; shift -= 8 {
+		b := byte(value >> uint(shift))
+		if b != 0 || len(buf) > 0 {
+			buf = append(buf, b)
+		}
+	}
+	return buf
+}
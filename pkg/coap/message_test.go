@@ -0,0 +1,129 @@
+package coap
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	msg := &message{
+		kind:      confirmable,
+		code:      codePost,
+		messageId: 0xbeef,
+		token:     []byte{1, 2, 3, 4},
+		options: []option{
+			{number: optionUriQuery, value: []byte("unit=F")},
+			{number: optionUriPath, value: []byte("temp")},
+			{number: optionContentFormat, value: uintValue(contentFormatCbor)},
+			// a delta over 268 and a value longer than 12 bytes use the extended encodings
+			{number: 2048, value: bytes.Repeat([]byte("x"), 300)},
+			{number: optionObserve, value: nil},
+		},
+		payload: []byte("kitchen 21.5"),
+	}
+	parsed, err := parseMessage(msg.bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.kind != msg.kind || parsed.code != msg.code || parsed.messageId != msg.messageId ||
+		!bytes.Equal(parsed.token, msg.token) || !bytes.Equal(parsed.payload, msg.payload) {
+		t.Errorf("parsed %+v, want %+v", parsed, msg)
+	}
+	numbers := make([]uint16, 0)
+	for _, opt := range parsed.options {
+		numbers = append(numbers, opt.number)
+	}
+	// options are written ordered by their number
+	if want := []uint16{optionObserve, optionUriPath, optionContentFormat, optionUriQuery, 2048}; !reflect.DeepEqual(numbers, want) {
+		t.Errorf("options %v, want %v", numbers, want)
+	}
+	if value, _ := parsed.option(2048); len(value) != 300 {
+		t.Errorf("an extended option has %d bytes, want 300", len(value))
+	}
+	if format, ok := parsed.uintOption(optionContentFormat); !ok || format != contentFormatCbor {
+		t.Errorf("content format %d, %v", format, ok)
+	}
+	if observe, ok := parsed.uintOption(optionObserve); !ok || observe != 0 {
+		t.Errorf("an empty observe option is %d, %v, want 0", observe, ok)
+	}
+}
+
+func TestMessageWithoutPayload(t *testing.T) {
+	data := (&message{kind: acknowledgement, code: codeEmpty, messageId: 7}).bytes()
+	if want := []byte{0x60, 0, 0, 7}; !bytes.Equal(data, want) {
+		t.Errorf("an empty acknowledgement is % x, want % x", data, want)
+	}
+	parsed, err := parseMessage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.kind != acknowledgement || parsed.messageId != 7 || parsed.payload != nil || len(parsed.options) != 0 {
+		t.Errorf("parsed %+v", parsed)
+	}
+}
+
+func TestParseMalformedMessages(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"too short":              {0x40, 1, 0},
+		"version 2":              {0x80, 1, 0, 1},
+		"token too long":         {0x49, 1, 0, 1, 1, 2, 3, 4, 5, 6, 7, 8, 9},
+		"truncated token":        {0x44, 1, 0, 1, 1, 2},
+		"marker without payload": {0x40, 2, 0, 1, payloadMarker},
+		"truncated option":       {0x40, 1, 0, 1, 0xb4, 't', 'e'},
+		"truncated extension":    {0x40, 1, 0, 1, 0xd0},
+		"reserved delta":         {0x40, 1, 0, 1, 0xf0},
+		"reserved length":        {0x40, 1, 0, 1, 0x1f},
+		"option number overflow": {0x40, 1, 0, 1, 0xe0, 0xff, 0xff, 0xe0, 0xff, 0xff},
+	} {
+		if _, err := parseMessage(data); err != errMalformedMessage {
+			t.Errorf("%s: returned %v, want %v", name, err, errMalformedMessage)
+		}
+	}
+}
+
+func TestPathAndQuery(t *testing.T) {
+	msg := &message{options: []option{
+		{number: optionUriPath, value: []byte("temp")},
+		{number: optionUriPath, value: []byte("kitchen")},
+		{number: optionUriPath, value: []byte("latest")},
+		{number: optionUriQuery, value: []byte("units=K")},
+		{number: optionUriQuery, value: []byte("unit=F")},
+	}}
+	if got := msg.path(); got != "/temp/kitchen/latest" {
+		t.Errorf("path %q", got)
+	}
+	if got := msg.query("unit"); got != "F" {
+		t.Errorf("query unit %q, want F", got)
+	}
+	if got := msg.query("sensor"); got != "" {
+		t.Errorf("a missing query is %q", got)
+	}
+	if got := (&message{}).path(); got != "/" {
+		t.Errorf("an empty path is %q", got)
+	}
+}
+
+func TestUintOptions(t *testing.T) {
+	for _, value := range []uint32{0, 1, 0xff, 0x100, 0xffffff, 0xffffffff} {
+		encoded := uintValue(value)
+		if value == 0 && len(encoded) != 0 {
+			t.Errorf("zero is encoded as % x, want an empty value", encoded)
+		}
+		if len(encoded) > 0 && encoded[0] == 0 {
+			t.Errorf("%d is encoded as % x with a leading zero", value, encoded)
+		}
+		msg := &message{options: []option{{number: optionAccept, value: encoded}}}
+		if got, ok := msg.uintOption(optionAccept); !ok || got != value {
+			t.Errorf("%d decoded as %d, %v", value, got, ok)
+		}
+	}
+	tooLong := &message{options: []option{{number: optionAccept, value: []byte(strings.Repeat("x", 5))}}}
+	if _, ok := tooLong.uintOption(optionAccept); ok {
+		t.Error("a five byte integer option was accepted")
+	}
+	if _, ok := tooLong.uintOption(optionObserve); ok {
+		t.Error("a missing option was found")
+	}
+}
@@ -0,0 +1,461 @@
+package coap
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/andreikom/sensor-server/pkg/api/metric"
+	"github.com/andreikom/sensor-server/pkg/api/temperature"
+	"github.com/andreikom/sensor-server/pkg/ingest"
+	"github.com/andreikom/sensor-server/pkg/logging"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultAddress = ":5683"
+	maxMessageSize = 1152
+	// exchangeLifetime - EXCHANGE_LIFETIME of RFC 7252, responses to confirmable requests are kept this long
+	// so retransmitted requests are answered without being processed twice
+	exchangeLifetime = 247 * time.Second
+	// ackTimeout and maxRetransmit - ACK_TIMEOUT and MAX_RETRANSMIT of RFC 7252 for confirmable notifications
+	ackTimeout    = 2 * time.Second
+	maxRetransmit = 4
+	maxObservers  = 1024
+	sweepInterval = 500 * time.Millisecond
+)
+
+// exchangeKey - a message id is unique per endpoint
+type exchangeKey struct {
+	addr      string
+	messageId uint16
+}
+
+// exchange - a confirmable request, response is nil while it is processed
+type exchange struct {
+	response []byte
+	expires  time.Time
+}
+
+// observer - a client observing the latest temperature of a sensor (RFC 7641)
+type observer struct {
+	addr     net.Addr
+	token    []byte
+	sensorId string
+	unit     temperature.Unit
+	accept   uint32
+	// pendingId - the message id of the confirmable notification awaiting its acknowledgement
+	pendingId uint16
+	pending   bool
+}
+
+// pendingNotification - a confirmable notification retransmitted until it is acknowledged
+type pendingNotification struct {
+	observer *observer
+	data     []byte
+	attempts int
+	timeout  time.Duration
+	next     time.Time
+}
+
+// Server - CoAP over UDP for battery-powered sensors:
+//
+//	POST /temp                     - "sensorId value [timestamp]", CBOR or, with content format 50, the /temp/ JSON
+//	GET  /temp/{sensorId}/latest   - the latest temperature, observable, ?unit=F selects the unit
+type Server struct {
+	tempService *temperature.TempService
+	address     string
+	conn        net.PacketConn
+	mutex       sync.Mutex
+	exchanges   map[exchangeKey]*exchange
+	// observers - sensorId -> address and token -> observer
+	observers     map[string]map[string]*observer
+	observerCount int
+	pending       map[uint16]*pendingNotification
+	messageId     uint16
+	observeSeq    uint32
+	quit          chan struct{}
+	closed        bool
+}
+
+// Options - Address defaults to the standard CoAP port
+type Options struct {
+	Address string
+}
+
+func NewServer(tempService *temperature.TempService, options Options) *Server {
+	if options.Address == "" {
+		options.Address = DefaultAddress
+	}
+	server := &Server{
+		tempService: tempService,
+		address:     options.Address,
+		exchanges:   make(map[exchangeKey]*exchange),
+		observers:   make(map[string]map[string]*observer),
+		pending:     make(map[uint16]*pendingNotification),
+		messageId:   uint16(rand.Intn(0x10000)),
+		quit:        make(chan struct{}),
+	}
+	tempService.OnTempUpdate(server.notifyObservers)
+	return server
+}
+
+// ListenAndServe receives messages until Close is called
+func (s *Server) ListenAndServe() error {
+	conn, err := net.ListenPacket("udp", s.address)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	s.conn = conn
+	s.mutex.Unlock()
+	logging.Log.WithField("address", s.address).Info("Starting the CoAP server")
+	go s.sweep()
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			s.mutex.Lock()
+			closed := s.closed
+			s.mutex.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		msg, err := parseMessage(buf[:n])
+		if err != nil {
+			continue
+		}
+		s.handleMessage(addr, msg)
+	}
+}
+
+// Close stops the server
+func (s *Server) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	close(s.quit)
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
+
+func (s *Server) handleMessage(addr net.Addr, msg *message) {
+	switch {
+	case msg.kind == acknowledgement || msg.kind == reset:
+		s.handleReply(msg)
+		return
+	case msg.code == codeEmpty:
+		// a confirmable empty message is a CoAP ping
+		if msg.kind == confirmable {
+			s.send(addr, &message{kind: reset, messageId: msg.messageId})
+		}
+		return
+	case msg.code>>5 != 0:
+		return // only requests are served
+	}
+	if msg.kind == confirmable {
+		key := exchangeKey{addr: addr.String(), messageId: msg.messageId}
+		s.mutex.Lock()
+		previous, duplicate := s.exchanges[key]
+		if !duplicate {
+			s.exchanges[key] = &exchange{expires: time.Now().Add(exchangeLifetime)}
+		}
+		s.mutex.Unlock()
+		if duplicate {
+			if previous.response != nil {
+				s.write(addr, previous.response)
+			}
+			return
+		}
+	}
+	// saving a reading waits for the broker, the read loop must not
+	go s.handleRequest(addr, msg)
+}
+
+func (s *Server) handleRequest(addr net.Addr, req *message) {
+	resp := s.route(addr, req)
+	resp.token = req.token
+	if req.kind == confirmable {
+		resp.kind, resp.messageId = acknowledgement, req.messageId
+	} else {
+		resp.kind, resp.messageId = nonConfirmable, s.nextMessageId()
+	}
+	data := resp.bytes()
+	if req.kind == confirmable {
+		s.mutex.Lock()
+		if ex, ok := s.exchanges[exchangeKey{addr: addr.String(), messageId: req.messageId}]; ok {
+			ex.response = data
+		}
+		s.mutex.Unlock()
+	}
+	s.write(addr, data)
+}
+
+func (s *Server) route(addr net.Addr, req *message) *message {
+	segments := strings.Split(strings.Trim(req.path(), "/"), "/")
+	switch {
+	case len(segments) == 1 && segments[0] == "temp":
+		if req.code != codePost {
+			return textResponse(codeMethodNotAllowed, "")
+		}
+		return s.saveTemp(req)
+	case len(segments) == 3 && segments[0] == "temp" && segments[2] == "latest":
+		if req.code != codeGet {
+			return textResponse(codeMethodNotAllowed, "")
+		}
+		return s.getLatestTemp(addr, req, segments[1])
+	}
+	return textResponse(codeNotFound, "")
+}
+
+func (s *Server) saveTemp(req *message) *message {
+	format, _ := req.uintOption(optionContentFormat)
+	var sensorId, rawUnit string
+	var value float64
+	var timestamp time.Time
+	switch format {
+	case contentFormatJson:
+		payload := temperature.SensorIdTempJson{}
+		if err := json.Unmarshal(req.payload, &payload); err != nil || payload.SensorId == "" {
+			return textResponse(codeBadRequest, "invalid json payload")
+		}
+		sensorId, value, rawUnit = payload.SensorId, payload.Temp, payload.Unit
+	case contentFormatText, contentFormatCbor:
+		reading, err := ingest.Decode(req.payload, nil)
+		if err != nil {
+			return textResponse(codeBadRequest, err.Error())
+		}
+		if reading.Metric != "" && reading.Metric != metric.Temperature {
+			return textResponse(codeBadRequest, "only temperature readings can be posted to /temp")
+		}
+		sensorId, value, rawUnit, timestamp = reading.SensorId, reading.Value, reading.Unit, reading.Time
+	default:
+		return textResponse(codeUnsupportedFormat, "")
+	}
+	unit, err := temperature.ParseUnit(rawUnit)
+	if err != nil {
+		return textResponse(codeBadRequest, err.Error())
+	}
+	if timestamp.IsZero() {
+		err = s.tempService.SaveTemperature(context.Background(), sensorId, value, unit)
+	} else {
+		err = s.tempService.SaveTemperatureAt(context.Background(), sensorId, value, unit, timestamp)
+	}
+	if err != nil {
+		logging.Log.WithError(err).WithField("sensorId", sensorId).Error("Could not have saved a CoAP reading")
+		return textResponse(codeServiceUnavailable, "")
+	}
+	return textResponse(codeChanged, "")
+}
+
+// getLatestTemp serves the latest temperature, an Observe option of 0 registers the client for
+// notifications of new readings and 1 deregisters it
+func (s *Server) getLatestTemp(addr net.Addr, req *message, sensorId string) *message {
+	unit, err := temperature.ParseUnit(req.query("unit"))
+	if err != nil {
+		return textResponse(codeBadRequest, err.Error())
+	}
+	accept, ok := req.uintOption(optionAccept)
+	if !ok {
+		accept = contentFormatText
+	}
+	if accept != contentFormatText && accept != contentFormatJson {
+		return textResponse(codeNotAcceptable, "")
+	}
+	resp := s.latestTemp(sensorId, unit, accept)
+	observe, observing := req.uintOption(optionObserve)
+	switch {
+	case observing && observe == 0 && resp.code == codeContent:
+		if s.addObserver(&observer{addr: addr, token: req.token, sensorId: sensorId, unit: unit, accept: accept}) {
+			resp.options = append(resp.options, option{number: optionObserve, value: uintValue(s.nextObserveSeq())})
+		}
+	case observing && observe == 1:
+		s.removeObserver(sensorId, observerKey(addr, req.token))
+	}
+	return resp
+}
+
+func (s *Server) latestTemp(sensorId string, unit temperature.Unit, accept uint32) *message {
+	value, timestamp, err := s.tempService.GetLatestTemp(sensorId, unit)
+	if err != nil {
+		return textResponse(codeNotFound, err.Error())
+	}
+	if accept == contentFormatJson {
+		payload, err := json.Marshal(map[string]interface{}{"sensorId": sensorId, "temp": value, "unit": unit, "hour": timestamp})
+		if err != nil {
+			return textResponse(codeInternalServerError, "")
+		}
+		return &message{code: codeContent, options: []option{{number: optionContentFormat, value: uintValue(contentFormatJson)}}, payload: payload}
+	}
+	return textResponse(codeContent, metric.FormatReading(value))
+}
+
+func (s *Server) addObserver(o *observer) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	sensorObservers, ok := s.observers[o.sensorId]
+	if !ok {
+		sensorObservers = make(map[string]*observer)
+		s.observers[o.sensorId] = sensorObservers
+	}
+	key := observerKey(o.addr, o.token)
+	if _, registered := sensorObservers[key]; !registered {
+		if s.observerCount >= maxObservers {
+			return false
+		}
+		s.observerCount++
+	}
+	sensorObservers[key] = o
+	return true
+}
+
+func (s *Server) removeObserver(sensorId string, key string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.removeObserverLocked(sensorId, key)
+}
+
+func (s *Server) removeObserverLocked(sensorId string, key string) {
+	sensorObservers := s.observers[sensorId]
+	o, ok := sensorObservers[key]
+	if !ok {
+		return
+	}
+	if o.pending {
+		delete(s.pending, o.pendingId)
+	}
+	delete(sensorObservers, key)
+	s.observerCount--
+	if len(sensorObservers) == 0 {
+		delete(s.observers, sensorId)
+	}
+}
+
+// notifyObservers sends the new latest temperature to the observers of a sensor as confirmable
+// notifications, a notification still awaiting its acknowledgement is replaced
+func (s *Server) notifyObservers(update metric.Update) {
+	s.mutex.Lock()
+	observers := make([]*observer, 0, len(s.observers[update.SensorId]))
+	for _, o := range s.observers[update.SensorId] {
+		observers = append(observers, o)
+	}
+	s.mutex.Unlock()
+	for _, o := range observers {
+		notification := s.latestTemp(o.sensorId, o.unit, o.accept)
+		notification.kind = confirmable
+		notification.token = o.token
+		notification.messageId = s.nextMessageId()
+		notification.options = append(notification.options, option{number: optionObserve, value: uintValue(s.nextObserveSeq())})
+		data := notification.bytes()
+		s.mutex.Lock()
+		if o.pending {
+			delete(s.pending, o.pendingId)
+		}
+		o.pending, o.pendingId = true, notification.messageId
+		timeout := ackTimeout + time.Duration(rand.Int63n(int64(ackTimeout/2)))
+		s.pending[notification.messageId] = &pendingNotification{observer: o, data: data, timeout: timeout, next: time.Now().Add(timeout)}
+		s.mutex.Unlock()
+		s.write(o.addr, data)
+	}
+}
+
+// handleReply - an acknowledgement confirms a notification, a reset cancels the observation
+func (s *Server) handleReply(msg *message) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	notification, ok := s.pending[msg.messageId]
+	if !ok {
+		return
+	}
+	delete(s.pending, msg.messageId)
+	notification.observer.pending = false
+	if msg.kind == reset {
+		s.removeObserverLocked(notification.observer.sensorId, observerKey(notification.observer.addr, notification.observer.token))
+	}
+}
+
+// sweep retransmits unacknowledged notifications with exponential backoff, drops observers which stopped
+// acknowledging and forgets expired exchanges
+func (s *Server) sweep() {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.quit:
+			return
+		case now := <-ticker.C:
+			type retransmission struct {
+				addr net.Addr
+				data []byte
+			}
+			retransmissions := make([]retransmission, 0)
+			s.mutex.Lock()
+			for messageId, notification := range s.pending {
+				if now.Before(notification.next) {
+					continue
+				}
+				if notification.attempts >= maxRetransmit {
+					delete(s.pending, messageId)
+					notification.observer.pending = false
+					s.removeObserverLocked(notification.observer.sensorId, observerKey(notification.observer.addr, notification.observer.token))
+					continue
+				}
+				notification.attempts++
+				notification.timeout *= 2
+				notification.next = now.Add(notification.timeout)
+				retransmissions = append(retransmissions, retransmission{addr: notification.observer.addr, data: notification.data})
+			}
+			for key, ex := range s.exchanges {
+				if now.After(ex.expires) {
+					delete(s.exchanges, key)
+				}
+			}
+			s.mutex.Unlock()
+			for _, r := range retransmissions {
+				s.write(r.addr, r.data)
+			}
+		}
+	}
+}
+
+func (s *Server) send(addr net.Addr, msg *message) {
+	s.write(addr, msg.bytes())
+}
+
+func (s *Server) write(addr net.Addr, data []byte) {
+	if _, err := s.conn.WriteTo(data, addr); err != nil {
+		logging.Log.WithError(err).WithField("address", addr.String()).Warn("Could not have sent a CoAP message")
+	}
+}
+
+func (s *Server) nextMessageId() uint16 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.messageId++
+	return s.messageId
+}
+
+// nextObserveSeq - the 24 bit sequence number ordering notifications
+func (s *Server) nextObserveSeq() uint32 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.observeSeq = (s.observeSeq + 1) & 0xffffff
+	return s.observeSeq
+}
+
+func observerKey(addr net.Addr, token []byte) string {
+	return addr.String() + "/" + string(token)
+}
+
+func textResponse(code byte, payload string) *message {
+	return &message{code: code, payload: []byte(payload)}
+}
@@ -0,0 +1,306 @@
+package coap
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/andreikom/sensor-server/pkg/api/metric"
+	"github.com/andreikom/sensor-server/pkg/api/metric/metrictest"
+	"github.com/andreikom/sensor-server/pkg/api/temperature"
+	"github.com/andreikom/sensor-server/pkg/broker"
+	"net"
+	"testing"
+	"time"
+)
+
+// coapClient - a UDP endpoint exchanging messages with the server under test
+type coapClient struct {
+	t      *testing.T
+	conn   net.PacketConn
+	server net.Addr
+}
+
+func newServer(t *testing.T) (*Server, *metric.MetricService, *broker.Spool, *coapClient) {
+	service, spool := metrictest.NewSpoolingService(t)
+	server := NewServer(temperature.NewTempService(service), Options{Address: "127.0.0.1:0"})
+	done := make(chan error, 1)
+	go func() { done <- server.ListenAndServe() }()
+	t.Cleanup(func() {
+		_ = server.Close()
+		if err := <-done; err != nil {
+			t.Errorf("the server returned %v after Close", err)
+		}
+	})
+	var address net.Addr
+	for deadline := time.Now().Add(5 * time.Second); address == nil && time.Now().Before(deadline); {
+		server.mutex.Lock()
+		if server.conn != nil {
+			address = server.conn.LocalAddr()
+		}
+		server.mutex.Unlock()
+		time.Sleep(5 * time.Millisecond)
+	}
+	if address == nil {
+		t.Fatal("the server did not start")
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return server, service, spool, &coapClient{t: t, conn: conn, server: address}
+}
+
+func (c *coapClient) send(msg *message) {
+	c.t.Helper()
+	if _, err := c.conn.WriteTo(msg.bytes(), c.server); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *coapClient) receive() *message {
+	c.t.Helper()
+	msg, err := c.receiveWithin(5 * time.Second)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return msg
+}
+
+func (c *coapClient) receiveWithin(timeout time.Duration) (*message, error) {
+	buf := make([]byte, maxMessageSize)
+	_ = c.conn.SetReadDeadline(time.Now().Add(timeout))
+	n, _, err := c.conn.ReadFrom(buf)
+	if err != nil {
+		return nil, err
+	}
+	return parseMessage(buf[:n])
+}
+
+// exchange sends a request and returns its response
+func (c *coapClient) exchange(msg *message) *message {
+	c.t.Helper()
+	c.send(msg)
+	return c.receive()
+}
+
+func request(kind byte, code byte, messageId uint16, path ...string) *message {
+	msg := &message{kind: kind, code: code, messageId: messageId, token: []byte{byte(messageId), 0xaa}}
+	for _, segment := range path {
+		msg.options = append(msg.options, option{number: optionUriPath, value: []byte(segment)})
+	}
+	return msg
+}
+
+func withOption(msg *message, number uint16, value []byte) *message {
+	msg.options = append(msg.options, option{number: number, value: value})
+	return msg
+}
+
+func importTemp(t *testing.T, service *metric.MetricService, sensorId string, value float64, at time.Time) {
+	t.Helper()
+	if err := service.Import(context.Background(), []metric.Reading{{SensorId: sensorId, Metric: metric.Temperature, Value: value, Timestamp: at}}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfirmablePostIsAcknowledged(t *testing.T) {
+	_, _, spool, client := newServer(t)
+	req := request(confirmable, codePost, 100, "temp")
+	req.payload = []byte("kitchen 70.7:F")
+	resp := client.exchange(req)
+	if resp.kind != acknowledgement || resp.messageId != 100 || string(resp.token) != string(req.token) || resp.code != codeChanged {
+		t.Fatalf("a confirmable POST was answered with %+v", resp)
+	}
+	// a retransmitted request is answered again without saving the reading twice
+	if again := client.exchange(req); again.kind != acknowledgement || again.messageId != 100 || again.code != codeChanged {
+		t.Errorf("a retransmission was answered with %+v", again)
+	}
+	readings := metrictest.SpooledReadings(t, spool)
+	if len(readings) != 1 {
+		t.Fatalf("stored %d readings, want 1", len(readings))
+	}
+	if r := readings[0]; r.SensorId != "kitchen" || r.Metric != metric.Temperature || r.Value < 21.49 || r.Value > 21.51 {
+		t.Errorf("stored %+v, want 21.5 degrees Celsius of kitchen", r)
+	}
+}
+
+func TestPostPayloads(t *testing.T) {
+	_, _, spool, client := newServer(t)
+	jsonReq := withOption(request(nonConfirmable, codePost, 200, "temp"), optionContentFormat, uintValue(contentFormatJson))
+	jsonReq.payload = []byte(`{"sensorId":"hallway","temp":19.5}`)
+	if resp := client.exchange(jsonReq); resp.kind != nonConfirmable || resp.code != codeChanged || string(resp.token) != string(jsonReq.token) {
+		t.Errorf("a non-confirmable JSON POST was answered with %+v", resp)
+	}
+	tests := []struct {
+		name    string
+		format  uint32
+		payload string
+		code    byte
+	}{
+		{"invalid json", contentFormatJson, `{"temp":19.5}`, codeBadRequest},
+		{"invalid text", contentFormatText, "hallway warm", codeBadRequest},
+		{"humidity", contentFormatCbor, "\xa3bid\x67hallwayav\x18\x41am\x68humidity", codeBadRequest},
+		{"unsupported unit", contentFormatText, "hallway 19:X", codeBadRequest},
+		{"xml", 41, "<temp/>", codeUnsupportedFormat},
+	}
+	for i, test := range tests {
+		req := withOption(request(confirmable, codePost, uint16(201+i), "temp"), optionContentFormat, uintValue(test.format))
+		req.payload = []byte(test.payload)
+		if resp := client.exchange(req); resp.code != test.code {
+			t.Errorf("%s: answered with code %d.%02d, want %d.%02d", test.name, resp.code>>5, resp.code&0x1f, test.code>>5, test.code&0x1f)
+		}
+	}
+	if readings := metrictest.SpooledReadings(t, spool); len(readings) != 1 || readings[0].SensorId != "hallway" {
+		t.Errorf("stored %+v, want the JSON reading only", readings)
+	}
+}
+
+func TestRouting(t *testing.T) {
+	_, _, _, client := newServer(t)
+	tests := []struct {
+		name string
+		req  *message
+		code byte
+	}{
+		{"unknown path", request(confirmable, codeGet, 300, "humidity"), codeNotFound},
+		{"GET /temp", request(confirmable, codeGet, 301, "temp"), codeMethodNotAllowed},
+		{"POST latest", request(confirmable, codePost, 302, "temp", "kitchen", "latest"), codeMethodNotAllowed},
+		{"unknown sensor", request(confirmable, codeGet, 303, "temp", "kitchen", "latest"), codeNotFound},
+	}
+	for _, test := range tests {
+		if resp := client.exchange(test.req); resp.code != test.code {
+			t.Errorf("%s: answered with code %d.%02d, want %d.%02d", test.name, resp.code>>5, resp.code&0x1f, test.code>>5, test.code&0x1f)
+		}
+	}
+	// a confirmable empty message is a ping answered with a reset
+	if resp := client.exchange(&message{kind: confirmable, code: codeEmpty, messageId: 304}); resp.kind != reset || resp.messageId != 304 {
+		t.Errorf("a ping was answered with %+v", resp)
+	}
+}
+
+func TestGetLatestTemp(t *testing.T) {
+	_, service, _, client := newServer(t)
+	importTemp(t, service, "kitchen", 21.5, time.Now().UTC().Add(-time.Hour))
+	text := withOption(request(confirmable, codeGet, 400, "temp", "kitchen", "latest"), optionUriQuery, []byte("unit=F"))
+	if resp := client.exchange(text); resp.code != codeContent || string(resp.payload) != "70.70" {
+		t.Errorf("the latest temperature in Fahrenheit is %d.%02d %q, want 2.05 \"70.70\"", resp.code>>5, resp.code&0x1f, resp.payload)
+	}
+	jsonReq := withOption(request(confirmable, codeGet, 401, "temp", "kitchen", "latest"), optionAccept, uintValue(contentFormatJson))
+	resp := client.exchange(jsonReq)
+	if format, _ := resp.uintOption(optionContentFormat); resp.code != codeContent || format != contentFormatJson {
+		t.Fatalf("a JSON request was answered with %+v", resp)
+	}
+	payload := struct {
+		SensorId string  `json:"sensorId"`
+		Temp     float64 `json:"temp"`
+		Unit     string  `json:"unit"`
+	}{}
+	if err := json.Unmarshal(resp.payload, &payload); err != nil || payload.SensorId != "kitchen" || payload.Temp != 21.5 || payload.Unit != "C" {
+		t.Errorf("the JSON payload %s decoded as %+v, %v", resp.payload, payload, err)
+	}
+	cbor := withOption(request(confirmable, codeGet, 402, "temp", "kitchen", "latest"), optionAccept, uintValue(contentFormatCbor))
+	if resp := client.exchange(cbor); resp.code != codeNotAcceptable {
+		t.Errorf("a CBOR request was answered with %d.%02d, want 4.06", resp.code>>5, resp.code&0x1f)
+	}
+	badUnit := withOption(request(confirmable, codeGet, 403, "temp", "kitchen", "latest"), optionUriQuery, []byte("unit=X"))
+	if resp := client.exchange(badUnit); resp.code != codeBadRequest {
+		t.Errorf("an unsupported unit was answered with %d.%02d, want 4.00", resp.code>>5, resp.code&0x1f)
+	}
+}
+
+func TestObserveNotifications(t *testing.T) {
+	server, service, _, client := newServer(t)
+	start := time.Now().UTC().Truncate(time.Hour).Add(-3 * time.Hour)
+	importTemp(t, service, "kitchen", 20, start)
+	observe := withOption(request(confirmable, codeGet, 500, "temp", "kitchen", "latest"), optionObserve, nil)
+	resp := client.exchange(observe)
+	first, observing := resp.uintOption(optionObserve)
+	if resp.code != codeContent || !observing || string(resp.payload) != "20.00" {
+		t.Fatalf("a registration was answered with %+v", resp)
+	}
+
+	importTemp(t, service, "kitchen", 21, start.Add(time.Hour))
+	server.notifyObservers(metric.Update{SensorId: "kitchen", Metric: metric.Temperature, Value: 21})
+	notification := client.receive()
+	seq, _ := notification.uintOption(optionObserve)
+	if notification.kind != confirmable || string(notification.token) != string(observe.token) || string(notification.payload) != "21.00" || seq <= first {
+		t.Fatalf("received the notification %+v with sequence %d after %d", notification, seq, first)
+	}
+	client.send(&message{kind: acknowledgement, messageId: notification.messageId})
+	waitFor(t, "the acknowledgement", func() bool {
+		server.mutex.Lock()
+		defer server.mutex.Unlock()
+		return len(server.pending) == 0
+	})
+
+	// an unacknowledged notification is retransmitted after the ACK timeout
+	importTemp(t, service, "kitchen", 22, start.Add(2*time.Hour))
+	server.notifyObservers(metric.Update{SensorId: "kitchen", Metric: metric.Temperature, Value: 22})
+	notification = client.receive()
+	retransmission, err := client.receiveWithin(2 * ackTimeout)
+	if err != nil {
+		t.Fatalf("the notification was not retransmitted: %v", err)
+	}
+	if retransmission.messageId != notification.messageId || string(retransmission.payload) != "22.00" {
+		t.Errorf("retransmitted %+v, want %+v", retransmission, notification)
+	}
+
+	// a reset cancels the observation
+	client.send(&message{kind: reset, messageId: notification.messageId})
+	waitFor(t, "the reset", func() bool {
+		server.mutex.Lock()
+		defer server.mutex.Unlock()
+		return server.observerCount == 0 && len(server.observers) == 0 && len(server.pending) == 0
+	})
+	server.notifyObservers(metric.Update{SensorId: "kitchen", Metric: metric.Temperature, Value: 22})
+	if msg, err := client.receiveWithin(200 * time.Millisecond); err == nil {
+		t.Errorf("a cancelled observer was notified with %+v", msg)
+	}
+}
+
+func TestObserveDeregistration(t *testing.T) {
+	server, service, _, client := newServer(t)
+	importTemp(t, service, "kitchen", 20, time.Now().UTC().Add(-time.Hour))
+	register := withOption(request(confirmable, codeGet, 600, "temp", "kitchen", "latest"), optionObserve, nil)
+	client.exchange(register)
+	// registering the same token again does not add an observer
+	register.messageId = 601
+	client.exchange(register)
+	server.mutex.Lock()
+	count := server.observerCount
+	server.mutex.Unlock()
+	if count != 1 {
+		t.Fatalf("%d observers registered, want 1", count)
+	}
+	deregister := withOption(request(confirmable, codeGet, 602, "temp", "kitchen", "latest"), optionObserve, uintValue(1))
+	deregister.token = register.token
+	if resp := client.exchange(deregister); resp.code != codeContent {
+		t.Errorf("a deregistration was answered with %+v", resp)
+	}
+	server.mutex.Lock()
+	count = server.observerCount
+	server.mutex.Unlock()
+	if count != 0 {
+		t.Errorf("%d observers left after deregistering", count)
+	}
+	// observing a sensor without readings registers nothing
+	unknown := withOption(request(confirmable, codeGet, 603, "temp", "hallway", "latest"), optionObserve, nil)
+	if resp := client.exchange(unknown); resp.code != codeNotFound {
+		t.Errorf("observing an unknown sensor was answered with %+v", resp)
+	}
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if server.observerCount != 0 {
+		t.Error("an observer of an unknown sensor was registered")
+	}
+}
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if condition() {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
@@ -0,0 +1,253 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Redacted - replaces the secrets of a printed configuration
+const Redacted = "REDACTED"
+
+// structTypes - yaml.v2 names the sections an unknown setting was found in by their Go types, which reads
+// poorly for anonymous structs
+var structTypes = regexp.MustCompile(` in type struct \{.*\}`)
+
+// Duration - a time.Duration written as "30s" in YAML, yaml.v2 would write nanoseconds
+type Duration time.Duration
+
+func (d Duration) Duration() time.Duration {
+	return time.Duration(d)
+}
+
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return time.Duration(d).String(), nil
+}
+
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// LoadFile decodes the YAML file at path onto cfg, a pointer to a struct. Settings missing from the file keep
+// their values, unknown ones are errors so typos do not go unnoticed
+func LoadFile(cfg interface{}, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	decoder := yaml.NewDecoder(file)
+	decoder.SetStrict(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return errors.New(structTypes.ReplaceAllString(err.Error(), ""))
+	}
+	return nil
+}
+
+// Set parses value into the setting of cfg at path, the YAML keys of its sections joined by dots, e.g.
+// "server.port". Lists of strings may be comma separated, other values are read as YAML, e.g. "30s" or "[a, b]"
+func Set(cfg interface{}, path string, value string) error {
+	field, err := lookup(reflect.ValueOf(cfg).Elem(), path)
+	if err != nil {
+		return err
+	}
+	switch {
+	case field.Kind() == reflect.Struct && !isScalar(field.Type()):
+		return fmt.Errorf("%s is a section, not a setting", path)
+	case field.Kind() == reflect.String:
+		field.SetString(value)
+		return nil
+	case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String && !strings.HasPrefix(value, "["):
+		values := reflect.MakeSlice(field.Type(), 0, 0)
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values = reflect.Append(values, reflect.ValueOf(item).Convert(field.Type().Elem()))
+			}
+		}
+		field.Set(values)
+		return nil
+	}
+	parsed := reflect.New(field.Type())
+	if err := yaml.UnmarshalStrict([]byte(value), parsed.Interface()); err != nil {
+		return fmt.Errorf("invalid value of %s: %w", path, err)
+	}
+	field.Set(parsed.Elem())
+	return nil
+}
+
+// ApplyEnv sets the settings of cfg named by the variables of environ, "NAME=value" pairs, starting with
+// prefix. The rest of the name is the path of the setting in upper snake case, e.g. SERVER_MAX_CONNECTIONS for
+// server.maxConnections. Returns the names of the variables applied, unknown ones are ignored as orchestrators
+// define variables of the same prefix
+func ApplyEnv(cfg interface{}, prefix string, environ []string) ([]string, error) {
+	paths := make(map[string]string)
+	collectPaths(reflect.ValueOf(cfg).Elem().Type(), "", paths)
+	var applied []string
+	for _, variable := range environ {
+		parts := strings.SplitN(variable, "=", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], prefix) {
+			continue
+		}
+		path, ok := paths[strings.TrimPrefix(parts[0], prefix)]
+		if !ok {
+			continue
+		}
+		if err := Set(cfg, path, parts[1]); err != nil {
+			return applied, fmt.Errorf("%s: %w", parts[0], err)
+		}
+		applied = append(applied, parts[0])
+	}
+	return applied, nil
+}
+
+// Diff returns the paths of the settings which differ between old and new, pointers to structs of a type
+func Diff(old interface{}, new interface{}) []string {
+	var paths []string
+	diff(reflect.ValueOf(old).Elem(), reflect.ValueOf(new).Elem(), "", &paths)
+	return paths
+}
+
+func diff(old reflect.Value, new reflect.Value, prefix string, paths *[]string) {
+	for i := 0; i < old.NumField(); i++ {
+		field := old.Type().Field(i)
+		path := yamlKey(field)
+		if prefix != "" {
+			path = prefix + "." + path
+		}
+		if field.Type.Kind() == reflect.Struct && !isScalar(field.Type) {
+			diff(old.Field(i), new.Field(i), path, paths)
+			continue
+		}
+		if !reflect.DeepEqual(old.Field(i).Interface(), new.Field(i).Interface()) {
+			*paths = append(*paths, path)
+		}
+	}
+}
+
+// Redact returns a copy of cfg, a pointer to a struct, with the settings tagged `secret:"true"` replaced by
+// Redacted and the passwords of the urls tagged `secret:"url"` masked. cfg is not modified
+func Redact(cfg interface{}) interface{} {
+	original := reflect.ValueOf(cfg).Elem()
+	copied := reflect.New(original.Type())
+	copied.Elem().Set(original)
+	redact(copied.Elem())
+	return copied.Interface()
+}
+
+func redact(v reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		switch v.Type().Field(i).Tag.Get("secret") {
+		case "url":
+			if parsed, err := url.Parse(field.String()); err == nil {
+				field.SetString(parsed.Redacted())
+			}
+		case "true":
+			redactValue(field)
+		default:
+			if field.Kind() == reflect.Struct {
+				redact(field)
+			}
+		}
+	}
+}
+
+// redactValue - maps are replaced rather than modified, the copy of Redact shares them with the original
+func redactValue(field reflect.Value) {
+	switch field.Kind() {
+	case reflect.String:
+		if field.String() != "" {
+			field.SetString(Redacted)
+		}
+	case reflect.Map:
+		if field.Len() == 0 {
+			return
+		}
+		redacted := reflect.MakeMapWithSize(field.Type(), field.Len())
+		for _, key := range field.MapKeys() {
+			redacted.SetMapIndex(key, reflect.ValueOf(Redacted).Convert(field.Type().Elem()))
+		}
+		field.Set(redacted)
+	}
+}
+
+func lookup(v reflect.Value, path string) (reflect.Value, error) {
+	for _, key := range strings.Split(path, ".") {
+		if v.Kind() != reflect.Struct || isScalar(v.Type()) {
+			return reflect.Value{}, fmt.Errorf("unknown setting %s", path)
+		}
+		found := false
+		for i := 0; i < v.NumField(); i++ {
+			if strings.EqualFold(yamlKey(v.Type().Field(i)), key) {
+				v = v.Field(i)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return reflect.Value{}, fmt.Errorf("unknown setting %s", path)
+		}
+	}
+	return v, nil
+}
+
+func collectPaths(t reflect.Type, prefix string, paths map[string]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		path := yamlKey(field)
+		if prefix != "" {
+			path = prefix + "." + path
+		}
+		if field.Type.Kind() == reflect.Struct && !isScalar(field.Type) {
+			collectPaths(field.Type, path, paths)
+			continue
+		}
+		paths[envName(path)] = path
+	}
+}
+
+// isScalar - structs read from a single YAML value
+func isScalar(t reflect.Type) bool {
+	return reflect.PtrTo(t).Implements(reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem())
+}
+
+func yamlKey(field reflect.StructField) string {
+	if key := strings.Split(field.Tag.Get("yaml"), ",")[0]; key != "" {
+		return key
+	}
+	return strings.ToLower(field.Name)
+}
+
+// envName - server.maxConnections is SERVER_MAX_CONNECTIONS
+func envName(path string) string {
+	var name strings.Builder
+	for _, r := range path {
+		switch {
+		case r == '.':
+			name.WriteRune('_')
+		case unicode.IsUpper(r):
+			name.WriteRune('_')
+			name.WriteRune(r)
+		default:
+			name.WriteRune(unicode.ToUpper(r))
+		}
+	}
+	return name.String()
+}
@@ -0,0 +1,81 @@
+package health
+
+import (
+	"context"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+// watchInterval - how often Watch re-runs the checks to notice changes of the serving status
+const watchInterval = 5 * time.Second
+
+// GrpcServer - the grpc.health.v1 service. The empty service name and the names of the registered services
+// report the readiness of the server, the name of a dependency, e.g. "broker", its own status
+type GrpcServer struct {
+	healthpb.UnimplementedHealthServer
+	checker  *Checker
+	services map[string]bool
+}
+
+// NewGrpcServer answers for the given service names besides "" and the dependencies of checker
+func NewGrpcServer(checker *Checker, services []string) *GrpcServer {
+	known := make(map[string]bool, len(services)+1)
+	known[""] = true
+	for _, service := range services {
+		known[service] = true
+	}
+	return &GrpcServer{checker: checker, services: known}
+}
+
+func (s *GrpcServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	servingStatus, ok := s.servingStatus(ctx, req.Service)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "unknown service %s", req.Service)
+	}
+	return &healthpb.HealthCheckResponse{Status: servingStatus}, nil
+}
+
+// Watch sends the serving status right away and then whenever it changes, unknown services are reported as
+// SERVICE_UNKNOWN
+func (s *GrpcServer) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	last := healthpb.HealthCheckResponse_ServingStatus(-1)
+	for {
+		servingStatus, ok := s.servingStatus(stream.Context(), req.Service)
+		if !ok {
+			servingStatus = healthpb.HealthCheckResponse_SERVICE_UNKNOWN
+		}
+		if servingStatus != last {
+			if err := stream.Send(&healthpb.HealthCheckResponse{Status: servingStatus}); err != nil {
+				return err
+			}
+			last = servingStatus
+		}
+		select {
+		case <-ticker.C:
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		}
+	}
+}
+
+func (s *GrpcServer) servingStatus(ctx context.Context, service string) (healthpb.HealthCheckResponse_ServingStatus, bool) {
+	if s.services[service] {
+		return toServingStatus(s.checker.Run(ctx).Ok()), true
+	}
+	result, ok := s.checker.RunOne(ctx, service)
+	if !ok {
+		return healthpb.HealthCheckResponse_SERVICE_UNKNOWN, false
+	}
+	return toServingStatus(result.Status == StatusOk), true
+}
+
+func toServingStatus(ok bool) healthpb.HealthCheckResponse_ServingStatus {
+	if ok {
+		return healthpb.HealthCheckResponse_SERVING
+	}
+	return healthpb.HealthCheckResponse_NOT_SERVING
+}
@@ -0,0 +1,102 @@
+package health
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"testing"
+	"time"
+)
+
+// newHealthClient serves the health service of checker in process
+func newHealthClient(t *testing.T, checker *Checker) healthpb.HealthClient {
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	healthpb.RegisterHealthServer(server, NewGrpcServer(checker, []string{"metric.MetricService"}))
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return healthpb.NewHealthClient(conn)
+}
+
+func TestGrpcCheck(t *testing.T) {
+	checker := NewChecker()
+	checker.Add("storage", ok)
+	checker.Add("broker", failing)
+	client := newHealthClient(t, checker)
+	tests := []struct {
+		service string
+		want    healthpb.HealthCheckResponse_ServingStatus
+	}{
+		{"", healthpb.HealthCheckResponse_NOT_SERVING},
+		{"metric.MetricService", healthpb.HealthCheckResponse_NOT_SERVING},
+		{"storage", healthpb.HealthCheckResponse_SERVING},
+		{"broker", healthpb.HealthCheckResponse_NOT_SERVING},
+	}
+	for _, test := range tests {
+		resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: test.service})
+		if err != nil {
+			t.Fatalf("Check(%q): %v", test.service, err)
+		}
+		if resp.Status != test.want {
+			t.Errorf("Check(%q) = %s, want %s", test.service, resp.Status, test.want)
+		}
+	}
+	checker.Add("broker", ok)
+	if resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{}); err != nil || resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("Check of a ready server = %v, %v", resp, err)
+	}
+	if _, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "mqtt"}); status.Code(err) != codes.NotFound {
+		t.Errorf("Check of an unknown service returned %v", err)
+	}
+	checker.ShutDown()
+	if resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "storage"}); err != nil || resp.Status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("Check after ShutDown = %v, %v", resp, err)
+	}
+}
+
+func TestGrpcWatch(t *testing.T) {
+	checker := NewChecker()
+	checker.Add("storage", ok)
+	client := newHealthClient(t, checker)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tests := []struct {
+		service string
+		want    healthpb.HealthCheckResponse_ServingStatus
+	}{
+		{"", healthpb.HealthCheckResponse_SERVING},
+		{"storage", healthpb.HealthCheckResponse_SERVING},
+		// unknown services are watched rather than rejected, they may be registered later
+		{"mqtt", healthpb.HealthCheckResponse_SERVICE_UNKNOWN},
+	}
+	for _, test := range tests {
+		watchCtx, stopWatching := context.WithCancel(ctx)
+		stream, err := client.Watch(watchCtx, &healthpb.HealthCheckRequest{Service: test.service})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("Watch(%q): %v", test.service, err)
+		}
+		if resp.Status != test.want {
+			t.Errorf("Watch(%q) sent %s, want %s", test.service, resp.Status, test.want)
+		}
+		stopWatching()
+		if _, err := stream.Recv(); status.Code(err) != codes.Canceled {
+			t.Errorf("Watch(%q) after the client stopped watching returned %v", test.service, err)
+		}
+	}
+}
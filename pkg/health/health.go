@@ -0,0 +1,150 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	StatusOk   = "ok"
+	StatusFail = "fail"
+	// checkTimeout - how long a dependency may take to answer before it counts as failed
+	checkTimeout = 2 * time.Second
+)
+
+// ErrShuttingDown - the server stopped accepting work, probes fail so it is taken out of load balancing
+var ErrShuttingDown = errors.New("the server is shutting down")
+
+// Check reports whether a dependency is usable, nil if it is
+type Check func(ctx context.Context) error
+
+// Result - the outcome of the check of a dependency
+type Result struct {
+	Status     string  `json:"status"`
+	Error      string  `json:"error,omitempty"`
+	DurationMs float64 `json:"durationMs"`
+}
+
+// Report - the readiness of the server, it is ready if every dependency is
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+func (r Report) Ok() bool {
+	return r.Status == StatusOk
+}
+
+// Checker - runs the readiness checks of the dependencies of the server
+type Checker struct {
+	mutex        sync.RWMutex
+	checks       map[string]Check
+	shuttingDown bool
+}
+
+func NewChecker() *Checker {
+	return &Checker{checks: make(map[string]Check)}
+}
+
+// Add registers the check of a dependency, a check added under an existing name replaces it
+func (c *Checker) Add(name string, check Check) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.checks[name] = check
+}
+
+// Names returns the names of the dependencies in order
+func (c *Checker) Names() []string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	names := make([]string, 0, len(c.checks))
+	for name := range c.checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ShutDown fails every following check with ErrShuttingDown
+func (c *Checker) ShutDown() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.shuttingDown = true
+}
+
+// Run checks all dependencies concurrently, each within checkTimeout
+func (c *Checker) Run(ctx context.Context) Report {
+	c.mutex.RLock()
+	checks := make(map[string]Check, len(c.checks))
+	for name, check := range c.checks {
+		checks[name] = check
+	}
+	shuttingDown := c.shuttingDown
+	c.mutex.RUnlock()
+	report := Report{Status: StatusOk, Checks: make(map[string]Result, len(checks))}
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+			result := run(ctx, check, shuttingDown)
+			mutex.Lock()
+			defer mutex.Unlock()
+			report.Checks[name] = result
+			if result.Status != StatusOk {
+				report.Status = StatusFail
+			}
+		}(name, check)
+	}
+	wg.Wait()
+	if shuttingDown {
+		report.Status = StatusFail
+	}
+	return report
+}
+
+// RunOne checks a single dependency, ok is false if there is none of that name
+func (c *Checker) RunOne(ctx context.Context, name string) (Result, bool) {
+	c.mutex.RLock()
+	check, ok := c.checks[name]
+	shuttingDown := c.shuttingDown
+	c.mutex.RUnlock()
+	if !ok {
+		return Result{}, false
+	}
+	return run(ctx, check, shuttingDown), true
+}
+
+func run(ctx context.Context, check Check, shuttingDown bool) Result {
+	start := time.Now()
+	err := ErrShuttingDown
+	if !shuttingDown {
+		checkCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+		err = runWithin(checkCtx, check)
+		cancel()
+	}
+	result := Result{Status: StatusOk, DurationMs: float64(time.Since(start)) / float64(time.Millisecond)}
+	if err != nil {
+		result.Status = StatusFail
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// runWithin - checks do not have to honour ctx, a check still running at the deadline fails
+func runWithin(ctx context.Context, check Check) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- check(ctx)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,112 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func ok(ctx context.Context) error {
+	return nil
+}
+
+func failing(ctx context.Context) error {
+	return errors.New("connection refused")
+}
+
+func TestRunReportsEveryDependency(t *testing.T) {
+	checker := NewChecker()
+	checker.Add("storage", ok)
+	checker.Add("cache", ok)
+	report := checker.Run(context.Background())
+	if !report.Ok() || len(report.Checks) != 2 || report.Checks["cache"].Status != StatusOk {
+		t.Errorf("Run = %+v", report)
+	}
+	checker.Add("broker", failing)
+	report = checker.Run(context.Background())
+	if report.Ok() || report.Status != StatusFail {
+		t.Errorf("Run with a failing dependency = %+v", report)
+	}
+	if result := report.Checks["broker"]; result.Status != StatusFail || result.Error != "connection refused" {
+		t.Errorf("the broker result is %+v", result)
+	}
+	if result := report.Checks["storage"]; result.Status != StatusOk || result.Error != "" {
+		t.Errorf("the storage result is %+v", result)
+	}
+	if names := checker.Names(); fmt.Sprint(names) != "[broker cache storage]" {
+		t.Errorf("Names = %v", names)
+	}
+	// a check added under an existing name replaces it
+	checker.Add("broker", ok)
+	if report := checker.Run(context.Background()); !report.Ok() {
+		t.Errorf("Run after replacing the broker check = %+v", report)
+	}
+}
+
+func TestChecksStillRunningAtTheDeadlineFail(t *testing.T) {
+	checker := NewChecker()
+	release := make(chan struct{})
+	defer close(release)
+	// the check ignores its context
+	checker.Add("broker", func(ctx context.Context) error {
+		<-release
+		return nil
+	})
+	checker.Add("storage", ok)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	report := checker.Run(ctx)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Run took %s", elapsed)
+	}
+	if result := report.Checks["broker"]; report.Ok() || result.Error != context.DeadlineExceeded.Error() {
+		t.Errorf("Run = %+v", report)
+	}
+	if result := report.Checks["storage"]; result.Status != StatusOk {
+		t.Errorf("the storage result is %+v", result)
+	}
+}
+
+func TestRunOne(t *testing.T) {
+	checker := NewChecker()
+	checker.Add("broker", failing)
+	checker.Add("storage", ok)
+	if result, found := checker.RunOne(context.Background(), "storage"); !found || result.Status != StatusOk {
+		t.Errorf("RunOne(storage) = %+v, %v", result, found)
+	}
+	if result, found := checker.RunOne(context.Background(), "broker"); !found || result.Status != StatusFail {
+		t.Errorf("RunOne(broker) = %+v, %v", result, found)
+	}
+	if _, found := checker.RunOne(context.Background(), "mqtt"); found {
+		t.Error("RunOne found an unknown dependency")
+	}
+}
+
+func TestShutDownFailsEveryCheck(t *testing.T) {
+	checker := NewChecker()
+	called := false
+	checker.Add("storage", func(ctx context.Context) error {
+		called = true
+		return nil
+	})
+	checker.ShutDown()
+	report := checker.Run(context.Background())
+	if report.Ok() || report.Checks["storage"].Error != ErrShuttingDown.Error() {
+		t.Errorf("Run after ShutDown = %+v", report)
+	}
+	if result, _ := checker.RunOne(context.Background(), "storage"); result.Status != StatusFail {
+		t.Errorf("RunOne after ShutDown = %+v", result)
+	}
+	if called {
+		t.Error("a dependency was checked after ShutDown")
+	}
+	// a server without dependencies is not ready either while it shuts down
+	empty := NewChecker()
+	empty.ShutDown()
+	if empty.Run(context.Background()).Ok() {
+		t.Error("a checker without dependencies reported ready after ShutDown")
+	}
+}
@@ -0,0 +1,213 @@
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/andreikom/sensor-server/pkg/api/metric"
+	"github.com/andreikom/sensor-server/pkg/logging"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// maxDatagramSize - readings are tiny, anything larger is not a reading
+	maxDatagramSize = 1024
+	maxLineSize     = 1024
+	tcpIdleTimeout  = 5 * time.Minute
+	// signedReadingWindow - how far the timestamp of a signed reading may be from the server clock,
+	// older signed readings are rejected as replays and newer ones are remembered by their signature
+	// so the same reading is accepted once
+	signedReadingWindow = 5 * time.Minute
+)
+
+var errRateLimited = errors.New("rate limited")
+
+// Options - the addresses of the listeners, an empty address disables a listener
+type Options struct {
+	UdpAddress string
+	TcpAddress string
+	// Secret - when set, every reading has to be signed with an HMAC-SHA256 of this secret and carry a timestamp
+	Secret []byte
+	// RatePerSecond and Burst limit the readings accepted from a single source address
+	RatePerSecond float64
+	Burst         int
+}
+
+// Listener - receives compact readings from constrained devices over UDP datagrams and TCP lines and
+// publishes them like SaveTemperature, readings without a metric are temperatures
+type Listener struct {
+	metricService *metric.MetricService
+	options       Options
+	limiter       *sourceLimiter
+	replays       *replayCache
+	mutex         sync.Mutex
+	udpConn       net.PacketConn
+	tcpListener   net.Listener
+	closed        bool
+}
+
+func NewListener(metricService *metric.MetricService, options Options) *Listener {
+	return &Listener{metricService: metricService, options: options, limiter: newSourceLimiter(options.RatePerSecond, options.Burst),
+		replays: newReplayCache(signedReadingWindow)}
+}
+
+// ServeUdp receives datagrams until Close is called, every datagram holds a single text or CBOR reading
+func (l *Listener) ServeUdp() error {
+	conn, err := net.ListenPacket("udp", l.options.UdpAddress)
+	if err != nil {
+		return err
+	}
+	l.mutex.Lock()
+	l.udpConn = conn
+	l.mutex.Unlock()
+	logging.Log.WithField("address", l.options.UdpAddress).Info("Starting the UDP listener")
+	buf := make([]byte, maxDatagramSize+1)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if l.isClosed() {
+				return nil
+			}
+			return err
+		}
+		if n > maxDatagramSize {
+			continue
+		}
+		if err := l.handle(sourceOf(addr), buf[:n]); err != nil && err != errRateLimited {
+			logging.Log.WithError(err).WithField("source", addr.String()).Warn("Dropping a UDP reading")
+		}
+	}
+}
+
+// ServeTcp accepts connections until Close is called, every line holds a single text reading
+// and is answered with "ok" or "error: <reason>"
+func (l *Listener) ServeTcp() error {
+	listener, err := net.Listen("tcp", l.options.TcpAddress)
+	if err != nil {
+		return err
+	}
+	l.mutex.Lock()
+	l.tcpListener = listener
+	l.mutex.Unlock()
+	logging.Log.WithField("address", l.options.TcpAddress).Info("Starting the TCP listener")
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if l.isClosed() {
+				return nil
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Temporary() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go l.serveConn(conn)
+	}
+}
+
+func (l *Listener) serveConn(conn net.Conn) {
+	defer conn.Close()
+	source := sourceOf(conn.RemoteAddr())
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, maxLineSize), maxLineSize)
+	writer := bufio.NewWriter(conn)
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(tcpIdleTimeout))
+		if !scanner.Scan() {
+			return
+		}
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		if err := l.handle(source, line); err != nil {
+			_, _ = fmt.Fprintf(writer, "error: %s\n", err)
+		} else {
+			_, _ = writer.WriteString("ok\n")
+		}
+		if writer.Flush() != nil {
+			return
+		}
+	}
+}
+
+// Close stops both listeners
+func (l *Listener) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.closed = true
+	var err error
+	if l.udpConn != nil {
+		err = l.udpConn.Close()
+	}
+	if l.tcpListener != nil {
+		if tcpErr := l.tcpListener.Close(); tcpErr != nil {
+			err = tcpErr
+		}
+	}
+	return err
+}
+
+func (l *Listener) isClosed() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.closed
+}
+
+func (l *Listener) handle(source string, data []byte) error {
+	if !l.limiter.allow(source) {
+		return errRateLimited
+	}
+	reading, err := Decode(data, l.options.Secret)
+	if err != nil {
+		return err
+	}
+	if len(l.options.Secret) > 0 {
+		if reading.Time.IsZero() {
+			return errors.New("signed readings need a timestamp")
+		}
+		if skew := time.Since(reading.Time); skew > signedReadingWindow || skew < -signedReadingWindow {
+			return fmt.Errorf("timestamp of a signed reading is more than %s off", signedReadingWindow)
+		}
+	}
+	if reading.Metric == "" {
+		reading.Metric = metric.Temperature
+	}
+	unit, err := metric.ParseUnit(reading.Metric, reading.Unit)
+	if err != nil {
+		return err
+	}
+	if reading.signature != nil {
+		if err := l.replays.add(reading.signature, reading.Time); err != nil {
+			return err
+		}
+	}
+	if err := l.save(reading, unit); err != nil {
+		if reading.signature != nil {
+			l.replays.remove(reading.signature)
+		}
+		return err
+	}
+	return nil
+}
+
+func (l *Listener) save(reading *Reading, unit metric.Unit) error {
+	if reading.Time.IsZero() {
+		return l.metricService.SaveMetric(context.Background(), reading.SensorId, reading.Metric, reading.Value, unit)
+	}
+	return l.metricService.SaveMetricAt(context.Background(), reading.SensorId, reading.Metric, reading.Value, unit, reading.Time)
+}
+
+// sourceOf - readings are rate limited per host, a device reconnecting from a new port is the same source
+func sourceOf(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
@@ -0,0 +1,235 @@
+package ingest
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/andreikom/sensor-server/pkg/api/metric"
+	"github.com/andreikom/sensor-server/pkg/api/metric/metrictest"
+	"github.com/andreikom/sensor-server/pkg/broker"
+	"github.com/andreikom/sensor-server/pkg/storage"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandleStoresReadings(t *testing.T) {
+	service, spool := metrictest.NewSpoolingService(t)
+	listener := NewListener(service, Options{Burst: 100})
+	cbor := encodeCbor(t, map[string]interface{}{"id": "bathroom", "v": 65, "m": metric.Humidity})
+	for _, data := range [][]byte{[]byte("kitchen 70.7:F"), cbor} {
+		if err := listener.handle("10.0.0.1", data); err != nil {
+			t.Fatalf("handle(%q) returned %v", data, err)
+		}
+	}
+	readings := metrictest.SpooledReadings(t, spool)
+	if len(readings) != 2 {
+		t.Fatalf("stored %d readings, want 2", len(readings))
+	}
+	if r := readings[0]; r.SensorId != "kitchen" || r.Metric != metric.Temperature || !metrictest.Near(r.Value, 21.5) {
+		t.Errorf("stored %+v, want 21.5 degrees Celsius of kitchen", r)
+	}
+	if r := readings[1]; r.SensorId != "bathroom" || r.Metric != metric.Humidity || r.Value != 65 {
+		t.Errorf("stored %+v, want 65%% humidity of bathroom", r)
+	}
+	for _, data := range []string{"kitchen 21:ppm", "../etc 21", "kitchen warm"} {
+		if err := listener.handle("10.0.0.1", []byte(data)); err == nil {
+			t.Errorf("handle(%q) accepted an invalid reading", data)
+		}
+	}
+}
+
+func TestHandleRejectsReplays(t *testing.T) {
+	service, spool := metrictest.NewSpoolingService(t)
+	listener := NewListener(service, Options{Secret: testSecret, Burst: 100})
+	now := time.Now().Unix()
+	line := signText(fmt.Sprintf("kitchen 21.5 %d", now))
+	if err := listener.handle("10.0.0.1", []byte(line)); err != nil {
+		t.Fatal(err)
+	}
+	if err := listener.handle("10.0.0.2", []byte(line)); err != errReplayed {
+		t.Errorf("a replayed text reading returned %v, want %v", err, errReplayed)
+	}
+	payload := encodeCbor(t, map[string]interface{}{"id": "kitchen", "v": 21.5, "t": now})
+	datagram := append(payload, sign(payload)...)
+	if err := listener.handle("10.0.0.1", datagram); err != nil {
+		t.Fatal(err)
+	}
+	if err := listener.handle("10.0.0.1", datagram); err != errReplayed {
+		t.Errorf("a replayed CBOR reading returned %v, want %v", err, errReplayed)
+	}
+	if readings := metrictest.SpooledReadings(t, spool); len(readings) != 2 {
+		t.Errorf("stored %d readings, want 2", len(readings))
+	}
+
+	for name, line := range map[string]string{
+		"without a timestamp": signText("kitchen 21.5"),
+		"too old":             signText(fmt.Sprintf("kitchen 21.5 %d", now-int64(2*signedReadingWindow/time.Second))),
+		"too far ahead":       signText(fmt.Sprintf("kitchen 21.5 %d", now+int64(2*signedReadingWindow/time.Second))),
+		"unsigned":            fmt.Sprintf("kitchen 21.5 %d", now),
+	} {
+		if err := listener.handle("10.0.0.1", []byte(line)); err == nil {
+			t.Errorf("a signed reading %s was accepted", name)
+		}
+	}
+}
+
+func TestHandleForgetsReadingsNotStored(t *testing.T) {
+	dir := t.TempDir()
+	service := metric.NewMetricService(storage.NewFSDriver(dir), broker.NewClient(broker.Options{}, nil), metric.Options{})
+	t.Cleanup(service.Close)
+	listener := NewListener(service, Options{Secret: testSecret, Burst: 100})
+	line := signText(fmt.Sprintf("kitchen 21.5 %d", time.Now().Unix()))
+	for i := 0; i < 2; i++ {
+		if err := listener.handle("10.0.0.1", []byte(line)); err == nil || err == errReplayed {
+			t.Fatalf("attempt %d returned %v, want the error of the broker", i+1, err)
+		}
+	}
+}
+
+func TestHandleRateLimitsSources(t *testing.T) {
+	service, spool := metrictest.NewSpoolingService(t)
+	listener := NewListener(service, Options{RatePerSecond: 0.001, Burst: 2})
+	for i := 0; i < 2; i++ {
+		if err := listener.handle("10.0.0.1", []byte("kitchen 21")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := listener.handle("10.0.0.1", []byte("kitchen 21")); err != errRateLimited {
+		t.Errorf("a reading over the burst returned %v, want %v", err, errRateLimited)
+	}
+	if err := listener.handle("10.0.0.2", []byte("kitchen 21")); err != nil {
+		t.Errorf("another source was limited: %v", err)
+	}
+	if readings := metrictest.SpooledReadings(t, spool); len(readings) != 3 {
+		t.Errorf("stored %d readings, want 3", len(readings))
+	}
+}
+
+func TestReplayCache(t *testing.T) {
+	cache := newReplayCache(time.Minute)
+	now := time.Now()
+	if err := cache.add([]byte("a"), now); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.add([]byte("a"), now); err != errReplayed {
+		t.Errorf("a seen signature returned %v, want %v", err, errReplayed)
+	}
+	// a reading older than the window would be rejected by its timestamp, its signature is forgotten
+	if err := cache.add([]byte("b"), now.Add(-2*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.add([]byte("b"), now.Add(-2*time.Minute)); err != nil {
+		t.Errorf("an expired signature returned %v", err)
+	}
+	cache.remove([]byte("a"))
+	if err := cache.add([]byte("a"), now); err != nil {
+		t.Errorf("a removed signature returned %v", err)
+	}
+}
+
+func TestReplayCacheIsBounded(t *testing.T) {
+	cache := newReplayCache(time.Minute)
+	now := time.Now()
+	for i := 0; i < maxSeenSignatures; i++ {
+		cache.expires[fmt.Sprint(i)] = now.Add(time.Minute)
+	}
+	if err := cache.add([]byte("new"), now); err != errTooManyReadings {
+		t.Errorf("a full cache returned %v, want %v", err, errTooManyReadings)
+	}
+	for i := 0; i < 10; i++ {
+		cache.expires[fmt.Sprint(i)] = now.Add(-time.Second)
+	}
+	if err := cache.add([]byte("new"), now); err != nil {
+		t.Fatal(err)
+	}
+	if len(cache.expires) != maxSeenSignatures-9 {
+		t.Errorf("kept %d signatures, want the expired ones swept", len(cache.expires))
+	}
+}
+
+func TestServeUdpAndTcp(t *testing.T) {
+	service, spool := metrictest.NewSpoolingService(t)
+	listener := NewListener(service, Options{UdpAddress: "127.0.0.1:0", TcpAddress: "127.0.0.1:0", Burst: 100})
+	udpDone := make(chan error, 1)
+	tcpDone := make(chan error, 1)
+	go func() { udpDone <- listener.ServeUdp() }()
+	go func() { tcpDone <- listener.ServeTcp() }()
+	udpAddress, tcpAddress := listenerAddresses(t, listener)
+
+	udp, err := net.Dial("udp", udpAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+	if _, err := udp.Write([]byte("kitchen 21.5\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	tcp, err := net.Dial("tcp", tcpAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcp.Close()
+	replies := bufio.NewReader(tcp)
+	for _, test := range []struct{ line, reply string }{
+		{"hallway 19", "ok"},
+		{"hallway warm", "error: invalid value 'warm'"},
+		{"hallway 66:F", "ok"},
+	} {
+		if _, err := fmt.Fprintf(tcp, "%s\n", test.line); err != nil {
+			t.Fatal(err)
+		}
+		_ = tcp.SetReadDeadline(time.Now().Add(5 * time.Second))
+		reply, err := replies.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.TrimSpace(reply) != test.reply {
+			t.Errorf("%q was answered with %q, want %q", test.line, strings.TrimSpace(reply), test.reply)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	stored := make(map[string]int)
+	for len(stored) < 2 && time.Now().Before(deadline) {
+		for _, reading := range metrictest.SpooledReadings(t, spool) {
+			stored[reading.SensorId]++
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if stored["kitchen"] != 1 || stored["hallway"] != 2 {
+		t.Errorf("stored %v, want a kitchen and two hallway readings", stored)
+	}
+
+	if err := listener.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, done := range []chan error{udpDone, tcpDone} {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("a listener returned %v after Close", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("a listener did not stop after Close")
+		}
+	}
+}
+
+// listenerAddresses waits for both listeners to be bound
+func listenerAddresses(t *testing.T, l *Listener) (string, string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		l.mutex.Lock()
+		udpConn, tcpListener := l.udpConn, l.tcpListener
+		l.mutex.Unlock()
+		if udpConn != nil && tcpListener != nil {
+			return udpConn.LocalAddr().String(), tcpListener.Addr().String()
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("the listeners did not start")
+	return "", ""
+}
@@ -0,0 +1,154 @@
+package ingest
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/fxamacker/cbor/v2"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// signaturePrefix - the last field of a signed text reading: "s1 21.5 1630000000 sig=<hex HMAC-SHA256>",
+	// the HMAC covers the bytes before the space preceding it
+	signaturePrefix = "sig="
+	// cborSignatureSize - a signed CBOR reading is followed by the raw HMAC-SHA256 of its bytes
+	cborSignatureSize = sha256.Size
+)
+
+var errMissingSignature = errors.New("missing signature")
+
+// Reading - a reading decoded from a datagram or a line, a zero Time means the time it was received
+type Reading struct {
+	SensorId string
+	Metric   string
+	Value    float64
+	Unit     string
+	Time     time.Time
+	// signature - the HMAC of a signed reading, the listener remembers it to reject replays
+	signature []byte
+}
+
+// cborReading - the CBOR map of a reading, short keys keep datagrams of constrained devices small
+type cborReading struct {
+	SensorId string      `cbor:"id"`
+	Value    *float64    `cbor:"v"`
+	Time     interface{} `cbor:"t,omitempty"`
+	Metric   string      `cbor:"m,omitempty"`
+	Unit     string      `cbor:"u,omitempty"`
+}
+
+// decodeText parses "sensorId value [timestamp]", the value may carry a unit suffix separated by a colon
+// ("21.5:F") and the timestamp is in unix seconds
+func decodeText(line string, secret []byte) (*Reading, error) {
+	line = strings.TrimSpace(line)
+	var signature []byte
+	if len(secret) > 0 {
+		i := strings.LastIndex(line, " "+signaturePrefix)
+		if i < 0 {
+			return nil, errMissingSignature
+		}
+		var err error
+		signature, err = hex.DecodeString(line[i+len(signaturePrefix)+1:])
+		if err != nil || !validSignature(secret, []byte(line[:i]), signature) {
+			return nil, errors.New("invalid signature")
+		}
+		line = line[:i]
+	}
+	reading, err := parseText(line)
+	if err != nil {
+		return nil, err
+	}
+	reading.signature = signature
+	return reading, nil
+}
+
+func parseText(line string) (*Reading, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || len(fields) > 3 {
+		return nil, fmt.Errorf("expected 'sensorId value [timestamp]', got '%s'", line)
+	}
+	reading := &Reading{SensorId: fields[0]}
+	rawValue := fields[1]
+	if i := strings.IndexByte(rawValue, ':'); i >= 0 {
+		rawValue, reading.Unit = rawValue[:i], rawValue[i+1:]
+	}
+	value, err := strconv.ParseFloat(rawValue, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value '%s'", fields[1])
+	}
+	reading.Value = value
+	if len(fields) == 3 {
+		seconds, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp '%s'", fields[2])
+		}
+		reading.Time = unixTime(seconds)
+	}
+	return reading, nil
+}
+
+// decodeCbor parses a CBOR map {"id": sensorId, "v": value, "t": timestamp, "m": metric, "u": unit},
+// the timestamp is in unix seconds or a CBOR time tag
+func decodeCbor(data []byte, secret []byte) (*Reading, error) {
+	var signature []byte
+	if len(secret) > 0 {
+		if len(data) <= cborSignatureSize {
+			return nil, errMissingSignature
+		}
+		payload := data[:len(data)-cborSignatureSize]
+		signature = data[len(data)-cborSignatureSize:]
+		if !validSignature(secret, payload, signature) {
+			return nil, errors.New("invalid signature")
+		}
+		data = payload
+	}
+	decoded := cborReading{}
+	if err := cbor.Unmarshal(data, &decoded); err != nil {
+		return nil, fmt.Errorf("invalid cbor payload: %w", err)
+	}
+	if decoded.SensorId == "" || decoded.Value == nil {
+		return nil, errors.New("cbor payload needs 'id' and 'v'")
+	}
+	reading := &Reading{SensorId: decoded.SensorId, Metric: decoded.Metric, Value: *decoded.Value, Unit: decoded.Unit, signature: signature}
+	switch t := decoded.Time.(type) {
+	case nil:
+	case time.Time:
+		reading.Time = t
+	case uint64:
+		reading.Time = time.Unix(int64(t), 0)
+	case int64:
+		reading.Time = time.Unix(t, 0)
+	case float64:
+		reading.Time = unixTime(t)
+	default:
+		return nil, fmt.Errorf("invalid cbor timestamp %v", t)
+	}
+	return reading, nil
+}
+
+// Decode tells CBOR from text by the first byte, text readings start with a printable sensor id
+// while a CBOR map starts with a major type 5 header
+func Decode(data []byte, secret []byte) (*Reading, error) {
+	if len(data) > 0 && data[0]>>5 == 5 {
+		return decodeCbor(data, secret)
+	}
+	return decodeText(string(bytes.TrimRight(data, "\r\n")), secret)
+}
+
+func validSignature(secret []byte, payload []byte, signature []byte) bool {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return hmac.Equal(mac.Sum(nil), signature)
+}
+
+func unixTime(seconds float64) time.Time {
+	whole, fraction := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(fraction*float64(time.Second)))
+}
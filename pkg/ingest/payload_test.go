@@ -0,0 +1,127 @@
+package ingest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/fxamacker/cbor/v2"
+	"testing"
+	"time"
+)
+
+var testSecret = []byte("s3cret")
+
+func sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, testSecret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+func signText(line string) string {
+	return fmt.Sprintf("%s %s%s", line, signaturePrefix, hex.EncodeToString(sign([]byte(line))))
+}
+
+func encodeCbor(t *testing.T, reading map[string]interface{}) []byte {
+	t.Helper()
+	data, err := cbor.Marshal(reading)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestDecodeText(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Reading
+	}{
+		{"kitchen 21.5", Reading{SensorId: "kitchen", Value: 21.5}},
+		{"kitchen 70.7:F\r\n", Reading{SensorId: "kitchen", Value: 70.7, Unit: "F"}},
+		{"kitchen -3 1630000000", Reading{SensorId: "kitchen", Value: -3, Time: time.Unix(1630000000, 0)}},
+		{"kitchen 21 1630000000.5", Reading{SensorId: "kitchen", Value: 21, Time: time.Unix(1630000000, int64(500*time.Millisecond))}},
+	}
+	for _, test := range tests {
+		got, err := Decode([]byte(test.input), nil)
+		if err != nil {
+			t.Errorf("Decode(%q) returned %v", test.input, err)
+			continue
+		}
+		if got.SensorId != test.want.SensorId || got.Value != test.want.Value || got.Unit != test.want.Unit || !got.Time.Equal(test.want.Time) {
+			t.Errorf("Decode(%q) = %+v, want %+v", test.input, *got, test.want)
+		}
+	}
+	for _, input := range []string{"", "kitchen", "kitchen warm", "kitchen 21 yesterday", "kitchen 21 1630000000 extra"} {
+		if _, err := Decode([]byte(input), nil); err == nil {
+			t.Errorf("Decode(%q) accepted an invalid reading", input)
+		}
+	}
+}
+
+func TestDecodeCbor(t *testing.T) {
+	data := encodeCbor(t, map[string]interface{}{"id": "kitchen", "v": 40.5, "t": 1630000000, "m": "humidity", "u": "%"})
+	got, err := Decode(data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.SensorId != "kitchen" || got.Value != 40.5 || got.Metric != "humidity" || got.Unit != "%" || !got.Time.Equal(time.Unix(1630000000, 0)) {
+		t.Errorf("decoded %+v", *got)
+	}
+	fractional := encodeCbor(t, map[string]interface{}{"id": "kitchen", "v": 21, "t": 1630000000.25})
+	if got, err := Decode(fractional, nil); err != nil || !got.Time.Equal(time.Unix(1630000000, int64(250*time.Millisecond))) {
+		t.Errorf("decoded a fractional timestamp as %v, %v", got, err)
+	}
+	for name, invalid := range map[string][]byte{
+		"missing value": encodeCbor(t, map[string]interface{}{"id": "kitchen"}),
+		"missing id":    encodeCbor(t, map[string]interface{}{"v": 21}),
+		"bad timestamp": encodeCbor(t, map[string]interface{}{"id": "kitchen", "v": 21, "t": "yesterday"}),
+		"truncated":     data[:len(data)-2],
+	} {
+		if _, err := Decode(invalid, nil); err == nil {
+			t.Errorf("%s: accepted an invalid payload", name)
+		}
+	}
+}
+
+func TestDecodeSignedText(t *testing.T) {
+	line := signText("kitchen 21.5 1630000000")
+	got, err := Decode([]byte(line), testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.SensorId != "kitchen" || got.Value != 21.5 || !hmac.Equal(got.signature, sign([]byte("kitchen 21.5 1630000000"))) {
+		t.Errorf("decoded %+v", *got)
+	}
+	tampered := signText("kitchen 21.5 1630000000")
+	tampered = "kitchen 31.5" + tampered[len("kitchen 21.5"):]
+	for name, input := range map[string]string{
+		"unsigned": "kitchen 21.5 1630000000",
+		"tampered": tampered,
+		"not hex":  "kitchen 21.5 1630000000 sig=zz",
+	} {
+		if _, err := Decode([]byte(input), testSecret); err == nil {
+			t.Errorf("%s: accepted %q", name, input)
+		}
+	}
+	if got, err := Decode([]byte("kitchen 21.5"), nil); err != nil || got.signature != nil {
+		t.Errorf("an unsigned reading without a secret decoded as %v, %v", got, err)
+	}
+}
+
+func TestDecodeSignedCbor(t *testing.T) {
+	payload := encodeCbor(t, map[string]interface{}{"id": "kitchen", "v": 21.5, "t": 1630000000})
+	got, err := Decode(append(append([]byte{}, payload...), sign(payload)...), testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.SensorId != "kitchen" || !hmac.Equal(got.signature, sign(payload)) {
+		t.Errorf("decoded %+v", *got)
+	}
+	if _, err := Decode(payload, testSecret); err == nil {
+		t.Error("accepted an unsigned payload")
+	}
+	forged := append(append([]byte{}, payload...), sign([]byte("something else"))...)
+	if _, err := Decode(forged, testSecret); err == nil {
+		t.Error("accepted a payload with a signature of other bytes")
+	}
+}
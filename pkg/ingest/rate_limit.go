@@ -0,0 +1,59 @@
+package ingest
+
+import (
+	"golang.org/x/time/rate"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRatePerSecond = 1.0
+	defaultBurst         = 10
+	// limiterIdleTimeout - limiters of sources which were quiet this long are dropped
+	limiterIdleTimeout = 10 * time.Minute
+)
+
+// sourceLimiter - a token bucket per source address
+type sourceLimiter struct {
+	limit     rate.Limit
+	burst     int
+	mutex     sync.Mutex
+	limiters  map[string]*sourceBucket
+	lastSweep time.Time
+}
+
+type sourceBucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newSourceLimiter(ratePerSecond float64, burst int) *sourceLimiter {
+	if ratePerSecond <= 0 {
+		ratePerSecond = defaultRatePerSecond
+	}
+	if burst <= 0 {
+		burst = defaultBurst
+	}
+	return &sourceLimiter{limit: rate.Limit(ratePerSecond), burst: burst, limiters: make(map[string]*sourceBucket), lastSweep: time.Now()}
+}
+
+func (s *sourceLimiter) allow(source string) bool {
+	now := time.Now()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if now.Sub(s.lastSweep) > limiterIdleTimeout {
+		for key, bucket := range s.limiters {
+			if now.Sub(bucket.lastSeen) > limiterIdleTimeout {
+				delete(s.limiters, key)
+			}
+		}
+		s.lastSweep = now
+	}
+	bucket, ok := s.limiters[source]
+	if !ok {
+		bucket = &sourceBucket{limiter: rate.NewLimiter(s.limit, s.burst)}
+		s.limiters[source] = bucket
+	}
+	bucket.lastSeen = now
+	return bucket.limiter.AllowN(now, 1)
+}
@@ -0,0 +1,43 @@
+package ingest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSourceLimiter(t *testing.T) {
+	limiter := newSourceLimiter(0.001, 3)
+	for i := 0; i < 3; i++ {
+		if !limiter.allow("10.0.0.1") {
+			t.Fatalf("reading %d within the burst was limited", i+1)
+		}
+	}
+	if limiter.allow("10.0.0.1") {
+		t.Error("a reading over the burst was allowed")
+	}
+	if !limiter.allow("10.0.0.2") {
+		t.Error("another source shares the bucket")
+	}
+}
+
+func TestSourceLimiterDefaults(t *testing.T) {
+	limiter := newSourceLimiter(0, 0)
+	if limiter.limit != defaultRatePerSecond || limiter.burst != defaultBurst {
+		t.Errorf("defaults %v/%d, want %v/%d", limiter.limit, limiter.burst, defaultRatePerSecond, defaultBurst)
+	}
+}
+
+func TestSourceLimiterDropsIdleSources(t *testing.T) {
+	limiter := newSourceLimiter(1, 1)
+	limiter.allow("10.0.0.1")
+	limiter.allow("10.0.0.2")
+	limiter.limiters["10.0.0.1"].lastSeen = time.Now().Add(-2 * limiterIdleTimeout)
+	limiter.lastSweep = time.Now().Add(-2 * limiterIdleTimeout)
+	limiter.allow("10.0.0.2")
+	if _, ok := limiter.limiters["10.0.0.1"]; ok {
+		t.Error("the limiter of an idle source was kept")
+	}
+	if _, ok := limiter.limiters["10.0.0.2"]; !ok {
+		t.Error("the limiter of an active source was dropped")
+	}
+}
@@ -0,0 +1,58 @@
+package ingest
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// maxSeenSignatures - bounds the signatures remembered within the window, a source signing more
+// readings than this every few minutes is rejected until older signatures expire
+const maxSeenSignatures = 100000
+
+var (
+	errReplayed        = errors.New("signed reading was already received")
+	errTooManyReadings = errors.New("too many signed readings within the replay window")
+)
+
+// replayCache - the signatures of signed readings accepted within the window, a reading is only accepted
+// while its timestamp is within the window, so a signature can be forgotten once the window passed it
+type replayCache struct {
+	window  time.Duration
+	mutex   sync.Mutex
+	expires map[string]time.Time
+}
+
+func newReplayCache(window time.Duration) *replayCache {
+	return &replayCache{window: window, expires: make(map[string]time.Time)}
+}
+
+// add - remembers the signature of a reading taken at the given time, fails when it was seen before
+func (c *replayCache) add(signature []byte, at time.Time) error {
+	now := time.Now()
+	key := string(signature)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if expires, ok := c.expires[key]; ok && now.Before(expires) {
+		return errReplayed
+	}
+	if len(c.expires) >= maxSeenSignatures {
+		for seen, expires := range c.expires {
+			if !now.Before(expires) {
+				delete(c.expires, seen)
+			}
+		}
+		if len(c.expires) >= maxSeenSignatures {
+			return errTooManyReadings
+		}
+	}
+	c.expires[key] = at.Add(c.window)
+	return nil
+}
+
+// remove - forgets a signature, so a reading which could not have been stored may be sent again
+func (c *replayCache) remove(signature []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.expires, string(signature))
+}
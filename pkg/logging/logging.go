@@ -0,0 +1,76 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"os"
+	"strings"
+)
+
+const (
+	Json   = "json"
+	Logfmt = "logfmt"
+	// RequestIdField - the field carrying the request id of an entry
+	RequestIdField = "requestId"
+)
+
+// Log - the server logger, logfmt at info level until Configure is called
+var Log = newLogger()
+
+type requestIdKey struct{}
+
+func newLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(os.Stdout)
+	logger.SetFormatter(&logrus.TextFormatter{DisableColors: true, FullTimestamp: true})
+	return logger
+}
+
+// Configure sets the format, json or logfmt (default), and the level, info if empty
+func Configure(format string, level string) error {
+	switch strings.ToLower(format) {
+	case "", Logfmt:
+		Log.SetFormatter(&logrus.TextFormatter{DisableColors: true, FullTimestamp: true})
+	case Json:
+		Log.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return fmt.Errorf("unknown log format '%s', expected %s or %s", format, Json, Logfmt)
+	}
+	if level == "" {
+		level = logrus.InfoLevel.String()
+	}
+	parsed, err := logrus.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	Log.SetLevel(parsed)
+	return nil
+}
+
+// NewRequestId returns a random id for a request which did not bring one
+func NewRequestId() string {
+	return uuid.NewString()
+}
+
+func WithRequestId(ctx context.Context, requestId string) context.Context {
+	return context.WithValue(ctx, requestIdKey{}, requestId)
+}
+
+// RequestId returns the request id of ctx, empty if it has none
+func RequestId(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	requestId, _ := ctx.Value(requestIdKey{}).(string)
+	return requestId
+}
+
+// FromContext returns an entry of Log carrying the request id of ctx
+func FromContext(ctx context.Context) *logrus.Entry {
+	if requestId := RequestId(ctx); requestId != "" {
+		return Log.WithField(RequestIdField, requestId)
+	}
+	return logrus.NewEntry(Log)
+}
@@ -0,0 +1,81 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"testing"
+)
+
+// captureLog redirects Log into a buffer until the test ends
+func captureLog(t *testing.T) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	formatter, level := Log.Formatter, Log.Level
+	Log.SetOutput(buf)
+	t.Cleanup(func() {
+		Log.SetOutput(newLogger().Out)
+		Log.SetFormatter(formatter)
+		Log.SetLevel(level)
+	})
+	return buf
+}
+
+func TestConfigure(t *testing.T) {
+	buf := captureLog(t)
+	if err := Configure("JSON", "debug"); err != nil {
+		t.Fatal(err)
+	}
+	Log.WithField("sensorId", "kitchen").Debug("Stored a reading")
+	entry := map[string]interface{}{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("a json entry %q: %v", buf, err)
+	}
+	if entry["msg"] != "Stored a reading" || entry["level"] != "debug" || entry["sensorId"] != "kitchen" {
+		t.Errorf("logged %v", entry)
+	}
+
+	buf.Reset()
+	if err := Configure("", ""); err != nil {
+		t.Fatal(err)
+	}
+	Log.Debug("hidden")
+	Log.WithField("sensorId", "kitchen").Info("Stored a reading")
+	if line := buf.String(); strings.Contains(line, "hidden") || !strings.Contains(line, `level=info msg="Stored a reading" sensorId=kitchen`) {
+		t.Errorf("logged %q, want a logfmt info entry", line)
+	}
+	if Log.Level != logrus.InfoLevel {
+		t.Errorf("the default level is %s", Log.Level)
+	}
+
+	if err := Configure("xml", ""); err == nil {
+		t.Error("an unknown format was accepted")
+	}
+	if err := Configure(Logfmt, "chatty"); err == nil {
+		t.Error("an unknown level was accepted")
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	buf := captureLog(t)
+	ctx := WithRequestId(context.Background(), "req-1")
+	if got := RequestId(ctx); got != "req-1" {
+		t.Errorf("RequestId = %q", got)
+	}
+	FromContext(ctx).Info("with an id")
+	if !strings.Contains(buf.String(), RequestIdField+"=req-1") {
+		t.Errorf("logged %q without the request id", buf)
+	}
+	buf.Reset()
+	FromContext(context.Background()).Info("without an id")
+	if strings.Contains(buf.String(), RequestIdField) {
+		t.Errorf("logged %q with a request id", buf)
+	}
+	if RequestId(nil) != "" {
+		t.Error("a nil context has a request id")
+	}
+	if a, b := NewRequestId(), NewRequestId(); a == b || !validRequestId.MatchString(a) {
+		t.Errorf("new request ids %q and %q", a, b)
+	}
+}
@@ -0,0 +1,63 @@
+package logging
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"net/http"
+	"regexp"
+)
+
+const (
+	// RequestIdHeader - the HTTP header a request id is taken from and returned in
+	RequestIdHeader = "X-Request-Id"
+	// requestIdMetadata - the gRPC metadata key of the request id, keys are lower case
+	requestIdMetadata = "x-request-id"
+)
+
+// validRequestId - ids chosen by clients end up in logs and queue headers, anything unusual is replaced
+var validRequestId = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// StatusRecorder - a ResponseWriter capturing the status code written by a handler, for middlewares reporting it
+type StatusRecorder struct {
+	http.ResponseWriter
+	Status int
+}
+
+// NewStatusRecorder wraps w, the status is 200 until the handler writes another one
+func NewStatusRecorder(w http.ResponseWriter) *StatusRecorder {
+	return &StatusRecorder{ResponseWriter: w, Status: http.StatusOK}
+}
+
+func (r *StatusRecorder) WriteHeader(status int) {
+	r.Status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// HttpMiddleware puts the request id of the X-Request-Id header, or a new one, into the request context
+// and echoes it in the response
+func HttpMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestId := r.Header.Get(RequestIdHeader)
+		if !validRequestId.MatchString(requestId) {
+			requestId = NewRequestId()
+		}
+		w.Header().Set(RequestIdHeader, requestId)
+		next.ServeHTTP(w, r.WithContext(WithRequestId(r.Context(), requestId)))
+	})
+}
+
+// UnaryServerInterceptor puts the request id of the x-request-id metadata, or a new one, into the call context
+func UnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	requestId := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(requestIdMetadata); len(values) > 0 {
+			requestId = values[0]
+		}
+	}
+	if !validRequestId.MatchString(requestId) {
+		requestId = NewRequestId()
+	}
+	_ = grpc.SetHeader(ctx, metadata.Pairs(requestIdMetadata, requestId))
+	return handler(WithRequestId(ctx, requestId), req)
+}
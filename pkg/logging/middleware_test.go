@@ -0,0 +1,89 @@
+package logging
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHttpMiddleware(t *testing.T) {
+	var seen string
+	handler := HttpMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestId(r.Context())
+	}))
+	tests := []struct {
+		name      string
+		requestId string
+		kept      bool
+	}{
+		{"valid id", "2f1c-4a:x.y_z", true},
+		{"missing id", "", false},
+		{"id with spaces", "drop table", false},
+		{"id with a newline", "a\nlevel=error", false},
+		{"too long id", strings.Repeat("a", 129), false},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/temp", nil)
+		if test.requestId != "" {
+			req.Header.Set(RequestIdHeader, test.requestId)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		echoed := rec.Header().Get(RequestIdHeader)
+		if echoed != seen || seen == "" {
+			t.Errorf("%s: the handler saw %q and %q was echoed", test.name, seen, echoed)
+		}
+		if (seen == test.requestId) != test.kept {
+			t.Errorf("%s: the request id became %q", test.name, seen)
+		}
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	var seen string
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		seen = RequestId(ctx)
+		return req, nil
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(requestIdMetadata, "req-7"))
+	if resp, err := UnaryServerInterceptor(ctx, "kitchen", info, handler); err != nil || resp != "kitchen" {
+		t.Fatalf("returned %v, %v", resp, err)
+	}
+	if seen != "req-7" {
+		t.Errorf("the handler saw the request id %q, want req-7", seen)
+	}
+	invalid := metadata.NewIncomingContext(context.Background(), metadata.Pairs(requestIdMetadata, "not valid"))
+	for _, ctx := range []context.Context{context.Background(), invalid} {
+		if _, err := UnaryServerInterceptor(ctx, "kitchen", info, handler); err != nil {
+			t.Fatal(err)
+		}
+		if seen == "" || seen == "not valid" {
+			t.Errorf("the handler saw the request id %q, want a new one", seen)
+		}
+	}
+}
+
+func TestStatusRecorder(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		status  int
+	}{
+		{"body only", func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write([]byte("21.5")) }, http.StatusOK},
+		{"written status", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNotFound) }, http.StatusNotFound},
+		{"error", func(w http.ResponseWriter, r *http.Request) { http.Error(w, "broken", http.StatusInternalServerError) }, http.StatusInternalServerError},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		recorder := NewStatusRecorder(rec)
+		test.handler(recorder, httptest.NewRequest(http.MethodGet, "/temp", nil))
+		if recorder.Status != test.status || rec.Code != test.status {
+			t.Errorf("%s: recorded %d and wrote %d, want %d", test.name, recorder.Status, rec.Code, test.status)
+		}
+	}
+}
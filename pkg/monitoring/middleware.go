@@ -0,0 +1,36 @@
+package monitoring
+
+import (
+	"context"
+	"github.com/andreikom/sensor-server/pkg/logging"
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// HttpMiddleware records the duration of requests by the path template of their route
+func HttpMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := logging.NewStatusRecorder(w)
+		next.ServeHTTP(recorder, r)
+		route := "unmatched"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		HttpRequestDuration.WithLabelValues(route, r.Method, strconv.Itoa(recorder.Status)).Observe(time.Since(start).Seconds())
+	})
+}
+
+// UnaryServerInterceptor records the duration of unary gRPC calls by method and status code
+func UnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	GrpcRequestDuration.WithLabelValues(info.FullMethod, status.Code(err).String()).Observe(time.Since(start).Seconds())
+	return resp, err
+}
@@ -0,0 +1,48 @@
+package monitoring
+
+import (
+	"context"
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHttpMiddlewareLabelsRouteTemplates(t *testing.T) {
+	router := mux.NewRouter()
+	router.Use(HttpMiddleware)
+	router.HandleFunc("/test/sensors/{sensorId}", func(w http.ResponseWriter, r *http.Request) {
+		if mux.Vars(r)["sensorId"] == "missing" {
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	}).Methods("GET")
+	for _, path := range []string{"/test/sensors/kitchen", "/test/sensors/hallway", "/test/sensors/missing"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	exposition := scrape(t)
+	// sensor ids do not become labels, the path template does
+	assertSample(t, exposition, `sensor_server_http_request_duration_seconds_count{code="200",method="GET",route="/test/sensors/{sensorId}"} 2`)
+	assertSample(t, exposition, `sensor_server_http_request_duration_seconds_count{code="404",method="GET",route="/test/sensors/{sensorId}"} 1`)
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		if req == nil {
+			return nil, status.Error(codes.NotFound, "no such sensor")
+		}
+		return req, nil
+	}
+	if resp, err := UnaryServerInterceptor(context.Background(), "kitchen", info, handler); err != nil || resp != "kitchen" {
+		t.Fatalf("the interceptor returned %v, %v", resp, err)
+	}
+	if _, err := UnaryServerInterceptor(context.Background(), nil, info, handler); status.Code(err) != codes.NotFound {
+		t.Fatalf("the interceptor returned %v, want the error of the handler", err)
+	}
+	exposition := scrape(t)
+	assertSample(t, exposition, `sensor_server_grpc_request_duration_seconds_count{code="OK",method="/test.Service/Get"} 1`)
+	assertSample(t, exposition, `sensor_server_grpc_request_duration_seconds_count{code="NotFound",method="/test.Service/Get"} 1`)
+}
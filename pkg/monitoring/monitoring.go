@@ -0,0 +1,101 @@
+package monitoring
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+)
+
+const namespace = "sensor_server"
+
+// Registry - the server internals exposed on /metrics, a registry of its own keeps the collectors of
+// libraries registered on the default one out
+var Registry = prometheus.NewRegistry()
+
+var (
+	// ReadingsReceived - readings accepted by SaveMetric and queued, per metric
+	ReadingsReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "readings_received_total",
+		Help:      "Readings accepted and published to the queue.",
+	}, []string{"metric"})
+	// ReadingsStored - readings consumed from the queue and committed to disk, per metric
+	ReadingsStored = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "readings_stored_total",
+		Help:      "Readings consumed from the queue and committed to storage.",
+	}, []string{"metric"})
+	// ReadingsDeadLettered - messages moved to the dead letter queue
+	ReadingsDeadLettered = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "readings_dead_lettered_total",
+		Help:      "Messages moved to the dead letter queue.",
+	})
+	// PublishDuration - from publishing a message to its confirmation, result is confirmed, nacked, timeout or error
+	PublishDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "broker",
+		Name:      "publish_duration_seconds",
+		Help:      "Time from publishing a message to its confirmation by the broker.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+	}, []string{"result"})
+	// ConsumeLatency - from publishing a reading to committing it to disk
+	ConsumeLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "broker",
+		Name:      "consume_latency_seconds",
+		Help:      "Time from publishing a reading to committing it to storage.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 16),
+	})
+	// StorageDuration - storage driver calls, op is the driver method
+	StorageDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "storage",
+		Name:      "operation_duration_seconds",
+		Help:      "Duration of storage driver operations.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 2, 16),
+	}, []string{"op"})
+	StorageErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "storage",
+		Name:      "errors_total",
+		Help:      "Failed storage driver operations.",
+	}, []string{"op"})
+	// HttpRequestDuration - route is the path template of the matched route, so sensor ids do not become labels
+	HttpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Duration of HTTP requests.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "code"})
+	GrpcRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "grpc",
+		Name:      "request_duration_seconds",
+		Help:      "Duration of gRPC calls.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "code"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		ReadingsReceived,
+		ReadingsStored,
+		ReadingsDeadLettered,
+		PublishDuration,
+		ConsumeLatency,
+		StorageDuration,
+		StorageErrors,
+		HttpRequestDuration,
+		GrpcRequestDuration,
+	)
+}
+
+// Handler serves the Registry in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
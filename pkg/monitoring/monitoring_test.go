@@ -0,0 +1,50 @@
+package monitoring
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// scrape returns the exposition served by Handler
+func scrape(t *testing.T) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("/metrics answered with %d", rec.Code)
+	}
+	body, err := ioutil.ReadAll(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func assertSample(t *testing.T, exposition string, sample string) {
+	t.Helper()
+	for _, line := range strings.Split(exposition, "\n") {
+		if line == sample {
+			return
+		}
+	}
+	t.Errorf("the sample %q was not exposed", sample)
+}
+
+func TestHandlerExposesTheRegistry(t *testing.T) {
+	ReadingsReceived.WithLabelValues("co2").Inc()
+	exposition := scrape(t)
+	for _, family := range []string{
+		"go_goroutines",
+		"sensor_server_readings_received_total",
+		"sensor_server_readings_dead_lettered_total",
+		"sensor_server_broker_consume_latency_seconds",
+	} {
+		if !strings.Contains(exposition, "# TYPE "+family+" ") {
+			t.Errorf("the family %s was not exposed", family)
+		}
+	}
+	assertSample(t, exposition, `sensor_server_readings_received_total{metric="co2"} 1`)
+}
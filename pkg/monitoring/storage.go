@@ -0,0 +1,58 @@
+package monitoring
+
+import (
+	"context"
+	"errors"
+	"github.com/andreikom/sensor-server/pkg/storage"
+	"os"
+	"time"
+)
+
+// instrumentedDriver - records the duration and failures of every call of the wrapped driver
+type instrumentedDriver struct {
+	driver storage.Driver
+}
+
+func InstrumentDriver(driver storage.Driver) storage.Driver {
+	return &instrumentedDriver{driver: driver}
+}
+
+func (d *instrumentedDriver) SaveSensorData(ctx context.Context, metric string, sensorId string, data []byte) error {
+	defer observe("save_sensor_data", time.Now())
+	return countError("save_sensor_data", d.driver.SaveSensorData(ctx, metric, sensorId, data))
+}
+
+func (d *instrumentedDriver) GetAvailableSensors(ctx context.Context, metric string) ([]string, error) {
+	defer observe("get_available_sensors", time.Now())
+	sensors, err := d.driver.GetAvailableSensors(ctx, metric)
+	return sensors, countError("get_available_sensors", err)
+}
+
+func (d *instrumentedDriver) GetSensorData(ctx context.Context, metric string, sensorId string) ([]byte, error) {
+	defer observe("get_sensor_data", time.Now())
+	data, err := d.driver.GetSensorData(ctx, metric, sensorId)
+	return data, countError("get_sensor_data", err)
+}
+
+func (d *instrumentedDriver) SaveArchiveData(ctx context.Context, metric string, sensorId string, data []byte) error {
+	defer observe("save_archive_data", time.Now())
+	return countError("save_archive_data", d.driver.SaveArchiveData(ctx, metric, sensorId, data))
+}
+
+func (d *instrumentedDriver) GetArchiveData(ctx context.Context, metric string, sensorId string) ([]byte, error) {
+	defer observe("get_archive_data", time.Now())
+	data, err := d.driver.GetArchiveData(ctx, metric, sensorId)
+	return data, countError("get_archive_data", err)
+}
+
+func observe(op string, start time.Time) {
+	StorageDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+}
+
+// countError - a missing archive is not a failure, sensors without archived days have none
+func countError(op string, err error) error {
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		StorageErrors.WithLabelValues(op).Inc()
+	}
+	return err
+}
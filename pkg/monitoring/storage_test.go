@@ -0,0 +1,38 @@
+package monitoring
+
+import (
+	"context"
+	"errors"
+	"github.com/andreikom/sensor-server/pkg/storage"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"os"
+	"testing"
+)
+
+func TestInstrumentedDriver(t *testing.T) {
+	driver := InstrumentDriver(storage.NewFSDriver(t.TempDir()))
+	ctx := context.Background()
+	saveErrors := testutil.ToFloat64(StorageErrors.WithLabelValues("save_sensor_data"))
+	archiveErrors := testutil.ToFloat64(StorageErrors.WithLabelValues("get_archive_data"))
+
+	if err := driver.SaveSensorData(ctx, "temperature", "kitchen", []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := driver.GetSensorData(ctx, "temperature", "kitchen"); err != nil || string(data) != "{}" {
+		t.Fatalf("read %q, %v back", data, err)
+	}
+	if err := driver.SaveSensorData(ctx, "temperature", "../kitchen", []byte("{}")); err == nil {
+		t.Fatal("an invalid sensor id was saved")
+	}
+	if got := testutil.ToFloat64(StorageErrors.WithLabelValues("save_sensor_data")) - saveErrors; got != 1 {
+		t.Errorf("counted %v failed saves, want 1", got)
+	}
+	// sensors without archived days have no archive, that is not a failure
+	if _, err := driver.GetArchiveData(ctx, "temperature", "kitchen"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("a missing archive returned %v", err)
+	}
+	if got := testutil.ToFloat64(StorageErrors.WithLabelValues("get_archive_data")) - archiveErrors; got != 0 {
+		t.Errorf("counted %v failed archive reads, want none", got)
+	}
+	assertSample(t, scrape(t), `sensor_server_storage_operation_duration_seconds_count{op="get_sensor_data"} 1`)
+}
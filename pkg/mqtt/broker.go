@@ -0,0 +1,452 @@
+package mqtt
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"github.com/andreikom/sensor-server/pkg/api/metric"
+	"github.com/andreikom/sensor-server/pkg/logging"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultBrokerAddress = ":1883"
+	// connectPacketTimeout - how long a new connection may take to send its CONNECT packet
+	connectPacketTimeout = 10 * time.Second
+	writeTimeout         = 10 * time.Second
+	// maxQos - QoS 2 publishes are accepted, deliveries to subscribers are downgraded to QoS 1
+	maxQos byte = 1
+)
+
+// CONNACK return codes of MQTT 3.1.1 and their MQTT 5 reason codes
+const (
+	connAccepted           byte = 0x00
+	connBadProtocolVersion byte = 0x01
+	connIdentifierRejected byte = 0x02
+	connBadCredentials     byte = 0x04
+	connNotAuthorized      byte = 0x05
+
+	connV5UnsupportedProtocolVersion byte = 0x84
+	connV5ClientIdentifierNotValid   byte = 0x85
+	connV5BadCredentials             byte = 0x86
+	connV5NotAuthorized              byte = 0x87
+)
+
+// BrokerOptions - the listener of the embedded broker and the credentials of the sensors publishing to it
+type BrokerOptions struct {
+	Address string
+	// Sensors - sensorId -> password, a password starting with "$2" is a bcrypt hash.
+	// A sensor logs in with its id as the username and may only report its own readings
+	Sensors map[string]string
+	// AllowAnonymous accepts clients without credentials, they may report and subscribe to readings of any sensor
+	AllowAnonymous bool
+	// Topics - readings published on these topics are stored, other messages are only routed to subscribers.
+	// Clients may only subscribe to filters within these topics, see canSubscribe
+	Topics []Topic
+}
+
+// Broker - an embedded MQTT 3.1.1 and 5 broker, so devices can publish to the sensor server without a
+// separate broker. Sessions are not persisted and retained messages are not stored
+type Broker struct {
+	options  BrokerOptions
+	ingester *ingester
+	listener net.Listener
+	mutex    sync.RWMutex
+	sessions map[string]*session
+	closed   bool
+}
+
+func NewBroker(metricService *metric.MetricService, options BrokerOptions) *Broker {
+	if options.Address == "" {
+		options.Address = DefaultBrokerAddress
+	}
+	return &Broker{options: options, ingester: &ingester{metricService: metricService}, sessions: make(map[string]*session)}
+}
+
+// ListenAndServe accepts connections until Close is called
+func (b *Broker) ListenAndServe() error {
+	listener, err := net.Listen("tcp", b.options.Address)
+	if err != nil {
+		return err
+	}
+	b.mutex.Lock()
+	b.listener = listener
+	b.mutex.Unlock()
+	logging.Log.WithField("address", b.options.Address).Info("Starting the MQTT broker")
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			b.mutex.RLock()
+			closed := b.closed
+			b.mutex.RUnlock()
+			if closed {
+				return nil
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Temporary() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go b.serve(conn)
+	}
+}
+
+// Close stops accepting connections and disconnects all clients
+func (b *Broker) Close() error {
+	b.mutex.Lock()
+	b.closed = true
+	listener := b.listener
+	sessions := make([]*session, 0, len(b.sessions))
+	for _, s := range b.sessions {
+		sessions = append(sessions, s)
+	}
+	b.mutex.Unlock()
+	for _, s := range sessions {
+		_ = s.conn.Close()
+	}
+	if listener == nil {
+		return nil
+	}
+	return listener.Close()
+}
+
+// session - a connected client
+type session struct {
+	conn          net.Conn
+	clientId      string
+	username      string
+	sensorId      string
+	version       byte
+	writeMutex    sync.Mutex
+	subscriptions map[string]byte // filter -> granted QoS, guarded by the broker mutex
+	packetId      uint16
+	// awaitingRelease - ids of QoS 2 publishes received and not yet released by PUBREL
+	awaitingRelease map[uint16]bool
+	will            *publishRequest
+}
+
+func (b *Broker) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	_ = conn.SetReadDeadline(time.Now().Add(connectPacketTimeout))
+	first, err := readPacket(reader)
+	if err != nil || first.kind != connectPacket {
+		return
+	}
+	s, keepAlive, ok := b.connect(conn, first.body)
+	if !ok {
+		return
+	}
+	defer b.disconnect(s)
+	for {
+		if keepAlive > 0 {
+			_ = conn.SetReadDeadline(time.Now().Add(keepAlive * 3 / 2))
+		} else {
+			_ = conn.SetReadDeadline(time.Time{})
+		}
+		p, err := readPacket(reader)
+		if err != nil {
+			return
+		}
+		if err := b.handlePacket(s, p); err != nil {
+			logging.Log.WithError(err).WithField("clientId", s.clientId).Warn("Closing an MQTT connection")
+			return
+		}
+		if p.kind == disconnectPacket {
+			s.will = nil
+			return
+		}
+	}
+}
+
+// connect authenticates a client and replaces an existing session with the same client id
+func (b *Broker) connect(conn net.Conn, body []byte) (*session, time.Duration, bool) {
+	req, err := decodeConnect(body)
+	if err != nil {
+		return nil, 0, false
+	}
+	s := &session{conn: conn, clientId: req.clientId, username: req.username, version: req.version,
+		subscriptions: make(map[string]byte), awaitingRelease: make(map[uint16]bool)}
+	if req.version != protocolV31 && req.version != protocolV311 && req.version != protocolV5 {
+		s.version = protocolV311
+		_ = s.connack(connBadProtocolVersion, connV5UnsupportedProtocolVersion)
+		return nil, 0, false
+	}
+	if req.clientId == "" {
+		if !req.cleanSession && req.version != protocolV5 {
+			_ = s.connack(connIdentifierRejected, connV5ClientIdentifierNotValid)
+			return nil, 0, false
+		}
+		s.clientId = fmt.Sprintf("auto-%s-%d", conn.RemoteAddr(), time.Now().UnixNano())
+	}
+	if code, v5Code, ok := b.authenticate(req); !ok {
+		logging.Log.WithFields(logrus.Fields{"clientId": req.clientId, "username": req.username}).Warn("Rejected an MQTT client")
+		_ = s.connack(code, v5Code)
+		return nil, 0, false
+	}
+	if req.username != "" {
+		s.sensorId = req.username
+	}
+	if req.hasWill {
+		s.will = &publishRequest{topic: req.willTopic, qos: req.willQos, payload: req.willPayload}
+	}
+	b.mutex.Lock()
+	previous := b.sessions[s.clientId]
+	b.sessions[s.clientId] = s
+	b.mutex.Unlock()
+	if previous != nil {
+		_ = previous.conn.Close()
+	}
+	if err := s.connack(connAccepted, connAccepted); err != nil {
+		return nil, 0, false
+	}
+	return s, time.Duration(req.keepAlive) * time.Second, true
+}
+
+func (b *Broker) authenticate(req *connectRequest) (byte, byte, bool) {
+	if req.username == "" && req.password == nil {
+		if b.options.AllowAnonymous {
+			return connAccepted, connAccepted, true
+		}
+		return connNotAuthorized, connV5NotAuthorized, false
+	}
+	expected, ok := b.options.Sensors[req.username]
+	if !ok || !checkPassword(expected, req.password) {
+		return connBadCredentials, connV5BadCredentials, false
+	}
+	return connAccepted, connAccepted, true
+}
+
+func checkPassword(expected string, password []byte) bool {
+	if strings.HasPrefix(expected, "$2") {
+		return bcrypt.CompareHashAndPassword([]byte(expected), password) == nil
+	}
+	return subtle.ConstantTimeCompare([]byte(expected), password) == 1
+}
+
+// disconnect removes the session unless it was taken over and publishes the will of a client
+// which did not disconnect cleanly
+func (b *Broker) disconnect(s *session) {
+	b.mutex.Lock()
+	if b.sessions[s.clientId] == s {
+		delete(b.sessions, s.clientId)
+	}
+	b.mutex.Unlock()
+	if s.will != nil {
+		if err := b.route(s, s.will); err != nil {
+			logging.Log.WithError(err).WithFields(logrus.Fields{"clientId": s.clientId, "topic": s.will.topic}).Warn("Dropping the will of an MQTT client")
+		}
+	}
+}
+
+func (b *Broker) handlePacket(s *session, p *packet) error {
+	switch p.kind {
+	case publishPacket:
+		return b.handlePublish(s, p)
+	case pubrelPacket:
+		d := &decoder{data: p.body}
+		packetId := d.uint16()
+		if d.err != nil {
+			return d.err
+		}
+		delete(s.awaitingRelease, packetId)
+		return s.write(pubcompPacket, 0, appendUint16(nil, packetId))
+	case pubackPacket, pubrecPacket, pubcompPacket:
+		// deliveries to subscribers are not retried, their acknowledgements need no bookkeeping
+		if p.kind == pubrecPacket && len(p.body) >= 2 {
+			return s.write(pubrelPacket, 0x02, p.body[:2])
+		}
+		return nil
+	case subscribePacket:
+		return b.handleSubscribe(s, p)
+	case unsubscribePacket:
+		return b.handleUnsubscribe(s, p)
+	case pingreqPacket:
+		return s.write(pingrespPacket, 0, nil)
+	case disconnectPacket:
+		return nil
+	case connectPacket:
+		return errors.New("second CONNECT packet")
+	}
+	return fmt.Errorf("unexpected packet type %d", p.kind)
+}
+
+// handlePublish acknowledges a QoS 1 publish once its reading is stored or spooled, a QoS 2 publish is
+// processed when it is first received and duplicates are dropped until it is released.
+// A publish whose reading could not be stored is not acknowledged, the client sends it again
+func (b *Broker) handlePublish(s *session, p *packet) error {
+	req, err := decodePublish(s.version, p.flags, p.body)
+	if err != nil {
+		return err
+	}
+	if req.qos == 2 && s.awaitingRelease[req.packetId] {
+		return s.write(pubrecPacket, 0, appendUint16(nil, req.packetId))
+	}
+	if err := b.route(s, req); err != nil {
+		logging.Log.WithError(err).WithFields(logrus.Fields{"clientId": s.clientId, "topic": req.topic, "qos": req.qos}).Warn("Dropping an MQTT message")
+		return nil
+	}
+	switch req.qos {
+	case 1:
+		return s.write(pubackPacket, 0, appendUint16(nil, req.packetId))
+	case 2:
+		s.awaitingRelease[req.packetId] = true
+		return s.write(pubrecPacket, 0, appendUint16(nil, req.packetId))
+	}
+	return nil
+}
+
+// route stores the reading of a message published on an ingest topic and delivers it to the subscribers,
+// a message whose reading could not be stored is not delivered
+func (b *Broker) route(publisher *session, req *publishRequest) error {
+	for _, topic := range b.options.Topics {
+		_, err := b.ingester.handle(topic, req.topic, req.payload, publisher.sensorId)
+		if errors.Is(err, errTopicMismatch) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		break
+	}
+	type delivery struct {
+		s   *session
+		qos byte
+	}
+	deliveries := make([]delivery, 0)
+	b.mutex.RLock()
+	for _, s := range b.sessions {
+		granted, matched := byte(0), false
+		for filter, qos := range s.subscriptions {
+			if matchFilter(filter, req.topic) && (!matched || qos > granted) {
+				granted, matched = qos, true
+			}
+		}
+		if matched {
+			if req.qos < granted {
+				granted = req.qos
+			}
+			deliveries = append(deliveries, delivery{s: s, qos: granted})
+		}
+	}
+	b.mutex.RUnlock()
+	for _, d := range deliveries {
+		if err := d.s.deliver(req.topic, req.payload, d.qos); err != nil {
+			_ = d.s.conn.Close()
+		}
+	}
+	return nil
+}
+
+func (b *Broker) handleSubscribe(s *session, p *packet) error {
+	if p.flags != 0x02 {
+		return errMalformedPacket
+	}
+	packetId, subscriptions, err := decodeSubscribe(s.version, p.body)
+	if err != nil {
+		return err
+	}
+	body := appendUint16(nil, packetId)
+	if s.version == protocolV5 {
+		body = append(body, 0) // no properties
+	}
+	b.mutex.Lock()
+	for _, sub := range subscriptions {
+		if !validFilter(sub.filter) || sub.qos > 2 {
+			body = append(body, 0x80) // failure, the MQTT 5 unspecified error
+			continue
+		}
+		if !b.canSubscribe(s, sub.filter) {
+			logging.Log.WithFields(logrus.Fields{"clientId": s.clientId, "filter": sub.filter}).Warn("Rejected an MQTT subscription")
+			if s.version == protocolV5 {
+				body = append(body, 0x87) // not authorized
+			} else {
+				body = append(body, 0x80)
+			}
+			continue
+		}
+		granted := sub.qos
+		if granted > maxQos {
+			granted = maxQos
+		}
+		s.subscriptions[sub.filter] = granted
+		body = append(body, granted)
+	}
+	b.mutex.Unlock()
+	return s.write(subackPacket, 0, body)
+}
+
+// canSubscribe applies the rules of publishing to subscriptions: a filter has to lie within one of the
+// ingest topics and a client authenticated as a sensor has to name its own id in the sensor level
+func (b *Broker) canSubscribe(s *session, filter string) bool {
+	for _, topic := range b.options.Topics {
+		if topic.Pattern.covers(filter, s.sensorId) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Broker) handleUnsubscribe(s *session, p *packet) error {
+	packetId, filters, err := decodeUnsubscribe(s.version, p.body)
+	if err != nil {
+		return err
+	}
+	body := appendUint16(nil, packetId)
+	if s.version == protocolV5 {
+		body = append(body, 0) // no properties
+	}
+	b.mutex.Lock()
+	for _, filter := range filters {
+		code := byte(0x11) // MQTT 5 no subscription existed
+		if _, ok := s.subscriptions[filter]; ok {
+			delete(s.subscriptions, filter)
+			code = 0x00
+		}
+		if s.version == protocolV5 {
+			body = append(body, code)
+		}
+	}
+	b.mutex.Unlock()
+	return s.write(unsubackPacket, 0, body)
+}
+
+func (s *session) connack(code byte, v5Code byte) error {
+	if s.version == protocolV5 {
+		return s.write(connackPacket, 0, []byte{0, v5Code, 0})
+	}
+	return s.write(connackPacket, 0, []byte{0, code})
+}
+
+func (s *session) deliver(topic string, payload []byte, qos byte) error {
+	body := appendString(nil, topic)
+	s.writeMutex.Lock()
+	if qos > 0 {
+		s.packetId++
+		if s.packetId == 0 {
+			s.packetId = 1
+		}
+		body = appendUint16(body, s.packetId)
+	}
+	s.writeMutex.Unlock()
+	if s.version == protocolV5 {
+		body = append(body, 0) // no properties
+	}
+	body = append(body, payload...)
+	return s.write(publishPacket, qos<<1, body)
+}
+
+func (s *session) write(kind byte, flags byte, body []byte) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	_ = s.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return writePacket(s.conn, kind, flags, body)
+}
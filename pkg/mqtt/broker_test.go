@@ -0,0 +1,291 @@
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/andreikom/sensor-server/pkg/api/metric"
+	"github.com/andreikom/sensor-server/pkg/api/metric/metrictest"
+	"github.com/andreikom/sensor-server/pkg/broker"
+	"github.com/andreikom/sensor-server/pkg/storage"
+	"golang.org/x/crypto/bcrypt"
+	"net"
+	"testing"
+	"time"
+)
+
+// rawClient speaks the MQTT wire protocol directly, so every packet of a flow can be checked
+type rawClient struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	version byte
+}
+
+// dialRaw connects and returns the client with the return code of the CONNACK
+func dialRaw(t *testing.T, address string, version byte, clientId string, username string, password string) (*rawClient, byte) {
+	t.Helper()
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	c := &rawClient{conn: conn, reader: bufio.NewReader(conn), version: version}
+	c.send(t, connectPacket, 0, connectBody(version, clientId, username, password, nil))
+	connack := c.expect(t, connackPacket)
+	if len(connack.body) < 2 {
+		t.Fatalf("malformed CONNACK %x", connack.body)
+	}
+	return c, connack.body[1]
+}
+
+func (c *rawClient) send(t *testing.T, kind byte, flags byte, body []byte) {
+	t.Helper()
+	if err := writePacket(c.conn, kind, flags, body); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (c *rawClient) read(wait time.Duration) (*packet, error) {
+	_ = c.conn.SetReadDeadline(time.Now().Add(wait))
+	return readPacket(c.reader)
+}
+
+func (c *rawClient) expect(t *testing.T, kind byte) *packet {
+	t.Helper()
+	p, err := c.read(5 * time.Second)
+	if err != nil {
+		t.Fatalf("expected a packet of type %d: %v", kind, err)
+	}
+	if p.kind != kind {
+		t.Fatalf("got a packet of type %d, want %d", p.kind, kind)
+	}
+	return p
+}
+
+// expectNothing checks that no packet arrives for a while and the connection is still open
+func (c *rawClient) expectNothing(t *testing.T) {
+	t.Helper()
+	if p, err := c.read(200 * time.Millisecond); err == nil {
+		t.Fatalf("got an unexpected packet of type %d", p.kind)
+	}
+	c.reader = bufio.NewReader(c.conn)
+	c.send(t, pingreqPacket, 0, nil)
+	c.expect(t, pingrespPacket)
+}
+
+func (c *rawClient) subscribe(t *testing.T, packetId uint16, filter string, qos byte) byte {
+	t.Helper()
+	c.send(t, subscribePacket, 0x02, subscribeBody(c.version, packetId, subscription{filter: filter, qos: qos}))
+	suback := c.expect(t, subackPacket)
+	d := &decoder{data: suback.body}
+	if d.uint16() != packetId {
+		t.Fatalf("SUBACK %x does not acknowledge packet %d", suback.body, packetId)
+	}
+	if c.version == protocolV5 {
+		d.skipProperties()
+	}
+	codes := d.rest()
+	if len(codes) != 1 {
+		t.Fatalf("SUBACK %x has %d return codes", suback.body, len(codes))
+	}
+	return codes[0]
+}
+
+func newFailingService(t *testing.T) *metric.MetricService {
+	// a broker client which is not connected and has no spool accepts no readings
+	service := metric.NewMetricService(storage.NewFSDriver(t.TempDir()), broker.NewClient(broker.Options{}, nil), metric.Options{})
+	t.Cleanup(service.Close)
+	return service
+}
+
+func brokerOptions(t *testing.T) BrokerOptions {
+	hash, err := bcrypt.GenerateFromPassword([]byte("hashed-secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return BrokerOptions{
+		Address: metrictest.FreeAddress(t),
+		Sensors: map[string]string{"kitchen": "secret", "hallway": string(hash)},
+		Topics:  []Topic{mustParseTopic(t, "sensors/+/+", "", Auto)},
+	}
+}
+
+func TestBrokerAuthentication(t *testing.T) {
+	service, _ := metrictest.NewSpoolingService(t)
+	options := brokerOptions(t)
+	startBroker(t, service, options)
+	tests := []struct {
+		name     string
+		version  byte
+		username string
+		password string
+		code     byte
+	}{
+		{"plain password", protocolV311, "kitchen", "secret", connAccepted},
+		{"bcrypt password", protocolV311, "hallway", "hashed-secret", connAccepted},
+		{"wrong password", protocolV311, "kitchen", "wrong", connBadCredentials},
+		{"unknown sensor", protocolV311, "attic", "secret", connBadCredentials},
+		{"anonymous", protocolV311, "", "", connNotAuthorized},
+		{"MQTT 5 wrong password", protocolV5, "kitchen", "wrong", connV5BadCredentials},
+		{"MQTT 5 anonymous", protocolV5, "", "", connV5NotAuthorized},
+		{"unsupported version", 6, "kitchen", "secret", connBadProtocolVersion},
+	}
+	for _, test := range tests {
+		if _, code := dialRaw(t, options.Address, test.version, "client", test.username, test.password); code != test.code {
+			t.Errorf("%s: CONNACK return code %x, want %x", test.name, code, test.code)
+		}
+	}
+}
+
+func TestBrokerQos1Publish(t *testing.T) {
+	service, spool := metrictest.NewSpoolingService(t)
+	options := brokerOptions(t)
+	startBroker(t, service, options)
+	c, _ := dialRaw(t, options.Address, protocolV311, "kitchen-1", "kitchen", "secret")
+	c.send(t, publishPacket, 0x02, publishBody(protocolV311, "sensors/kitchen/temperature", 1, 42, "21.5"))
+	puback := c.expect(t, pubackPacket)
+	if !bytes.Equal(puback.body, []byte{0, 42}) {
+		t.Errorf("PUBACK %x does not acknowledge packet 42", puback.body)
+	}
+	// the reading is stored before the publish is acknowledged
+	if spool.Len() != 1 {
+		t.Fatalf("%d readings were stored, want 1", spool.Len())
+	}
+	readings := waitForReadings(t, spool, 1)
+	if readings[0].SensorId != "kitchen" || readings[0].Metric != metric.Temperature || readings[0].Value != 21.5 {
+		t.Errorf("stored %+v", readings[0])
+	}
+	// messages on topics other than the ingest topics are acknowledged without being stored
+	c.send(t, publishPacket, 0x02, publishBody(protocolV311, "status/kitchen", 1, 43, "online"))
+	c.expect(t, pubackPacket)
+	if spool.Len() != 0 {
+		t.Errorf("a message outside of the ingest topics was stored")
+	}
+}
+
+func TestBrokerQos2Publish(t *testing.T) {
+	for _, version := range []byte{protocolV311, protocolV5} {
+		service, spool := metrictest.NewSpoolingService(t)
+		options := brokerOptions(t)
+		startBroker(t, service, options)
+		c, _ := dialRaw(t, options.Address, version, "kitchen-1", "kitchen", "secret")
+		body := publishBody(version, "sensors/kitchen/humidity", 2, 7, "40")
+		c.send(t, publishPacket, 0x04, body)
+		if pubrec := c.expect(t, pubrecPacket); !bytes.Equal(pubrec.body, []byte{0, 7}) {
+			t.Errorf("version %d: PUBREC %x does not acknowledge packet 7", version, pubrec.body)
+		}
+		// a duplicate sent before the release is acknowledged again but not stored again
+		c.send(t, publishPacket, 0x0c, body)
+		c.expect(t, pubrecPacket)
+		c.send(t, pubrelPacket, 0x02, []byte{0, 7})
+		if pubcomp := c.expect(t, pubcompPacket); !bytes.Equal(pubcomp.body, []byte{0, 7}) {
+			t.Errorf("version %d: PUBCOMP %x does not complete packet 7", version, pubcomp.body)
+		}
+		if spool.Len() != 1 {
+			t.Errorf("version %d: %d readings were stored, want 1", version, spool.Len())
+		}
+		// once released the packet id is free to be used by a new message
+		c.send(t, publishPacket, 0x04, body)
+		c.expect(t, pubrecPacket)
+		if spool.Len() != 2 {
+			t.Errorf("version %d: %d readings were stored, want 2", version, spool.Len())
+		}
+	}
+}
+
+func TestBrokerWithholdsAcksOfReadingsNotStored(t *testing.T) {
+	options := brokerOptions(t)
+	startBroker(t, newFailingService(t), options)
+	c, _ := dialRaw(t, options.Address, protocolV311, "kitchen-1", "kitchen", "secret")
+	c.send(t, publishPacket, 0x02, publishBody(protocolV311, "sensors/kitchen/temperature", 1, 1, "21.5"))
+	c.expectNothing(t)
+	c.send(t, publishPacket, 0x04, publishBody(protocolV311, "sensors/kitchen/temperature", 2, 2, "21.5"))
+	c.expectNothing(t)
+
+	service, spool := metrictest.NewSpoolingService(t)
+	options = brokerOptions(t)
+	startBroker(t, service, options)
+	c, _ = dialRaw(t, options.Address, protocolV311, "kitchen-1", "kitchen", "secret")
+	// a sensor may only report its own readings
+	c.send(t, publishPacket, 0x02, publishBody(protocolV311, "sensors/hallway/temperature", 1, 3, "21.5"))
+	c.expectNothing(t)
+	c.send(t, publishPacket, 0x02, publishBody(protocolV311, "sensors/kitchen/temperature", 1, 4, "warm"))
+	c.expectNothing(t)
+	if spool.Len() != 0 {
+		t.Errorf("%d rejected readings were stored", spool.Len())
+	}
+}
+
+func TestBrokerSubscriptionAcl(t *testing.T) {
+	service, _ := metrictest.NewSpoolingService(t)
+	options := brokerOptions(t)
+	options.AllowAnonymous = true
+	startBroker(t, service, options)
+	tests := []struct {
+		version  byte
+		username string
+		password string
+		filter   string
+		code     byte
+	}{
+		{protocolV311, "kitchen", "secret", "sensors/kitchen/+", 1},
+		{protocolV311, "kitchen", "secret", "sensors/kitchen/temperature", 1},
+		{protocolV311, "kitchen", "secret", "sensors/hallway/temperature", 0x80},
+		{protocolV311, "kitchen", "secret", "sensors/+/+", 0x80},
+		{protocolV311, "kitchen", "secret", "sensors/#", 0x80},
+		{protocolV311, "kitchen", "secret", "#", 0x80},
+		{protocolV5, "kitchen", "secret", "sensors/hallway/+", 0x87},
+		{protocolV311, "", "", "sensors/+/+", 1},
+		{protocolV311, "", "", "sensors/hallway/co2", 1},
+		{protocolV311, "", "", "#", 0x80},
+		{protocolV311, "", "", "$SYS/#", 0x80},
+		{protocolV311, "", "", "status/+", 0x80},
+		{protocolV311, "", "", "sensors/+/+/+", 0x80},
+		{protocolV5, "", "", "#", 0x87},
+	}
+	for _, test := range tests {
+		c, code := dialRaw(t, options.Address, test.version, "client", test.username, test.password)
+		if code != connAccepted {
+			t.Fatalf("CONNACK return code %x", code)
+		}
+		if code := c.subscribe(t, 1, test.filter, 1); code != test.code {
+			t.Errorf("version %d, user %q subscribing %s: return code %x, want %x", test.version, test.username, test.filter, code, test.code)
+		}
+	}
+}
+
+func TestBrokerDeliversToSubscribers(t *testing.T) {
+	service, _ := metrictest.NewSpoolingService(t)
+	options := brokerOptions(t)
+	startBroker(t, service, options)
+	subscriber, _ := dialRaw(t, options.Address, protocolV5, "kitchen-display", "kitchen", "secret")
+	if code := subscriber.subscribe(t, 1, "sensors/kitchen/+", 2); code != maxQos {
+		t.Fatalf("granted QoS %d, want %d", code, maxQos)
+	}
+	publisher, _ := dialRaw(t, options.Address, protocolV311, "kitchen-1", "kitchen", "secret")
+	for _, qos := range []byte{0, 2} {
+		publisher.send(t, publishPacket, qos<<1, publishBody(protocolV311, "sensors/kitchen/temperature", qos, 5, "21.5"))
+		delivery := subscriber.expect(t, publishPacket)
+		req, err := decodePublish(protocolV5, delivery.flags, delivery.body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// deliveries are downgraded to the granted QoS
+		wantQos := qos
+		if wantQos > maxQos {
+			wantQos = maxQos
+		}
+		if req.topic != "sensors/kitchen/temperature" || req.qos != wantQos || string(req.payload) != "21.5" {
+			t.Errorf("QoS %d publish was delivered as %+v", qos, req)
+		}
+		if req.qos > 0 {
+			subscriber.send(t, pubackPacket, 0, appendUint16(nil, req.packetId))
+		}
+	}
+	// unsubscribed clients receive nothing
+	subscriber.send(t, unsubscribePacket, 0x02, append(appendUint16(nil, 2), append([]byte{0}, appendString(nil, "sensors/kitchen/+")...)...))
+	subscriber.expect(t, unsubackPacket)
+	publisher.send(t, publishPacket, 0, publishBody(protocolV311, "sensors/kitchen/temperature", 0, 0, "22"))
+	subscriber.expectNothing(t)
+}
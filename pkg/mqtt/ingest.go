@@ -0,0 +1,54 @@
+package mqtt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/andreikom/sensor-server/pkg/api/metric"
+)
+
+var errTopicMismatch = errors.New("topic does not match")
+
+// ingester - decodes sensor messages and stores their readings through the MetricService
+type ingester struct {
+	metricService *metric.MetricService
+}
+
+// handle stores the reading of a message published on a topic matching topic.Pattern. A non-empty
+// sensorId restricts the reading to that sensor, publishers authenticated as a sensor may only
+// report their own readings. Returns the stored reading
+func (i *ingester) handle(topic Topic, topicName string, payload []byte, sensorId string) (*Reading, error) {
+	reading, err := decodeMessage(topic, topicName, payload)
+	if err != nil {
+		return nil, err
+	}
+	if sensorId != "" && reading.SensorId != sensorId {
+		return nil, fmt.Errorf("sensor %s is not allowed to report readings of sensor %s", sensorId, reading.SensorId)
+	}
+	unit, err := metric.ParseUnit(reading.Metric, reading.Unit)
+	if err != nil {
+		return nil, err
+	}
+	if err := i.metricService.SaveMetric(context.Background(), reading.SensorId, reading.Metric, reading.Value, unit); err != nil {
+		return nil, fmt.Errorf("could not have saved a reading of sensor %s: %w", reading.SensorId, err)
+	}
+	return reading, nil
+}
+
+func decodeMessage(topic Topic, topicName string, payload []byte) (*Reading, error) {
+	sensorId, metricName, ok := topic.Pattern.match(topicName)
+	if !ok {
+		return nil, fmt.Errorf("%w '%s'", errTopicMismatch, topic.Pattern.Filter)
+	}
+	if metricName == "" {
+		metricName = topic.Metric
+	}
+	reading := &Reading{SensorId: sensorId, Metric: metricName}
+	if err := decodePayload(topic.Format, payload, reading); err != nil {
+		return nil, err
+	}
+	if reading.Metric == "" {
+		reading.Metric = metric.Temperature
+	}
+	return reading, nil
+}
@@ -0,0 +1,292 @@
+package mqtt
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// control packet types of MQTT 3.1.1 and 5.0
+const (
+	connectPacket     byte = 1
+	connackPacket     byte = 2
+	publishPacket     byte = 3
+	pubackPacket      byte = 4
+	pubrecPacket      byte = 5
+	pubrelPacket      byte = 6
+	pubcompPacket     byte = 7
+	subscribePacket   byte = 8
+	subackPacket      byte = 9
+	unsubscribePacket byte = 10
+	unsubackPacket    byte = 11
+	pingreqPacket     byte = 12
+	pingrespPacket    byte = 13
+	disconnectPacket  byte = 14
+	authPacket        byte = 15
+)
+
+// protocol levels of the CONNECT packet
+const (
+	protocolV31  byte = 3
+	protocolV311 byte = 4
+	protocolV5   byte = 5
+)
+
+const maxPacketSize = 256 * 1024
+
+var errMalformedPacket = errors.New("malformed packet")
+
+type packet struct {
+	kind  byte
+	flags byte
+	body  []byte
+}
+
+func readPacket(r *bufio.Reader) (*packet, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	length := 0
+	for shift := 0; ; shift += 7 {
+		if shift > 21 {
+			return nil, errMalformedPacket
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		length |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	if length > maxPacketSize {
+		return nil, fmt.Errorf("packet of %d bytes exceeds the limit of %d bytes", length, maxPacketSize)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return &packet{kind: header >> 4, flags: header & 0x0f, body: body}, nil
+}
+
+func writePacket(w io.Writer, kind byte, flags byte, body []byte) error {
+	buf := make([]byte, 0, len(body)+5)
+	buf = append(buf, kind<<4|flags)
+	buf = appendVarint(buf, len(body))
+	buf = append(buf, body...)
+	_, err := w.Write(buf)
+	return err
+}
+
+func appendVarint(buf []byte, value int) []byte {
+	for {
+		b := byte(value & 0x7f)
+		value >>= 7
+		if value > 0 {
+			b |= 0x80
+		}
+		buf = append(buf, b)
+		if value == 0 {
+			return buf
+		}
+	}
+}
+
+func appendUint16(buf []byte, value uint16) []byte {
+	return append(buf, byte(value>>8), byte(value))
+}
+
+func appendString(buf []byte, value string) []byte {
+	buf = appendUint16(buf, uint16(len(value)))
+	return append(buf, value...)
+}
+
+// decoder reads the fields of a packet body, the first failure sticks in err
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.data) {
+		d.err = errMalformedPacket
+		return nil
+	}
+	taken := d.data[:n]
+	d.data = d.data[n:]
+	return taken
+}
+
+func (d *decoder) byte() byte {
+	b := d.take(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (d *decoder) uint16() uint16 {
+	b := d.take(2)
+	if b == nil {
+		return 0
+	}
+	return uint16(b[0])<<8 | uint16(b[1])
+}
+
+func (d *decoder) bytes() []byte {
+	return d.take(int(d.uint16()))
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *decoder) varint() int {
+	value := 0
+	for shift := 0; shift <= 21; shift += 7 {
+		b := d.byte()
+		value |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return value
+		}
+	}
+	d.err = errMalformedPacket
+	return 0
+}
+
+// skipProperties skips the properties of an MQTT 5 packet, none of them change how readings are ingested
+func (d *decoder) skipProperties() {
+	d.take(d.varint())
+}
+
+func (d *decoder) rest() []byte {
+	if d.err != nil {
+		return nil
+	}
+	rest := d.data
+	d.data = nil
+	return rest
+}
+
+// connectRequest - the fields of a CONNECT packet the broker uses
+type connectRequest struct {
+	version      byte
+	cleanSession bool
+	keepAlive    uint16
+	clientId     string
+	willTopic    string
+	willPayload  []byte
+	willQos      byte
+	hasWill      bool
+	username     string
+	password     []byte
+}
+
+func decodeConnect(body []byte) (*connectRequest, error) {
+	d := &decoder{data: body}
+	protocol := d.string()
+	req := &connectRequest{version: d.byte()}
+	flags := d.byte()
+	req.keepAlive = d.uint16()
+	if d.err != nil {
+		return nil, d.err
+	}
+	if protocol != "MQTT" && protocol != "MQIsdp" {
+		return nil, fmt.Errorf("unknown protocol '%s'", protocol)
+	}
+	if flags&0x01 != 0 {
+		return nil, errMalformedPacket
+	}
+	if req.version == protocolV5 {
+		d.skipProperties()
+	}
+	req.cleanSession = flags&0x02 != 0
+	req.clientId = d.string()
+	if flags&0x04 != 0 {
+		req.hasWill = true
+		if req.version == protocolV5 {
+			d.skipProperties()
+		}
+		req.willTopic = d.string()
+		req.willPayload = d.bytes()
+		req.willQos = flags >> 3 & 0x03
+	}
+	if flags&0x80 != 0 {
+		req.username = d.string()
+	}
+	if flags&0x40 != 0 {
+		req.password = d.bytes()
+	}
+	return req, d.err
+}
+
+// publishRequest - a decoded PUBLISH packet
+type publishRequest struct {
+	topic    string
+	qos      byte
+	packetId uint16
+	payload  []byte
+}
+
+func decodePublish(version byte, flags byte, body []byte) (*publishRequest, error) {
+	d := &decoder{data: body}
+	req := &publishRequest{qos: flags >> 1 & 0x03, topic: d.string()}
+	if req.qos > 0 {
+		req.packetId = d.uint16()
+	}
+	if version == protocolV5 {
+		d.skipProperties()
+	}
+	req.payload = d.rest()
+	if d.err != nil {
+		return nil, d.err
+	}
+	if req.qos > 2 || req.topic == "" || !validTopicName(req.topic) {
+		return nil, errMalformedPacket
+	}
+	return req, nil
+}
+
+// subscription - a topic filter of a SUBSCRIBE packet with its requested QoS
+type subscription struct {
+	filter string
+	qos    byte
+}
+
+func decodeSubscribe(version byte, body []byte) (uint16, []subscription, error) {
+	d := &decoder{data: body}
+	packetId := d.uint16()
+	if version == protocolV5 {
+		d.skipProperties()
+	}
+	subscriptions := make([]subscription, 0)
+	for d.err == nil && len(d.data) > 0 {
+		filter := d.string()
+		// the upper bits carry the MQTT 5 no local, retain as published and retain handling options
+		options := d.byte()
+		subscriptions = append(subscriptions, subscription{filter: filter, qos: options & 0x03})
+	}
+	if d.err == nil && len(subscriptions) == 0 {
+		d.err = errMalformedPacket
+	}
+	return packetId, subscriptions, d.err
+}
+
+func decodeUnsubscribe(version byte, body []byte) (uint16, []string, error) {
+	d := &decoder{data: body}
+	packetId := d.uint16()
+	if version == protocolV5 {
+		d.skipProperties()
+	}
+	filters := make([]string, 0)
+	for d.err == nil && len(d.data) > 0 {
+		filters = append(filters, d.string())
+	}
+	return packetId, filters, d.err
+}
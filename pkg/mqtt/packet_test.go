@@ -0,0 +1,220 @@
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestPacketRoundTrip(t *testing.T) {
+	// remaining lengths at the boundaries of the one, two and three byte encodings
+	for _, length := range []int{0, 1, 127, 128, 16383, 16384, maxPacketSize} {
+		body := bytes.Repeat([]byte{0xab}, length)
+		buf := &bytes.Buffer{}
+		if err := writePacket(buf, publishPacket, 0x03, body); err != nil {
+			t.Fatal(err)
+		}
+		p, err := readPacket(bufio.NewReader(buf))
+		if err != nil {
+			t.Fatalf("a packet of %d bytes: %v", length, err)
+		}
+		if p.kind != publishPacket || p.flags != 0x03 || !bytes.Equal(p.body, body) {
+			t.Errorf("a packet of %d bytes came back as type %d, flags %x and %d bytes", length, p.kind, p.flags, len(p.body))
+		}
+	}
+	buf := &bytes.Buffer{}
+	_ = writePacket(buf, publishPacket, 0, make([]byte, maxPacketSize+1))
+	if _, err := readPacket(bufio.NewReader(buf)); err == nil {
+		t.Error("a packet exceeding the limit was read")
+	}
+	// five length bytes with the continuation bit
+	if _, err := readPacket(bufio.NewReader(bytes.NewReader([]byte{0x30, 0xff, 0xff, 0xff, 0xff, 0x7f}))); !errors.Is(err, errMalformedPacket) {
+		t.Errorf("an overlong remaining length returned %v", err)
+	}
+	// cut short
+	if _, err := readPacket(bufio.NewReader(bytes.NewReader([]byte{0x30, 0x05, 0x00}))); err == nil {
+		t.Error("a truncated packet was read")
+	}
+}
+
+// connectBody encodes a CONNECT packet body, an empty username or password is left out
+func connectBody(version byte, clientId string, username string, password string, will *publishRequest) []byte {
+	protocol := "MQTT"
+	if version == protocolV31 {
+		protocol = "MQIsdp"
+	}
+	flags := byte(0x02)
+	if will != nil {
+		flags |= 0x04 | will.qos<<3
+	}
+	if username != "" {
+		flags |= 0x80
+	}
+	if password != "" {
+		flags |= 0x40
+	}
+	body := appendString(nil, protocol)
+	body = append(body, version, flags)
+	body = appendUint16(body, 30)
+	if version == protocolV5 {
+		body = append(body, 0)
+	}
+	body = appendString(body, clientId)
+	if will != nil {
+		if version == protocolV5 {
+			body = append(body, 0)
+		}
+		body = appendString(body, will.topic)
+		body = appendString(body, string(will.payload))
+	}
+	if username != "" {
+		body = appendString(body, username)
+	}
+	if password != "" {
+		body = appendString(body, password)
+	}
+	return body
+}
+
+func TestDecodeConnect(t *testing.T) {
+	for _, version := range []byte{protocolV31, protocolV311, protocolV5} {
+		will := &publishRequest{topic: "sensors/kitchen/status", qos: 1, payload: []byte("offline")}
+		req, err := decodeConnect(connectBody(version, "kitchen-1", "kitchen", "secret", will))
+		if err != nil {
+			t.Fatalf("version %d: %v", version, err)
+		}
+		if req.version != version || !req.cleanSession || req.keepAlive != 30 || req.clientId != "kitchen-1" ||
+			req.username != "kitchen" || string(req.password) != "secret" {
+			t.Errorf("version %d decoded as %+v", version, req)
+		}
+		if !req.hasWill || req.willTopic != will.topic || req.willQos != 1 || string(req.willPayload) != "offline" {
+			t.Errorf("version %d will decoded as %+v", version, req)
+		}
+	}
+	req, err := decodeConnect(connectBody(protocolV311, "", "", "", nil))
+	if err != nil || req.hasWill || req.username != "" || req.password != nil {
+		t.Errorf("a CONNECT without will and credentials decoded as %+v, %v", req, err)
+	}
+	invalid := connectBody(protocolV311, "kitchen", "", "", nil)
+	invalid[2] = 'X' // protocol name
+	if _, err := decodeConnect(invalid); err == nil {
+		t.Error("an unknown protocol name was accepted")
+	}
+	reserved := connectBody(protocolV311, "kitchen", "", "", nil)
+	reserved[7] |= 0x01 // reserved flag
+	if _, err := decodeConnect(reserved); err == nil {
+		t.Error("a CONNECT with the reserved flag set was accepted")
+	}
+	if _, err := decodeConnect(connectBody(protocolV311, "kitchen", "user", "", nil)[:12]); err == nil {
+		t.Error("a truncated CONNECT was accepted")
+	}
+}
+
+// publishBody encodes a PUBLISH packet body
+func publishBody(version byte, topic string, qos byte, packetId uint16, payload string) []byte {
+	body := appendString(nil, topic)
+	if qos > 0 {
+		body = appendUint16(body, packetId)
+	}
+	if version == protocolV5 {
+		// a payload format indicator property
+		body = append(body, 2, 0x01, 0x01)
+	}
+	return append(body, payload...)
+}
+
+func TestDecodePublish(t *testing.T) {
+	for _, version := range []byte{protocolV311, protocolV5} {
+		for _, qos := range []byte{0, 1, 2} {
+			req, err := decodePublish(version, qos<<1, publishBody(version, "sensors/kitchen/temperature", qos, 7, "21.5"))
+			if err != nil {
+				t.Fatalf("version %d QoS %d: %v", version, qos, err)
+			}
+			wantId := uint16(0)
+			if qos > 0 {
+				wantId = 7
+			}
+			if req.topic != "sensors/kitchen/temperature" || req.qos != qos || req.packetId != wantId || string(req.payload) != "21.5" {
+				t.Errorf("version %d QoS %d decoded as %+v", version, qos, req)
+			}
+		}
+	}
+	invalid := []struct {
+		name  string
+		flags byte
+		body  []byte
+	}{
+		{"QoS 3", 0x06, publishBody(protocolV311, "sensors/kitchen/temperature", 1, 1, "1")},
+		{"wildcard topic", 0, publishBody(protocolV311, "sensors/+/temperature", 0, 0, "1")},
+		{"empty topic", 0, publishBody(protocolV311, "", 0, 0, "1")},
+		{"truncated topic", 0, []byte{0, 10, 's'}},
+	}
+	for _, test := range invalid {
+		if _, err := decodePublish(protocolV311, test.flags, test.body); err == nil {
+			t.Errorf("a PUBLISH with %s was accepted", test.name)
+		}
+	}
+}
+
+func subscribeBody(version byte, packetId uint16, subscriptions ...subscription) []byte {
+	body := appendUint16(nil, packetId)
+	if version == protocolV5 {
+		body = append(body, 0)
+	}
+	for _, sub := range subscriptions {
+		body = appendString(body, sub.filter)
+		body = append(body, sub.qos)
+	}
+	return body
+}
+
+func TestDecodeSubscribeAndUnsubscribe(t *testing.T) {
+	for _, version := range []byte{protocolV311, protocolV5} {
+		packetId, subscriptions, err := decodeSubscribe(version, subscribeBody(version, 9, subscription{filter: "sensors/+/+", qos: 2}))
+		if err != nil || packetId != 9 || len(subscriptions) != 1 || subscriptions[0] != (subscription{filter: "sensors/+/+", qos: 2}) {
+			t.Errorf("version %d SUBSCRIBE decoded as %d, %+v, %v", version, packetId, subscriptions, err)
+		}
+		body := appendUint16(nil, 10)
+		if version == protocolV5 {
+			body = append(body, 0)
+		}
+		body = appendString(appendString(body, "a/+"), "b/#")
+		packetId, filters, err := decodeUnsubscribe(version, body)
+		if err != nil || packetId != 10 || len(filters) != 2 || filters[0] != "a/+" || filters[1] != "b/#" {
+			t.Errorf("version %d UNSUBSCRIBE decoded as %d, %v, %v", version, packetId, filters, err)
+		}
+	}
+	if _, _, err := decodeSubscribe(protocolV311, appendUint16(nil, 1)); err == nil {
+		t.Error("a SUBSCRIBE without filters was accepted")
+	}
+	if _, _, err := decodeSubscribe(protocolV311, appendString(appendUint16(nil, 1), "a/+")); err == nil {
+		t.Error("a SUBSCRIBE without the options byte was accepted")
+	}
+}
+
+func TestFilters(t *testing.T) {
+	for filter, valid := range map[string]bool{"a/b": true, "a/+/c": true, "#": true, "a/#": true, "": false, "a/#/c": false, "a+/b": false, "a/b#": false} {
+		if validFilter(filter) != valid {
+			t.Errorf("validFilter(%q) = %v", filter, !valid)
+		}
+	}
+	tests := []struct {
+		filter string
+		topic  string
+		match  bool
+	}{
+		{"a/+/c", "a/b/c", true},
+		{"a/+/c", "a/b/d", false},
+		{"a/#", "a", true},
+		{"a/#", "a/b/c", true},
+		{"#", "$SYS/uptime", false},
+		{"$SYS/#", "$SYS/uptime", true},
+		{"a/b", "a/b/c", false},
+	}
+	for _, test := range tests {
+		if matchFilter(test.filter, test.topic) != test.match {
+			t.Errorf("matchFilter(%q, %q) = %v", test.filter, test.topic, !test.match)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PayloadFormat - how the payload of a sensor message is encoded
+type PayloadFormat string
+
+const (
+	// Raw - a plain number, optionally followed by its unit: "21.5" or "70.7 F"
+	Raw PayloadFormat = "raw"
+	// Json - {"value": 21.5, "unit": "C"}, the sensorId and metric fields override the topic
+	Json PayloadFormat = "json"
+	// Auto - Json for payloads starting with '{', Raw otherwise
+	Auto PayloadFormat = "auto"
+)
+
+// Reading - a single sensor reading decoded from a message
+type Reading struct {
+	SensorId string
+	Metric   string
+	Value    float64
+	Unit     string
+}
+
+type jsonPayload struct {
+	SensorId string   `json:"sensorId"`
+	Metric   string   `json:"metric"`
+	Value    *float64 `json:"value"`
+	// Temp - the field name of the /temp/ payloads
+	Temp *float64 `json:"temp"`
+	Unit string   `json:"unit"`
+}
+
+// ParsePayloadFormat resolves a payload format from the configuration, an empty value falls back to Auto
+func ParsePayloadFormat(format string) (PayloadFormat, error) {
+	switch PayloadFormat(strings.ToLower(format)) {
+	case "", Auto:
+		return Auto, nil
+	case Raw:
+		return Raw, nil
+	case Json:
+		return Json, nil
+	}
+	return "", fmt.Errorf("unknown payload format '%s', expected raw, json or auto", format)
+}
+
+// decodePayload fills the value and unit of reading, a Json payload may also override its sensorId and metric
+func decodePayload(format PayloadFormat, payload []byte, reading *Reading) error {
+	trimmed := strings.TrimSpace(string(payload))
+	if format == Auto {
+		format = Raw
+		if strings.HasPrefix(trimmed, "{") {
+			format = Json
+		}
+	}
+	if format == Raw {
+		fields := strings.Fields(trimmed)
+		if len(fields) == 0 || len(fields) > 2 {
+			return fmt.Errorf("invalid raw payload '%s'", trimmed)
+		}
+		value, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return fmt.Errorf("invalid raw payload '%s': %w", trimmed, err)
+		}
+		reading.Value = value
+		if len(fields) == 2 {
+			reading.Unit = fields[1]
+		}
+		return nil
+	}
+	decoded := jsonPayload{}
+	if err := json.Unmarshal([]byte(trimmed), &decoded); err != nil {
+		return fmt.Errorf("invalid json payload: %w", err)
+	}
+	switch {
+	case decoded.Value != nil:
+		reading.Value = *decoded.Value
+	case decoded.Temp != nil:
+		reading.Value = *decoded.Temp
+	default:
+		return fmt.Errorf("json payload has no 'value'")
+	}
+	if decoded.SensorId != "" {
+		reading.SensorId = decoded.SensorId
+	}
+	if decoded.Metric != "" {
+		reading.Metric = decoded.Metric
+	}
+	if decoded.Unit != "" {
+		reading.Unit = decoded.Unit
+	}
+	return nil
+}
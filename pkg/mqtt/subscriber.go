@@ -0,0 +1,95 @@
+package mqtt
+
+import (
+	"github.com/andreikom/sensor-server/pkg/api/metric"
+	"github.com/andreikom/sensor-server/pkg/logging"
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"time"
+)
+
+const (
+	defaultClientId      = "sensor-server"
+	connectTimeout       = 30 * time.Second
+	maxReconnectInterval = time.Minute
+)
+
+// Options - the broker the Subscriber connects to and the topics it subscribes
+type Options struct {
+	// BrokerUrl - tcp://host:1883, ssl://host:8883 or ws://host/mqtt
+	BrokerUrl string
+	ClientId  string
+	Username  string
+	Password  string
+	Topics    []Topic
+}
+
+// Topic - a subscription, readings on topics without a metric level are stored as Metric
+type Topic struct {
+	Pattern *TopicPattern
+	Metric  string
+	Qos     byte
+	Format  PayloadFormat
+}
+
+// Subscriber - feeds readings published by MQTT devices into the MetricService ingest path
+type Subscriber struct {
+	ingester *ingester
+	options  Options
+	client   paho.Client
+}
+
+func NewSubscriber(metricService *metric.MetricService, options Options) *Subscriber {
+	if options.ClientId == "" {
+		options.ClientId = defaultClientId
+	}
+	return &Subscriber{ingester: &ingester{metricService: metricService}, options: options}
+}
+
+// Start connects in the background, subscriptions are restored whenever the connection is re-established
+func (s *Subscriber) Start() {
+	clientOptions := paho.NewClientOptions().
+		AddBroker(s.options.BrokerUrl).
+		SetClientID(s.options.ClientId).
+		SetUsername(s.options.Username).
+		SetPassword(s.options.Password).
+		SetCleanSession(false).
+		SetOrderMatters(false).
+		SetAutoReconnect(true).
+		SetMaxReconnectInterval(maxReconnectInterval).
+		SetConnectRetry(true).
+		SetOnConnectHandler(s.subscribe).
+		SetConnectionLostHandler(func(client paho.Client, err error) {
+			logging.Log.WithError(err).WithField("broker", s.options.BrokerUrl).Warn("Lost the MQTT connection, reconnecting")
+		})
+	s.client = paho.NewClient(clientOptions)
+	token := s.client.Connect()
+	go func() {
+		if token.WaitTimeout(connectTimeout) && token.Error() != nil {
+			logging.Log.WithError(token.Error()).WithField("broker", s.options.BrokerUrl).Error("Could not connect to the MQTT broker")
+		}
+	}()
+}
+
+// Stop disconnects, waiting up to a second for in-flight messages
+func (s *Subscriber) Stop() {
+	if s.client != nil {
+		s.client.Disconnect(1000)
+	}
+}
+
+func (s *Subscriber) subscribe(client paho.Client) {
+	logging.Log.WithField("broker", s.options.BrokerUrl).Info("Connected to the MQTT broker")
+	for _, topic := range s.options.Topics {
+		topic := topic
+		token := client.Subscribe(topic.Pattern.Filter, topic.Qos, func(client paho.Client, msg paho.Message) {
+			if _, err := s.ingester.handle(topic, msg.Topic(), msg.Payload(), ""); err != nil {
+				logging.Log.WithError(err).WithField("topic", msg.Topic()).Warn("Dropping an MQTT message")
+			}
+		})
+		go func() {
+			if token.WaitTimeout(connectTimeout) && token.Error() != nil {
+				logging.Log.WithError(token.Error()).WithField("topic", topic.Pattern.Filter).Error("Could not subscribe")
+			}
+		}()
+	}
+}
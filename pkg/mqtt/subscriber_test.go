@@ -0,0 +1,254 @@
+package mqtt
+
+import (
+	"github.com/andreikom/sensor-server/pkg/api/metric"
+	"github.com/andreikom/sensor-server/pkg/api/metric/metrictest"
+	"github.com/andreikom/sensor-server/pkg/broker"
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"net"
+	"sort"
+	"testing"
+	"time"
+)
+
+// waitForReadings waits until n readings are spooled and removes them from the spool
+func waitForReadings(t *testing.T, spool *broker.Spool, n int) []metric.MetricQueueMsg {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for spool.Len() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d of %d readings arrived", spool.Len(), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	readings := metrictest.SpooledReadings(t, spool)
+	sort.Slice(readings, func(i, j int) bool {
+		return readings[i].SensorId < readings[j].SensorId
+	})
+	return readings
+}
+
+// startBroker serves an embedded broker until the test ends, it returns once the broker accepts connections
+func startBroker(t *testing.T, metricService *metric.MetricService, options BrokerOptions) *Broker {
+	t.Helper()
+	b := NewBroker(metricService, options)
+	go func() {
+		_ = b.ListenAndServe()
+	}()
+	t.Cleanup(func() {
+		_ = b.Close()
+	})
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", options.Address)
+		if err == nil {
+			conn.Close()
+			return b
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the broker did not start: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// connectClient connects a device to the broker at address, username may be empty
+func connectClient(t *testing.T, address string, clientId string, username string, password string) (paho.Client, error) {
+	options := paho.NewClientOptions().AddBroker("tcp://" + address).SetClientID(clientId).
+		SetUsername(username).SetPassword(password).SetAutoReconnect(false).SetConnectTimeout(5 * time.Second)
+	client := paho.NewClient(options)
+	token := client.Connect()
+	if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		return nil, token.Error()
+	}
+	t.Cleanup(func() {
+		client.Disconnect(100)
+	})
+	return client, nil
+}
+
+func publish(t *testing.T, client paho.Client, topic string, qos byte, payload string) {
+	t.Helper()
+	token := client.Publish(topic, qos, false, payload)
+	if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("could not publish to %s: %v", topic, token.Error())
+	}
+}
+
+func mustParseTopic(t *testing.T, filter string, metricName string, format PayloadFormat) Topic {
+	pattern, err := ParseTopicPattern(filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Topic{Pattern: pattern, Metric: metricName, Qos: 1, Format: format}
+}
+
+func TestParseTopicPattern(t *testing.T) {
+	tests := []struct {
+		filter   string
+		topic    string
+		sensorId string
+		metric   string
+		ok       bool
+	}{
+		{"sensors/+/temperature", "sensors/kitchen/temperature", "kitchen", "", true},
+		{"sensors/+/temperature", "sensors/kitchen/humidity", "", "", false},
+		{"sensors/+/temperature", "sensors/kitchen", "", "", false},
+		{"sensors/+/+", "sensors/kitchen/co2", "kitchen", "co2", true},
+		{"sensors/+/+", "sensors//co2", "", "co2", false},
+		{"home/+/#", "home/kitchen/ground/floor", "kitchen", "", true},
+		{"home/+/#", "home/kitchen", "kitchen", "", true},
+		{"+/temperature", "$SYS/temperature", "", "", false},
+	}
+	for _, test := range tests {
+		pattern, err := ParseTopicPattern(test.filter)
+		if err != nil {
+			t.Fatalf("ParseTopicPattern(%q): %v", test.filter, err)
+		}
+		sensorId, metricName, ok := pattern.match(test.topic)
+		if ok != test.ok || (ok && (sensorId != test.sensorId || metricName != test.metric)) {
+			t.Errorf("%s matching %s = %q, %q, %v, want %q, %q, %v", test.filter, test.topic, sensorId, metricName, ok, test.sensorId, test.metric, test.ok)
+		}
+	}
+	for _, filter := range []string{"sensors/temperature", "sensors/+/+/+", "sensors/#/+", "sensors/kitchen+/temperature", "sensors/+/temp#"} {
+		if _, err := ParseTopicPattern(filter); err == nil {
+			t.Errorf("ParseTopicPattern(%q) accepted an invalid pattern", filter)
+		}
+	}
+}
+
+func TestDecodePayload(t *testing.T) {
+	tests := []struct {
+		format  PayloadFormat
+		payload string
+		want    Reading
+	}{
+		{Raw, "21.5", Reading{SensorId: "kitchen", Metric: "temperature", Value: 21.5}},
+		{Raw, " 70.7 F\n", Reading{SensorId: "kitchen", Metric: "temperature", Value: 70.7, Unit: "F"}},
+		{Json, `{"value": 21.5, "unit": "C"}`, Reading{SensorId: "kitchen", Metric: "temperature", Value: 21.5, Unit: "C"}},
+		{Json, `{"temp": 19}`, Reading{SensorId: "kitchen", Metric: "temperature", Value: 19}},
+		{Json, `{"sensorId": "hallway", "metric": "humidity", "value": 40}`, Reading{SensorId: "hallway", Metric: "humidity", Value: 40}},
+		{Auto, `{"value": 1}`, Reading{SensorId: "kitchen", Metric: "temperature", Value: 1}},
+		{Auto, "1013 hPa", Reading{SensorId: "kitchen", Metric: "temperature", Value: 1013, Unit: "hPa"}},
+	}
+	for _, test := range tests {
+		reading := Reading{SensorId: "kitchen", Metric: "temperature"}
+		if err := decodePayload(test.format, []byte(test.payload), &reading); err != nil {
+			t.Errorf("%s payload %q: %v", test.format, test.payload, err)
+			continue
+		}
+		if reading != test.want {
+			t.Errorf("%s payload %q = %+v, want %+v", test.format, test.payload, reading, test.want)
+		}
+	}
+	invalid := []struct {
+		format  PayloadFormat
+		payload string
+	}{
+		{Raw, ""},
+		{Raw, "warm"},
+		{Raw, "21.5 C extra"},
+		{Raw, `{"value": 1}`},
+		{Json, "21.5"},
+		{Json, `{"unit": "C"}`},
+		{Auto, "{broken"},
+	}
+	for _, test := range invalid {
+		if err := decodePayload(test.format, []byte(test.payload), &Reading{}); err == nil {
+			t.Errorf("%s payload %q was accepted", test.format, test.payload)
+		}
+	}
+	for input, want := range map[string]PayloadFormat{"": Auto, "RAW": Raw, "json": Json, "auto": Auto} {
+		if got, err := ParsePayloadFormat(input); err != nil || got != want {
+			t.Errorf("ParsePayloadFormat(%q) = %q, %v, want %q", input, got, err, want)
+		}
+	}
+	if _, err := ParsePayloadFormat("xml"); err == nil {
+		t.Error("ParsePayloadFormat accepted an unknown format")
+	}
+}
+
+func TestDecodeMessage(t *testing.T) {
+	topic := mustParseTopic(t, "sensors/+/+", "", Auto)
+	reading, err := decodeMessage(topic, "sensors/kitchen/humidity", []byte("40"))
+	if err != nil || *reading != (Reading{SensorId: "kitchen", Metric: "humidity", Value: 40}) {
+		t.Errorf("decodeMessage = %+v, %v", reading, err)
+	}
+	// topics without a metric level are stored as the metric of the topic, temperature by default
+	reading, err = decodeMessage(mustParseTopic(t, "co2/+", "co2", Raw), "co2/kitchen", []byte("400"))
+	if err != nil || reading.Metric != "co2" {
+		t.Errorf("decodeMessage = %+v, %v, want a co2 reading", reading, err)
+	}
+	reading, err = decodeMessage(mustParseTopic(t, "legacy/+", "", Raw), "legacy/kitchen", []byte("20"))
+	if err != nil || reading.Metric != metric.Temperature {
+		t.Errorf("decodeMessage = %+v, %v, want a temperature reading", reading, err)
+	}
+	if _, err := decodeMessage(topic, "other/kitchen/humidity", []byte("40")); err == nil {
+		t.Error("a message on a topic not matching the pattern was decoded")
+	}
+}
+
+func TestSubscriberStoresReadingsAndResubscribes(t *testing.T) {
+	brokerService, _ := metrictest.NewSpoolingService(t)
+	address := metrictest.FreeAddress(t)
+	options := BrokerOptions{Address: address, AllowAnonymous: true, Topics: []Topic{mustParseTopic(t, "sensors/+/+", "", Auto)}}
+	embedded := startBroker(t, brokerService, options)
+
+	service, spool := metrictest.NewSpoolingService(t)
+	subscriber := NewSubscriber(service, Options{BrokerUrl: "tcp://" + address, ClientId: "subscriber", Topics: []Topic{mustParseTopic(t, "sensors/+/+", "", Auto)}})
+	subscriber.Start()
+	defer subscriber.Stop()
+	waitForSubscription(t, embedded, "subscriber")
+
+	device, err := connectClient(t, address, "device", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publish(t, device, "sensors/kitchen/temperature", 1, "70.7 F")
+	publish(t, device, "sensors/hallway/humidity", 1, `{"value": 40.5}`)
+	// neither stored nor acknowledged by the embedded broker
+	publish(t, device, "sensors/attic/temperature", 0, "not a number")
+	readings := waitForReadings(t, spool, 2)
+	if readings[0].SensorId != "hallway" || readings[0].Metric != metric.Humidity || readings[0].Value != 40.5 {
+		t.Errorf("first reading = %+v", readings[0])
+	}
+	if readings[1].SensorId != "kitchen" || readings[1].Metric != metric.Temperature || readings[1].Value < 21.49 || readings[1].Value > 21.51 {
+		t.Errorf("second reading = %+v, want 21.5 Celsius", readings[1])
+	}
+
+	// the broker goes away, the subscriber reconnects to its replacement and subscribes again
+	_ = embedded.Close()
+	embedded = startBroker(t, brokerService, options)
+	waitForSubscription(t, embedded, "subscriber")
+	device, err = connectClient(t, address, "device", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publish(t, device, "sensors/kitchen/co2", 1, "415")
+	readings = waitForReadings(t, spool, 1)
+	if readings[0].SensorId != "kitchen" || readings[0].Metric != metric.CO2 || readings[0].Value != 415 {
+		t.Errorf("reading after the reconnect = %+v", readings[0])
+	}
+	if spool.Len() != 0 {
+		t.Errorf("%d unexpected readings were stored", spool.Len())
+	}
+}
+
+// waitForSubscription waits until the client subscribed on the broker
+func waitForSubscription(t *testing.T, b *Broker, clientId string) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		b.mutex.RLock()
+		s, ok := b.sessions[clientId]
+		subscribed := ok && len(s.subscriptions) > 0
+		b.mutex.RUnlock()
+		if subscribed {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s did not subscribe", clientId)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
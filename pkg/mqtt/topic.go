@@ -0,0 +1,122 @@
+package mqtt
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TopicPattern - an MQTT topic filter whose first '+' level carries the sensor id, e.g. sensors/+/temperature.
+// A second '+' level carries the metric name, e.g. sensors/+/+
+type TopicPattern struct {
+	Filter      string
+	levels      []string
+	sensorLevel int
+	metricLevel int
+}
+
+func ParseTopicPattern(filter string) (*TopicPattern, error) {
+	pattern := &TopicPattern{Filter: filter, levels: strings.Split(filter, "/"), sensorLevel: -1, metricLevel: -1}
+	for i, level := range pattern.levels {
+		switch {
+		case level == "+" && pattern.sensorLevel < 0:
+			pattern.sensorLevel = i
+		case level == "+" && pattern.metricLevel < 0:
+			pattern.metricLevel = i
+		case level == "+":
+			return nil, fmt.Errorf("topic pattern '%s' has more than two '+' levels", filter)
+		case level == "#" && i != len(pattern.levels)-1:
+			return nil, fmt.Errorf("'#' must be the last level of topic pattern '%s'", filter)
+		case level == "#":
+		case strings.ContainsAny(level, "+#"):
+			return nil, fmt.Errorf("wildcards must fill a whole level of topic pattern '%s'", filter)
+		}
+	}
+	if pattern.sensorLevel < 0 {
+		return nil, fmt.Errorf("topic pattern '%s' has no '+' level for the sensor id", filter)
+	}
+	return pattern, nil
+}
+
+// match extracts the sensor id and, for patterns with a metric level, the metric name of a topic
+func (p *TopicPattern) match(topic string) (string, string, bool) {
+	if !matchFilter(p.Filter, topic) {
+		return "", "", false
+	}
+	levels := strings.Split(topic, "/")
+	metricName := ""
+	if p.metricLevel >= 0 {
+		metricName = levels[p.metricLevel]
+	}
+	return levels[p.sensorLevel], metricName, levels[p.sensorLevel] != ""
+}
+
+// covers reports whether every topic matching filter also matches the pattern, a non-empty sensorId
+// has to fill the sensor level of filter
+func (p *TopicPattern) covers(filter string, sensorId string) bool {
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		if i >= len(p.levels) {
+			return false
+		}
+		patternLevel := p.levels[i]
+		if patternLevel == "#" {
+			return true // the sensor level comes before '#' and was checked already
+		}
+		if i == p.sensorLevel && sensorId != "" && level != sensorId {
+			return false
+		}
+		switch {
+		case level == "#":
+			return false // '#' matches any number of levels, only a '#' of the pattern covers it
+		case patternLevel == "+":
+		case level != patternLevel:
+			return false
+		}
+	}
+	if len(levels) == len(p.levels) {
+		return true
+	}
+	// the parent level of a trailing '#' is matched by it as well
+	return len(levels) == len(p.levels)-1 && p.levels[len(p.levels)-1] == "#"
+}
+
+// validTopicName - topics messages are published on must not contain wildcards
+func validTopicName(topic string) bool {
+	return !strings.ContainsAny(topic, "+#\x00")
+}
+
+// validFilter - '+' and '#' must fill a whole level and '#' must be the last one
+func validFilter(filter string) bool {
+	if filter == "" || strings.ContainsRune(filter, 0) {
+		return false
+	}
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		if strings.ContainsAny(level, "+#") && len(level) != 1 {
+			return false
+		}
+		if level == "#" && i != len(levels)-1 {
+			return false
+		}
+	}
+	return true
+}
+
+// matchFilter reports whether a topic matches a subscription filter, topics starting with '$'
+// are not matched by a leading wildcard
+func matchFilter(filter string, topic string) bool {
+	if strings.HasPrefix(topic, "$") && (strings.HasPrefix(filter, "+") || strings.HasPrefix(filter, "#")) {
+		return false
+	}
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) || (level != "+" && level != topicLevels[i]) {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
@@ -0,0 +1,64 @@
+package simulate
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// peakDayFraction - the diurnal sine peaks mid-afternoon, at 15:00 local time
+const peakDayFraction = 0.625
+
+// Pattern - how the readings of a virtual sensor evolve
+type Pattern struct {
+	// Base - the mean of the readings
+	Base float64
+	// Amplitude - of the diurnal sine around Base
+	Amplitude float64
+	// Noise - the standard deviation of the gaussian noise added to every reading
+	Noise float64
+	// DriftPerHour - how far the mean moves every hour of the simulation, e.g. a sensor losing its calibration
+	DriftPerHour float64
+	// DropoutRate - the share of readings a sensor fails to send, 0 to 1
+	DropoutRate float64
+	// SpikeRate - the share of readings off by SpikeSize, up or down, 0 to 1
+	SpikeRate float64
+	SpikeSize float64
+}
+
+// sensor - a virtual sensor, readings of a sensor are generated one at a time
+type sensor struct {
+	id    string
+	mutex sync.Mutex
+	rand  *rand.Rand
+	// phase - shifts the diurnal peak of the sensor by up to an hour, so sensors do not move in lockstep
+	phase float64
+}
+
+func newSensor(id string, seed int64) *sensor {
+	random := rand.New(rand.NewSource(seed))
+	return &sensor{id: id, rand: random, phase: (random.Float64()*2 - 1) / 24}
+}
+
+// next returns the reading of the sensor at the simulated time at, ok is false for a dropout
+func (s *sensor) next(pattern Pattern, at time.Time, elapsed time.Duration) (float64, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.rand.Float64() < pattern.DropoutRate {
+		return 0, false
+	}
+	dayFraction := (float64(at.Hour()) + float64(at.Minute())/60 + float64(at.Second())/3600) / 24
+	value := pattern.Base +
+		pattern.Amplitude*math.Sin(2*math.Pi*(dayFraction-peakDayFraction+s.phase)+math.Pi/2) +
+		pattern.DriftPerHour*elapsed.Hours() +
+		pattern.Noise*s.rand.NormFloat64()
+	if s.rand.Float64() < pattern.SpikeRate {
+		if s.rand.Intn(2) == 0 {
+			value -= pattern.SpikeSize
+		} else {
+			value += pattern.SpikeSize
+		}
+	}
+	return value, true
+}
@@ -0,0 +1,70 @@
+package simulate
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSensorsWithTheSameSeedAgree(t *testing.T) {
+	pattern := Pattern{Base: 21, Amplitude: 4, Noise: 0.5, SpikeRate: 0.1, SpikeSize: 10, DropoutRate: 0.1}
+	first, second := newSensor("a", 42), newSensor("b", 42)
+	at := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 100; i++ {
+		v1, ok1 := first.next(pattern, at, 0)
+		v2, ok2 := second.next(pattern, at, 0)
+		if v1 != v2 || ok1 != ok2 {
+			t.Fatalf("reading %d: %v %v and %v %v", i, v1, ok1, v2, ok2)
+		}
+	}
+	if other, _ := newSensor("c", 43).next(Pattern{Base: 21, Noise: 1}, at, 0); other == 21 {
+		t.Error("the noise of another seed is 0")
+	}
+}
+
+func TestDiurnalPattern(t *testing.T) {
+	pattern := Pattern{Base: 20, Amplitude: 5}
+	day := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+	// the phase of a sensor shifts its peak by up to an hour
+	shifted := pattern.Amplitude * math.Cos(2*math.Pi/24)
+	for seed := int64(0); seed < 20; seed++ {
+		s := newSensor("kitchen", seed)
+		if peak, _ := s.next(pattern, day.Add(15*time.Hour), 0); peak < pattern.Base+shifted || peak > pattern.Base+pattern.Amplitude {
+			t.Errorf("seed %d: the reading at 15:00 is %v", seed, peak)
+		}
+		if trough, _ := s.next(pattern, day.Add(3*time.Hour), 0); trough > pattern.Base-shifted || trough < pattern.Base-pattern.Amplitude {
+			t.Errorf("seed %d: the reading at 03:00 is %v", seed, trough)
+		}
+	}
+}
+
+func TestDriftDropoutsAndSpikes(t *testing.T) {
+	at := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	s := newSensor("kitchen", 1)
+	if value, ok := s.next(Pattern{Base: 20, DriftPerHour: 0.5}, at, 10*time.Hour); !ok || value != 25 {
+		t.Errorf("the reading after 10h of drift is %v, %v", value, ok)
+	}
+	for i := 0; i < 100; i++ {
+		if _, ok := s.next(Pattern{Base: 20, DropoutRate: 1}, at, 0); ok {
+			t.Fatal("a sensor with a dropout rate of 1 sent a reading")
+		}
+		if _, ok := s.next(Pattern{Base: 20}, at, 0); !ok {
+			t.Fatal("a sensor without dropouts skipped a reading")
+		}
+	}
+	up, down := 0, 0
+	for i := 0; i < 200; i++ {
+		value, _ := s.next(Pattern{Base: 20, SpikeRate: 1, SpikeSize: 10}, at, 0)
+		switch value {
+		case 30:
+			up++
+		case 10:
+			down++
+		default:
+			t.Fatalf("a spike of %v", value)
+		}
+	}
+	if up == 0 || down == 0 {
+		t.Errorf("%d spikes up and %d down", up, down)
+	}
+}
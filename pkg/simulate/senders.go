@@ -0,0 +1,99 @@
+package simulate
+
+import (
+	"context"
+	"fmt"
+	"github.com/andreikom/sensor-server/pkg/client"
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"strconv"
+	"time"
+)
+
+const (
+	Http = "http"
+	Grpc = "grpc"
+	Mqtt = "mqtt"
+	// mqttConnectTimeout - how long NewMqttSender waits for the broker
+	mqttConnectTimeout = 10 * time.Second
+)
+
+// Sender - sends the reading of a sensor, it is called concurrently
+type Sender interface {
+	Send(ctx context.Context, sensorId string, metric string, value float64) error
+	Close() error
+}
+
+// clientSender - the HTTP or gRPC API through the client package
+type clientSender struct {
+	save  func(ctx context.Context, reading client.Reading) error
+	close func() error
+}
+
+func (s *clientSender) Send(ctx context.Context, sensorId string, metric string, value float64) error {
+	return s.save(ctx, client.Reading{SensorId: sensorId, Metric: metric, Value: value})
+}
+
+func (s *clientSender) Close() error {
+	return s.close()
+}
+
+// NewHttpSender posts readings to the HTTP API, retries are disabled so latencies are those of single calls
+func NewHttpSender(options client.Options) (Sender, error) {
+	options.MaxRetries = -1
+	httpClient, err := client.New(options)
+	if err != nil {
+		return nil, err
+	}
+	return &clientSender{save: httpClient.Save, close: func() error { return nil }}, nil
+}
+
+// NewGrpcSender calls SaveMetric of the gRPC API, without retries
+func NewGrpcSender(options client.Options) (Sender, error) {
+	options.MaxRetries = -1
+	grpcClient, err := client.NewGrpc(options)
+	if err != nil {
+		return nil, err
+	}
+	return &clientSender{save: grpcClient.Save, close: grpcClient.Close}, nil
+}
+
+// mqttSender - publishes raw readings on sensors/<sensorId>/<metric>, the default topic of the server,
+// with QoS 1 so a send completes once the broker acknowledged it
+type mqttSender struct {
+	client paho.Client
+}
+
+// NewMqttSender connects to brokerUrl, e.g. tcp://localhost:1883. Brokers authenticating sensors by their
+// id as the username need a connection per sensor, username and password are shared by all virtual sensors
+func NewMqttSender(brokerUrl string, username string, password string) (Sender, error) {
+	options := paho.NewClientOptions().
+		AddBroker(brokerUrl).
+		SetClientID(fmt.Sprintf("sensor-server-simulate-%d", time.Now().UnixNano())).
+		SetUsername(username).
+		SetPassword(password).
+		SetConnectTimeout(mqttConnectTimeout)
+	mqttClient := paho.NewClient(options)
+	token := mqttClient.Connect()
+	if !token.WaitTimeout(mqttConnectTimeout) {
+		return nil, fmt.Errorf("could not have connected to %s within %s", brokerUrl, mqttConnectTimeout)
+	}
+	if err := token.Error(); err != nil {
+		return nil, err
+	}
+	return &mqttSender{client: mqttClient}, nil
+}
+
+func (s *mqttSender) Send(ctx context.Context, sensorId string, metric string, value float64) error {
+	token := s.client.Publish("sensors/"+sensorId+"/"+metric, 1, false, strconv.FormatFloat(value, 'f', -1, 64))
+	select {
+	case <-token.Done():
+		return token.Error()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *mqttSender) Close() error {
+	s.client.Disconnect(250)
+	return nil
+}
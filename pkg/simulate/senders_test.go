@@ -0,0 +1,92 @@
+package simulate
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/andreikom/sensor-server/pkg/api/metric/metrictest"
+	"github.com/andreikom/sensor-server/pkg/client"
+	"github.com/andreikom/sensor-server/pkg/mqtt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHttpSender(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || r.URL.Path != "/metric/" ||
+			body["sensorId"] != "sim-0001" || body["metric"] != "humidity" || body["value"] != 45.5 {
+			t.Errorf("%s %s %v: %v", r.Method, r.URL.Path, body, err)
+		}
+		if atomic.LoadInt32(&calls) > 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	sender, err := NewHttpSender(client.Options{Address: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	if err := sender.Send(context.Background(), "sim-0001", "humidity", 45.5); err != nil {
+		t.Fatal(err)
+	}
+	// failed readings are not retried, the latency is that of a single call
+	if err := sender.Send(context.Background(), "sim-0001", "humidity", 45.5); err == nil {
+		t.Error("a failed reading was not reported")
+	}
+	if calls != 2 {
+		t.Errorf("the server was called %d times, want 2", calls)
+	}
+	if _, err := NewHttpSender(client.Options{Address: "localhost"}); err == nil {
+		t.Error("an address without a scheme was accepted")
+	}
+	if _, err := NewGrpcSender(client.Options{}); err == nil {
+		t.Error("a gRPC sender without an address was created")
+	}
+}
+
+func TestMqttSender(t *testing.T) {
+	service, spool := metrictest.NewSpoolingService(t)
+	pattern, err := mqtt.ParseTopicPattern("sensors/+/+")
+	if err != nil {
+		t.Fatal(err)
+	}
+	format, err := mqtt.ParsePayloadFormat("auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := metrictest.FreeAddress(t)
+	embedded := mqtt.NewBroker(service, mqtt.BrokerOptions{Address: address, AllowAnonymous: true,
+		Topics: []mqtt.Topic{{Pattern: pattern, Qos: 1, Format: format}}})
+	go embedded.ListenAndServe()
+	defer embedded.Close()
+
+	var sender Sender
+	deadline := time.Now().Add(5 * time.Second)
+	for sender == nil {
+		if sender, err = NewMqttSender("tcp://"+address, "", ""); err != nil {
+			if time.Now().After(deadline) {
+				t.Fatal(err)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+	defer sender.Close()
+	for _, id := range []string{"sim-0001", "sim-0002"} {
+		if err := sender.Send(context.Background(), id, "temperature", 21.25); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// QoS 1 completes once the broker stored the reading
+	if spool.Len() != 2 {
+		t.Errorf("%d readings were stored, want 2", spool.Len())
+	}
+	if _, err := NewMqttSender("tcp://"+metrictest.FreeAddress(t), "", ""); err == nil {
+		t.Error("connected to a broker which is not running")
+	}
+}
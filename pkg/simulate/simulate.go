@@ -0,0 +1,233 @@
+package simulate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/time/rate"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultSensors     = 10
+	defaultRate        = 10
+	defaultConcurrency = 16
+	defaultMetric      = "temperature"
+	// maxLatencySamples - latencies kept for the percentiles, a uniform sample of them beyond that
+	maxLatencySamples = 100000
+)
+
+// Options - what Run simulates
+type Options struct {
+	// Sensors - virtual sensors, readings are sent for them in turn, defaultSensors if empty
+	Sensors int
+	// SensorPrefix - sensor ids are the prefix followed by the number of the sensor, "sim-" if empty
+	SensorPrefix string
+	// Metric - of the readings, temperature if empty
+	Metric string
+	// Rate - readings per second over all sensors, dropouts included, defaultRate if empty
+	Rate float64
+	// Duration - of the simulation, until ctx is done if empty
+	Duration time.Duration
+	// Concurrency - readings sent at once, bounds the achieved rate when the server is slow, defaultConcurrency if empty
+	Concurrency int
+	// TimeScale - simulated time passing per real time, e.g. 1440 runs through a day a minute, 1 if empty
+	TimeScale float64
+	// Seed - of the generated readings, runs with the same seed and options generate the same values
+	Seed    int64
+	Pattern Pattern
+	// Progress is called every ProgressInterval with the report so far, if set
+	Progress         func(report Report)
+	ProgressInterval time.Duration
+}
+
+func (o Options) withDefaults() Options {
+	if o.Sensors <= 0 {
+		o.Sensors = defaultSensors
+	}
+	if o.SensorPrefix == "" {
+		o.SensorPrefix = "sim-"
+	}
+	if o.Metric == "" {
+		o.Metric = defaultMetric
+	}
+	if o.Rate <= 0 {
+		o.Rate = defaultRate
+	}
+	if o.Concurrency <= 0 {
+		o.Concurrency = defaultConcurrency
+	}
+	if o.TimeScale <= 0 {
+		o.TimeScale = 1
+	}
+	if o.ProgressInterval <= 0 {
+		o.ProgressInterval = 5 * time.Second
+	}
+	return o
+}
+
+// Report - the outcome of a simulation
+type Report struct {
+	// Sent - readings the server accepted
+	Sent int64
+	// Failed - readings the server rejected or which could not be sent
+	Failed int64
+	// Dropped - readings the sensors did not send, see Pattern.DropoutRate
+	Dropped int64
+	Elapsed time.Duration
+	// LastError - the last failure to send a reading
+	LastError error
+	// latencies - sorted, of sent and failed readings
+	latencies []time.Duration
+}
+
+// Throughput - readings sent per second
+func (r Report) Throughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Sent) / r.Elapsed.Seconds()
+}
+
+// Percentile - the latency p, 0 to 100, of the readings sent and failed, 0 without readings
+func (r Report) Percentile(p float64) time.Duration {
+	if len(r.latencies) == 0 {
+		return 0
+	}
+	index := int(p/100*float64(len(r.latencies))+0.5) - 1
+	if index < 0 {
+		index = 0
+	}
+	if index >= len(r.latencies) {
+		index = len(r.latencies) - 1
+	}
+	return r.latencies[index]
+}
+
+func (r Report) String() string {
+	return fmt.Sprintf("sent %d, failed %d, dropped %d in %s, %.1f readings/s, latency p50 %s p90 %s p99 %s max %s",
+		r.Sent, r.Failed, r.Dropped, r.Elapsed.Round(time.Millisecond), r.Throughput(),
+		r.Percentile(50), r.Percentile(90), r.Percentile(99), r.Percentile(100))
+}
+
+// recorder - collects the outcome of the readings from the workers
+type recorder struct {
+	start     time.Time
+	sent      int64
+	failed    int64
+	dropped   int64
+	mutex     sync.Mutex
+	seen      int64
+	latencies []time.Duration
+	lastError error
+	random    *rand.Rand
+}
+
+func (r *recorder) record(latency time.Duration, err error) {
+	if err != nil {
+		atomic.AddInt64(&r.failed, 1)
+	} else {
+		atomic.AddInt64(&r.sent, 1)
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if err != nil {
+		r.lastError = err
+	}
+	// reservoir sampling keeps the memory bounded for long runs
+	r.seen++
+	if len(r.latencies) < maxLatencySamples {
+		r.latencies = append(r.latencies, latency)
+	} else if i := r.random.Int63n(r.seen); i < maxLatencySamples {
+		r.latencies[i] = latency
+	}
+}
+
+func (r *recorder) report() Report {
+	r.mutex.Lock()
+	latencies := make([]time.Duration, len(r.latencies))
+	copy(latencies, r.latencies)
+	lastError := r.lastError
+	r.mutex.Unlock()
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	return Report{
+		Sent:      atomic.LoadInt64(&r.sent),
+		Failed:    atomic.LoadInt64(&r.failed),
+		Dropped:   atomic.LoadInt64(&r.dropped),
+		Elapsed:   time.Since(r.start),
+		LastError: lastError,
+		latencies: latencies,
+	}
+}
+
+// Run sends the readings of the virtual sensors through sender at options.Rate until options.Duration passed or
+// ctx is done, then waits for the readings in flight. It fails only if no reading could be sent
+func Run(ctx context.Context, sender Sender, options Options) (Report, error) {
+	options = options.withDefaults()
+	if options.Pattern.DropoutRate < 0 || options.Pattern.DropoutRate > 1 || options.Pattern.SpikeRate < 0 || options.Pattern.SpikeRate > 1 {
+		return Report{}, errors.New("the dropout and spike rates have to be between 0 and 1")
+	}
+	if options.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.Duration)
+		defer cancel()
+	}
+	sensors := make([]*sensor, options.Sensors)
+	for i := range sensors {
+		sensors[i] = newSensor(fmt.Sprintf("%s%04d", options.SensorPrefix, i+1), options.Seed+int64(i))
+	}
+	// a burst of a tenth of a second smooths the pacing of the workers at high rates
+	burst := int(options.Rate / 10)
+	if burst < 1 {
+		burst = 1
+	}
+	limiter := rate.NewLimiter(rate.Limit(options.Rate), burst)
+	rec := &recorder{start: time.Now(), random: rand.New(rand.NewSource(options.Seed))}
+	var next int64 = -1
+	var wg sync.WaitGroup
+	for w := 0; w < options.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for limiter.Wait(ctx) == nil {
+				s := sensors[atomic.AddInt64(&next, 1)%int64(len(sensors))]
+				elapsed := time.Duration(float64(time.Since(rec.start)) * options.TimeScale)
+				value, ok := s.next(options.Pattern, rec.start.Add(elapsed), elapsed)
+				if !ok {
+					atomic.AddInt64(&rec.dropped, 1)
+					continue
+				}
+				// a reading in flight when the simulation ends is still awaited
+				sendStart := time.Now()
+				err := sender.Send(context.Background(), s.id, options.Metric, value)
+				rec.record(time.Since(sendStart), err)
+			}
+		}()
+	}
+	if options.Progress != nil {
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			ticker := time.NewTicker(options.ProgressInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					options.Progress(rec.report())
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	report := rec.report()
+	if report.Sent == 0 && report.Failed > 0 {
+		return report, fmt.Errorf("could not have sent any reading: %w", report.LastError)
+	}
+	return report, nil
+}
@@ -0,0 +1,191 @@
+package simulate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSender - records the readings sent, failing with err if set
+type fakeSender struct {
+	mutex    sync.Mutex
+	readings map[string]int
+	values   []float64
+	err      error
+	delay    time.Duration
+}
+
+func newFakeSender() *fakeSender {
+	return &fakeSender{readings: make(map[string]int)}
+}
+
+func (s *fakeSender) Send(ctx context.Context, sensorId string, metric string, value float64) error {
+	time.Sleep(s.delay)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.readings[sensorId+" "+metric]++
+	s.values = append(s.values, value)
+	return nil
+}
+
+func (s *fakeSender) Close() error {
+	return nil
+}
+
+func TestRunSendsTheReadingsOfAllSensors(t *testing.T) {
+	sender := newFakeSender()
+	sender.delay = time.Millisecond
+	progress := make(chan Report, 100)
+	report, err := Run(context.Background(), sender, Options{
+		Sensors:          3,
+		SensorPrefix:     "room-",
+		Metric:           "humidity",
+		Rate:             200,
+		Duration:         300 * time.Millisecond,
+		Concurrency:      4,
+		Pattern:          Pattern{Base: 50},
+		Progress:         func(report Report) { progress <- report },
+		ProgressInterval: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Sent < 20 || report.Failed != 0 || report.Dropped != 0 || report.LastError != nil {
+		t.Errorf("Run = %s", report)
+	}
+	if int(report.Sent) != len(sender.values) {
+		t.Errorf("reported %d readings sent, the sender got %d", report.Sent, len(sender.values))
+	}
+	// the sensors are sent in turn
+	for _, id := range []string{"room-0001", "room-0002", "room-0003"} {
+		if count := sender.readings[id+" humidity"]; count < int(report.Sent)/3-4 {
+			t.Errorf("%s sent %d of %d readings", id, count, report.Sent)
+		}
+	}
+	// the rate is kept, with the initial burst
+	if max := 200*report.Elapsed.Seconds() + 25; float64(report.Sent) > max {
+		t.Errorf("sent %d readings in %s at 200/s", report.Sent, report.Elapsed)
+	}
+	if report.Percentile(50) < time.Millisecond || report.Throughput() <= 0 {
+		t.Errorf("Run = %s", report)
+	}
+	if len(progress) == 0 {
+		t.Error("no progress was reported")
+	}
+}
+
+func TestRunStopsWithTheContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	report, err := Run(ctx, newFakeSender(), Options{Rate: 100})
+	if err != nil || report.Sent == 0 {
+		t.Errorf("Run = %s, %v", report, err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Run took %s", elapsed)
+	}
+}
+
+func TestRunFailures(t *testing.T) {
+	sender := newFakeSender()
+	sender.err = errors.New("connection refused")
+	report, err := Run(context.Background(), sender, Options{Rate: 100, Duration: 100 * time.Millisecond})
+	if err == nil || !errors.Is(err, sender.err) || report.Sent != 0 || report.Failed == 0 {
+		t.Errorf("Run with a failing sender = %s, %v", report, err)
+	}
+	// sensors which never send are not a failure of the server
+	report, err = Run(context.Background(), newFakeSender(), Options{Rate: 100, Duration: 100 * time.Millisecond, Pattern: Pattern{DropoutRate: 1}})
+	if err != nil || report.Sent != 0 || report.Dropped == 0 {
+		t.Errorf("Run with dropouts only = %s, %v", report, err)
+	}
+	for _, pattern := range []Pattern{{DropoutRate: -0.1}, {DropoutRate: 1.5}, {SpikeRate: 2}} {
+		if _, err := Run(context.Background(), newFakeSender(), Options{Pattern: pattern}); err == nil {
+			t.Errorf("Run accepted the pattern %+v", pattern)
+		}
+	}
+}
+
+func TestRunIsReproducible(t *testing.T) {
+	values := func() []float64 {
+		sender := newFakeSender()
+		// a single worker and sensor send the readings in a fixed order
+		if _, err := Run(context.Background(), sender, Options{Sensors: 1, Concurrency: 1, Rate: 1000, Duration: 50 * time.Millisecond, Seed: 7,
+			Pattern: Pattern{Base: 20, Noise: 1}}); err != nil {
+			t.Fatal(err)
+		}
+		return sender.values
+	}
+	first, second := values(), values()
+	for i := 0; i < len(first) && i < len(second) && i < 10; i++ {
+		if first[i] != second[i] {
+			t.Fatalf("reading %d is %v and %v", i, first[i], second[i])
+		}
+	}
+}
+
+func TestReport(t *testing.T) {
+	report := Report{Sent: 90, Failed: 10, Elapsed: 3 * time.Second}
+	for i := 1; i <= 100; i++ {
+		report.latencies = append(report.latencies, time.Duration(i)*time.Millisecond)
+	}
+	tests := map[float64]time.Duration{0: time.Millisecond, 50: 50 * time.Millisecond, 90: 90 * time.Millisecond, 99: 99 * time.Millisecond, 100: 100 * time.Millisecond}
+	for p, want := range tests {
+		if got := report.Percentile(p); got != want {
+			t.Errorf("Percentile(%v) = %s, want %s", p, got, want)
+		}
+	}
+	if report.Throughput() != 30 {
+		t.Errorf("Throughput = %v", report.Throughput())
+	}
+	if got := report.String(); !strings.HasPrefix(got, "sent 90, failed 10, dropped 0 in 3s, 30.0 readings/s, latency p50 50ms p90 90ms") {
+		t.Errorf("String = %s", got)
+	}
+	if empty := (Report{}); empty.Percentile(50) != 0 || empty.Throughput() != 0 {
+		t.Errorf("an empty report has %s", empty)
+	}
+}
+
+func TestRecorderKeepsABoundedSample(t *testing.T) {
+	rec := &recorder{start: time.Now(), random: rand.New(rand.NewSource(1))}
+	failure := errors.New("timeout")
+	for i := 0; i < maxLatencySamples+1000; i++ {
+		var err error
+		if i%10 == 0 {
+			err = failure
+		}
+		rec.record(time.Duration(i), err)
+	}
+	report := rec.report()
+	if len(report.latencies) != maxLatencySamples {
+		t.Errorf("kept %d latencies", len(report.latencies))
+	}
+	if report.Sent+report.Failed != maxLatencySamples+1000 || report.Failed != (maxLatencySamples+1000)/10 || report.LastError != failure {
+		t.Errorf("recorded %s", report)
+	}
+	if !sortedDurations(report.latencies) {
+		t.Error("the latencies are not sorted")
+	}
+}
+
+func sortedDurations(durations []time.Duration) bool {
+	for i := 1; i < len(durations); i++ {
+		if durations[i] < durations[i-1] {
+			return false
+		}
+	}
+	return true
+}
+
+func ExampleReport_String() {
+	fmt.Println(Report{Sent: 10, Elapsed: time.Second})
+	// Output: sent 10, failed 0, dropped 0 in 1s, 10.0 readings/s, latency p50 0s p90 0s p99 0s max 0s
+}
@@ -1,87 +1,165 @@
 package storage
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"github.com/andreikom/sensor-server/pkg/logging"
 	"io/fs"
 	"io/ioutil"
-	"log"
 	"os"
 	"path/filepath"
 	"strings"
 )
 
-var temperatureStorePath string
+const (
+	// legacyTemperatureFolder - temperature records are kept in the folder used before multi-metric support
+	legacyTemperatureFolder = "temperatures"
+	metricsFolder           = "metrics"
+	archiveFolder           = "archive"
+)
+
+// ErrInvalidSensorId - a sensor id which would not name a file directly inside a metric folder
+var ErrInvalidSensorId = errors.New("invalid sensor id")
 
 type FsDriver struct {
 	storePath string
 }
 
 func NewFSDriver(storePath string) *FsDriver {
-	temperatureStorePath = filepath.Join(storePath, "temperatures")
+	driver := &FsDriver{storePath: storePath}
+	temperatureStorePath := driver.metricStorePath("temperature")
+	logger := logging.Log.WithField("path", temperatureStorePath)
 	if _, err := os.Stat(temperatureStorePath); err == nil {
-		fmt.Printf("Temperatures store folder already exists: %s\n", temperatureStorePath)
+		logger.Debug("Temperatures store folder already exists")
 	} else if errors.Is(err, os.ErrNotExist) {
-		fmt.Printf("Creating temperatures folder at: %s\n", temperatureStorePath)
+		logger.Info("Creating temperatures folder")
 		if err := os.Mkdir(temperatureStorePath, 0755); err != nil {
 			errorMsg := fmt.Sprintf("Could not have created a folder at: %s\n", err)
 			panic(errorMsg)
 		}
-		fmt.Printf("Created temperatures folder\n")
 	}
-	log.Println(temperatureStorePath)
-	return &FsDriver{storePath: storePath}
+	return driver
+}
+
+// metricStorePath - each metric is kept in its own folder under the store path
+func (d FsDriver) metricStorePath(metric string) string {
+	if metric == "temperature" {
+		return filepath.Join(d.storePath, legacyTemperatureFolder)
+	}
+	return filepath.Join(d.storePath, metricsFolder, metric)
 }
 
-func (d FsDriver) SaveSensorData(sensorId string, data []byte) error {
-	sensorJsonFilePath := filepath.Join(temperatureStorePath, sensorId+".json")
-	err := ioutil.WriteFile(sensorJsonFilePath, data, 0644)
+// sensorFilePath - the record of a sensor in folder, ids are checked at the service boundary as well,
+// the driver still refuses ids which would leave folder
+func sensorFilePath(folder string, sensorId string) (string, error) {
+	if sensorId == "" || sensorId == "." || sensorId == ".." || strings.ContainsAny(sensorId, `/\`) {
+		return "", fmt.Errorf("%w: '%s'", ErrInvalidSensorId, sensorId)
+	}
+	return filepath.Join(folder, sensorId+".json"), nil
+}
+
+func (d FsDriver) SaveSensorData(ctx context.Context, metric string, sensorId string, data []byte) error {
+	metricStorePath := d.metricStorePath(metric)
+	sensorJsonFilePath, err := sensorFilePath(metricStorePath, sensorId)
 	if err != nil {
-		fmt.Println("Could not have created a file at: " + sensorJsonFilePath)
+		return err
+	}
+	if err := os.MkdirAll(metricStorePath, 0755); err != nil {
+		logging.Log.WithError(err).WithField("path", metricStorePath).Error("Could not have created a folder")
+		return err
+	}
+	err = ioutil.WriteFile(sensorJsonFilePath, data, 0644)
+	if err != nil {
+		logging.Log.WithError(err).WithField("path", sensorJsonFilePath).Error("Could not have created a file")
 		return err
 	}
 	return nil
 }
 
-func (d FsDriver) GetAvailableSensors() ([]string, error) {
+func (d FsDriver) GetAvailableSensors(ctx context.Context, metric string) ([]string, error) {
 	sensors := make([]string, 0)
-	err := filepath.WalkDir(temperatureStorePath+"/", visitBySensorId(&sensors))
+	metricStorePath := d.metricStorePath(metric)
+	if _, err := os.Stat(metricStorePath); errors.Is(err, os.ErrNotExist) {
+		return sensors, nil
+	}
+	err := filepath.WalkDir(metricStorePath, visitBySensorId(metricStorePath, &sensors))
 	if err != nil {
-		fmt.Printf("Error while attempting to list sensors: %s", err)
+		logging.Log.WithError(err).WithField("metric", metric).Error("Could not list sensors")
 		return nil, err
 	}
 	return sensors, nil
 }
 
-func visitBySensorId(sensors *[]string) fs.WalkDirFunc {
+func visitBySensorId(root string, sensors *[]string) fs.WalkDirFunc {
 	return func(path string, entry fs.DirEntry, err error) error {
-		if !entry.IsDir() {
-			splitPath := strings.Split(path, ".")
-			sensorId := strings.Split(splitPath[0], "/")
-			*sensors = append(*sensors, sensorId[len(sensorId)-1])
-		}
 		if err != nil {
 			return err
 		}
+		if entry.IsDir() && path != root {
+			return fs.SkipDir // sensor records are only kept at the top level of a metric folder
+		}
+		if !entry.IsDir() && filepath.Ext(path) == ".json" {
+			*sensors = append(*sensors, strings.TrimSuffix(filepath.Base(path), ".json"))
+		}
 		return nil
 	}
 }
 
-func (d FsDriver) GetSensorData(sensorId string) ([]byte, error) {
-	sensorFolderPath := filepath.Join(temperatureStorePath)
-	folder, err := os.Open(sensorFolderPath) // TODO [andreik]: add existence check first
+func (d FsDriver) GetSensorData(ctx context.Context, metric string, sensorId string) ([]byte, error) {
+	sensorPath, err := sensorFilePath(d.metricStorePath(metric), sensorId)
+	if err != nil {
+		return nil, err
+	}
+	sensorFile, err := ioutil.ReadFile(sensorPath)
+	if err != nil {
+		logging.Log.WithError(err).WithField("path", sensorPath).Error("Could not read a sensor record file")
+		return nil, err
+	}
+	return sensorFile, nil
+}
+
+func (d FsDriver) SaveArchiveData(ctx context.Context, metric string, sensorId string, data []byte) error {
+	archiveStorePath := filepath.Join(d.metricStorePath(metric), archiveFolder)
+	archiveJsonFilePath, err := sensorFilePath(archiveStorePath, sensorId)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(archiveStorePath, 0755); err != nil {
+		logging.Log.WithError(err).WithField("path", archiveStorePath).Error("Could not have created a folder")
+		return err
+	}
+	// written to a temporary file first, a partially written archive would lose every archived day
+	tmpFilePath := archiveJsonFilePath + ".tmp"
+	if err := ioutil.WriteFile(tmpFilePath, data, 0644); err != nil {
+		logging.Log.WithError(err).WithField("path", tmpFilePath).Error("Could not have created a file")
+		return err
+	}
+	return os.Rename(tmpFilePath, archiveJsonFilePath)
+}
+
+// GetArchiveData returns an error wrapping os.ErrNotExist if the sensor has no archived days
+func (d FsDriver) GetArchiveData(ctx context.Context, metric string, sensorId string) ([]byte, error) {
+	archivePath, err := sensorFilePath(filepath.Join(d.metricStorePath(metric), archiveFolder), sensorId)
 	if err != nil {
-		fmt.Printf("Error while attempting to open temp folder at: %s. Error: %s\n", sensorFolderPath, err)
 		return nil, err
-	} else if folder != nil { // TODO [andreik]: probably dont need this
-		sensorPath := filepath.Join(temperatureStorePath, sensorId)
-		sensorPath = sensorPath + ".json"
-		sensorFile, err := ioutil.ReadFile(sensorPath)
+	}
+	return ioutil.ReadFile(archivePath)
+}
+
+// CheckWritable creates and removes a file in the store folder
+func (d FsDriver) CheckWritable(ctx context.Context) error {
+	probe, err := ioutil.TempFile(d.storePath, ".healthcheck-*")
+	if err != nil {
+		return err
+	}
+	_, writeErr := probe.Write([]byte("ok"))
+	closeErr := probe.Close()
+	removeErr := os.Remove(probe.Name())
+	for _, err := range []error{writeErr, closeErr, removeErr} {
 		if err != nil {
-			fmt.Printf("Error while attempting to read a sensor record file at: %s. Error: %s\n", sensorPath, err)
-			return nil, err
+			return err
 		}
-		return sensorFile, nil
 	}
-	return nil, nil // the case that the file does not exist and neither an error happened
+	return nil
 }
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestSensorRecordsRoundTrip(t *testing.T) {
+	driver := NewFSDriver(t.TempDir())
+	ctx := context.Background()
+	for _, metric := range []string{"temperature", "humidity"} {
+		if err := driver.SaveSensorData(ctx, metric, "kitchen", []byte(`{"id":"kitchen"}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := driver.SaveSensorData(ctx, "temperature", "hallway", []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := driver.SaveArchiveData(ctx, "temperature", "attic", []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	sensors, err := driver.GetAvailableSensors(ctx, "temperature")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(sensors)
+	// archived sensors are kept in a sub folder and are not listed
+	if len(sensors) != 2 || sensors[0] != "hallway" || sensors[1] != "kitchen" {
+		t.Errorf("GetAvailableSensors = %v, want [hallway kitchen]", sensors)
+	}
+	data, err := driver.GetSensorData(ctx, "humidity", "kitchen")
+	if err != nil || string(data) != `{"id":"kitchen"}` {
+		t.Errorf("GetSensorData = %s, %v", data, err)
+	}
+	if _, err := driver.GetArchiveData(ctx, "temperature", "kitchen"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("GetArchiveData of a sensor without archive returned %v", err)
+	}
+}
+
+func TestSensorIdsCanNotLeaveTheStore(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "store"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	driver := NewFSDriver(filepath.Join(dir, "store"))
+	ctx := context.Background()
+	for _, id := range []string{"", ".", "..", "../../escaped", "../escaped", "a/b", `a\b`} {
+		if err := driver.SaveSensorData(ctx, "temperature", id, []byte(`{}`)); !errors.Is(err, ErrInvalidSensorId) {
+			t.Errorf("SaveSensorData(%q) returned %v", id, err)
+		}
+		if _, err := driver.GetSensorData(ctx, "temperature", id); !errors.Is(err, ErrInvalidSensorId) {
+			t.Errorf("GetSensorData(%q) returned %v", id, err)
+		}
+		if err := driver.SaveArchiveData(ctx, "temperature", id, []byte(`{}`)); !errors.Is(err, ErrInvalidSensorId) {
+			t.Errorf("SaveArchiveData(%q) returned %v", id, err)
+		}
+		if _, err := driver.GetArchiveData(ctx, "temperature", id); !errors.Is(err, ErrInvalidSensorId) {
+			t.Errorf("GetArchiveData(%q) returned %v", id, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "escaped.json")); !os.IsNotExist(err) {
+		t.Errorf("a record was written outside of the store: %v", err)
+	}
+}
+
+func TestCheckWritable(t *testing.T) {
+	dir := t.TempDir()
+	if err := NewFSDriver(dir).CheckWritable(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// the probe file is removed
+	if probes, err := filepath.Glob(filepath.Join(dir, ".healthcheck-*")); err != nil || len(probes) != 0 {
+		t.Errorf("the store folder holds %v, %v", probes, err)
+	}
+	missing := &FsDriver{storePath: filepath.Join(dir, "missing")}
+	if err := missing.CheckWritable(context.Background()); err == nil {
+		t.Error("a missing store folder was reported writable")
+	}
+}
@@ -1,7 +1,13 @@
 package storage
 
+import "context"
+
+// Driver - ctx carries the trace of the reading or request a call is made for
 type Driver interface {
-	SaveSensorData(sensorId string, data []byte) error
-	GetAvailableSensors() ([]string, error)
-	GetSensorData(sensorId string) ([]byte, error)
+	SaveSensorData(ctx context.Context, metric string, sensorId string, data []byte) error
+	GetAvailableSensors(ctx context.Context, metric string) ([]string, error)
+	GetSensorData(ctx context.Context, metric string, sensorId string) ([]byte, error)
+	// SaveArchiveData and GetArchiveData keep the rolled up days of a sensor, separately from its raw readings
+	SaveArchiveData(ctx context.Context, metric string, sensorId string, data []byte) error
+	GetArchiveData(ctx context.Context, metric string, sensorId string) ([]byte, error)
 }
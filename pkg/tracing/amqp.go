@@ -0,0 +1,55 @@
+package tracing
+
+import (
+	"context"
+	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// headerCarrier - adapts the headers of an AMQP message to a propagation.TextMapCarrier
+type headerCarrier amqp.Table
+
+func (c headerCarrier) Get(key string) string {
+	value, _ := c[key].(string)
+	return value
+}
+
+func (c headerCarrier) Set(key string, value string) {
+	c[key] = value
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// StartPublish starts the producer span of a message published to queue and writes its trace context into
+// headers, so the span processing the message continues the trace. Copies of the headers carry the trace
+// along when the message is retried or dead-lettered
+func StartPublish(ctx context.Context, queue string, headers amqp.Table) (context.Context, trace.Span) {
+	ctx, span := Tracer.Start(ctx, queue+" send", trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(messagingAttributes(queue)...))
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier(headers))
+	return ctx, span
+}
+
+// StartProcess starts the consumer span of a delivery from queue, a child of the span it was published in
+func StartProcess(queue string, headers amqp.Table) (context.Context, trace.Span) {
+	parent := otel.GetTextMapPropagator().Extract(context.Background(), headerCarrier(headers))
+	attributes := append(messagingAttributes(queue), semconv.MessagingOperationProcess)
+	return Tracer.Start(parent, queue+" process", trace.WithSpanKind(trace.SpanKindConsumer), trace.WithAttributes(attributes...))
+}
+
+func messagingAttributes(queue string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		semconv.MessagingSystemKey.String("rabbitmq"),
+		semconv.MessagingDestinationKey.String(queue),
+		semconv.MessagingDestinationKindQueue,
+	}
+}
@@ -0,0 +1,45 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// tracesPath - the path of the trace service of an OTLP/HTTP receiver
+	tracesPath    = "/v1/traces"
+	exportTimeout = 10 * time.Second
+)
+
+// otlpUrl accepts host:port, meaning http://host:port/v1/traces, or a url
+func otlpUrl(endpoint string) (*url.URL, error) {
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+	parsed, err := url.Parse(endpoint)
+	if err != nil || parsed.Host == "" || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return nil, fmt.Errorf("invalid OTLP endpoint '%s'", endpoint)
+	}
+	if parsed.Path == "" || parsed.Path == "/" {
+		parsed.Path = tracesPath
+	}
+	return parsed, nil
+}
+
+// newOtlpExporter - exports spans to the OTLP/HTTP receiver at endpoint as binary protobuf
+func newOtlpExporter(ctx context.Context, endpoint *url.URL) (*otlptrace.Exporter, error) {
+	options := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(endpoint.Host),
+		otlptracehttp.WithURLPath(endpoint.Path),
+		otlptracehttp.WithTimeout(exportTimeout),
+	}
+	if endpoint.Scheme == "http" {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	return otlptracehttp.New(ctx, options...)
+}
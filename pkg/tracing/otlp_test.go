@@ -0,0 +1,140 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestOtlpUrl(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		want     string
+	}{
+		{"localhost:4318", "http://localhost:4318/v1/traces"},
+		{"http://collector:4318/", "http://collector:4318/v1/traces"},
+		{"https://otlp.example.com/otlp/v1/traces", "https://otlp.example.com/otlp/v1/traces"},
+	}
+	for _, test := range tests {
+		got, err := otlpUrl(test.endpoint)
+		if err != nil || got.String() != test.want {
+			t.Errorf("otlpUrl(%q) = %v, %v, want %s", test.endpoint, got, err, test.want)
+		}
+	}
+	for _, endpoint := range []string{"http://", "grpc://collector:4317", "http://[::1"} {
+		if _, err := otlpUrl(endpoint); err == nil {
+			t.Errorf("otlpUrl(%q) accepted an invalid endpoint", endpoint)
+		}
+	}
+}
+
+// otlpReceiver - an OTLP/HTTP receiver collecting the requests it was sent
+type otlpReceiver struct {
+	requests chan *collectortrace.ExportTraceServiceRequest
+	paths    chan string
+}
+
+func (r *otlpReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	request := &collectortrace.ExportTraceServiceRequest{}
+	if req.Header.Get("Content-Type") != "application/x-protobuf" || proto.Unmarshal(body, request) != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	r.paths <- req.URL.Path
+	r.requests <- request
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	response, _ := proto.Marshal(&collectortrace.ExportTraceServiceResponse{})
+	_, _ = w.Write(response)
+}
+
+func TestOtlpExporter(t *testing.T) {
+	receiver := &otlpReceiver{requests: make(chan *collectortrace.ExportTraceServiceRequest, 10), paths: make(chan string, 10)}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	endpoint, err := url.Parse(server.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoint.Path = tracesPath
+	exporter, err := newOtlpExporter(context.Background(), endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithSyncer(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String("sensor-server-test"))),
+	)
+	tracer := provider.Tracer(instrumentationName)
+	ctx, parent := tracer.Start(context.Background(), "POST /temp", trace.WithSpanKind(trace.SpanKindServer))
+	_, child := tracer.Start(ctx, "storage.SaveSensorData", trace.WithAttributes(SensorIdKey.String("kitchen"), attribute.Float64("value", 21.5)))
+	End(child, errors.New("disk full"))
+	parent.End()
+	if err := provider.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if path := <-receiver.paths; path != tracesPath {
+		t.Errorf("spans were sent to %s", path)
+	}
+	spans := make([]*tracepb.Span, 0)
+	for len(spans) < 2 {
+		request := <-receiver.requests
+		for _, resourceSpans := range request.ResourceSpans {
+			serviceName := ""
+			for _, kv := range resourceSpans.Resource.Attributes {
+				if kv.Key == string(semconv.ServiceNameKey) {
+					serviceName = kv.Value.GetStringValue()
+				}
+			}
+			if serviceName != "sensor-server-test" {
+				t.Errorf("the resource has the service name %q", serviceName)
+			}
+			for _, scopeSpans := range resourceSpans.ScopeSpans {
+				if scopeSpans.Scope.Name != instrumentationName {
+					t.Errorf("spans of the scope %q", scopeSpans.Scope.Name)
+				}
+				spans = append(spans, scopeSpans.Spans...)
+			}
+		}
+	}
+	exportedChild, exportedParent := spans[0], spans[1]
+	if exportedChild.Name != "storage.SaveSensorData" || exportedParent.Name != "POST /temp" {
+		t.Fatalf("exported %q and %q", exportedChild.Name, exportedParent.Name)
+	}
+	parentId := parent.SpanContext().SpanID()
+	if string(exportedChild.ParentSpanId) != string(parentId[:]) || exportedParent.Kind != tracepb.Span_SPAN_KIND_SERVER {
+		t.Errorf("the child has the parent %x, the parent is a %s span", exportedChild.ParentSpanId, exportedParent.Kind)
+	}
+	if exportedChild.Status.Code != tracepb.Status_STATUS_CODE_ERROR || exportedChild.Status.Message != "disk full" || len(exportedChild.Events) != 1 {
+		t.Errorf("the failed span was exported with %v and %d events", exportedChild.Status, len(exportedChild.Events))
+	}
+	values := make(map[string]interface{})
+	for _, kv := range exportedChild.Attributes {
+		switch value := kv.Value.Value.(type) {
+		case *commonpb.AnyValue_StringValue:
+			values[kv.Key] = value.StringValue
+		case *commonpb.AnyValue_DoubleValue:
+			values[kv.Key] = value.DoubleValue
+		}
+	}
+	if values[string(SensorIdKey)] != "kitchen" || values["value"] != 21.5 {
+		t.Errorf("exported the attributes %v", values)
+	}
+	if exportedParent.Status.Code != tracepb.Status_STATUS_CODE_UNSET {
+		t.Errorf("the parent was exported with %v", exportedParent.Status)
+	}
+}
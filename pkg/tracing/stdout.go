@@ -0,0 +1,79 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"io"
+	"sync"
+	"time"
+)
+
+// stdoutExporter - writes a JSON object per span, for local debugging without a collector
+type stdoutExporter struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+}
+
+type stdoutSpan struct {
+	Name          string                 `json:"name"`
+	TraceId       string                 `json:"traceId"`
+	SpanId        string                 `json:"spanId"`
+	ParentSpanId  string                 `json:"parentSpanId,omitempty"`
+	Kind          string                 `json:"kind"`
+	Start         time.Time              `json:"start"`
+	DurationMs    float64                `json:"durationMs"`
+	Attributes    map[string]interface{} `json:"attributes,omitempty"`
+	Events        []stdoutEvent          `json:"events,omitempty"`
+	Status        string                 `json:"status"`
+	StatusMessage string                 `json:"statusMessage,omitempty"`
+}
+
+type stdoutEvent struct {
+	Name       string                 `json:"name"`
+	Time       time.Time              `json:"time"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+func newStdoutExporter(w io.Writer) *stdoutExporter {
+	return &stdoutExporter{encoder: json.NewEncoder(w)}
+}
+
+func (e *stdoutExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	for _, span := range spans {
+		out := stdoutSpan{
+			Name:          span.Name(),
+			TraceId:       span.SpanContext().TraceID().String(),
+			SpanId:        span.SpanContext().SpanID().String(),
+			Kind:          span.SpanKind().String(),
+			Start:         span.StartTime(),
+			DurationMs:    float64(span.EndTime().Sub(span.StartTime())) / float64(time.Millisecond),
+			Attributes:    make(map[string]interface{}, len(span.Attributes())),
+			Status:        span.Status().Code.String(),
+			StatusMessage: span.Status().Description,
+		}
+		if span.Parent().IsValid() {
+			out.ParentSpanId = span.Parent().SpanID().String()
+		}
+		for _, kv := range span.Attributes() {
+			out.Attributes[string(kv.Key)] = kv.Value.AsInterface()
+		}
+		for _, event := range span.Events() {
+			outEvent := stdoutEvent{Name: event.Name, Time: event.Time, Attributes: make(map[string]interface{}, len(event.Attributes))}
+			for _, kv := range event.Attributes {
+				outEvent.Attributes[string(kv.Key)] = kv.Value.AsInterface()
+			}
+			out.Events = append(out.Events, outEvent)
+		}
+		if err := e.encoder.Encode(out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *stdoutExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
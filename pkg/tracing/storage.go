@@ -0,0 +1,59 @@
+package tracing
+
+import (
+	"context"
+	"github.com/andreikom/sensor-server/pkg/storage"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracedDriver - starts a span for every call of the wrapped driver, a child of the span of the call context
+type tracedDriver struct {
+	driver storage.Driver
+}
+
+func TraceDriver(driver storage.Driver) storage.Driver {
+	return &tracedDriver{driver: driver}
+}
+
+func (d *tracedDriver) SaveSensorData(ctx context.Context, metric string, sensorId string, data []byte) error {
+	ctx, span := startStorageSpan(ctx, "SaveSensorData", metric, sensorId)
+	err := d.driver.SaveSensorData(ctx, metric, sensorId, data)
+	End(span, err)
+	return err
+}
+
+func (d *tracedDriver) GetAvailableSensors(ctx context.Context, metric string) ([]string, error) {
+	ctx, span := startStorageSpan(ctx, "GetAvailableSensors", metric, "")
+	sensors, err := d.driver.GetAvailableSensors(ctx, metric)
+	End(span, err)
+	return sensors, err
+}
+
+func (d *tracedDriver) GetSensorData(ctx context.Context, metric string, sensorId string) ([]byte, error) {
+	ctx, span := startStorageSpan(ctx, "GetSensorData", metric, sensorId)
+	data, err := d.driver.GetSensorData(ctx, metric, sensorId)
+	endIgnoringNotExist(span, err)
+	return data, err
+}
+
+func (d *tracedDriver) SaveArchiveData(ctx context.Context, metric string, sensorId string, data []byte) error {
+	ctx, span := startStorageSpan(ctx, "SaveArchiveData", metric, sensorId)
+	err := d.driver.SaveArchiveData(ctx, metric, sensorId, data)
+	End(span, err)
+	return err
+}
+
+func (d *tracedDriver) GetArchiveData(ctx context.Context, metric string, sensorId string) ([]byte, error) {
+	ctx, span := startStorageSpan(ctx, "GetArchiveData", metric, sensorId)
+	data, err := d.driver.GetArchiveData(ctx, metric, sensorId)
+	endIgnoringNotExist(span, err)
+	return data, err
+}
+
+func startStorageSpan(ctx context.Context, operation string, metric string, sensorId string) (context.Context, trace.Span) {
+	ctx, span := Tracer.Start(ctx, "storage."+operation, trace.WithAttributes(MetricKey.String(metric)))
+	if sensorId != "" {
+		span.SetAttributes(SensorIdKey.String(sensorId))
+	}
+	return ctx, span
+}
@@ -0,0 +1,116 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/andreikom/sensor-server/pkg/logging"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+	"os"
+	"strings"
+)
+
+const (
+	Otlp   = "otlp"
+	Stdout = "stdout"
+	// defaultEndpoint - the OTLP/HTTP receiver of a collector running next to the server
+	defaultEndpoint     = "localhost:4318"
+	defaultServiceName  = "sensor-server"
+	instrumentationName = "github.com/andreikom/sensor-server"
+)
+
+const (
+	// SensorIdKey and MetricKey - the attributes of spans handling a reading
+	SensorIdKey = attribute.Key("sensor.id")
+	MetricKey   = attribute.Key("sensor.metric")
+	// requestIdKey - the request id a span was started for, see logging.RequestId
+	requestIdKey = attribute.Key("request.id")
+)
+
+// Options - where spans are exported to, tracing is disabled if Exporter is empty
+type Options struct {
+	// Exporter - otlp or stdout
+	Exporter string
+	// Endpoint - host:port or url of the OTLP/HTTP receiver, defaultEndpoint if empty
+	Endpoint string
+	// ServiceName - the service.name resource attribute, defaultServiceName if empty
+	ServiceName string
+	// SampleRatio - the share of traces started by the server which are recorded, all if 0.
+	// Traces started by clients follow the sampling decision of the client
+	SampleRatio float64
+}
+
+// Tracer - the tracer of all server spans, they are dropped until Configure installs an exporter
+var Tracer = otel.Tracer(instrumentationName)
+
+// Configure installs the exporter of options as the global tracer provider and the W3C trace context
+// propagator. The returned function flushes the spans not exported yet and stops the exporter
+func Configure(options Options) (func(ctx context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	noop := func(ctx context.Context) error { return nil }
+	if options.Endpoint == "" {
+		options.Endpoint = defaultEndpoint
+	}
+	if options.ServiceName == "" {
+		options.ServiceName = defaultServiceName
+	}
+	if options.SampleRatio <= 0 {
+		options.SampleRatio = 1
+	}
+	var exporter sdktrace.SpanExporter
+	logger := logging.Log.WithField("exporter", options.Exporter)
+	switch strings.ToLower(options.Exporter) {
+	case "":
+		return noop, nil
+	case Otlp:
+		endpoint, err := otlpUrl(options.Endpoint)
+		if err != nil {
+			return noop, err
+		}
+		otlp, err := newOtlpExporter(context.Background(), endpoint)
+		if err != nil {
+			return noop, err
+		}
+		exporter = otlp
+		logger = logger.WithField("url", endpoint.String())
+	case Stdout:
+		exporter = newStdoutExporter(os.Stdout)
+	default:
+		return noop, fmt.Errorf("unknown trace exporter '%s', expected %s or %s", options.Exporter, Otlp, Stdout)
+	}
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		logging.Log.WithError(err).Warn("Could not export spans")
+	}))
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(options.ServiceName))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(options.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	logger.Info("Tracing enabled")
+	return provider.Shutdown, nil
+}
+
+// End records err on span, if any, and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// endIgnoringNotExist - a missing file is an expected answer of the storage driver, not a failure
+func endIgnoringNotExist(span trace.Span, err error) {
+	if errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+	End(span, err)
+}
@@ -0,0 +1,209 @@
+package tracing
+
+import (
+	"context"
+	"github.com/andreikom/sensor-server/pkg/storage"
+	"github.com/gorilla/mux"
+	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+const (
+	clientTraceId = "4bf92f3577b34da6a3ce929d0e0e4736"
+	clientSpanId  = "00f067aa0ba902b7"
+	traceparent   = "00-" + clientTraceId + "-" + clientSpanId + "-01"
+)
+
+// recorder - the spans of Tracer, the global tracer provider delegates to the first provider set only
+var (
+	recorder = tracetest.NewSpanRecorder()
+	seen     int
+)
+
+func TestMain(m *testing.M) {
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	// an empty exporter only installs the propagator
+	if _, err := Configure(Options{}); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// endedSpans returns the spans ended since the last call
+func endedSpans() []sdktrace.ReadOnlySpan {
+	spans := recorder.Ended()[seen:]
+	seen += len(spans)
+	return spans
+}
+
+func attributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	values := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes() {
+		values[kv.Key] = kv.Value
+	}
+	return values
+}
+
+func TestConfigureRejectsOptions(t *testing.T) {
+	for name, options := range map[string]Options{
+		"unknown exporter": {Exporter: "zipkin"},
+		"invalid endpoint": {Exporter: Otlp, Endpoint: "ftp://collector:4318"},
+	} {
+		if _, err := Configure(options); err == nil {
+			t.Errorf("%s: accepted %+v", name, options)
+		}
+	}
+	shutdown, err := Configure(Options{})
+	if err != nil || shutdown(context.Background()) != nil {
+		t.Errorf("disabled tracing returned %v", err)
+	}
+}
+
+func TestHttpMiddleware(t *testing.T) {
+	endedSpans()
+	router := mux.NewRouter()
+	router.Use(HttpMiddleware)
+	router.HandleFunc("/api/temp/{sensorId}", func(w http.ResponseWriter, r *http.Request) {
+		if !trace.SpanContextFromContext(r.Context()).IsValid() {
+			t.Error("the handler context carries no span")
+		}
+		if mux.Vars(r)["sensorId"] == "broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+	req := httptest.NewRequest(http.MethodGet, "/api/temp/kitchen?unit=F", nil)
+	req.Header.Set("traceparent", traceparent)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/temp/broken", nil))
+
+	spans := endedSpans()
+	if len(spans) != 2 {
+		t.Fatalf("ended %d spans, want 2", len(spans))
+	}
+	span := spans[0]
+	if span.Name() != "GET /api/temp/{sensorId}" || span.SpanKind() != trace.SpanKindServer {
+		t.Errorf("started %s span %q", span.SpanKind(), span.Name())
+	}
+	// the trace of the client is continued
+	if span.SpanContext().TraceID().String() != clientTraceId || span.Parent().SpanID().String() != clientSpanId {
+		t.Errorf("the span is not a child of the client span: %s %s", span.SpanContext().TraceID(), span.Parent().SpanID())
+	}
+	values := attributes(span)
+	if values["http.route"].AsString() != "/api/temp/{sensorId}" || values["http.target"].AsString() != "/api/temp/kitchen?unit=F" ||
+		values["http.status_code"].AsInt64() != http.StatusOK {
+		t.Errorf("span attributes %v", values)
+	}
+	if span.Status().Code != codes.Unset {
+		t.Errorf("a successful request has the status %v", span.Status().Code)
+	}
+	failed := spans[1]
+	if failed.Parent().IsValid() || failed.Status().Code != codes.Error || attributes(failed)["http.status_code"].AsInt64() != http.StatusInternalServerError {
+		t.Errorf("a failed request without a traceparent ended as %+v", failed.Status())
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	endedSpans()
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("traceparent", traceparent))
+	info := &grpc.UnaryServerInfo{FullMethod: "/temperature_grpc.TempService/GetLatestTemp"}
+	_, err := UnaryServerInterceptor(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Error(grpccodes.NotFound, "no such sensor")
+	})
+	if status.Code(err) != grpccodes.NotFound {
+		t.Fatalf("returned %v, want the error of the handler", err)
+	}
+	spans := endedSpans()
+	if len(spans) != 1 {
+		t.Fatalf("ended %d spans, want 1", len(spans))
+	}
+	span := spans[0]
+	values := attributes(span)
+	if span.Name() != "temperature_grpc.TempService/GetLatestTemp" || values["rpc.service"].AsString() != "temperature_grpc.TempService" ||
+		values["rpc.method"].AsString() != "GetLatestTemp" || values["rpc.grpc.status_code"].AsInt64() != int64(grpccodes.NotFound) {
+		t.Errorf("span %q with attributes %v", span.Name(), values)
+	}
+	if span.SpanContext().TraceID().String() != clientTraceId || span.Status().Code != codes.Error || len(span.Events()) != 1 {
+		t.Errorf("the span continues %s with status %v and %d events", span.SpanContext().TraceID(), span.Status(), len(span.Events()))
+	}
+}
+
+func TestMessagesContinueTheTrace(t *testing.T) {
+	endedSpans()
+	headers := amqp.Table{"x-request-id": "req-1"}
+	_, publish := StartPublish(context.Background(), "metrics", headers)
+	publish.End()
+	if _, ok := headers["traceparent"].(string); !ok {
+		t.Fatalf("the trace context was not written into the headers %v", headers)
+	}
+	// a retried message carries a copy of the headers
+	retried := amqp.Table{}
+	for key, value := range headers {
+		retried[key] = value
+	}
+	_, process := StartProcess("metrics", retried)
+	End(process, nil)
+
+	spans := endedSpans()
+	if len(spans) != 2 {
+		t.Fatalf("ended %d spans, want 2", len(spans))
+	}
+	producer, consumer := spans[0], spans[1]
+	if producer.SpanKind() != trace.SpanKindProducer || consumer.SpanKind() != trace.SpanKindConsumer {
+		t.Errorf("span kinds %s and %s", producer.SpanKind(), consumer.SpanKind())
+	}
+	if consumer.Parent().SpanID() != producer.SpanContext().SpanID() || consumer.SpanContext().TraceID() != producer.SpanContext().TraceID() {
+		t.Error("the processing span is not a child of the publishing span")
+	}
+	if attributes(consumer)["messaging.destination"].AsString() != "metrics" || consumer.Name() != "metrics process" {
+		t.Errorf("the processing span %q has the attributes %v", consumer.Name(), attributes(consumer))
+	}
+}
+
+func TestTraceDriver(t *testing.T) {
+	endedSpans()
+	driver := TraceDriver(storage.NewFSDriver(t.TempDir()))
+	ctx, parent := Tracer.Start(context.Background(), "parent")
+	if err := driver.SaveSensorData(ctx, "temperature", "kitchen", []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := driver.GetArchiveData(ctx, "temperature", "kitchen"); err == nil {
+		t.Fatal("a missing archive was read")
+	}
+	if err := driver.SaveSensorData(ctx, "temperature", "../kitchen", []byte("{}")); err == nil {
+		t.Fatal("an invalid sensor id was saved")
+	}
+	parent.End()
+	spans := endedSpans()
+	if len(spans) != 4 {
+		t.Fatalf("ended %d spans, want 4", len(spans))
+	}
+	for _, span := range spans[:3] {
+		if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("%s is not a child of the call context span", span.Name())
+		}
+	}
+	saved, missing, failed := spans[0], spans[1], spans[2]
+	if saved.Name() != "storage.SaveSensorData" || attributes(saved)[SensorIdKey].AsString() != "kitchen" || attributes(saved)[MetricKey].AsString() != "temperature" {
+		t.Errorf("span %q with attributes %v", saved.Name(), attributes(saved))
+	}
+	// a missing archive is an expected answer, not a failure
+	if missing.Status().Code == codes.Error {
+		t.Error("a missing archive failed the span")
+	}
+	if failed.Status().Code != codes.Error {
+		t.Error("a failed write did not fail the span")
+	}
+}
@@ -1,14 +1,14 @@
 package utils
 
 import (
-	"fmt"
+	"github.com/andreikom/sensor-server/pkg/logging"
 	"os"
 )
 
 func GetUserHome() string {
 	userHome, err := os.UserHomeDir()
 	if err != nil {
-		fmt.Printf("Error occured while getting user home from env: %s\n", err)
+		logging.Log.WithError(err).Error("Could not get the user home from env")
 	}
 	return userHome
 }